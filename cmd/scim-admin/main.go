@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	goahttp "goa.design/goa/v3/http"
+
+	"github.com/iamBelugaa/scim-gateway/gen/admin"
+	adminclient "github.com/iamBelugaa/scim-gateway/gen/http/admin/client"
+)
+
+const usage = `Usage: scim-admin [flags] tokens <command> [arguments]
+       scim-admin mapping <command> [arguments]
+
+Manage service account tokens of a tenant of a running gateway. Requests
+are authenticated with a JWT carrying the admin scope, issued for the same
+tenant. Mapping commands run locally, without a gateway or token.
+
+Commands:
+  tokens create -account <name> [-ttl <duration>]
+  tokens list
+  tokens rotate -id <id> [-overlap <duration>]
+  tokens expire -id <id> [-at <RFC 3339 time>]
+  tokens revoke -id <id>
+  mapping preview -mapping <file> [-resource <file>] [-full]
+
+Flags:
+`
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "scim-admin:", err)
+		os.Exit(1)
+	}
+}
+
+// run parses the global flags, builds the admin client and dispatches to the
+// requested command.
+func run(args []string) error {
+	global := flag.NewFlagSet("scim-admin", flag.ContinueOnError)
+	global.Usage = func() {
+		fmt.Fprint(global.Output(), usage)
+		global.PrintDefaults()
+	}
+
+	addr := global.String("url", envOr("SCIM_ADMIN_URL", "http://localhost:8080"), "Base URL of the gateway (env SCIM_ADMIN_URL)")
+	token := global.String("token", os.Getenv("SCIM_ADMIN_TOKEN"), "JWT with the admin scope (env SCIM_ADMIN_TOKEN)")
+	tenant := global.String("tenant", envOr("SCIM_ADMIN_TENANT", "default"), "Tenant whose tokens are managed (env SCIM_ADMIN_TENANT)")
+	timeout := global.Duration("timeout", 30*time.Second, "Request timeout")
+
+	if err := global.Parse(args); err != nil {
+		return err
+	}
+
+	args = global.Args()
+	if len(args) >= 2 && args[0] == "mapping" {
+		res, err := runMapping(args[1], args[2:])
+		if err != nil {
+			return err
+		}
+		return printJSON(res)
+	}
+	if len(args) < 2 || args[0] != "tokens" {
+		global.Usage()
+		return errors.New("expected a tokens or mapping command")
+	}
+	if *token == "" {
+		return errors.New("an admin token is required, set -token or SCIM_ADMIN_TOKEN")
+	}
+
+	target, err := url.Parse(*addr)
+	if err != nil || target.Host == "" {
+		return fmt.Errorf("invalid gateway url %q", *addr)
+	}
+
+	c := adminclient.NewClient(
+		target.Scheme, target.Host, &http.Client{Timeout: *timeout},
+		goahttp.RequestEncoder, goahttp.ResponseDecoder, false,
+	)
+	client := admin.NewClient(
+		c.ListVersions(), c.GetVersion(), c.GetAsOf(), c.RestoreVersion(), c.ListDeleted(), c.RestoreDeleted(),
+		c.CreateToken(), c.ListTokens(), c.RotateToken(), c.ExpireToken(), c.RevokeToken(),
+		c.ListDeadLetters(), c.GetDeadLetter(), c.RetryDeadLetter(), c.DiscardDeadLetter(),
+		c.ReconcileConnector(), c.ListReconciliations(), c.GetReconciliation(), c.ExportReconciliationDrifts(),
+	)
+
+	res, err := runTokens(context.Background(), client, *token, *tenant, args[1], args[2:])
+	if err != nil {
+		// SCIM errors describe themselves generically, the detail is what
+		// tells the operator what went wrong.
+		var scimErr *admin.SCIMError
+		if errors.As(err, &scimErr) {
+			return fmt.Errorf("%s : %s", scimErr.Status, scimErr.Detail)
+		}
+		return err
+	}
+
+	return printJSON(res)
+}
+
+// printJSON prints the result of a command as indented JSON.
+func printJSON(res any) error {
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	return out.Encode(res)
+}
+
+// runTokens executes a tokens subcommand and returns its result.
+func runTokens(ctx context.Context, client *admin.Client, token, tenant, command string, args []string) (any, error) {
+	flags := flag.NewFlagSet("tokens "+command, flag.ContinueOnError)
+
+	switch command {
+	case "create":
+		account := flags.String("account", "", "Service account to issue the token to")
+		ttl := flags.Duration("ttl", 0, "Lifetime of the token, zero for a token that never expires")
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		if *account == "" {
+			return nil, errors.New("-account is required")
+		}
+
+		p := &admin.CreateTokenPayload{ServiceAccount: *account, TenantID: tenant, Token: &token}
+		if *ttl > 0 {
+			seconds := int64(ttl.Seconds())
+			p.ExpiresInSeconds = &seconds
+		}
+		return client.CreateToken(ctx, p)
+
+	case "list":
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		return client.ListTokens(ctx, &admin.ListTokensPayload{TenantID: tenant, Token: &token})
+
+	case "rotate":
+		id := flags.String("id", "", "Id of the token to rotate")
+		overlap := flags.Duration("overlap", 24*time.Hour, "How long the old token keeps working")
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		if *id == "" {
+			return nil, errors.New("-id is required")
+		}
+		seconds := int64(overlap.Seconds())
+		return client.RotateToken(ctx, &admin.RotateTokenPayload{ID: *id, TenantID: tenant, OverlapSeconds: &seconds, Token: &token})
+
+	case "expire":
+		id := flags.String("id", "", "Id of the token to expire")
+		at := flags.String("at", "", "Time the token expires in RFC 3339 format, defaults to now")
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		if *id == "" {
+			return nil, errors.New("-id is required")
+		}
+
+		p := &admin.ExpireTokenPayload{ID: *id, TenantID: tenant, Token: &token}
+		if *at != "" {
+			if _, err := time.Parse(time.RFC3339, *at); err != nil {
+				return nil, fmt.Errorf("-at must be an RFC 3339 time : %w", err)
+			}
+			p.At = at
+		}
+		return client.ExpireToken(ctx, p)
+
+	case "revoke":
+		id := flags.String("id", "", "Id of the token to revoke")
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		if *id == "" {
+			return nil, errors.New("-id is required")
+		}
+		return client.RevokeToken(ctx, &admin.TokenRef{ID: *id, TenantID: tenant, Token: &token})
+
+	default:
+		return nil, fmt.Errorf("unknown tokens command %q", command)
+	}
+}
+
+// envOr returns the value of the environment variable, or the fallback when
+// it is not set.
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
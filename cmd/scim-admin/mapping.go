@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/iamBelugaa/scim-gateway/internal/mapping"
+)
+
+// runMapping executes a mapping subcommand and returns its result. Mapping
+// commands run locally and do not contact the gateway.
+func runMapping(command string, args []string) (any, error) {
+	flags := flag.NewFlagSet("mapping "+command, flag.ContinueOnError)
+
+	switch command {
+	case "preview":
+		file := flags.String("mapping", "", "YAML or JSON mapping file")
+		resource := flags.String("resource", "-", "JSON file of the sample resource, - for standard input")
+		full := flags.Bool("full", false, "Print the whole mapped resource rather than the mapped targets only")
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		if *file == "" {
+			return nil, errors.New("-mapping is required")
+		}
+
+		m, err := mapping.Load(*file)
+		if err != nil {
+			return nil, err
+		}
+		attrs, err := readResource(*resource)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.Apply(attrs); err != nil {
+			return nil, err
+		}
+
+		if *full {
+			return attrs, nil
+		}
+		targets := make(map[string]any)
+		for _, target := range m.Targets() {
+			val, _ := target.Get(attrs)
+			targets[target.String()] = val
+		}
+		return targets, nil
+
+	default:
+		return nil, fmt.Errorf("unknown mapping command %q", command)
+	}
+}
+
+// readResource reads a sample resource from a JSON file, or from standard
+// input when the path is "-".
+func readResource(path string) (map[string]any, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read resource : %w", err)
+	}
+
+	var attrs map[string]any
+	if err := json.Unmarshal(data, &attrs); err != nil {
+		return nil, fmt.Errorf("failed to decode resource : %w", err)
+	}
+	if attrs == nil {
+		attrs = make(map[string]any)
+	}
+	return attrs, nil
+}
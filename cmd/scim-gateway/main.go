@@ -37,7 +37,10 @@ func run() error {
 	logger.Infow(fmt.Sprintf("starting %s service", conf.Application.Service))
 
 	// Start serving HTTP requests.
-	server := server.NewWithConfig(logger, conf)
+	server, err := server.NewWithConfig(logger, conf)
+	if err != nil {
+		return fmt.Errorf("failed to construct server : %w", err)
+	}
 	server.ListenAndServe()
 
 	// Wait for shutdown signal or error and gracefully shut down the server.
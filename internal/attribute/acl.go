@@ -0,0 +1,129 @@
+package attribute
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ErrProtected is returned when a caller attempts to change an attribute it
+// is not allowed to write.
+var ErrProtected = errors.New("attribute is write protected")
+
+// Modes restricting a caller's access to an attribute.
+const (
+	ModeHide   = "hide"   // The attribute is left out of every response.
+	ModeReject = "reject" // Writes changing the attribute are rejected.
+	ModeDrop   = "drop"   // Writes to the attribute are silently ignored.
+)
+
+// ACL restricts which attributes a caller may see or change. A nil ACL
+// restricts nothing.
+type ACL struct {
+	hidden   []Path // Attributes left out of responses.
+	rejected []Path // Attributes whose changes are rejected.
+	dropped  []Path // Attributes whose changes are ignored.
+}
+
+// Mask returns a copy of attrs without the attributes hidden from the caller.
+func (a *ACL) Mask(attrs map[string]any) map[string]any {
+	masked, _ := deepCopy(attrs).(map[string]any)
+	a.hide(masked)
+	return masked
+}
+
+// hide removes the attributes hidden from the caller from attrs in place.
+func (a *ACL) hide(attrs map[string]any) {
+	if a == nil || attrs == nil {
+		return
+	}
+	for _, path := range a.hidden {
+		path.Remove(attrs)
+	}
+}
+
+// Write checks the caller may replace current with updated. Changes to
+// dropped attributes are undone in updated, which is modified in place.
+// ErrProtected is returned when a rejected attribute is changed.
+func (a *ACL) Write(current, updated map[string]any) error {
+	if a == nil {
+		return nil
+	}
+
+	for _, path := range a.rejected {
+		before, hadBefore := path.Get(current)
+		after, hasAfter := path.Get(updated)
+		if hadBefore != hasAfter || !reflect.DeepEqual(before, after) {
+			return fmt.Errorf("%w : %s", ErrProtected, path)
+		}
+	}
+	for _, path := range a.dropped {
+		path.Restore(updated, current)
+	}
+	return nil
+}
+
+// ACLs holds the attribute ACL of every principal.
+type ACLs struct {
+	acls map[string]*ACL // ACLs keyed by principal subject.
+}
+
+// NewACLs parses ACL entries of the form
+// "<subject>:<hide|reject|drop>:<space separated attribute paths>", where the
+// subject names the principal with its tenant, either
+// "<tenant>/<service-account>" or "<tenant>/<scheme>/<name>" for principals
+// authenticated by tokens such as JWTs. Entries for the same subject
+// accumulate.
+func NewACLs(entries []string) (*ACLs, error) {
+	a := &ACLs{acls: make(map[string]*ACL)}
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 3)
+		tenant, account, _ := strings.Cut(parts[0], "/")
+		if len(parts) != 3 || tenant == "" || account == "" {
+			return nil, fmt.Errorf("attribute ACL entry %q must be of the form '<tenant>/<service-account>:<mode>:<attribute paths>'", entry)
+		}
+		subject, mode, fields := parts[0], parts[1], strings.Fields(parts[2])
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("attribute ACL entry %q lists no attributes", entry)
+		}
+
+		paths, err := ParsePaths(fields)
+		if err != nil {
+			return nil, fmt.Errorf("attribute ACL entry %q : %w", entry, err)
+		}
+
+		acl, ok := a.acls[subject]
+		if !ok {
+			acl = &ACL{}
+			a.acls[subject] = acl
+		}
+
+		switch mode {
+		case ModeHide:
+			acl.hidden = append(acl.hidden, paths...)
+		case ModeReject:
+			acl.rejected = append(acl.rejected, paths...)
+		case ModeDrop:
+			acl.dropped = append(acl.dropped, paths...)
+		default:
+			return nil, fmt.Errorf("attribute ACL entry %q has unknown mode %q", entry, mode)
+		}
+	}
+	return a, nil
+}
+
+// For returns the ACL of the principal with the given subject, nil when it is
+// unrestricted.
+func (a *ACLs) For(subject string) *ACL {
+	if a == nil {
+		return nil
+	}
+	return a.acls[subject]
+}
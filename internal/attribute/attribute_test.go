@@ -0,0 +1,264 @@
+package attribute
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/iamBelugaa/scim-gateway/internal/schema"
+)
+
+// newUser returns the attributes of a user with core, complex, multi-valued
+// and extension attributes.
+func newUser() map[string]any {
+	return map[string]any{
+		"schemas":  []any{schema.URIUser, schema.URIEnterpriseUser},
+		"id":       "2819c223",
+		"userName": "bjensen",
+		"password": "t1meMa$heen",
+		"name":     map[string]any{"givenName": "Barbara", "familyName": "Jensen"},
+		"emails": []any{
+			map[string]any{"value": "bjensen@example.com", "type": "work"},
+			map[string]any{"value": "babs@example.com", "type": "home"},
+		},
+		"meta":                   map[string]any{"resourceType": "User"},
+		schema.URIEnterpriseUser: map[string]any{"costCenter": "4130", "department": "Tour Operations"},
+	}
+}
+
+// newACLs parses ACL entries, failing the test on error.
+func newACLs(t *testing.T, entries ...string) *ACLs {
+	t.Helper()
+
+	acls, err := NewACLs(entries)
+	if err != nil {
+		t.Fatalf("failed to parse acls : %v", err)
+	}
+	return acls
+}
+
+// TestParsePath checks simple, complex and schema qualified paths.
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want Path
+	}{
+		{path: "userName", want: Path{Name: "userName"}},
+		{path: "name.givenName", want: Path{Name: "name", Sub: "givenName"}},
+		{path: schema.URIUser + ":emails.value", want: Path{Schema: schema.URIUser, Name: "emails", Sub: "value"}},
+		{path: schema.URIEnterpriseUser + ":costCenter", want: Path{Schema: schema.URIEnterpriseUser, Name: "costCenter"}},
+		{path: schema.URIEnterpriseUser, want: Path{Schema: schema.URIEnterpriseUser}},
+		{path: "urn:example:ext:1.0:User:badge", want: Path{Schema: "urn:example:ext:1.0:User", Name: "badge"}},
+	}
+
+	for _, tt := range tests {
+		got, err := ParsePath(tt.path)
+		if err != nil {
+			t.Errorf("%s : unexpected error %v", tt.path, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s : got %+v, want %+v", tt.path, got, tt.want)
+		}
+		if got.String() != tt.path {
+			t.Errorf("%s : formatted as %s", tt.path, got.String())
+		}
+	}
+
+	for _, path := range []string{"", "name.givenName.first", "emails[type eq \"work\"]", schema.URIUser} {
+		if _, err := ParsePath(path); err == nil {
+			t.Errorf("%q : expected an error", path)
+		}
+	}
+}
+
+// TestProjection checks attributes and excludedAttributes select attributes
+// as defined by RFC 7644 section 3.4.2.5.
+func TestProjection(t *testing.T) {
+	attributes, _ := ParsePaths([]string{"userName", "emails.value", schema.URIEnterpriseUser + ":costCenter"})
+	got := Projection{Attributes: attributes}.Apply(newUser(), nil)
+	want := map[string]any{
+		"schemas":  []any{schema.URIUser, schema.URIEnterpriseUser},
+		"id":       "2819c223",
+		"meta":     map[string]any{"resourceType": "User"},
+		"userName": "bjensen",
+		"emails": []any{
+			map[string]any{"value": "bjensen@example.com", "type": "work"},
+			map[string]any{"value": "babs@example.com", "type": "home"},
+		},
+		schema.URIEnterpriseUser: map[string]any{"costCenter": "4130"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("attributes : got %v, want %v", got, want)
+	}
+
+	excluded, _ := ParsePaths([]string{"id", "name.familyName", "emails", schema.URIEnterpriseUser})
+	got = Projection{Excluded: excluded}.Apply(newUser(), nil)
+	if _, ok := got["id"]; !ok {
+		t.Errorf("excludedAttributes : removed the always returned id")
+	}
+	if _, ok := got["emails"]; ok {
+		t.Errorf("excludedAttributes : returned emails")
+	}
+	if _, ok := got[schema.URIEnterpriseUser]; ok {
+		t.Errorf("excludedAttributes : returned the enterprise extension")
+	}
+	if name := got["name"].(map[string]any); len(name) != 1 || name["givenName"] != "Barbara" {
+		t.Errorf("excludedAttributes : got name %v, want only the given name", name)
+	}
+}
+
+// TestProjectionHonoursACL checks hidden attributes are never returned, even
+// when requested explicitly, and the source attributes are left untouched.
+func TestProjectionHonoursACL(t *testing.T) {
+	acl := newACLs(t, "default/okta:hide:password emails.value "+schema.URIEnterpriseUser+":costCenter").For("default/okta")
+	attrs := newUser()
+
+	requested, _ := ParsePaths([]string{"password", "emails", schema.URIEnterpriseUser})
+	got := Projection{Attributes: requested}.Apply(attrs, acl)
+
+	if _, ok := got["password"]; ok {
+		t.Errorf("returned the hidden password")
+	}
+	for _, email := range got["emails"].([]any) {
+		if _, ok := email.(map[string]any)["value"]; ok {
+			t.Errorf("returned a hidden email value")
+		}
+	}
+	if ext := got[schema.URIEnterpriseUser].(map[string]any); ext["costCenter"] != nil || ext["department"] == nil {
+		t.Errorf("got enterprise extension %v, want only the department", ext)
+	}
+	if !reflect.DeepEqual(attrs, newUser()) {
+		t.Errorf("modified the source attributes")
+	}
+
+	if masked := acl.Mask(attrs); masked["password"] != nil || masked["userName"] != "bjensen" {
+		t.Errorf("mask : got %v", masked)
+	}
+	if unrestricted := newACLs(t).For("default/okta"); !reflect.DeepEqual(unrestricted.Mask(attrs), attrs) {
+		t.Errorf("mask without acl : modified the attributes")
+	}
+}
+
+// TestWriteRejectsProtectedAttributes checks changes to rejected attributes
+// fail while unchanged values and other attributes are accepted.
+func TestWriteRejectsProtectedAttributes(t *testing.T) {
+	acl := newACLs(t, "default/okta:reject:userName "+schema.URIEnterpriseUser+":costCenter").For("default/okta")
+
+	updated := newUser()
+	updated["name"] = map[string]any{"givenName": "Babs"}
+	if err := acl.Write(newUser(), updated); err != nil {
+		t.Errorf("unrelated change : unexpected error %v", err)
+	}
+
+	updated = newUser()
+	updated["username"] = "bjensen2"
+	delete(updated, "userName")
+	if err := acl.Write(newUser(), updated); !errors.Is(err, ErrProtected) {
+		t.Errorf("changed userName : got error %v, want %v", err, ErrProtected)
+	}
+
+	updated = newUser()
+	delete(updated, schema.URIEnterpriseUser)
+	if err := acl.Write(newUser(), updated); !errors.Is(err, ErrProtected) {
+		t.Errorf("removed costCenter : got error %v, want %v", err, ErrProtected)
+	}
+}
+
+// TestWriteDropsProtectedAttributes checks changes to dropped attributes are
+// silently undone while the rest of the write goes through.
+func TestWriteDropsProtectedAttributes(t *testing.T) {
+	acl := newACLs(t, "default/okta:drop:password name.familyName emails.type").For("default/okta")
+
+	updated := newUser()
+	delete(updated, "password")
+	updated["name"] = map[string]any{"givenName": "Babs", "familyName": "Smith"}
+	updated["emails"] = []any{map[string]any{"value": "babs@example.com"}}
+	updated["title"] = "Tour Guide"
+
+	if err := acl.Write(newUser(), updated); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	current := newUser()
+	if updated["password"] != current["password"] {
+		t.Errorf("password : got %v, want it restored", updated["password"])
+	}
+	if name := updated["name"].(map[string]any); name["givenName"] != "Babs" || name["familyName"] != "Jensen" {
+		t.Errorf("name : got %v, want the new given name and the old family name", name)
+	}
+	if !reflect.DeepEqual(updated["emails"], current["emails"]) {
+		t.Errorf("emails : got %v, want them restored", updated["emails"])
+	}
+	if updated["title"] != "Tour Guide" {
+		t.Errorf("title : got %v, want the new value", updated["title"])
+	}
+}
+
+// TestACLsAreScopedByTenant checks an ACL only applies to the principal of
+// its own tenant and scheme.
+func TestACLsAreScopedByTenant(t *testing.T) {
+	acls := newACLs(t, "acme/okta:hide:password", "globex/okta:reject:userName")
+
+	if acl := acls.For("acme/okta"); acl == nil || len(acl.hidden) != 1 || len(acl.rejected) != 0 {
+		t.Errorf("acme/okta : got acl %+v", acl)
+	}
+	if acl := acls.For("globex/okta"); acl == nil || len(acl.hidden) != 0 || len(acl.rejected) != 1 {
+		t.Errorf("globex/okta : got acl %+v", acl)
+	}
+	for _, subject := range []string{"okta", "default/okta", "acme/jwt/okta"} {
+		if acl := acls.For(subject); acl != nil {
+			t.Errorf("%s : expected no acl, got %+v", subject, acl)
+		}
+	}
+}
+
+// TestNewACLsRejectsInvalidEntries checks malformed ACLs are reported at
+// startup.
+func TestNewACLsRejectsInvalidEntries(t *testing.T) {
+	for _, entry := range []string{
+		"default/okta", "default/okta:hide", "default/okta:hide: ", ":hide:password", "default/okta:mask:password",
+		"default/okta:hide:a.b.c", "okta:hide:password", "default/:hide:password",
+	} {
+		if _, err := NewACLs([]string{entry}); err == nil {
+			t.Errorf("%q : expected an error", entry)
+		}
+	}
+}
+
+// TestSet checks values are set in complex, multi-valued and extension
+// attributes, creating them when needed.
+func TestSet(t *testing.T) {
+	attrs := newUser()
+
+	mustParse := func(path string) Path {
+		p, err := ParsePath(path)
+		if err != nil {
+			t.Fatalf("failed to parse %q : %v", path, err)
+		}
+		return p
+	}
+
+	mustParse("name.formatted").Set(attrs, "Barbara Jensen")
+	mustParse("emails.value").Set(attrs, []any{"a@example.com", "b@example.com"})
+	mustParse("emails.primary").Set(attrs, false)
+	mustParse(schema.URIEnterpriseUser+":division").Set(attrs, "Tours")
+	mustParse("urn:example:2.0:User:badge").Set(attrs, "42")
+
+	if got := attrs["name"].(map[string]any)["formatted"]; got != "Barbara Jensen" {
+		t.Errorf("expected the formatted name, got %v", got)
+	}
+	emails := []any{
+		map[string]any{"value": "a@example.com", "type": "work", "primary": false},
+		map[string]any{"value": "b@example.com", "type": "home", "primary": false},
+	}
+	if !reflect.DeepEqual(attrs["emails"], emails) {
+		t.Errorf("expected every email to be set, got %v", attrs["emails"])
+	}
+	if got := attrs[schema.URIEnterpriseUser].(map[string]any)["division"]; got != "Tours" {
+		t.Errorf("expected the division in the enterprise extension, got %v", got)
+	}
+	if got := attrs["urn:example:2.0:User"].(map[string]any)["badge"]; got != "42" {
+		t.Errorf("expected the badge in a new extension, got %v", got)
+	}
+}
@@ -0,0 +1,342 @@
+// Package attribute addresses SCIM resource attributes by path (RFC 7644
+// section 3.10) and restricts which of them a caller may see or change.
+package attribute
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/iamBelugaa/scim-gateway/internal/schema"
+)
+
+// coreSchemas are the schemas whose attributes are held at the top level of a
+// resource. Attributes of any other schema are nested under the schema URI.
+var coreSchemas = []string{schema.URIUser, schema.URIGroup}
+
+// Path addresses an attribute or a sub-attribute of a resource, optionally
+// qualified by the URI of the schema defining it. A path holding only an
+// extension schema URI addresses the whole extension.
+type Path struct {
+	Schema string // Schema URI, empty when the path is not qualified.
+	Name   string // Attribute name, empty when the path addresses a whole extension.
+	Sub    string // Sub-attribute name, empty when the path addresses the whole attribute.
+}
+
+// ParsePath parses an attribute path such as "emails", "name.givenName" or
+// "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:costCenter".
+func ParsePath(path string) (Path, error) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return Path{}, errors.New("attribute path is empty")
+	}
+
+	var p Path
+	if strings.HasPrefix(strings.ToLower(path), "urn:") {
+		p.Schema, path = splitSchema(path)
+		if path == "" {
+			if isCore(p.Schema) {
+				return Path{}, fmt.Errorf("attribute path %q does not name an attribute", p.Schema)
+			}
+			return p, nil
+		}
+	}
+
+	p.Name, p.Sub, _ = strings.Cut(path, ".")
+	if p.Name == "" || strings.Contains(p.Sub, ".") || strings.ContainsAny(p.Name, ":[] ") {
+		return Path{}, fmt.Errorf("invalid attribute path %q", path)
+	}
+	return p, nil
+}
+
+// ParsePaths parses a list of attribute paths.
+func ParsePaths(paths []string) ([]Path, error) {
+	parsed := make([]Path, 0, len(paths))
+	for _, path := range paths {
+		p, err := ParsePath(path)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, p)
+	}
+	return parsed, nil
+}
+
+// String returns the path in its fully qualified form when it is qualified.
+func (p Path) String() string {
+	var b strings.Builder
+	if p.Schema != "" {
+		b.WriteString(p.Schema)
+		if p.Name != "" {
+			b.WriteString(":")
+		}
+	}
+	b.WriteString(p.Name)
+	if p.Sub != "" {
+		b.WriteString(".")
+		b.WriteString(p.Sub)
+	}
+	return b.String()
+}
+
+// splitSchema splits a qualified path into its schema URI and the attribute
+// path that follows it. Built in schemas are matched first, since their URIs
+// are known. Otherwise the last colon separates the schema from the
+// attribute.
+func splitSchema(path string) (string, string) {
+	for _, uri := range schema.URIs() {
+		switch {
+		case strings.EqualFold(path, uri):
+			return uri, ""
+		case len(path) > len(uri) && strings.EqualFold(path[:len(uri)], uri) && path[len(uri)] == ':':
+			return uri, path[len(uri)+1:]
+		}
+	}
+
+	i := strings.LastIndex(path, ":")
+	return path[:i], path[i+1:]
+}
+
+// isCore reports whether the schema's attributes are held at the top level of
+// a resource.
+func isCore(uri string) bool {
+	for _, core := range coreSchemas {
+		if strings.EqualFold(uri, core) {
+			return true
+		}
+	}
+	return false
+}
+
+// container returns the map holding the attribute the path addresses, and
+// the key of the attribute within it. The key is empty when the path
+// addresses a whole extension.
+func (p Path) container(attrs map[string]any) (map[string]any, string, bool) {
+	if p.Schema == "" || isCore(p.Schema) {
+		key, ok := lookupKey(attrs, p.Name)
+		return attrs, key, ok
+	}
+
+	extKey, ok := lookupKey(attrs, p.Schema)
+	if !ok {
+		return nil, "", false
+	}
+	if p.Name == "" {
+		return attrs, extKey, true
+	}
+
+	ext, ok := attrs[extKey].(map[string]any)
+	if !ok {
+		return nil, "", false
+	}
+	key, ok := lookupKey(ext, p.Name)
+	return ext, key, ok
+}
+
+// Get returns the value the path addresses. The sub-attribute of a
+// multi-valued attribute is returned as the list of its values.
+func (p Path) Get(attrs map[string]any) (any, bool) {
+	parent, key, ok := p.container(attrs)
+	if !ok {
+		return nil, false
+	}
+
+	val := parent[key]
+	if p.Sub == "" {
+		return val, true
+	}
+
+	switch v := val.(type) {
+	case map[string]any:
+		subKey, ok := lookupKey(v, p.Sub)
+		if !ok {
+			return nil, false
+		}
+		return v[subKey], true
+	case []any:
+		values := make([]any, 0, len(v))
+		for _, item := range v {
+			if m, ok := item.(map[string]any); ok {
+				if subKey, ok := lookupKey(m, p.Sub); ok {
+					values = append(values, m[subKey])
+				}
+			}
+		}
+		return values, len(values) > 0
+	default:
+		return nil, false
+	}
+}
+
+// Remove deletes the value the path addresses from attrs. Sub-attributes are
+// removed from every value of a multi-valued attribute.
+func (p Path) Remove(attrs map[string]any) {
+	parent, key, ok := p.container(attrs)
+	if !ok {
+		return
+	}
+
+	if p.Sub == "" {
+		delete(parent, key)
+		return
+	}
+
+	switch v := parent[key].(type) {
+	case map[string]any:
+		if subKey, ok := lookupKey(v, p.Sub); ok {
+			delete(v, subKey)
+		}
+	case []any:
+		for _, item := range v {
+			if m, ok := item.(map[string]any); ok {
+				if subKey, ok := lookupKey(m, p.Sub); ok {
+					delete(m, subKey)
+				}
+			}
+		}
+	}
+}
+
+// lookupKey returns the key of m matching name. Attribute names are case
+// insensitive (RFC 7643 section 2.1).
+func lookupKey(m map[string]any, name string) (string, bool) {
+	if _, ok := m[name]; ok {
+		return name, true
+	}
+	for key := range m {
+		if strings.EqualFold(key, name) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// Restore makes the value the path addresses in dst what it is in src,
+// removing it from dst when src does not hold it. A sub-attribute of a
+// multi-valued attribute cannot be matched up value by value, so the whole
+// attribute is restored instead.
+func (p Path) Restore(dst, src map[string]any) {
+	whole := Path{Schema: p.Schema, Name: p.Name}
+
+	if p.Sub != "" {
+		if parent, key, ok := whole.container(src); ok {
+			if _, multi := parent[key].([]any); multi {
+				whole.Restore(dst, src)
+				return
+			}
+		}
+	}
+
+	val, ok := p.Get(src)
+	if !ok {
+		p.Remove(dst)
+		return
+	}
+	p.Set(dst, deepCopy(val))
+}
+
+// Set sets the value the path addresses in attrs, creating the extension and
+// the complex attribute holding it when attrs does not hold them yet. The
+// sub-attribute of a multi-valued attribute is set in every value, either to
+// val or, when val is a list holding a value per element Get returns, to the
+// matching element of val.
+func (p Path) Set(attrs map[string]any, val any) {
+	parent := attrs
+	if p.Schema != "" && !isCore(p.Schema) && p.Name != "" {
+		extKey, ok := lookupKey(attrs, p.Schema)
+		if !ok {
+			extKey = p.Schema
+		}
+		ext, ok := attrs[extKey].(map[string]any)
+		if !ok {
+			ext = make(map[string]any)
+			attrs[extKey] = ext
+		}
+		parent = ext
+	}
+
+	name := p.Name
+	if name == "" {
+		name = p.Schema
+	}
+	key, ok := lookupKey(parent, name)
+	if !ok {
+		key = name
+	}
+
+	if p.Sub == "" {
+		parent[key] = val
+		return
+	}
+
+	if items, ok := parent[key].([]any); ok && setEach(items, p.Sub, val) {
+		return
+	}
+
+	value, ok := parent[key].(map[string]any)
+	if !ok {
+		value = make(map[string]any)
+		parent[key] = value
+	}
+	subKey, ok := lookupKey(value, p.Sub)
+	if !ok {
+		subKey = p.Sub
+	}
+	value[subKey] = val
+}
+
+// setEach sets a sub-attribute in the values of a multi-valued attribute,
+// and reports whether it could. A list of values is matched up with the
+// values holding the sub-attribute, in order, as Get returns them.
+func setEach(items []any, sub string, val any) bool {
+	values, isList := val.([]any)
+	if !isList {
+		for _, item := range items {
+			if m, ok := item.(map[string]any); ok {
+				subKey, ok := lookupKey(m, sub)
+				if !ok {
+					subKey = sub
+				}
+				m[subKey] = val
+			}
+		}
+		return true
+	}
+
+	holders := make([]map[string]any, 0, len(items))
+	for _, item := range items {
+		if m, ok := item.(map[string]any); ok {
+			if _, ok := lookupKey(m, sub); ok {
+				holders = append(holders, m)
+			}
+		}
+	}
+	if len(holders) != len(values) {
+		return false
+	}
+	for i, m := range holders {
+		subKey, _ := lookupKey(m, sub)
+		m[subKey] = values[i]
+	}
+	return true
+}
+
+// deepCopy copies nested maps and slices of a JSON decoded value.
+func deepCopy(val any) any {
+	switch v := val.(type) {
+	case map[string]any:
+		clone := make(map[string]any, len(v))
+		for key, item := range v {
+			clone[key] = deepCopy(item)
+		}
+		return clone
+	case []any:
+		clone := make([]any, len(v))
+		for i, item := range v {
+			clone[i] = deepCopy(item)
+		}
+		return clone
+	default:
+		return v
+	}
+}
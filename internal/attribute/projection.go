@@ -0,0 +1,56 @@
+package attribute
+
+// alwaysReturned lists the attributes returned regardless of the attributes
+// requested (RFC 7643 section 3.1).
+var alwaysReturned = []string{"id", "schemas", "meta"}
+
+// Projection selects the attributes returned for a resource, as requested
+// with the attributes and excludedAttributes parameters (RFC 7644 section
+// 3.4.2.5).
+type Projection struct {
+	Attributes []Path // Attributes to return instead of the default set.
+	Excluded   []Path // Attributes to leave out of the default set.
+}
+
+// Apply returns a copy of attrs holding the projected attributes. The
+// caller's ACL is applied last, so hidden attributes cannot be requested
+// back. attrs itself is never modified.
+func (p Projection) Apply(attrs map[string]any, acl *ACL) map[string]any {
+	var projected map[string]any
+
+	switch {
+	case len(p.Attributes) > 0:
+		projected = make(map[string]any)
+		for _, name := range alwaysReturned {
+			if key, ok := lookupKey(attrs, name); ok {
+				projected[key] = deepCopy(attrs[key])
+			}
+		}
+		for _, path := range p.Attributes {
+			path.Restore(projected, attrs)
+		}
+
+	default:
+		projected = deepCopy(attrs).(map[string]any)
+		for _, path := range p.Excluded {
+			if path.Schema == "" && path.Sub == "" && isAlwaysReturned(path.Name) {
+				continue
+			}
+			path.Remove(projected)
+		}
+	}
+
+	acl.hide(projected)
+	return projected
+}
+
+// isAlwaysReturned reports whether an attribute is returned regardless of
+// the attributes requested.
+func isAlwaysReturned(name string) bool {
+	for _, always := range alwaysReturned {
+		if always == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+// Package auth authenticates callers of the gateway APIs and carries the
+// authenticated principal through the request context.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DefaultTenant is the tenant of credentials that are not bound to a tenant.
+const DefaultTenant = "default"
+
+// ErrUnauthorized is returned when a request carries no valid credentials.
+var ErrUnauthorized = errors.New("unauthorized")
+
+// ErrWrongTenant is returned when valid credentials are used against a tenant
+// they do not belong to.
+var ErrWrongTenant = errors.New("credentials belong to another tenant")
+
+// Principal identifies an authenticated caller.
+type Principal struct {
+	Name         string   // Service account or client the credentials belong to.
+	Scheme       string   // Authentication scheme that accepted the credentials.
+	Scopes       []string // Scopes granted to the credentials, when the scheme carries them.
+	CredentialID string   // Id of the specific credential used, when the scheme tracks one.
+	Tenant       string   // Tenant the principal belongs to, empty for the default tenant.
+}
+
+// TenantID returns the tenant the principal belongs to.
+func (p *Principal) TenantID() string {
+	if p.Tenant == "" {
+		return DefaultTenant
+	}
+	return p.Tenant
+}
+
+// Subject returns the name policies and attribute ACLs refer to the principal
+// by. Service accounts authenticated by static token or client certificate
+// are named "<tenant>/<service-account>", other principals
+// "<tenant>/<scheme>/<name>", so a token whose subject equals a service
+// account name never gets the grants of that account.
+func (p *Principal) Subject() string {
+	switch p.Scheme {
+	case SchemeStaticToken, SchemeClientCert:
+		return p.TenantID() + "/" + p.Name
+	default:
+		return p.TenantID() + "/" + p.Scheme + "/" + p.Name
+	}
+}
+
+// RequireTenant returns ErrWrongTenant unless the principal authenticated in
+// ctx belongs to the given tenant. Requests without a principal are rejected
+// with ErrUnauthorized.
+func RequireTenant(ctx context.Context, tenant string) error {
+	principal, ok := PrincipalFrom(ctx)
+	if !ok {
+		return ErrUnauthorized
+	}
+	if principal.TenantID() != tenant {
+		return fmt.Errorf("%w : tenant %q is not accessible", ErrWrongTenant, tenant)
+	}
+	return nil
+}
+
+// principalKey is the context key under which the principal is stored.
+type principalKey struct{}
+
+// clientIPKey is the context key under which the client address is stored.
+type clientIPKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the authenticated principal.
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the authenticated principal stored in ctx, if any.
+func PrincipalFrom(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok
+}
+
+// WithClientIP returns a copy of ctx carrying the address of the client that
+// sent the request.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// ClientIPFrom returns the client address stored in ctx, or an empty string.
+func ClientIPFrom(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+// BearerToken extracts the token from an authorization value of the form
+// "Bearer <token>". The scheme name is matched case insensitively.
+func BearerToken(value string) (string, error) {
+	scheme, token, ok := strings.Cut(strings.TrimSpace(value), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", errors.New("expected credentials of the form 'Bearer <token>'")
+	}
+
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", errors.New("bearer token is empty")
+	}
+	return token, nil
+}
@@ -0,0 +1,250 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/iamBelugaa/scim-gateway/internal/attribute"
+	"github.com/iamBelugaa/scim-gateway/internal/config"
+	"github.com/iamBelugaa/scim-gateway/pkg/logger"
+)
+
+// Authenticator validates the credentials presented to the gateway APIs.
+// Static service account tokens, TLS client certificates, JWTs from an
+// external identity provider, opaque tokens validated by an introspection
+// endpoint and access tokens issued by the gateway itself can be enabled
+// independently. Authenticated principals are then checked
+// against their policy before the request is handled.
+type Authenticator struct {
+	log      *logger.Logger
+	policies *Policies              // Operations granted to each principal.
+	acls     *attribute.ACLs        // Attributes each principal may see or change.
+	tokens   *ServiceTokens         // Static service account tokens.
+	certs    *CertificateIdentities // Client certificate identities.
+	jwt      []*JWTValidator        // JWT validators, one per trusted token issuer.
+	intro    *Introspector          // Introspector of opaque tokens, nil when disabled.
+	issuer   *Issuer                // The gateway's own token issuer, nil when disabled.
+}
+
+// NewWithConfig constructs an Authenticator from the auth configuration.
+func NewWithConfig(log *logger.Logger, cfg *config.Auth) (*Authenticator, error) {
+	a := &Authenticator{log: log}
+
+	tokens, err := NewServiceTokens(cfg.StaticTokens)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load static tokens : %w", err)
+	}
+	a.tokens = tokens
+
+	if a.policies, err = NewPolicies(cfg.Policies, cfg.PolicyDefault); err != nil {
+		return nil, fmt.Errorf("failed to load policies : %w", err)
+	}
+	if a.acls, err = attribute.NewACLs(cfg.AttributeACLs); err != nil {
+		return nil, fmt.Errorf("failed to load attribute acls : %w", err)
+	}
+
+	if a.certs, err = NewCertificateIdentities(cfg.CertIdentities); err != nil {
+		return nil, fmt.Errorf("failed to load certificate identities : %w", err)
+	}
+
+	if cfg.JWKSSource != "" {
+		if cfg.JWTIssuer == "" || cfg.JWTAudience == "" {
+			return nil, errors.New("jwt issuer and audience must be configured when a jwks is set")
+		}
+		keys := NewJWKS(cfg.JWKSSource, cfg.JWKSRefresh)
+		a.jwt = append(a.jwt, NewJWTValidator(keys, cfg.JWTIssuer, cfg.JWTAudience, cfg.JWTLeeway))
+	}
+
+	if a.intro, err = NewIntrospector(cfg); err != nil {
+		return nil, fmt.Errorf("failed to construct token introspector : %w", err)
+	}
+
+	if a.issuer, err = NewIssuer(log, cfg); err != nil {
+		return nil, fmt.Errorf("failed to construct oauth token issuer : %w", err)
+	}
+	if a.issuer != nil {
+		a.jwt = append(a.jwt, NewJWTValidator(a.issuer, a.issuer.Issuer(), a.issuer.Issuer(), cfg.JWTLeeway))
+	}
+
+	if !a.tokens.Enabled() && !a.certs.Enabled() && len(a.jwt) == 0 && a.intro == nil {
+		log.Warnw("no static tokens, certificate identities, jwks, introspection endpoint or oauth clients configured, every request will be rejected")
+	}
+	log.Infow(
+		"authentication schemes configured",
+		"static", a.tokens.Enabled(), "mtls", a.certs.Enabled(), "jwt", cfg.JWKSSource != "",
+		"introspection", a.intro != nil, "oauth2", a.issuer != nil,
+	)
+
+	return a, nil
+}
+
+// AuthenticateToken validates a static bearer token, already stripped of its
+// "Bearer" scheme prefix, and returns a context carrying the authenticated
+// principal. Requests without a token are authenticated by their verified TLS
+// client certificate instead, when one was presented. It returns an error
+// wrapping ErrUnauthorized when the credentials are missing, invalid, revoked
+// or expired, and ErrForbidden when the principal's policy does not grant the
+// operation declared in ctx. The returned context carries the principal in
+// the latter case.
+func (a *Authenticator) AuthenticateToken(ctx context.Context, token string) (context.Context, error) {
+	if token == "" {
+		if cert := ClientCertificateFrom(ctx); cert != nil {
+			return a.authenticateCertificate(ctx, cert)
+		}
+		return ctx, fmt.Errorf("%w : missing bearer token", ErrUnauthorized)
+	}
+	principal, err := a.tokens.Authenticate(ctx, token)
+	if err != nil {
+		return ctx, fmt.Errorf("%w : invalid bearer token", ErrUnauthorized)
+	}
+
+	ctx = WithPrincipal(ctx, principal)
+	return ctx, a.authorize(ctx, principal)
+}
+
+// authenticateCertificate maps a verified client certificate onto its
+// service account principal.
+func (a *Authenticator) authenticateCertificate(ctx context.Context, cert *x509.Certificate) (context.Context, error) {
+	principal, err := a.certs.Authenticate(ctx, cert)
+	if err != nil {
+		a.log.Infow("rejected client certificate", "subject", cert.Subject.String(), "serial", cert.SerialNumber.String())
+		return ctx, err
+	}
+
+	ctx = WithPrincipal(ctx, principal)
+	return ctx, a.authorize(ctx, principal)
+}
+
+// AuthenticateJWT validates a JWT bearer token, or an opaque token through the
+// introspection endpoint when no JWT validator accepts it, and checks it was
+// granted the required scopes. It returns a context carrying the authenticated principal,
+// or an error wrapping ErrUnauthorized when the token is missing or invalid,
+// ErrInsufficientScope when a scope is missing and ErrForbidden when the
+// principal's policy does not grant the operation declared in ctx.
+func (a *Authenticator) AuthenticateJWT(ctx context.Context, token string, requiredScopes []string) (context.Context, error) {
+	// Credentials already authenticated by another scheme only failed their
+	// policy check, so that decision stands rather than the credentials being
+	// rejected as an invalid JWT.
+	if principal, ok := PrincipalFrom(ctx); ok {
+		if op, ok := OperationFrom(ctx); ok {
+			return ctx, a.policies.Authorize(principal, op)
+		}
+		return ctx, nil
+	}
+
+	if token == "" {
+		return ctx, fmt.Errorf("%w : missing bearer token", ErrUnauthorized)
+	}
+
+	var principal *Principal
+	for _, validator := range a.jwt {
+		var err error
+		if principal, err = validator.Authenticate(ctx, token); err == nil {
+			break
+		}
+		a.log.Debugw("rejected jwt", "error", err)
+	}
+	if principal == nil && a.intro != nil {
+		var err error
+		if principal, err = a.intro.Authenticate(ctx, token); err != nil {
+			a.log.Debugw("rejected introspected token", "error", err)
+		}
+	}
+	if principal == nil {
+		return ctx, fmt.Errorf("%w : invalid bearer token", ErrUnauthorized)
+	}
+
+	if err := RequireScopes(principal, requiredScopes); err != nil {
+		return ctx, err
+	}
+
+	ctx = WithPrincipal(ctx, principal)
+	return ctx, a.authorize(ctx, principal)
+}
+
+// authorize checks the operation declared in ctx against the principal's
+// policy and logs denials. Requests that declare no operation are allowed.
+func (a *Authenticator) authorize(ctx context.Context, principal *Principal) error {
+	op, ok := OperationFrom(ctx)
+	if !ok {
+		return nil
+	}
+
+	if err := a.policies.Authorize(principal, op); err != nil {
+		a.log.Warnw(
+			"denied operation",
+			"principal", principal.Name, "scheme", principal.Scheme, "tenant", principal.TenantID(),
+			"operation", op.String(), "clientIp", ClientIPFrom(ctx),
+		)
+		return err
+	}
+	return nil
+}
+
+// Authorize checks an operation against the policy of the principal
+// authenticated in ctx, for requests such as SCIM Bulk requests that perform
+// several operations and so cannot declare a single one up front.
+func (a *Authenticator) Authorize(ctx context.Context, op Operation) error {
+	principal, ok := PrincipalFrom(ctx)
+	if !ok {
+		return fmt.Errorf("%w : no authenticated principal", ErrForbidden)
+	}
+	return a.authorize(WithOperation(ctx, op), principal)
+}
+
+// AttributeACL returns the attribute ACL of the principal authenticated in
+// ctx, nil when its attributes are unrestricted.
+func (a *Authenticator) AttributeACL(ctx context.Context) *attribute.ACL {
+	principal, ok := PrincipalFrom(ctx)
+	if !ok {
+		return nil
+	}
+	return a.acls.For(principal.Subject())
+}
+
+// Tokens returns the registry of static service account tokens.
+func (a *Authenticator) Tokens() *ServiceTokens {
+	return a.tokens
+}
+
+// Issuer returns the gateway's own OAuth token issuer, or nil when the token
+// endpoint is disabled.
+func (a *Authenticator) Issuer() *Issuer {
+	return a.issuer
+}
+
+// Scheme describes an authentication scheme as advertised in the SCIM
+// ServiceProviderConfig.
+type Scheme struct {
+	Type        string // Scheme type, e.g. "oauthbearertoken" or "oauth2".
+	Name        string // Human readable name of the scheme.
+	Description string // Description of the scheme.
+	SpecURI     string // Specification of the scheme.
+}
+
+// Schemes returns the authentication schemes enabled on the gateway, most
+// preferred first.
+func (a *Authenticator) Schemes() []Scheme {
+	var schemes []Scheme
+
+	if a.issuer != nil {
+		schemes = append(schemes, Scheme{
+			Type:        "oauth2",
+			Name:        "OAuth 2.0 Client Credentials",
+			Description: "Short lived access tokens issued by the gateway token endpoint at /oauth/token using the client credentials grant",
+			SpecURI:     "https://www.rfc-editor.org/info/rfc6749",
+		})
+	}
+
+	// Static tokens can be created at runtime through the admin API, so the
+	// bearer token scheme is always available.
+	schemes = append(schemes, Scheme{
+		Type:        "oauthbearertoken",
+		Name:        "OAuth Bearer Token",
+		Description: "Authentication scheme using the OAuth Bearer Token Standard",
+		SpecURI:     "https://www.rfc-editor.org/info/rfc6750",
+	})
+	return schemes
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"strings"
+)
+
+// SchemeClientCert identifies principals authenticated with a TLS client
+// certificate.
+const SchemeClientCert = "mtls"
+
+// certIdentity is the principal a client certificate identity maps to.
+type certIdentity struct {
+	serviceAccount string
+	tenant         string
+}
+
+// CertificateIdentities maps verified TLS client certificates onto service
+// account principals. Certificates are matched on their subject alternative
+// names first, then on their subject common name, so a certificate can only
+// be used if it has been explicitly registered.
+type CertificateIdentities struct {
+	identities map[string]certIdentity // Principals keyed by certificate identity.
+}
+
+// NewCertificateIdentities parses identity mappings of the form
+// "<certificate identity>:<service-account>:<tenant>". The identity itself may
+// contain colons, as URI SANs do, so the last two fields are split off.
+func NewCertificateIdentities(entries []string) (*CertificateIdentities, error) {
+	c := &CertificateIdentities{identities: make(map[string]certIdentity)}
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		rest, tenant, ok := cutLast(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("certificate identity %q must be of the form '<identity>:<service-account>:<tenant>'", entry)
+		}
+		identity, serviceAccount, ok := cutLast(rest, ":")
+		if !ok || identity == "" || serviceAccount == "" || strings.Contains(serviceAccount, "/") {
+			return nil, fmt.Errorf("certificate identity %q must be of the form '<identity>:<service-account>:<tenant>'", entry)
+		}
+
+		c.identities[identity] = certIdentity{serviceAccount: serviceAccount, tenant: tenant}
+	}
+	return c, nil
+}
+
+// Enabled reports whether any certificate identities are registered.
+func (c *CertificateIdentities) Enabled() bool {
+	return len(c.identities) > 0
+}
+
+// Authenticate maps a client certificate onto its registered principal. The
+// certificate must already have been verified against the trusted client CAs
+// during the TLS handshake.
+func (c *CertificateIdentities) Authenticate(ctx context.Context, cert *x509.Certificate) (*Principal, error) {
+	for _, identity := range certificateIdentities(cert) {
+		if mapped, ok := c.identities[identity]; ok {
+			return &Principal{
+				Name:         mapped.serviceAccount,
+				Scheme:       SchemeClientCert,
+				CredentialID: identity,
+				Tenant:       mapped.tenant,
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("%w : client certificate %q is not mapped to a service account", ErrUnauthorized, cert.Subject.CommonName)
+}
+
+// certificateIdentities lists the identities of a certificate in the order
+// they are matched.
+func certificateIdentities(cert *x509.Certificate) []string {
+	var identities []string
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+	identities = append(identities, cert.DNSNames...)
+	identities = append(identities, cert.EmailAddresses...)
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, cert.Subject.CommonName)
+	}
+	return identities
+}
+
+// cutLast slices s around the last instance of sep.
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// clientCertKey is the context key under which the verified client
+// certificate is stored.
+type clientCertKey struct{}
+
+// WithClientCertificate returns a copy of ctx carrying the verified TLS client
+// certificate of the connection.
+func WithClientCertificate(ctx context.Context, cert *x509.Certificate) context.Context {
+	return context.WithValue(ctx, clientCertKey{}, cert)
+}
+
+// ClientCertificateFrom returns the verified client certificate stored in
+// ctx, or nil.
+func ClientCertificateFrom(ctx context.Context) *x509.Certificate {
+	cert, _ := ctx.Value(clientCertKey{}).(*x509.Certificate)
+	return cert
+}
@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iamBelugaa/scim-gateway/internal/config"
+)
+
+// SchemeIntrospection identifies principals authenticated with an opaque
+// access token validated by an OAuth 2.0 introspection endpoint.
+const SchemeIntrospection = "introspection"
+
+// maxIntrospectionCacheSize bounds the number of cached introspection results,
+// so a flood of random tokens cannot grow the cache without limit.
+const maxIntrospectionCacheSize = 10000
+
+// introspectionResponse is the response of an introspection endpoint
+// (RFC 7662 section 2.2).
+type introspectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope"`     // Space separated scopes granted to the token.
+	ClientID  string `json:"client_id"` // Client the token was issued to.
+	Subject   string `json:"sub"`       // Subject of the token, used when no client id is returned.
+	TokenType string `json:"token_type"`
+	Exp       int64  `json:"exp"`       // Expiry of the token in seconds since the epoch, 0 when not returned.
+	TenantID  string `json:"tenant_id"` // Tenant the token is bound to, absent for the default tenant.
+}
+
+// introspectionResult is a cached introspection outcome. Inactive tokens are
+// cached with a nil principal.
+type introspectionResult struct {
+	principal *Principal
+	expiresAt time.Time
+}
+
+// Introspector authenticates opaque bearer tokens by calling an OAuth 2.0
+// token introspection endpoint (RFC 7662). Active and inactive results are
+// cached separately, so revoked tokens stop being accepted within the
+// positive TTL while invalid tokens do not reach the endpoint on every
+// request. Failures to reach the endpoint are never cached.
+type Introspector struct {
+	endpoint     string        // URL of the introspection endpoint.
+	clientID     string        // Client id the gateway authenticates to the endpoint with.
+	clientSecret string        // Client secret the gateway authenticates to the endpoint with.
+	positiveTTL  time.Duration // How long active tokens are cached, capped by their expiry.
+	negativeTTL  time.Duration // How long inactive tokens are cached.
+	client       *http.Client
+	now          func() time.Time
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]*introspectionResult // Results keyed by the SHA-256 of the token.
+}
+
+// NewIntrospector constructs an introspector from the auth configuration. It
+// returns a nil introspector when no introspection endpoint is configured.
+func NewIntrospector(cfg *config.Auth) (*Introspector, error) {
+	if cfg.IntrospectionURL == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(cfg.IntrospectionURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("introspection url %q must be an absolute http(s) url", cfg.IntrospectionURL)
+	}
+	if cfg.IntrospectionClientID == "" || cfg.IntrospectionClientSecret == "" {
+		return nil, errors.New("introspection client id and secret must be configured when an introspection url is set")
+	}
+
+	return &Introspector{
+		endpoint:     cfg.IntrospectionURL,
+		clientID:     cfg.IntrospectionClientID,
+		clientSecret: cfg.IntrospectionClientSecret,
+		positiveTTL:  cfg.IntrospectionCacheTTL,
+		negativeTTL:  cfg.IntrospectionNegativeCacheTTL,
+		client:       &http.Client{Timeout: time.Second * 10},
+		now:          time.Now,
+		cache:        make(map[[sha256.Size]byte]*introspectionResult),
+	}, nil
+}
+
+// Authenticate introspects the token and returns the principal it was issued
+// to. The principal is named after the client the token was issued to and
+// holds the scopes granted to the token. It returns an error wrapping
+// ErrUnauthorized when the token is inactive or cannot be introspected.
+func (i *Introspector) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	key := sha256.Sum256([]byte(token))
+
+	if result, ok := i.cached(key); ok {
+		if result.principal == nil {
+			return nil, fmt.Errorf("%w : inactive token", ErrUnauthorized)
+		}
+		return result.principal, nil
+	}
+
+	res, err := i.introspect(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("%w : failed to introspect token : %v", ErrUnauthorized, err)
+	}
+
+	now := i.now()
+	if !res.Active || (res.Exp != 0 && !now.Before(time.Unix(res.Exp, 0))) {
+		i.store(key, &introspectionResult{expiresAt: now.Add(i.negativeTTL)})
+		return nil, fmt.Errorf("%w : inactive token", ErrUnauthorized)
+	}
+
+	name := res.ClientID
+	if name == "" {
+		name = res.Subject
+	}
+	if name == "" {
+		return nil, fmt.Errorf("%w : introspection returned neither a client id nor a subject", ErrUnauthorized)
+	}
+
+	principal := &Principal{Name: name, Scheme: SchemeIntrospection, Scopes: strings.Fields(res.Scope), Tenant: res.TenantID}
+
+	// Active tokens are never cached past their own expiry.
+	expiresAt := now.Add(i.positiveTTL)
+	if res.Exp != 0 && time.Unix(res.Exp, 0).Before(expiresAt) {
+		expiresAt = time.Unix(res.Exp, 0)
+	}
+	i.store(key, &introspectionResult{principal: principal, expiresAt: expiresAt})
+
+	return principal, nil
+}
+
+// cached returns the unexpired cached result for a token.
+func (i *Introspector) cached(key [sha256.Size]byte) (*introspectionResult, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	result, ok := i.cache[key]
+	if !ok || !i.now().Before(result.expiresAt) {
+		return nil, false
+	}
+	return result, true
+}
+
+// store caches the result for a token. Expired results are evicted first
+// when the cache is full, and the whole cache is dropped if that is not
+// enough.
+func (i *Introspector) store(key [sha256.Size]byte, result *introspectionResult) {
+	if !i.now().Before(result.expiresAt) {
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if len(i.cache) >= maxIntrospectionCacheSize {
+		now := i.now()
+		for k, r := range i.cache {
+			if !now.Before(r.expiresAt) {
+				delete(i.cache, k)
+			}
+		}
+		if len(i.cache) >= maxIntrospectionCacheSize {
+			clear(i.cache)
+		}
+	}
+	i.cache[key] = result
+}
+
+// introspect posts the token to the introspection endpoint, authenticating
+// with the gateway's client credentials (RFC 7662 section 2.1).
+func (i *Introspector) introspect(ctx context.Context, token string) (*introspectionResponse, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(i.clientID), url.QueryEscape(i.clientSecret))
+
+	res, err := i.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d from introspection endpoint", res.StatusCode)
+	}
+
+	var body introspectionResponse
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode introspection response : %w", err)
+	}
+	return &body, nil
+}
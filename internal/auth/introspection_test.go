@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/iamBelugaa/scim-gateway/internal/config"
+	"github.com/iamBelugaa/scim-gateway/pkg/logger"
+)
+
+// stubIntrospection is a local introspection endpoint answering with the
+// response registered for each token and counting the requests it receives.
+type stubIntrospection struct {
+	*httptest.Server
+	responses map[string]map[string]any
+	calls     atomic.Int32
+}
+
+// newStubIntrospection starts an introspection endpoint that only accepts the
+// gateway's client credentials.
+func newStubIntrospection(t *testing.T, responses map[string]map[string]any) *stubIntrospection {
+	t.Helper()
+
+	stub := &stubIntrospection{responses: responses}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stub.calls.Add(1)
+
+		if id, secret, ok := r.BasicAuth(); !ok || id != "gateway" || secret != "gateway-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost || r.PostFormValue("token_type_hint") != "access_token" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		res, ok := stub.responses[r.PostFormValue("token")]
+		if !ok {
+			res = map[string]any{"active": false}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(res)
+	}))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+// newIntrospectionConfig returns an auth configuration introspecting tokens
+// at the stub endpoint.
+func newIntrospectionConfig(stub *stubIntrospection) *config.Auth {
+	return &config.Auth{
+		IntrospectionURL:              stub.URL,
+		IntrospectionClientID:         "gateway",
+		IntrospectionClientSecret:     "gateway-secret",
+		IntrospectionCacheTTL:         time.Minute,
+		IntrospectionNegativeCacheTTL: time.Second * 10,
+	}
+}
+
+// TestIntrospectorAuthenticate checks active tokens are mapped onto a
+// principal and inactive or expired tokens are rejected.
+func TestIntrospectorAuthenticate(t *testing.T) {
+	stub := newStubIntrospection(t, map[string]map[string]any{
+		"okta-token": {
+			"active": true, "client_id": "okta", "sub": "ignored", "scope": "scim:read scim:write",
+			"tenant_id": "acme", "exp": time.Now().Add(time.Hour).Unix(),
+		},
+		"subject-token": {"active": true, "sub": "hr-sync"},
+		"expired-token": {"active": true, "client_id": "okta", "exp": time.Now().Add(-time.Minute).Unix()},
+	})
+
+	intro, err := NewIntrospector(newIntrospectionConfig(stub))
+	if err != nil {
+		t.Fatalf("failed to construct introspector : %v", err)
+	}
+
+	principal, err := intro.Authenticate(context.Background(), "okta-token")
+	if err != nil {
+		t.Fatalf("okta-token : unexpected error %v", err)
+	}
+	if principal.Name != "okta" || principal.Scheme != SchemeIntrospection || principal.Tenant != "acme" {
+		t.Errorf("okta-token : got principal %+v", principal)
+	}
+	if err := RequireScopes(principal, []string{"scim:read", "scim:write"}); err != nil {
+		t.Errorf("okta-token : %v", err)
+	}
+
+	if principal, err := intro.Authenticate(context.Background(), "subject-token"); err != nil || principal.Name != "hr-sync" {
+		t.Errorf("subject-token : got principal %+v and error %v, want hr-sync", principal, err)
+	}
+
+	for _, token := range []string{"expired-token", "unknown-token"} {
+		if _, err := intro.Authenticate(context.Background(), token); !errors.Is(err, ErrUnauthorized) {
+			t.Errorf("%s : got error %v, want %v", token, err, ErrUnauthorized)
+		}
+	}
+}
+
+// TestIntrospectorCachesResults checks active and inactive results are served
+// from the cache until their TTL elapses, and active tokens are never cached
+// past their expiry.
+func TestIntrospectorCachesResults(t *testing.T) {
+	now := time.Now()
+	stub := newStubIntrospection(t, map[string]map[string]any{
+		"okta-token":     {"active": true, "client_id": "okta"},
+		"expiring-token": {"active": true, "client_id": "okta", "exp": now.Add(time.Second * 30).Unix()},
+	})
+
+	intro, err := NewIntrospector(newIntrospectionConfig(stub))
+	if err != nil {
+		t.Fatalf("failed to construct introspector : %v", err)
+	}
+	intro.now = func() time.Time { return now }
+
+	authenticate := func(token string, wantCalls int32) {
+		t.Helper()
+		_, _ = intro.Authenticate(context.Background(), token)
+		if got := stub.calls.Load(); got != wantCalls {
+			t.Fatalf("%s at %s : got %d introspection calls, want %d", token, now.Format(time.TimeOnly), got, wantCalls)
+		}
+	}
+
+	authenticate("okta-token", 1)
+	authenticate("okta-token", 1)
+	authenticate("unknown-token", 2)
+	authenticate("unknown-token", 2)
+	authenticate("expiring-token", 3)
+
+	now = now.Add(time.Second * 11)
+	authenticate("okta-token", 3)
+	authenticate("unknown-token", 4)
+
+	now = now.Add(time.Second * 20)
+	authenticate("expiring-token", 5)
+
+	now = now.Add(time.Minute)
+	authenticate("okta-token", 6)
+}
+
+// TestIntrospectorDoesNotCacheFailures checks an unreachable or misbehaving
+// endpoint rejects tokens without caching the outcome.
+func TestIntrospectorDoesNotCacheFailures(t *testing.T) {
+	stub := newStubIntrospection(t, map[string]map[string]any{"okta-token": {"active": true, "client_id": "okta"}})
+
+	cfg := newIntrospectionConfig(stub)
+	cfg.IntrospectionClientSecret = "wrong-secret"
+	intro, err := NewIntrospector(cfg)
+	if err != nil {
+		t.Fatalf("failed to construct introspector : %v", err)
+	}
+
+	for range 2 {
+		if _, err := intro.Authenticate(context.Background(), "okta-token"); !errors.Is(err, ErrUnauthorized) {
+			t.Fatalf("got error %v, want %v", err, ErrUnauthorized)
+		}
+	}
+	if got := stub.calls.Load(); got != 2 {
+		t.Errorf("got %d introspection calls, want 2", got)
+	}
+}
+
+// TestAuthenticateJWTIntrospectsOpaqueTokens checks opaque tokens are
+// authenticated through the introspection endpoint, with their scopes and
+// client id checked against the required scopes and policies.
+func TestAuthenticateJWTIntrospectsOpaqueTokens(t *testing.T) {
+	stub := newStubIntrospection(t, map[string]map[string]any{
+		"okta-token":   {"active": true, "client_id": "okta", "scope": "scim:read"},
+		"reader-token": {"active": true, "client_id": "reader", "scope": "scim:read scim:write"},
+	})
+
+	cfg := newIntrospectionConfig(stub)
+	cfg.Policies = []string{"default/introspection/reader:*:read"}
+	a, err := NewWithConfig(&logger.Logger{SugaredLogger: zap.NewNop().Sugar()}, cfg)
+	if err != nil {
+		t.Fatalf("failed to construct authenticator : %v", err)
+	}
+
+	ctx, err := a.AuthenticateJWT(context.Background(), "okta-token", []string{"scim:read"})
+	if err != nil {
+		t.Fatalf("okta-token : unexpected error %v", err)
+	}
+	if principal, _ := PrincipalFrom(ctx); principal.Name != "okta" {
+		t.Errorf("okta-token : got principal %+v", principal)
+	}
+
+	if _, err := a.AuthenticateJWT(context.Background(), "okta-token", []string{"scim:write"}); !errors.Is(err, ErrInsufficientScope) {
+		t.Errorf("okta-token write : got error %v, want %v", err, ErrInsufficientScope)
+	}
+
+	ctx = WithOperation(context.Background(), Operation{Resource: "User", Action: ActionDelete})
+	if _, err := a.AuthenticateJWT(ctx, "reader-token", []string{"scim:write"}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("reader-token delete : got error %v, want %v", err, ErrForbidden)
+	}
+
+	if _, err := a.AuthenticateJWT(context.Background(), "unknown-token", nil); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("unknown-token : got error %v, want %v", err, ErrUnauthorized)
+	}
+}
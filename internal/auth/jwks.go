@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minJWKSRefreshInterval limits how often an unknown key id can force the key
+// set to be fetched again, so forged tokens cannot hammer the key source.
+const minJWKSRefreshInterval = time.Minute
+
+// jsonWebKey is a single key of a JSON Web Key Set (RFC 7517).
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// JWKS holds the public keys of a JSON Web Key Set loaded from a file or URL.
+// Keys are cached and refreshed periodically, and immediately when a token is
+// signed with a key id that is not in the cache, to follow key rotation.
+type JWKS struct {
+	source      string        // File path or http(s) URL of the key set.
+	client      *http.Client  // Client used to fetch key sets from a URL.
+	refresh     time.Duration // How long a fetched key set is used before being fetched again.
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+}
+
+// NewJWKS constructs a key set that loads keys from source, which is either a
+// file path or an http(s) URL.
+func NewJWKS(source string, refresh time.Duration) *JWKS {
+	return &JWKS{
+		source:  source,
+		refresh: refresh,
+		client:  &http.Client{Timeout: time.Second * 10},
+	}
+}
+
+// Key returns the public key with the given key id. An empty kid matches the
+// key set when it holds exactly one key.
+func (j *JWKS) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	stale := j.keys == nil || now.Sub(j.fetchedAt) >= j.refresh
+
+	if key, ok := j.lookup(kid); ok && !stale {
+		return key, nil
+	}
+
+	// Refresh when the cache is stale or the key is unknown, but never more
+	// often than the minimum refresh interval.
+	if j.keys == nil || now.Sub(j.attemptedAt) >= minJWKSRefreshInterval {
+		j.attemptedAt = now
+
+		keys, err := j.load(ctx)
+		if err != nil && j.keys == nil {
+			return nil, fmt.Errorf("failed to load key set : %w", err)
+		}
+		if err == nil {
+			j.keys = keys
+			j.fetchedAt = now
+		}
+	}
+
+	if key, ok := j.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("no key with id %q in key set", kid)
+}
+
+// lookup finds a key in the cached key set. Callers must hold the lock.
+func (j *JWKS) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, true
+		}
+	}
+
+	key, ok := j.keys[kid]
+	return key, ok
+}
+
+// load reads and parses the key set from its source.
+func (j *JWKS) load(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	var data []byte
+	var err error
+
+	if strings.HasPrefix(j.source, "http://") || strings.HasPrefix(j.source, "https://") {
+		data, err = j.fetch(ctx)
+	} else {
+		data, err = os.ReadFile(j.source)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseJWKS(data)
+}
+
+// fetch downloads the key set from its URL.
+func (j *JWKS) fetch(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.source, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := j.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d fetching key set", res.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(res.Body, 1<<20))
+}
+
+// ParseJWKS parses a JSON Web Key Set document into public keys keyed by key
+// id. Keys of unsupported types or intended for encryption are skipped.
+func ParseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to decode key set : %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse key %q : %w", jwk.Kid, err)
+		}
+		if key != nil {
+			keys[jwk.Kid] = key
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("key set contains no signing keys")
+	}
+	return keys, nil
+}
+
+// publicKey converts the JWK into a public key. It returns a nil key for key
+// types that are not supported.
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+
+	default:
+		return nil, nil
+	}
+}
+
+// decodeBigInt decodes a base64url encoded unsigned big-endian integer.
+func decodeBigInt(encoded string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("invalid base64url encoded integer")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SchemeJWT identifies principals authenticated with a JSON Web Token.
+const SchemeJWT = "jwt"
+
+// ErrInsufficientScope is returned when a valid token lacks a required scope.
+var ErrInsufficientScope = errors.New("insufficient scope")
+
+// jwtSigningMethods lists the asymmetric algorithms accepted for tokens.
+// Symmetric algorithms are rejected since keys come from a public key set.
+var jwtSigningMethods = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+// jwtClaims are the claims read from an access token.
+type jwtClaims struct {
+	jwt.RegisteredClaims
+	Scope    string `json:"scope"`               // Space separated scopes (RFC 8693).
+	Scp      any    `json:"scp"`                 // Scopes as issued by some providers, a list or a string.
+	ClientID string `json:"client_id"`           // Client the token was issued to (RFC 9068).
+	Azp      string `json:"azp"`                 // Authorized party, used as client id by some providers.
+	TenantID string `json:"tenant_id,omitempty"` // Tenant the token is bound to, absent for the default tenant.
+}
+
+// scopes returns the scopes granted by the token.
+func (c *jwtClaims) scopes() []string {
+	scopes := strings.Fields(c.Scope)
+
+	switch scp := c.Scp.(type) {
+	case string:
+		scopes = append(scopes, strings.Fields(scp)...)
+	case []any:
+		for _, s := range scp {
+			if str, ok := s.(string); ok {
+				scopes = append(scopes, str)
+			}
+		}
+	}
+	return scopes
+}
+
+// KeySet resolves the public key a token was signed with from its key id.
+type KeySet interface {
+	Key(ctx context.Context, kid string) (crypto.PublicKey, error)
+}
+
+// JWTValidator authenticates signed JSON Web Tokens against a key set and the
+// expected issuer and audience.
+type JWTValidator struct {
+	keys   KeySet
+	parser *jwt.Parser
+}
+
+// NewJWTValidator constructs a validator accepting tokens signed by one of the
+// keys in the key set, issued by issuer for audience.
+func NewJWTValidator(keys KeySet, issuer, audience string, leeway time.Duration) *JWTValidator {
+	return &JWTValidator{
+		keys: keys,
+		parser: jwt.NewParser(
+			jwt.WithValidMethods(jwtSigningMethods),
+			jwt.WithIssuer(issuer),
+			jwt.WithAudience(audience),
+			jwt.WithExpirationRequired(),
+			jwt.WithIssuedAt(),
+			jwt.WithLeeway(leeway),
+		),
+	}
+}
+
+// Authenticate validates the token signature, iss, aud, exp and nbf claims and
+// returns the principal the token was issued to.
+func (v *JWTValidator) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	claims := &jwtClaims{}
+
+	_, err := v.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.keys.Key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w : %v", ErrUnauthorized, err)
+	}
+
+	// Client credential tokens may carry the client only in client_id or azp.
+	name := claims.Subject
+	if name == "" {
+		name = claims.ClientID
+	}
+	if name == "" {
+		name = claims.Azp
+	}
+
+	return &Principal{Name: name, Scheme: SchemeJWT, Scopes: claims.scopes(), Tenant: claims.TenantID}, nil
+}
+
+// RequireScopes returns ErrInsufficientScope unless the principal was granted
+// every required scope.
+func RequireScopes(principal *Principal, required []string) error {
+	for _, scope := range required {
+		if !slices.Contains(principal.Scopes, scope) {
+			return fmt.Errorf("%w : %q is required", ErrInsufficientScope, scope)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,355 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"github.com/iamBelugaa/scim-gateway/internal/config"
+	"github.com/iamBelugaa/scim-gateway/pkg/logger"
+)
+
+// SchemeOAuth identifies principals authenticated with an access token issued
+// by the gateway's own token endpoint.
+const SchemeOAuth = "oauth2"
+
+// oauthClient is a service account client registered for the client
+// credentials grant.
+type oauthClient struct {
+	id         string
+	secretHash []byte   // SHA-256 of the client secret.
+	scopes     []string // Scopes the client may request.
+	tenant     string   // Tenant issued tokens are bound to, empty for the default tenant.
+}
+
+// Issuer implements the OAuth 2.0 client credentials grant (RFC 6749 section
+// 4.4) for registered service account clients. It issues short lived JWTs
+// signed with the gateway's own key and publishes the matching public key as
+// a JWKS.
+type Issuer struct {
+	log      *logger.Logger
+	issuer   string                  // Value of the iss and aud claims of issued tokens.
+	lifetime time.Duration           // Lifetime of issued tokens.
+	clients  map[string]*oauthClient // Registered clients keyed by client id.
+	key      crypto.Signer           // Private key used to sign tokens.
+	kid      string                  // Key id of the signing key.
+	method   jwt.SigningMethod       // Signing algorithm matching the key.
+	jwks     []byte                  // Pre-rendered JWKS document of the public key.
+}
+
+// NewIssuer constructs a token issuer from the auth configuration. It returns
+// a nil issuer when no clients are registered.
+func NewIssuer(log *logger.Logger, cfg *config.Auth) (*Issuer, error) {
+	clients, err := parseOAuthClients(cfg.OAuthClients, cfg.OAuthClientTenants)
+	if err != nil {
+		return nil, err
+	}
+	if len(clients) == 0 {
+		return nil, nil
+	}
+
+	key, err := loadSigningKey(log, cfg.OAuthSigningKey)
+	if err != nil {
+		return nil, err
+	}
+
+	i := &Issuer{
+		log:      log,
+		issuer:   cfg.OAuthIssuer,
+		lifetime: cfg.OAuthTokenLifetime,
+		clients:  clients,
+		key:      key,
+	}
+
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		i.method = jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		i.method = jwt.SigningMethodES256
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode signing key : %w", err)
+	}
+	thumbprint := sha256.Sum256(der)
+	i.kid = base64.RawURLEncoding.EncodeToString(thumbprint[:12])
+
+	if i.jwks, err = renderJWKS(key.Public(), i.kid, i.method.Alg()); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// Issuer returns the issuer and audience of the tokens issued.
+func (i *Issuer) Issuer() string {
+	return i.issuer
+}
+
+// Key implements KeySet for tokens issued by the gateway itself.
+func (i *Issuer) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	if kid != i.kid {
+		return nil, fmt.Errorf("no key with id %q", kid)
+	}
+	return i.key.Public(), nil
+}
+
+// tokenResponse is the successful access token response (RFC 6749 section 5.1).
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+// tokenError is the error response of the token endpoint (RFC 6749 section 5.2).
+type tokenError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// ServeToken handles requests to the token endpoint. Clients authenticate
+// with HTTP Basic authentication or with client_id and client_secret form
+// parameters.
+func (i *Issuer) ServeToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeTokenError(w, http.StatusMethodNotAllowed, "invalid_request", "token requests must use POST")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<16)
+	if err := r.ParseForm(); err != nil {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request", "request body must be form encoded")
+		return
+	}
+
+	if grantType := r.PostForm.Get("grant_type"); grantType != "client_credentials" {
+		writeTokenError(w, http.StatusBadRequest, "unsupported_grant_type", "only the client_credentials grant is supported")
+		return
+	}
+
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	client, ok := i.authenticateClient(clientID, secret)
+	if !ok {
+		i.log.Infow("rejected token request", "clientId", clientID)
+		w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		writeTokenError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+
+	scopes := client.scopes
+	if requested := strings.Fields(r.PostForm.Get("scope")); len(requested) > 0 {
+		for _, scope := range requested {
+			if !slices.Contains(client.scopes, scope) {
+				writeTokenError(w, http.StatusBadRequest, "invalid_scope", fmt.Sprintf("scope %q is not allowed for this client", scope))
+				return
+			}
+		}
+		scopes = requested
+	}
+
+	token, err := i.issue(client, scopes)
+	if err != nil {
+		i.log.Errorw("failed to sign access token", "clientId", client.id, "error", err)
+		writeTokenError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	json.NewEncoder(w).Encode(tokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(i.lifetime / time.Second),
+		Scope:       strings.Join(scopes, " "),
+	})
+}
+
+// ServeJWKS publishes the public key tokens are signed with.
+func (i *Issuer) ServeJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write(i.jwks)
+}
+
+// authenticateClient checks the client credentials. The secret is compared
+// in constant time, including for unknown clients.
+func (i *Issuer) authenticateClient(clientID, secret string) (*oauthClient, bool) {
+	hash := sha256.Sum256([]byte(secret))
+
+	client, ok := i.clients[clientID]
+	if !ok {
+		subtle.ConstantTimeCompare(hash[:], hash[:])
+		return nil, false
+	}
+	return client, subtle.ConstantTimeCompare(hash[:], client.secretHash) == 1
+}
+
+// issue signs an access token for the client with the granted scopes.
+func (i *Issuer) issue(client *oauthClient, scopes []string) (string, error) {
+	now := time.Now()
+
+	token := jwt.NewWithClaims(i.method, &jwtClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Issuer:    i.issuer,
+			Subject:   client.id,
+			Audience:  jwt.ClaimStrings{i.issuer},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(i.lifetime)),
+		},
+		Scope:    strings.Join(scopes, " "),
+		ClientID: client.id,
+		TenantID: client.tenant,
+	})
+	token.Header["kid"] = i.kid
+
+	return token.SignedString(i.key)
+}
+
+// writeTokenError writes an error response of the token endpoint.
+func writeTokenError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(tokenError{Error: code, Description: description})
+}
+
+// parseOAuthClients parses client registrations of the form
+// "<client-id>:<hex encoded SHA-256 of the secret>:<space separated scopes>"
+// and assigns them the tenants listed as "<client-id>:<tenant>".
+func parseOAuthClients(entries, tenants []string) (map[string]*oauthClient, error) {
+	clients := make(map[string]*oauthClient)
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, errors.New("oauth client entry must be of the form '<client-id>:<sha256-hex>:<scopes>'")
+		}
+
+		hash, err := hex.DecodeString(parts[1])
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("oauth client secret hash for %q must be a hex encoded SHA-256 digest", parts[0])
+		}
+
+		clients[parts[0]] = &oauthClient{id: parts[0], secretHash: hash, scopes: strings.Fields(parts[2])}
+	}
+
+	for _, entry := range tenants {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		clientID, tenant, ok := strings.Cut(entry, ":")
+		if !ok || tenant == "" {
+			return nil, fmt.Errorf("oauth client tenant %q must be of the form '<client-id>:<tenant>'", entry)
+		}
+		client, ok := clients[clientID]
+		if !ok {
+			return nil, fmt.Errorf("oauth client tenant %q refers to an unknown client", entry)
+		}
+		client.tenant = tenant
+	}
+	return clients, nil
+}
+
+// loadSigningKey reads a PEM encoded RSA or ECDSA P-256 private key. When no
+// key file is configured an ephemeral key is generated, which invalidates
+// every issued token on restart.
+func loadSigningKey(log *logger.Logger, path string) (crypto.Signer, error) {
+	if path == "" {
+		log.Warnw("no oauth signing key configured, generating an ephemeral key")
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read oauth signing key : %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("oauth signing key is not PEM encoded")
+	}
+
+	var key any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse oauth signing key : %w", err)
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, errors.New("oauth signing key must use the P-256 curve")
+		}
+		return k, nil
+	default:
+		return nil, errors.New("oauth signing key must be an RSA or ECDSA key")
+	}
+}
+
+// renderJWKS renders a JWKS document holding a single public key.
+func renderJWKS(public crypto.PublicKey, kid, alg string) ([]byte, error) {
+	jwk := map[string]string{"kid": kid, "use": "sig", "alg": alg}
+
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		jwk["kty"] = "RSA"
+		jwk["n"] = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+		jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		jwk["kty"] = "EC"
+		jwk["crv"] = k.Curve.Params().Name
+		jwk["x"] = base64.RawURLEncoding.EncodeToString(k.X.FillBytes(make([]byte, size)))
+		jwk["y"] = base64.RawURLEncoding.EncodeToString(k.Y.FillBytes(make([]byte, size)))
+	default:
+		return nil, errors.New("unsupported signing key type")
+	}
+
+	return json.Marshal(map[string]any{"keys": []any{jwk}})
+}
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	goa "goa.design/goa/v3/pkg"
+)
+
+// ErrForbidden is returned when a principal's policy does not grant the
+// operation a request performs.
+var ErrForbidden = errors.New("operation not permitted")
+
+// Action is something a principal can do to a resource type.
+type Action string
+
+// Supported actions. The bulk action grants creating, updating and deleting
+// resources of a type through SCIM Bulk requests, and nothing outside them.
+const (
+	ActionCreate Action = "create"
+	ActionRead   Action = "read"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+	ActionBulk   Action = "bulk"
+)
+
+// ResourceToken is the resource type of the service account tokens managed
+// through the admin API, next to the SCIM resource types.
+const ResourceToken = "Token"
+
+// ResourceDeadLetter is the resource type of the changes connectors failed
+// to provision, managed through the admin API.
+const ResourceDeadLetter = "DeadLetter"
+
+// ResourceReconciliation is the resource type of the comparisons of the
+// gateway with the downstream systems of connectors, run through the admin
+// API.
+const ResourceReconciliation = "Reconciliation"
+
+// wildcard matches every resource type or every action in a policy entry.
+const wildcard = "*"
+
+// Operation is an action performed on a resource type.
+type Operation struct {
+	Resource string // Resource type, e.g. "User" or "Group".
+	Action   Action // Action performed on the resource type.
+}
+
+// String returns the operation in the form "<action> <resource>".
+func (o Operation) String() string {
+	return string(o.Action) + " " + o.Resource
+}
+
+// operationKey is the context key under which the operation a request
+// performs is stored.
+type operationKey struct{}
+
+// WithOperation returns a copy of ctx declaring the operation the request
+// performs, to be checked against the caller's policy once it is
+// authenticated.
+func WithOperation(ctx context.Context, op Operation) context.Context {
+	return context.WithValue(ctx, operationKey{}, op)
+}
+
+// OperationFrom returns the operation declared in ctx, if any.
+func OperationFrom(ctx context.Context) (Operation, bool) {
+	op, ok := ctx.Value(operationKey{}).(Operation)
+	return op, ok
+}
+
+// Policy lists the actions granted to a principal per resource type.
+type Policy struct {
+	grants map[string]map[Action]bool // Granted actions keyed by resource type, "*" matching any type.
+}
+
+// Allows reports whether the policy grants the operation.
+func (p *Policy) Allows(op Operation) bool {
+	for _, resource := range []string{op.Resource, wildcard} {
+		actions := p.grants[resource]
+		if actions[op.Action] || actions[wildcard] {
+			return true
+		}
+	}
+	return false
+}
+
+// Policies holds the policy of every principal. Principals without a policy
+// get full access, unless policies default to deny.
+type Policies struct {
+	policies    map[string]*Policy // Policies keyed by principal subject.
+	defaultDeny bool               // Whether principals without a policy are denied every operation.
+}
+
+// NewPolicies parses policy entries of the form
+// "<subject>:<resource type>:<space separated actions>", where the subject
+// is "<tenant>/<service-account>", or "<tenant>/<scheme>/<name>" for
+// principals authenticated by tokens such as JWTs, as returned by
+// Principal.Subject. The resource type and the actions may be "*" to match
+// anything. Entries for the same subject accumulate. The default is either
+// "allow" or "deny" and applies to principals without any entry.
+func NewPolicies(entries []string, defaultPolicy string) (*Policies, error) {
+	p := &Policies{policies: make(map[string]*Policy)}
+
+	switch defaultPolicy {
+	case "", "allow":
+	case "deny":
+		p.defaultDeny = true
+	default:
+		return nil, fmt.Errorf("default policy %q must be either 'allow' or 'deny'", defaultPolicy)
+	}
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || !validSubject(parts[0]) || parts[1] == "" {
+			return nil, fmt.Errorf("policy entry %q must be of the form '<tenant>/<service-account>:<resource type>:<actions>'", entry)
+		}
+		subject, resource, actions := parts[0], parts[1], strings.Fields(parts[2])
+		if len(actions) == 0 {
+			return nil, fmt.Errorf("policy entry %q grants no actions", entry)
+		}
+
+		policy, ok := p.policies[subject]
+		if !ok {
+			policy = &Policy{grants: make(map[string]map[Action]bool)}
+			p.policies[subject] = policy
+		}
+		if policy.grants[resource] == nil {
+			policy.grants[resource] = make(map[Action]bool)
+		}
+
+		for _, action := range actions {
+			switch Action(action) {
+			case ActionCreate, ActionRead, ActionUpdate, ActionDelete, ActionBulk, wildcard:
+				policy.grants[resource][Action(action)] = true
+			default:
+				return nil, fmt.Errorf("policy entry %q grants unknown action %q", entry, action)
+			}
+		}
+	}
+	return p, nil
+}
+
+// Authorize returns ErrForbidden unless the principal is allowed to perform
+// the operation.
+func (p *Policies) Authorize(principal *Principal, op Operation) error {
+	subject := principal.Subject()
+
+	policy, ok := p.policies[subject]
+	if !ok {
+		if p.defaultDeny {
+			return fmt.Errorf("%w : %q has no policy", ErrForbidden, subject)
+		}
+		return nil
+	}
+
+	if !policy.Allows(op) {
+		return fmt.Errorf("%w : %q may not %s", ErrForbidden, subject, op)
+	}
+	return nil
+}
+
+// validSubject reports whether a policy or ACL entry names its principal by
+// tenant and name.
+func validSubject(subject string) bool {
+	tenant, name, ok := strings.Cut(subject, "/")
+	return ok && tenant != "" && name != ""
+}
+
+// OperationResolver returns the operation a service method performs given its
+// payload. It returns false for methods that are open to every authenticated
+// caller.
+type OperationResolver func(method string, payload any) (Operation, bool)
+
+// DeclareOperations returns an endpoint middleware that declares the
+// operation each request performs, so it is checked against the caller's
+// policy as soon as the caller is authenticated and before the service method
+// runs.
+func DeclareOperations(resolve OperationResolver) func(goa.Endpoint) goa.Endpoint {
+	return func(next goa.Endpoint) goa.Endpoint {
+		return func(ctx context.Context, req any) (any, error) {
+			method, _ := ctx.Value(goa.MethodKey).(string)
+			if op, ok := resolve(method, req); ok {
+				ctx = WithOperation(ctx, op)
+			}
+			return next(ctx, req)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/iamBelugaa/scim-gateway/internal/config"
+	"github.com/iamBelugaa/scim-gateway/pkg/logger"
+)
+
+// TestPoliciesAuthorize checks operations against per service account
+// policies, including wildcards and the default for unlisted accounts.
+func TestPoliciesAuthorize(t *testing.T) {
+	policies, err := NewPolicies([]string{
+		"default/okta:User:create read update delete",
+		"default/okta:Group:read",
+		"default/hr-sync:*:bulk",
+		"default/auditor:*:read",
+		"default/operator:User:*",
+	}, "allow")
+	if err != nil {
+		t.Fatalf("failed to parse policies : %v", err)
+	}
+
+	tests := []struct {
+		principal string
+		op        Operation
+		allowed   bool
+	}{
+		{principal: "okta", op: Operation{Resource: "User", Action: ActionDelete}, allowed: true},
+		{principal: "okta", op: Operation{Resource: "Group", Action: ActionRead}, allowed: true},
+		{principal: "okta", op: Operation{Resource: "Group", Action: ActionUpdate}},
+		{principal: "okta", op: Operation{Resource: "User", Action: ActionBulk}},
+		{principal: "hr-sync", op: Operation{Resource: "Group", Action: ActionBulk}, allowed: true},
+		{principal: "hr-sync", op: Operation{Resource: "User", Action: ActionCreate}},
+		{principal: "auditor", op: Operation{Resource: ResourceToken, Action: ActionRead}, allowed: true},
+		{principal: "auditor", op: Operation{Resource: "User", Action: ActionUpdate}},
+		{principal: "operator", op: Operation{Resource: "User", Action: ActionDelete}, allowed: true},
+		{principal: "operator", op: Operation{Resource: "Group", Action: ActionRead}},
+		{principal: "unlisted", op: Operation{Resource: "Group", Action: ActionDelete}, allowed: true},
+	}
+
+	for _, tt := range tests {
+		err := policies.Authorize(&Principal{Name: tt.principal, Scheme: SchemeStaticToken}, tt.op)
+		if tt.allowed && err != nil {
+			t.Errorf("%s %s : unexpected error %v", tt.principal, tt.op, err)
+		}
+		if !tt.allowed && !errors.Is(err, ErrForbidden) {
+			t.Errorf("%s %s : got error %v, want %v", tt.principal, tt.op, err, ErrForbidden)
+		}
+	}
+}
+
+// TestPoliciesDefaultDeny checks service accounts without a policy are denied
+// when policies default to deny.
+func TestPoliciesDefaultDeny(t *testing.T) {
+	policies, err := NewPolicies([]string{"default/okta:User:read"}, "deny")
+	if err != nil {
+		t.Fatalf("failed to parse policies : %v", err)
+	}
+
+	op := Operation{Resource: "User", Action: ActionRead}
+	if err := policies.Authorize(&Principal{Name: "okta", Scheme: SchemeStaticToken}, op); err != nil {
+		t.Errorf("okta : unexpected error %v", err)
+	}
+	if err := policies.Authorize(&Principal{Name: "unlisted", Scheme: SchemeStaticToken}, op); !errors.Is(err, ErrForbidden) {
+		t.Errorf("unlisted : got error %v, want %v", err, ErrForbidden)
+	}
+}
+
+// TestPoliciesAreScopedByTenantAndScheme checks a policy only applies to the
+// service account of its own tenant, and never to a token whose subject
+// happens to match the account name.
+func TestPoliciesAreScopedByTenantAndScheme(t *testing.T) {
+	policies, err := NewPolicies([]string{
+		"acme/okta:*:*",
+		"globex/okta:User:read",
+		"globex/jwt/hr-sync:Group:read",
+	}, "deny")
+	if err != nil {
+		t.Fatalf("failed to parse policies : %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		principal *Principal
+		allowed   bool
+	}{
+		{name: "acme account", principal: &Principal{Name: "okta", Scheme: SchemeStaticToken, Tenant: "acme"}, allowed: true},
+		{name: "globex account", principal: &Principal{Name: "okta", Scheme: SchemeClientCert, Tenant: "globex"}},
+		{name: "default tenant account", principal: &Principal{Name: "okta", Scheme: SchemeStaticToken}},
+		{name: "jwt subject named like an account", principal: &Principal{Name: "okta", Scheme: SchemeJWT, Tenant: "acme"}},
+		{name: "introspected subject", principal: &Principal{Name: "hr-sync", Scheme: SchemeIntrospection, Tenant: "globex"}},
+	}
+
+	op := Operation{Resource: "User", Action: ActionDelete}
+	for _, tt := range tests {
+		err := policies.Authorize(tt.principal, op)
+		if tt.allowed && err != nil {
+			t.Errorf("%s : unexpected error %v", tt.name, err)
+		}
+		if !tt.allowed && !errors.Is(err, ErrForbidden) {
+			t.Errorf("%s : got error %v, want %v", tt.name, err, ErrForbidden)
+		}
+	}
+
+	jwt := &Principal{Name: "hr-sync", Scheme: SchemeJWT, Tenant: "globex"}
+	if err := policies.Authorize(jwt, Operation{Resource: "Group", Action: ActionRead}); err != nil {
+		t.Errorf("jwt subject : unexpected error %v", err)
+	}
+}
+
+// TestNewPoliciesRejectsInvalidEntries checks malformed policies are reported
+// at startup.
+func TestNewPoliciesRejectsInvalidEntries(t *testing.T) {
+	for _, entry := range []string{
+		"default/okta", "default/okta:User", "default/okta:User:", ":User:read", "default/okta:User:write",
+		"okta:User:read", "/okta:User:read", "default/:User:read",
+	} {
+		if _, err := NewPolicies([]string{entry}, "allow"); err == nil {
+			t.Errorf("%q : expected an error", entry)
+		}
+	}
+	if _, err := NewPolicies(nil, "maybe"); err == nil {
+		t.Errorf("unknown default : expected an error")
+	}
+}
+
+// TestDeniedStaticTokenIsNotRetriedAsJWT checks a static token rejected by
+// policy keeps its denial when the JWT scheme is tried next, as the
+// generated endpoints do, instead of being reported as invalid credentials.
+func TestDeniedStaticTokenIsNotRetriedAsJWT(t *testing.T) {
+	hash := sha256.Sum256([]byte("okta-token"))
+	a, err := NewWithConfig(&logger.Logger{SugaredLogger: zap.NewNop().Sugar()}, &config.Auth{
+		StaticTokens: []string{"okta:" + hex.EncodeToString(hash[:])},
+		Policies:     []string{"default/okta:Group:read"},
+	})
+	if err != nil {
+		t.Fatalf("failed to construct authenticator : %v", err)
+	}
+
+	ctx := WithOperation(context.Background(), Operation{Resource: "Group", Action: ActionDelete})
+
+	ctx, err = a.AuthenticateToken(ctx, "okta-token")
+	if !errors.Is(err, ErrForbidden) {
+		t.Fatalf("static token : got error %v, want %v", err, ErrForbidden)
+	}
+	if _, err := a.AuthenticateJWT(ctx, "okta-token", []string{"api:read"}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("jwt fallback : got error %v, want %v", err, ErrForbidden)
+	}
+}
+
+// TestAttributeACLIsScopedByTenantAndScheme checks the attribute ACL of a
+// service account is not applied to a same named principal of another tenant
+// or to a JWT subject.
+func TestAttributeACLIsScopedByTenantAndScheme(t *testing.T) {
+	a, err := NewWithConfig(&logger.Logger{SugaredLogger: zap.NewNop().Sugar()}, &config.Auth{
+		AttributeACLs: []string{"acme/okta:hide:password"},
+	})
+	if err != nil {
+		t.Fatalf("failed to construct authenticator : %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		principal  *Principal
+		restricted bool
+	}{
+		{name: "acme account", principal: &Principal{Name: "okta", Scheme: SchemeStaticToken, Tenant: "acme"}, restricted: true},
+		{name: "globex account", principal: &Principal{Name: "okta", Scheme: SchemeStaticToken, Tenant: "globex"}},
+		{name: "jwt subject named like an account", principal: &Principal{Name: "okta", Scheme: SchemeJWT, Tenant: "acme"}},
+	}
+
+	for _, tt := range tests {
+		acl := a.AttributeACL(WithPrincipal(context.Background(), tt.principal))
+		if restricted := acl != nil; restricted != tt.restricted {
+			t.Errorf("%s : got acl %+v", tt.name, acl)
+		}
+	}
+}
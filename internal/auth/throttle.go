@@ -0,0 +1,262 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iamBelugaa/scim-gateway/internal/config"
+	"github.com/iamBelugaa/scim-gateway/pkg/logger"
+)
+
+// throttlePrefixLength is the number of leading characters of a token failed
+// attempts are tracked by. It matches the hint shown for gateway tokens, so
+// no more of a token is retained than is already displayed to operators.
+const throttlePrefixLength = len(tokenPrefix) + 4
+
+// maxThrottleEntries bounds the number of tracked sources, so attempts from
+// many addresses or with many tokens cannot grow the tracker without limit.
+const maxThrottleEntries = 100000
+
+// throttleEntry tracks the failed attempts of a single source.
+type throttleEntry struct {
+	failures    int       // Failed attempts since the source was last locked out or forgiven.
+	lastFailure time.Time // Time of the most recent failed attempt.
+	lockedUntil time.Time // End of the current lockout, zero when not locked out.
+}
+
+// Throttle slows down and locks out sources of repeated failed
+// authentication attempts. Sources are the client address and the prefix of
+// the presented token, so credential stuffing is caught both from a single
+// address and across addresses trying variations of the same token. Once a
+// source reaches the delay threshold, every further attempt is delayed
+// progressively longer, and once it reaches the lockout threshold it is
+// rejected outright until the lockout ends.
+type Throttle struct {
+	log             *logger.Logger
+	window          time.Duration // How long failed attempts are remembered after the last one.
+	delayAfter      int           // Failed attempts after which attempts are delayed, 0 when disabled.
+	delayBase       time.Duration // First delay, doubled with every further failed attempt.
+	maxDelay        time.Duration // Upper bound of the delay.
+	lockoutAfter    int           // Failed attempts after which the source is locked out, 0 when disabled.
+	lockoutDuration time.Duration // How long a locked out source is rejected.
+	allowlist       []*net.IPNet  // Networks that are never throttled.
+	now             func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*throttleEntry // Tracked sources keyed by "ip:<address>" or "token:<prefix>".
+}
+
+// NewThrottle constructs a throttle from its configuration.
+func NewThrottle(log *logger.Logger, cfg *config.Throttle) (*Throttle, error) {
+	if cfg.DelayAfter < 0 || cfg.LockoutAfter < 0 {
+		return nil, errors.New("throttle thresholds must not be negative")
+	}
+	if cfg.DelayAfter > 0 && (cfg.DelayBase <= 0 || cfg.MaxDelay < cfg.DelayBase) {
+		return nil, errors.New("throttle delays must be positive and the maximum delay at least the first delay")
+	}
+	if cfg.LockoutAfter > 0 && cfg.LockoutDuration <= 0 {
+		return nil, errors.New("throttle lockout duration must be positive when lockouts are enabled")
+	}
+
+	t := &Throttle{
+		log:             log,
+		window:          cfg.Window,
+		delayAfter:      cfg.DelayAfter,
+		delayBase:       cfg.DelayBase,
+		maxDelay:        cfg.MaxDelay,
+		lockoutAfter:    cfg.LockoutAfter,
+		lockoutDuration: cfg.LockoutDuration,
+		now:             time.Now,
+		entries:         make(map[string]*throttleEntry),
+	}
+
+	for _, cidr := range cfg.Allowlist {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid throttle allowlist entry %q : %w", cidr, err)
+		}
+		t.allowlist = append(t.allowlist, network)
+	}
+	return t, nil
+}
+
+// Enabled reports whether failed attempts are delayed or locked out.
+func (t *Throttle) Enabled() bool {
+	return t != nil && (t.delayAfter > 0 || t.lockoutAfter > 0)
+}
+
+// Check returns how long an attempt from the client address with the token
+// must be delayed, or, when either source is locked out, how long until the
+// lockout ends.
+func (t *Throttle) Check(ip, token string) (delay time.Duration, retryAfter time.Duration) {
+	if !t.Enabled() || t.allowlisted(ip) {
+		return 0, 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	failures := 0
+	for _, key := range throttleKeys(ip, token) {
+		entry := t.entry(key, now)
+		if entry == nil {
+			continue
+		}
+		if now.Before(entry.lockedUntil) {
+			retryAfter = max(retryAfter, entry.lockedUntil.Sub(now))
+		}
+		failures = max(failures, entry.failures)
+	}
+
+	if retryAfter > 0 {
+		return 0, retryAfter
+	}
+	return t.delay(failures), 0
+}
+
+// Failed records a failed attempt from the client address with the token,
+// locking out every source that reaches the lockout threshold.
+func (t *Throttle) Failed(ip, token string) {
+	if !t.Enabled() || t.allowlisted(ip) {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	if len(t.entries) >= maxThrottleEntries {
+		t.sweep(now)
+	}
+
+	for _, key := range throttleKeys(ip, token) {
+		entry := t.entry(key, now)
+		if entry == nil {
+			entry = &throttleEntry{}
+			t.entries[key] = entry
+		}
+		entry.failures++
+		entry.lastFailure = now
+
+		if t.lockoutAfter > 0 && entry.failures >= t.lockoutAfter {
+			entry.failures = 0
+			entry.lockedUntil = now.Add(t.lockoutDuration)
+
+			source, value, _ := strings.Cut(key, ":")
+			t.log.Warnw(
+				"locked out authentication source",
+				"event", "auth_lockout", "source", source, "value", value, "clientIp", ip,
+				"failures", t.lockoutAfter, "lockedUntil", entry.lockedUntil.Format(time.RFC3339),
+			)
+		}
+	}
+}
+
+// Succeeded forgives the failed attempts made with a token once it
+// authenticates. Failures of the client address are kept, so a single valid
+// token cannot be used to keep resetting the tracking of an address.
+func (t *Throttle) Succeeded(token string) {
+	if !t.Enabled() {
+		return
+	}
+
+	prefix := throttleTokenPrefix(token)
+	if prefix == "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if entry, ok := t.entries["token:"+prefix]; ok && !t.now().Before(entry.lockedUntil) {
+		delete(t.entries, "token:"+prefix)
+	}
+}
+
+// entry returns the tracked entry of a source, dropping it once its failures
+// are outside the window and it is no longer locked out. Callers must hold
+// the lock.
+func (t *Throttle) entry(key string, now time.Time) *throttleEntry {
+	entry, ok := t.entries[key]
+	if !ok {
+		return nil
+	}
+	if t.expired(entry, now) {
+		delete(t.entries, key)
+		return nil
+	}
+	return entry
+}
+
+// expired reports whether an entry no longer affects its source.
+func (t *Throttle) expired(entry *throttleEntry, now time.Time) bool {
+	return !now.Before(entry.lockedUntil) && now.Sub(entry.lastFailure) >= t.window
+}
+
+// sweep drops every expired entry. Callers must hold the lock.
+func (t *Throttle) sweep(now time.Time) {
+	for key, entry := range t.entries {
+		if t.expired(entry, now) {
+			delete(t.entries, key)
+		}
+	}
+}
+
+// delay returns the delay applied to a source with the given number of
+// failed attempts.
+func (t *Throttle) delay(failures int) time.Duration {
+	if t.delayAfter == 0 || failures < t.delayAfter {
+		return 0
+	}
+
+	delay := t.delayBase
+	for i := t.delayAfter; i < failures && delay < t.maxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, t.maxDelay)
+}
+
+// allowlisted reports whether the client address is never throttled.
+func (t *Throttle) allowlisted(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, network := range t.allowlist {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// throttleKeys returns the sources an attempt is tracked by.
+func throttleKeys(ip, token string) []string {
+	keys := make([]string, 0, 2)
+	if ip != "" {
+		keys = append(keys, "ip:"+ip)
+	}
+	if prefix := throttleTokenPrefix(token); prefix != "" {
+		keys = append(keys, "token:"+prefix)
+	}
+	return keys
+}
+
+// throttleTokenPrefix returns the prefix a token's failed attempts are
+// tracked by. JWTs are not tracked by prefix since every JWT starts with the
+// same encoded header, which would lock out every JWT client at once.
+func throttleTokenPrefix(token string) string {
+	if len(token) < throttlePrefixLength || strings.Count(token, ".") == 2 {
+		return ""
+	}
+	return token[:throttlePrefixLength]
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/iamBelugaa/scim-gateway/internal/config"
+	"github.com/iamBelugaa/scim-gateway/pkg/logger"
+)
+
+// newTestThrottle constructs a throttle delaying after 2 failed attempts and
+// locking out after 4, driven by the returned clock.
+func newTestThrottle(t *testing.T) (*Throttle, *time.Time, *observer.ObservedLogs) {
+	t.Helper()
+
+	core, logs := observer.New(zap.WarnLevel)
+	throttle, err := NewThrottle(&logger.Logger{SugaredLogger: zap.New(core).Sugar()}, &config.Throttle{
+		Window:          time.Minute * 15,
+		DelayAfter:      2,
+		DelayBase:       time.Millisecond * 100,
+		MaxDelay:        time.Millisecond * 300,
+		LockoutAfter:    4,
+		LockoutDuration: time.Minute * 5,
+		Allowlist:       []string{"10.0.0.0/8"},
+	})
+	if err != nil {
+		t.Fatalf("failed to construct throttle : %v", err)
+	}
+
+	now := time.Now()
+	throttle.now = func() time.Time { return now }
+	return throttle, &now, logs
+}
+
+// TestThrottleDelaysAndLocksOut checks attempts are delayed progressively
+// after repeated failures, then rejected until the lockout ends, with every
+// lockout logged.
+func TestThrottleDelaysAndLocksOut(t *testing.T) {
+	throttle, now, logs := newTestThrottle(t)
+
+	wantDelays := []time.Duration{0, 0, time.Millisecond * 100, time.Millisecond * 200}
+	for i, want := range wantDelays {
+		delay, retryAfter := throttle.Check("203.0.113.7", "")
+		if delay != want || retryAfter != 0 {
+			t.Fatalf("attempt %d : got delay %s and retry after %s, want delay %s", i+1, delay, retryAfter, want)
+		}
+		throttle.Failed("203.0.113.7", "")
+	}
+
+	if _, retryAfter := throttle.Check("203.0.113.7", ""); retryAfter != time.Minute*5 {
+		t.Fatalf("locked out : got retry after %s, want %s", retryAfter, time.Minute*5)
+	}
+	if _, retryAfter := throttle.Check("203.0.113.8", ""); retryAfter != 0 {
+		t.Fatalf("other address : got retry after %s, want none", retryAfter)
+	}
+	if logs.FilterMessage("locked out authentication source").Len() != 1 {
+		t.Fatalf("got %d lockout events, want 1", logs.Len())
+	}
+
+	*now = now.Add(time.Minute * 5)
+	if delay, retryAfter := throttle.Check("203.0.113.7", ""); delay != 0 || retryAfter != 0 {
+		t.Fatalf("after lockout : got delay %s and retry after %s, want neither", delay, retryAfter)
+	}
+}
+
+// TestThrottleTracksTokenPrefixes checks variations of the same token tried
+// from many addresses are locked out together, while a successful
+// authentication forgives the token.
+func TestThrottleTracksTokenPrefixes(t *testing.T) {
+	throttle, _, _ := newTestThrottle(t)
+
+	addresses := []string{"198.51.100.1", "198.51.100.2", "198.51.100.3"}
+	for _, ip := range addresses {
+		throttle.Failed(ip, "sgw_AbCdguess-"+ip)
+	}
+	throttle.Succeeded("sgw_AbCdvalid")
+	for _, ip := range addresses {
+		throttle.Failed(ip, "sgw_AbCdguess-"+ip)
+	}
+	if _, retryAfter := throttle.Check("198.51.100.9", "sgw_AbCdanother"); retryAfter != 0 {
+		t.Fatalf("forgiven token : got retry after %s, want none", retryAfter)
+	}
+
+	throttle.Failed("198.51.100.4", "sgw_AbCdguess")
+	if _, retryAfter := throttle.Check("198.51.100.9", "sgw_AbCdanother"); retryAfter == 0 {
+		t.Fatalf("token prefix : expected a lockout")
+	}
+	if _, retryAfter := throttle.Check("198.51.100.9", "sgw_WxYzother"); retryAfter != 0 {
+		t.Fatalf("other token : got retry after %s, want none", retryAfter)
+	}
+}
+
+// TestThrottleIgnoresJWTPrefixes checks JWTs, which all share the same
+// encoded header, are only tracked by address.
+func TestThrottleIgnoresJWTPrefixes(t *testing.T) {
+	throttle, _, _ := newTestThrottle(t)
+
+	for i := range 4 {
+		throttle.Failed("198.51.100.1", "eyJhbGciOiJSUzI1NiJ9.e30.forged"+string(rune('a'+i)))
+	}
+	if _, retryAfter := throttle.Check("198.51.100.2", "eyJhbGciOiJSUzI1NiJ9.e30.valid"); retryAfter != 0 {
+		t.Fatalf("jwt from another address : got retry after %s, want none", retryAfter)
+	}
+}
+
+// TestThrottleForgetsOldFailures checks failures outside the window no longer
+// count towards delays or lockouts.
+func TestThrottleForgetsOldFailures(t *testing.T) {
+	throttle, now, _ := newTestThrottle(t)
+
+	for range 3 {
+		throttle.Failed("203.0.113.7", "")
+	}
+	*now = now.Add(time.Minute * 15)
+	throttle.Failed("203.0.113.7", "")
+
+	if delay, retryAfter := throttle.Check("203.0.113.7", ""); delay != 0 || retryAfter != 0 {
+		t.Fatalf("got delay %s and retry after %s, want neither", delay, retryAfter)
+	}
+}
+
+// TestThrottleAllowlist checks allowlisted networks are never throttled.
+func TestThrottleAllowlist(t *testing.T) {
+	throttle, _, _ := newTestThrottle(t)
+
+	for range 10 {
+		throttle.Failed("10.1.2.3", "")
+	}
+	if delay, retryAfter := throttle.Check("10.1.2.3", ""); delay != 0 || retryAfter != 0 {
+		t.Fatalf("got delay %s and retry after %s, want neither", delay, retryAfter)
+	}
+}
+
+// TestNewThrottleRejectsInvalidConfig checks misconfigured throttling is
+// reported at startup.
+func TestNewThrottleRejectsInvalidConfig(t *testing.T) {
+	log := &logger.Logger{SugaredLogger: zap.NewNop().Sugar()}
+	for _, cfg := range []*config.Throttle{
+		{DelayAfter: -1},
+		{DelayAfter: 3},
+		{DelayAfter: 3, DelayBase: time.Second, MaxDelay: time.Millisecond},
+		{LockoutAfter: 5},
+		{Allowlist: []string{"10.0.0.1"}},
+	} {
+		if _, err := NewThrottle(log, cfg); err == nil {
+			t.Errorf("%+v : expected an error", cfg)
+		}
+	}
+}
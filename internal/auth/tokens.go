@@ -0,0 +1,315 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SchemeStaticToken identifies principals authenticated with a static token.
+const SchemeStaticToken = "static"
+
+// tokenPrefix marks tokens generated by the gateway so they are easy to
+// recognize, for example by secret scanners.
+const tokenPrefix = "sgw_"
+
+// Token lifecycle errors.
+var (
+	ErrTokenNotFound       = errors.New("token not found")
+	ErrTokenInactive       = errors.New("token is revoked or expired")
+	ErrInvalidTokenRequest = errors.New("invalid token request")
+)
+
+// Token status values.
+const (
+	TokenStatusActive  = "active"
+	TokenStatusExpired = "expired"
+	TokenStatusRevoked = "revoked"
+)
+
+// Token describes a service account token. The token secret itself is never
+// stored, only its SHA-256 hash.
+type Token struct {
+	ID             string    // Unique identifier of the token.
+	ServiceAccount string    // Service account the token was issued to.
+	Tenant         string    // Tenant whose resources the token can access.
+	Hint           string    // First characters of the token, to help identify it.
+	CreatedAt      time.Time // Time the token was created.
+	ExpiresAt      time.Time // Time the token stops being accepted, zero if it never expires.
+	RevokedAt      time.Time // Time the token was revoked, zero if it is not revoked.
+	LastUsedAt     time.Time // Time the token last authenticated a request, zero if never used.
+	LastUsedIP     string    // Client address of the last request authenticated with the token.
+	RotatedFrom    string    // Id of the token this token replaced on rotation.
+
+	hash []byte // SHA-256 of the token.
+}
+
+// Status returns the status of the token at the given time.
+func (t *Token) Status(now time.Time) string {
+	switch {
+	case !t.RevokedAt.IsZero():
+		return TokenStatusRevoked
+	case !t.ExpiresAt.IsZero() && !now.Before(t.ExpiresAt):
+		return TokenStatusExpired
+	default:
+		return TokenStatusActive
+	}
+}
+
+// ServiceTokens authenticates long lived bearer tokens issued to service
+// accounts and manages their lifecycle. Only SHA-256 hashes of the tokens are
+// held in memory.
+type ServiceTokens struct {
+	mu     sync.Mutex
+	tokens map[string]*Token // Tokens keyed by id.
+	now    func() time.Time
+}
+
+// NewServiceTokens constructs the token registry, seeding it with configured
+// token hashes. Each entry has the form
+// "<service-account>:<hex encoded SHA-256 of the token>:<tenant>", where the
+// tenant may be omitted for tokens of the default tenant. Service account
+// names cannot contain "/", which separates them from their tenant in
+// policies and attribute ACLs.
+func NewServiceTokens(entries []string) (*ServiceTokens, error) {
+	s := &ServiceTokens{
+		tokens: make(map[string]*Token),
+		now:    func() time.Time { return time.Now().UTC() },
+	}
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) < 2 || parts[0] == "" || strings.Contains(parts[0], "/") {
+			return nil, fmt.Errorf("static token entry must be of the form '<service-account>:<sha256-hex>[:<tenant>]'")
+		}
+
+		name, encoded, tenant := parts[0], parts[1], DefaultTenant
+		if len(parts) == 3 && parts[2] != "" {
+			tenant = parts[2]
+		}
+
+		hash, err := hex.DecodeString(encoded)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("static token hash for %q must be a hex encoded SHA-256 digest", name)
+		}
+
+		// Configured tokens get a stable id derived from their hash, so they
+		// can be referred to across restarts.
+		id := "cfg-" + encoded[:12]
+		s.tokens[id] = &Token{ID: id, ServiceAccount: name, Tenant: tenant, CreatedAt: s.now(), hash: hash}
+	}
+
+	return s, nil
+}
+
+// Enabled reports whether any tokens are registered.
+func (s *ServiceTokens) Enabled() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.tokens) > 0
+}
+
+// Authenticate returns the service account the token was issued to and
+// records the use of the token.
+func (s *ServiceTokens) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	hash := sha256.Sum256([]byte(token))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Compare against every registered hash so the time taken does not reveal
+	// which, if any, of the tokens matched.
+	var matched *Token
+	for _, t := range s.tokens {
+		if subtle.ConstantTimeCompare(hash[:], t.hash) == 1 {
+			matched = t
+		}
+	}
+
+	now := s.now()
+	if matched == nil || matched.Status(now) != TokenStatusActive {
+		return nil, ErrUnauthorized
+	}
+
+	matched.LastUsedAt = now
+	matched.LastUsedIP = ClientIPFrom(ctx)
+
+	return &Principal{
+		Name:         matched.ServiceAccount,
+		Scheme:       SchemeStaticToken,
+		CredentialID: matched.ID,
+		Tenant:       matched.Tenant,
+	}, nil
+}
+
+// Create issues a new token for a service account of a tenant. A zero ttl
+// creates a token that never expires. The returned secret is not stored and
+// cannot be retrieved again.
+func (s *ServiceTokens) Create(ctx context.Context, serviceAccount, tenant string, ttl time.Duration) (*Token, string, error) {
+	if serviceAccount == "" {
+		return nil, "", fmt.Errorf("%w : service account is required", ErrInvalidTokenRequest)
+	}
+	if strings.Contains(serviceAccount, "/") {
+		return nil, "", fmt.Errorf("%w : service account must not contain '/'", ErrInvalidTokenRequest)
+	}
+	if tenant == "" {
+		return nil, "", fmt.Errorf("%w : tenant is required", ErrInvalidTokenRequest)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.create(serviceAccount, tenant, ttl, "")
+}
+
+// List returns every token registered for a tenant, most recently created
+// first.
+func (s *ServiceTokens) List(ctx context.Context, tenant string) []*Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens := make([]*Token, 0)
+	for _, t := range s.tokens {
+		if t.Tenant != tenant {
+			continue
+		}
+		clone := *t
+		tokens = append(tokens, &clone)
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		if tokens[i].CreatedAt.Equal(tokens[j].CreatedAt) {
+			return tokens[i].ID < tokens[j].ID
+		}
+		return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
+	})
+	return tokens
+}
+
+// Rotate issues a replacement for an active token of a tenant. The old token
+// keeps working for the overlap window so callers can switch over, then
+// expires. The replacement inherits the remaining lifetime of the old token.
+func (s *ServiceTokens) Rotate(ctx context.Context, tenant, id string, overlap time.Duration) (*Token, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, err := s.lookup(tenant, id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := s.now()
+	if old.Status(now) != TokenStatusActive {
+		return nil, "", fmt.Errorf("token %q : %w", id, ErrTokenInactive)
+	}
+
+	var ttl time.Duration
+	if !old.ExpiresAt.IsZero() {
+		ttl = old.ExpiresAt.Sub(now)
+	}
+
+	token, secret, err := s.create(old.ServiceAccount, old.Tenant, ttl, old.ID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if overlapEnd := now.Add(overlap); old.ExpiresAt.IsZero() || overlapEnd.Before(old.ExpiresAt) {
+		old.ExpiresAt = overlapEnd
+	}
+	return token, secret, nil
+}
+
+// Expire sets the time a token of a tenant stops being accepted. Expiry can
+// only be brought forward, never extended.
+func (s *ServiceTokens) Expire(ctx context.Context, tenant, id string, at time.Time) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.lookup(tenant, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.ExpiresAt.IsZero() || at.Before(t.ExpiresAt) {
+		t.ExpiresAt = at.UTC()
+	}
+
+	clone := *t
+	return &clone, nil
+}
+
+// Revoke immediately and permanently disables a token of a tenant.
+func (s *ServiceTokens) Revoke(ctx context.Context, tenant, id string) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.lookup(tenant, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.RevokedAt.IsZero() {
+		t.RevokedAt = s.now()
+	}
+
+	clone := *t
+	return &clone, nil
+}
+
+// lookup returns the token with the given id when it belongs to the tenant.
+// Tokens of other tenants are reported as not found, so their ids cannot be
+// probed. Callers must hold the lock.
+func (s *ServiceTokens) lookup(tenant, id string) (*Token, error) {
+	t, ok := s.tokens[id]
+	if !ok || t.Tenant != tenant {
+		return nil, fmt.Errorf("token %q : %w", id, ErrTokenNotFound)
+	}
+	return t, nil
+}
+
+// create generates and registers a token. Callers must hold the lock.
+func (s *ServiceTokens) create(serviceAccount, tenant string, ttl time.Duration, rotatedFrom string) (*Token, string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, "", fmt.Errorf("failed to generate token : %w", err)
+	}
+
+	secret := tokenPrefix + base64.RawURLEncoding.EncodeToString(random)
+	hash := sha256.Sum256([]byte(secret))
+
+	now := s.now()
+	t := &Token{
+		ID:             uuid.NewString(),
+		ServiceAccount: serviceAccount,
+		Tenant:         tenant,
+		Hint:           secret[:len(tokenPrefix)+4],
+		CreatedAt:      now,
+		RotatedFrom:    rotatedFrom,
+		hash:           hash[:],
+	}
+	if ttl > 0 {
+		t.ExpiresAt = now.Add(ttl)
+	}
+
+	s.tokens[t.ID] = t
+
+	clone := *t
+	return &clone, secret, nil
+}
@@ -47,11 +47,142 @@ type Server struct {
 	WriteTimeout    time.Duration `json:"writeTimeout"`    // Maximum duration before timing out writes of the response.
 	IdleTimeout     time.Duration `json:"idleTimeout"`     // Maximum amount of time to wait for the next request.
 	ShutdownTimeout time.Duration `json:"shutdownTimeout"` // Grace period for server shutdown.
+
+	TLSCertFile      string   `json:"tlsCertFile"`      // PEM file of the server certificate chain. The server listens over TLS when set.
+	TLSKeyFile       string   `json:"tlsKeyFile"`       // PEM file of the server private key.
+	TLSClientCAFiles []string `json:"tlsClientCaFiles"` // PEM files of the CAs client certificates must be signed by. Client certificates are not requested when empty.
+	TLSClientAuth    string   `json:"tlsClientAuth"`    // Either "require" to reject connections without a valid client certificate, or "optional".
+}
+
+// Store holds resource persistence settings.
+type Store struct {
+	HistoryRetention   time.Duration `json:"historyRetention"`   // How long superseded resource versions are kept.
+	TombstoneRetention time.Duration `json:"tombstoneRetention"` // How long deleted resources can be restored before they are purged.
+	PurgeInterval      time.Duration `json:"purgeInterval"`      // How often the background purge job runs.
+	DryRun             bool          `json:"dryRun"`             // Whether writes are only planned, as if every request asked for a dry run.
+
+	// Whether a SCIM Bulk request runs in one store transaction, so the
+	// operations that succeeded are rolled back when the request stops at
+	// its failOnErrors threshold. Connector changes are then only sent once
+	// the transaction commits.
+	AtomicBulk bool `json:"atomicBulk"`
+}
+
+// SCIM holds the limits of the SCIM API.
+type SCIM struct {
+	MaxResults         int   `json:"maxResults"`         // Largest page of resources a list request returns.
+	BulkMaxOperations  int   `json:"bulkMaxOperations"`  // Most operations a Bulk request may hold.
+	BulkMaxPayloadSize int64 `json:"bulkMaxPayloadSize"` // Largest Bulk request body accepted, in bytes.
+}
+
+// Tenancy holds the tenants served by the gateway.
+type Tenancy struct {
+	// Tenants served next to the default tenant, each of the form
+	// "<tenant-id>" or "<tenant-id>=<space separated schema URIs>". A tenant
+	// listing no schemas serves every built in schema.
+	Tenants []string `json:"tenants"`
+}
+
+// Auth holds the credentials accepted by the gateway APIs.
+type Auth struct {
+	// Static service account tokens, each of the form
+	// "<service-account>:<hex encoded SHA-256 of the token>:<tenant>". The
+	// tenant may be omitted for tokens of the default tenant.
+	StaticTokens []string `json:"-"`
+
+	JWKSSource  string        `json:"jwksSource"`  // File path or URL of the JWKS used to verify JWTs. JWTs are disabled when empty.
+	JWKSRefresh time.Duration `json:"jwksRefresh"` // How long a fetched JWKS is cached before being fetched again.
+	JWTIssuer   string        `json:"jwtIssuer"`   // Expected "iss" claim of JWTs.
+	JWTAudience string        `json:"jwtAudience"` // Expected "aud" claim of JWTs.
+	JWTLeeway   time.Duration `json:"jwtLeeway"`   // Clock skew tolerated when checking "exp", "nbf" and "iat".
+
+	// OAuth client credentials clients, each of the form
+	// "<client-id>:<hex encoded SHA-256 of the secret>:<space separated scopes>".
+	// The token endpoint is disabled when empty.
+	OAuthClients       []string      `json:"-"`
+	OAuthClientTenants []string      `json:"oauthClientTenants"` // Tenants of OAuth clients, each of the form "<client-id>:<tenant>". Unlisted clients belong to the default tenant.
+	OAuthSigningKey    string        `json:"oauthSigningKey"`    // PEM file of the RSA or P-256 key signing issued tokens.
+	OAuthIssuer        string        `json:"oauthIssuer"`        // Issuer and audience of issued tokens.
+	OAuthTokenLifetime time.Duration `json:"oauthTokenLifetime"` // Lifetime of issued tokens.
+
+	// OAuth token introspection (RFC 7662) of opaque access tokens. Tokens
+	// are introspected only when the URL is set.
+	IntrospectionURL              string        `json:"introspectionUrl"`              // URL of the introspection endpoint.
+	IntrospectionClientID         string        `json:"introspectionClientId"`         // Client id the gateway authenticates to the endpoint with.
+	IntrospectionClientSecret     string        `json:"-"`                             // Client secret the gateway authenticates to the endpoint with.
+	IntrospectionCacheTTL         time.Duration `json:"introspectionCacheTtl"`         // How long active tokens are cached, capped by their expiry.
+	IntrospectionNegativeCacheTTL time.Duration `json:"introspectionNegativeCacheTtl"` // How long inactive tokens are cached.
+
+	// Client certificate identities, each of the form
+	// "<certificate identity>:<service-account>:<tenant>". The identity is
+	// matched against the URI, DNS and email SANs of the certificate, then its
+	// subject common name. The tenant may be left empty.
+	CertIdentities []string `json:"certIdentities"`
+
+	// Authorization policies, each of the form
+	// "<tenant>/<service-account>:<resource type>:<space separated actions>",
+	// for example "default/okta:User:create read update delete". Principals
+	// authenticated by JWT or introspected token are named
+	// "<tenant>/<scheme>/<subject>" instead, for example "acme/jwt/hr-sync".
+	// The resource type and actions may be "*". Entries for the same principal
+	// accumulate.
+	Policies      []string `json:"policies"`
+	PolicyDefault string   `json:"policyDefault"` // Either "allow" or "deny", applied to principals without a policy.
+
+	// Attribute ACLs, each of the form
+	// "<tenant>/<service-account>:<hide|reject|drop>:<space separated attribute paths>",
+	// for example "default/okta:hide:password". Principals are named as in
+	// policies. Hidden attributes are left out of
+	// responses, changes to rejected attributes fail and changes to dropped
+	// attributes are ignored.
+	AttributeACLs []string `json:"attributeAcls"`
+}
+
+// Throttle holds the limits applied to failed authentication attempts, which
+// are tracked per client address and per token prefix.
+type Throttle struct {
+	Window          time.Duration `json:"window"`          // How long failed attempts are remembered after the last one.
+	DelayAfter      int           `json:"delayAfter"`      // Failed attempts after which responses are delayed. Delays are disabled when 0.
+	DelayBase       time.Duration `json:"delayBase"`       // First delay, doubled with every further failed attempt.
+	MaxDelay        time.Duration `json:"maxDelay"`        // Upper bound of the delay.
+	LockoutAfter    int           `json:"lockoutAfter"`    // Failed attempts after which the source is locked out. Lockouts are disabled when 0.
+	LockoutDuration time.Duration `json:"lockoutDuration"` // How long a locked out source is answered with 429.
+	Allowlist       []string      `json:"allowlist"`       // CIDRs of clients that are never throttled, such as identity provider egress ranges.
+}
+
+// Connectors holds the downstream systems resources are provisioned to.
+type Connectors struct {
+	ConfigFile string        `json:"configFile"` // YAML or JSON file defining the connectors. No connectors are used when empty.
+	Timeout    time.Duration `json:"timeout"`    // How long a single connector operation may take.
+
+	// Delivery of changes from the outbox of every tenant store.
+	OutboxDir      string        `json:"outboxDir"`      // Directory the outboxes and dead letters are persisted to, so they survive a restart. Held in memory only when empty.
+	Workers        int           `json:"workers"`        // Number of deliveries run concurrently.
+	MaxAttempts    int           `json:"maxAttempts"`    // Attempts made before a delivery is dead-lettered.
+	RetryBaseDelay time.Duration `json:"retryBaseDelay"` // Delay before the first retry, doubled on every further retry.
+	RetryMaxDelay  time.Duration `json:"retryMaxDelay"`  // Upper bound of the delay between retries.
+
+	// Scheduled reconciliation of the gateway with the downstream systems.
+	ReconcileInterval time.Duration `json:"reconcileInterval"` // How often every connector is reconciled. Never when zero.
+	ReconcileCorrect  bool          `json:"reconcileCorrect"`  // Whether scheduled reconciliations queue corrective operations.
+}
+
+// Upstreams holds the upstream SCIM servers resources are pulled from.
+type Upstreams struct {
+	ConfigFile string        `json:"configFile"` // YAML or JSON file defining the upstreams. Nothing is pulled when empty.
+	Timeout    time.Duration `json:"timeout"`    // How long a single request to an upstream may take.
 }
 
 // Config is the top level struct that aggregates all configuration domains.
 type Config struct {
 	Server      *Server      `json:"server"`      // HTTP server configuration.
+	Auth        *Auth        `json:"auth"`        // Authentication configuration.
+	Store       *Store       `json:"store"`       // Resource store configuration.
+	SCIM        *SCIM        `json:"scim"`        // SCIM API limits.
+	Tenancy     *Tenancy     `json:"tenancy"`     // Tenants served by the gateway.
+	Throttle    *Throttle    `json:"throttle"`    // Throttling of failed authentication attempts.
+	Connectors  *Connectors  `json:"connectors"`  // Downstream provisioning connectors.
+	Upstreams   *Upstreams   `json:"upstreams"`   // Upstream SCIM servers pulled from.
 	Logging     *Logging     `json:"logging"`     // Logging configuration.
 	Application *Application `json:"application"` // Application metadata and environment.
 }
@@ -67,6 +198,79 @@ func Load() *Config {
 			WriteTimeout:    GetEnvDuration("SERVER_WRITE_TIMEOUT", time.Second*15),
 			IdleTimeout:     GetEnvDuration("SERVER_IDLE_TIMEOUT", time.Second*30),
 			ShutdownTimeout: GetEnvDuration("SERVER_SHUTDOWN_TIMEOUT", time.Second*30),
+
+			TLSCertFile:      GetEnvString("SERVER_TLS_CERT_FILE", ""),
+			TLSKeyFile:       GetEnvString("SERVER_TLS_KEY_FILE", ""),
+			TLSClientCAFiles: GetEnvSlice("SERVER_TLS_CLIENT_CA_FILES", nil),
+			TLSClientAuth:    GetEnvString("SERVER_TLS_CLIENT_AUTH", "require"),
+		},
+		Auth: &Auth{
+			StaticTokens: GetEnvSlice("AUTH_STATIC_TOKENS", nil),
+			JWKSSource:   GetEnvString("AUTH_JWT_JWKS", ""),
+			JWKSRefresh:  GetEnvDuration("AUTH_JWT_JWKS_REFRESH", time.Minute*15),
+			JWTIssuer:    GetEnvString("AUTH_JWT_ISSUER", ""),
+			JWTAudience:  GetEnvString("AUTH_JWT_AUDIENCE", ""),
+			JWTLeeway:    GetEnvDuration("AUTH_JWT_LEEWAY", time.Second*30),
+
+			OAuthClients:       GetEnvSlice("AUTH_OAUTH_CLIENTS", nil),
+			OAuthSigningKey:    GetEnvString("AUTH_OAUTH_SIGNING_KEY", ""),
+			OAuthIssuer:        GetEnvString("AUTH_OAUTH_ISSUER", "scim-gateway"),
+			OAuthTokenLifetime: GetEnvDuration("AUTH_OAUTH_TOKEN_LIFETIME", time.Minute*15),
+			OAuthClientTenants: GetEnvSlice("AUTH_OAUTH_CLIENT_TENANTS", nil),
+
+			IntrospectionURL:              GetEnvString("AUTH_INTROSPECTION_URL", ""),
+			IntrospectionClientID:         GetEnvString("AUTH_INTROSPECTION_CLIENT_ID", ""),
+			IntrospectionClientSecret:     GetEnvString("AUTH_INTROSPECTION_CLIENT_SECRET", ""),
+			IntrospectionCacheTTL:         GetEnvDuration("AUTH_INTROSPECTION_CACHE_TTL", time.Minute),
+			IntrospectionNegativeCacheTTL: GetEnvDuration("AUTH_INTROSPECTION_NEGATIVE_CACHE_TTL", time.Second*10),
+
+			CertIdentities: GetEnvSlice("AUTH_MTLS_IDENTITIES", nil),
+
+			Policies:      GetEnvSlice("AUTH_POLICIES", nil),
+			PolicyDefault: GetEnvString("AUTH_POLICY_DEFAULT", "allow"),
+
+			AttributeACLs: GetEnvSlice("AUTH_ATTRIBUTE_ACLS", nil),
+		},
+		Store: &Store{
+			HistoryRetention:   GetEnvDuration("STORE_HISTORY_RETENTION", time.Hour*24*30),
+			TombstoneRetention: GetEnvDuration("STORE_TOMBSTONE_RETENTION", time.Hour*24*30),
+			PurgeInterval:      GetEnvDuration("STORE_PURGE_INTERVAL", time.Hour),
+			DryRun:             GetEnvBool("STORE_DRY_RUN", false),
+			AtomicBulk:         GetEnvBool("STORE_ATOMIC_BULK", false),
+		},
+		SCIM: &SCIM{
+			MaxResults:         GetEnvInt("SCIM_MAX_RESULTS", 100),
+			BulkMaxOperations:  GetEnvInt("SCIM_BULK_MAX_OPERATIONS", 1000),
+			BulkMaxPayloadSize: int64(GetEnvInt("SCIM_BULK_MAX_PAYLOAD_SIZE", 1<<20)),
+		},
+		Tenancy: &Tenancy{
+			Tenants: GetEnvSlice("TENANTS", nil),
+		},
+		Throttle: &Throttle{
+			Window:          GetEnvDuration("AUTH_THROTTLE_WINDOW", time.Minute*15),
+			DelayAfter:      GetEnvInt("AUTH_THROTTLE_DELAY_AFTER", 3),
+			DelayBase:       GetEnvDuration("AUTH_THROTTLE_DELAY_BASE", time.Millisecond*250),
+			MaxDelay:        GetEnvDuration("AUTH_THROTTLE_MAX_DELAY", time.Second*5),
+			LockoutAfter:    GetEnvInt("AUTH_THROTTLE_LOCKOUT_AFTER", 10),
+			LockoutDuration: GetEnvDuration("AUTH_THROTTLE_LOCKOUT_DURATION", time.Minute*15),
+			Allowlist:       GetEnvSlice("AUTH_THROTTLE_ALLOWLIST", nil),
+		},
+		Connectors: &Connectors{
+			ConfigFile: GetEnvString("CONNECTORS_CONFIG_FILE", ""),
+			Timeout:    GetEnvDuration("CONNECTORS_TIMEOUT", time.Second*30),
+
+			OutboxDir:      GetEnvString("CONNECTORS_OUTBOX_DIR", ""),
+			Workers:        GetEnvInt("CONNECTORS_WORKERS", 4),
+			MaxAttempts:    GetEnvInt("CONNECTORS_MAX_ATTEMPTS", 8),
+			RetryBaseDelay: GetEnvDuration("CONNECTORS_RETRY_BASE_DELAY", time.Second),
+			RetryMaxDelay:  GetEnvDuration("CONNECTORS_RETRY_MAX_DELAY", time.Minute*5),
+
+			ReconcileInterval: GetEnvDuration("CONNECTORS_RECONCILE_INTERVAL", 0),
+			ReconcileCorrect:  GetEnvBool("CONNECTORS_RECONCILE_CORRECT", false),
+		},
+		Upstreams: &Upstreams{
+			ConfigFile: GetEnvString("UPSTREAMS_CONFIG_FILE", ""),
+			Timeout:    GetEnvDuration("UPSTREAMS_TIMEOUT", time.Second*30),
 		},
 		Logging: &Logging{
 			Level:       GetEnvString("LOG_LEVEL", "info"),
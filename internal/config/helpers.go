@@ -32,6 +32,22 @@ func GetEnvInt(key string, fallback int) int {
 	return intVal
 }
 
+// GetEnvBool retrieves an environment variable and parses it as a boolean.
+// Accepted values are those of strconv.ParseBool (e.g., "true", "0").
+// If the variable is not set or the value is invalid, it returns the `fallback`.
+func GetEnvBool(key string, fallback bool) bool {
+	val, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+
+	boolVal, err := strconv.ParseBool(val)
+	if err != nil {
+		return fallback
+	}
+	return boolVal
+}
+
 // GetEnvDuration retrieves an environment variable and parses it as a time.Duration.
 // The string must follow Go's duration format (e.g., "5s", "1h").
 // If the variable is not set or the format is invalid, it returns the `fallback`.
@@ -0,0 +1,166 @@
+package connector
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/iamBelugaa/scim-gateway/internal/auth"
+	"github.com/iamBelugaa/scim-gateway/internal/config"
+	"github.com/iamBelugaa/scim-gateway/internal/filter"
+	"github.com/iamBelugaa/scim-gateway/internal/mapping"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+	"github.com/iamBelugaa/scim-gateway/internal/tenant"
+	"github.com/iamBelugaa/scim-gateway/pkg/logger"
+)
+
+// Factories holds the connector factories keyed by connector type.
+type Factories map[string]Factory
+
+// Definition defines a connector in the connectors configuration file.
+type Definition struct {
+	Name          string    `yaml:"name"`          // Unique name of the connector, used in statuses and logs.
+	Type          string    `yaml:"type"`          // Type of the connector, selecting its factory.
+	Tenant        string    `yaml:"tenant"`        // Tenant whose resources are provisioned, the default tenant when empty.
+	ResourceTypes []string  `yaml:"resourceTypes"` // Resource types provisioned, every type when empty.
+	Settings      yaml.Node `yaml:"settings"`      // Type specific settings.
+
+	// SCIM filter selecting the resources provisioned, such as
+	// `active eq true`, every resource when empty. It applies to every
+	// resource type provisioned. Resources leaving the scope are deleted
+	// downstream.
+	Scope string `yaml:"scope"`
+
+	// Attribute mapping applied to resources before they are provisioned,
+	// either inline or read from a mapping file.
+	Mapping     []mapping.Rule `yaml:"mapping"`
+	MappingFile string         `yaml:"mappingFile"`
+}
+
+// LoadDefinitions reads connector definitions from a YAML or JSON file of the
+// form {"connectors": [...]}.
+func LoadDefinitions(path string) ([]Definition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Connectors []Definition `yaml:"connectors"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to decode connectors file : %w", err)
+	}
+	return file.Connectors, nil
+}
+
+// NewWithConfig constructs a dispatcher with the connectors defined in the
+// configured file, each constructed by the factory of its type. The
+// dispatcher has no connectors when no file is configured.
+func NewWithConfig(log *logger.Logger, cfg *config.Connectors, tenants *tenant.Registry, factories Factories) (*Dispatcher, error) {
+	d := NewDispatcher(log, cfg.Timeout)
+	if cfg.ConfigFile == "" {
+		return d, nil
+	}
+
+	definitions, err := LoadDefinitions(cfg.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, def := range definitions {
+		if def.Name == "" {
+			return nil, fmt.Errorf("connector of type %q has no name", def.Type)
+		}
+		if def.Tenant == "" {
+			def.Tenant = auth.DefaultTenant
+		}
+		if _, err := tenants.Get(def.Tenant); err != nil {
+			return nil, fmt.Errorf("connector %q : %w", def.Name, err)
+		}
+
+		types, err := resourceTypes(def.ResourceTypes)
+		if err != nil {
+			return nil, fmt.Errorf("connector %q : %w", def.Name, err)
+		}
+
+		var scope *filter.Filter
+		if def.Scope != "" {
+			if scope, err = filter.Parse(def.Scope); err != nil {
+				return nil, fmt.Errorf("connector %q : invalid scope : %w", def.Name, err)
+			}
+		}
+
+		factory, ok := factories[def.Type]
+		if !ok {
+			return nil, fmt.Errorf("connector %q : %w %q", def.Name, ErrUnknownType, def.Type)
+		}
+		connector, err := factory(def.Name, &def.Settings)
+		if err != nil {
+			return nil, fmt.Errorf("failed to construct connector %q : %w", def.Name, err)
+		}
+
+		if connector, err = withMapping(connector, &def); err != nil {
+			return nil, fmt.Errorf("connector %q : %w", def.Name, err)
+		}
+
+		if err := d.Register(def.Name, def.Tenant, types, scope, connector); err != nil {
+			return nil, err
+		}
+		log.Infow(
+			"registered connector", "connector", def.Name, "type", def.Type, "tenant", def.Tenant, "resourceTypes", types,
+			"scope", def.Scope,
+		)
+	}
+	return d, nil
+}
+
+// withMapping wraps a connector with the attribute mapping of its
+// definition, if it has one.
+func withMapping(connector Connector, def *Definition) (Connector, error) {
+	if len(def.Mapping) > 0 && def.MappingFile != "" {
+		return nil, errors.New("mapping and mappingFile are mutually exclusive")
+	}
+
+	var (
+		m   *mapping.Mapping
+		err error
+	)
+	switch {
+	case def.MappingFile != "":
+		m, err = mapping.Load(def.MappingFile)
+	case len(def.Mapping) > 0:
+		m, err = mapping.New(def.Mapping)
+	default:
+		return connector, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid mapping : %w", err)
+	}
+
+	wrapped := &mapped{Connector: connector, mapping: m}
+	if lister, ok := connector.(Lister); ok {
+		return &mappedLister{mapped: wrapped, lister: lister}, nil
+	}
+	return wrapped, nil
+}
+
+// resourceTypes parses the resource types of a connector definition.
+func resourceTypes(names []string) ([]store.ResourceType, error) {
+	if len(names) == 0 {
+		return []store.ResourceType{store.ResourceTypeUser, store.ResourceTypeGroup}, nil
+	}
+
+	types := make([]store.ResourceType, 0, len(names))
+	for _, name := range names {
+		switch store.ResourceType(name) {
+		case store.ResourceTypeUser, store.ResourceTypeGroup:
+			types = append(types, store.ResourceType(name))
+		default:
+			return nil, fmt.Errorf("unknown resource type %q", name)
+		}
+	}
+	return types, nil
+}
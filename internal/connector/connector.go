@@ -0,0 +1,99 @@
+// Package connector provisions the resources held by the gateway to
+// downstream systems. Connectors are defined in a configuration file and
+// every successful change to a resource is dispatched to the connectors of
+// its tenant, with the outcome tracked per connector.
+package connector
+
+import (
+	"context"
+	"errors"
+
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+)
+
+// ErrUnknownType is returned when a connector definition names a type no
+// factory is registered for.
+var ErrUnknownType = errors.New("unknown connector type")
+
+// RetryableError is a failure of an operation that may succeed when the
+// operation is tried again, such as a downstream system being unavailable.
+// Other failures are permanent.
+type RetryableError struct {
+	Err error
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// IsRetryable reports whether err is a failure that may succeed when the
+// operation is tried again.
+func IsRetryable(err error) bool {
+	var retryable *RetryableError
+	return errors.As(err, &retryable)
+}
+
+// Operation is a provisioning operation performed by a connector.
+type Operation string
+
+// Supported operations.
+const (
+	OperationCreate     Operation = "create"
+	OperationUpdate     Operation = "update"
+	OperationDisable    Operation = "disable"
+	OperationDelete     Operation = "delete"
+	OperationMembership Operation = "membership"
+)
+
+// Member is a member of a group, identified both locally and in the
+// downstream system.
+type Member struct {
+	ID       string             // Id of the member in the gateway.
+	Type     store.ResourceType // Resource type of the member.
+	RemoteID string             // Id of the member in the downstream system, empty when it was never provisioned.
+}
+
+// Request is a single operation on a resource, as passed to a connector.
+type Request struct {
+	Tenant   string          // Tenant the resource belongs to.
+	Resource *store.Resource // Current version of the resource, or its last version when it was deleted.
+	RemoteID string          // Id of the resource in the downstream system, empty when it was never provisioned.
+	Members  []Member        // Current members of a group.
+	Added    []Member        // Members added to a group by the change.
+	Removed  []Member        // Members removed from a group by the change.
+}
+
+// Connector provisions resources to a downstream system. Connectors must be
+// safe for concurrent use. Operations on the same resource are never
+// dispatched concurrently.
+type Connector interface {
+	// Create provisions a new resource and returns its id in the downstream
+	// system.
+	Create(ctx context.Context, req *Request) (string, error)
+
+	// Update replaces the downstream representation of a resource. When the
+	// change moves the resource to a new downstream id, such as a renamed
+	// directory entry, Update sets the new id on req.
+	Update(ctx context.Context, req *Request) error
+
+	// Disable deactivates a user without removing it.
+	Disable(ctx context.Context, req *Request) error
+
+	// Delete removes a resource from the downstream system.
+	Delete(ctx context.Context, req *Request) error
+
+	// UpdateMembership adds and removes the members of a group.
+	UpdateMembership(ctx context.Context, req *Request) error
+}
+
+// Settings decodes the type specific settings of a connector definition.
+type Settings interface {
+	Decode(v any) error
+}
+
+// Factory constructs a connector of a given type from its settings.
+type Factory func(name string, settings Settings) (Connector, error)
@@ -0,0 +1,459 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/iamBelugaa/scim-gateway/internal/config"
+	"github.com/iamBelugaa/scim-gateway/internal/filter"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+	"github.com/iamBelugaa/scim-gateway/internal/tenant"
+	"github.com/iamBelugaa/scim-gateway/pkg/logger"
+)
+
+// recorder is a connector recording the operations dispatched to it. Remote
+// ids are the local ids prefixed with "remote-".
+type recorder struct {
+	mu    sync.Mutex
+	calls []call
+	fail  map[Operation]error // Errors returned per operation.
+}
+
+// call is a single recorded operation.
+type call struct {
+	op       Operation
+	id       string
+	remoteID string
+	added    []string // Remote ids of added members.
+	removed  []string // Remote ids of removed members.
+}
+
+func (r *recorder) record(op Operation, req *Request) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c := call{op: op, id: req.Resource.ID, remoteID: req.RemoteID}
+	for _, member := range req.Added {
+		c.added = append(c.added, member.RemoteID)
+	}
+	for _, member := range req.Removed {
+		c.removed = append(c.removed, member.RemoteID)
+	}
+	r.calls = append(r.calls, c)
+	return r.fail[op]
+}
+
+func (r *recorder) Create(ctx context.Context, req *Request) (string, error) {
+	if err := r.record(OperationCreate, req); err != nil {
+		return "", err
+	}
+	return "remote-" + req.Resource.ID, nil
+}
+
+func (r *recorder) Update(ctx context.Context, req *Request) error {
+	return r.record(OperationUpdate, req)
+}
+
+func (r *recorder) Disable(ctx context.Context, req *Request) error {
+	return r.record(OperationDisable, req)
+}
+
+func (r *recorder) Delete(ctx context.Context, req *Request) error {
+	return r.record(OperationDelete, req)
+}
+
+func (r *recorder) UpdateMembership(ctx context.Context, req *Request) error {
+	return r.record(OperationMembership, req)
+}
+
+// ops returns the recorded operations and clears them.
+func (r *recorder) ops() []call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	calls := r.calls
+	r.calls = nil
+	return calls
+}
+
+// newTestDispatcher returns a dispatcher with a recorder registered for the
+// default tenant.
+func newTestDispatcher(t *testing.T) (*Dispatcher, *recorder) {
+	t.Helper()
+
+	d := NewDispatcher(&logger.Logger{SugaredLogger: zap.NewNop().Sugar()}, time.Second)
+	rec := &recorder{fail: make(map[Operation]error)}
+	types := []store.ResourceType{store.ResourceTypeUser, store.ResourceTypeGroup}
+	if err := d.Register("downstream", "default", types, nil, rec); err != nil {
+		t.Fatalf("failed to register connector : %v", err)
+	}
+	return d, rec
+}
+
+// newTestDelivery runs an outbox delivering changes to the connectors of a
+// dispatcher. The returned function queues changes one at a time to every
+// connector registered for their tenant and resource type, and waits until
+// each is delivered. Failed deliveries are dead-lettered without a retry.
+func newTestDelivery(t *testing.T, d *Dispatcher) func(changes ...Change) {
+	t.Helper()
+
+	var tenantIDs []string
+	for _, reg := range d.connectors {
+		if reg.tenant != "default" && !slices.Contains(tenantIDs, reg.tenant) {
+			tenantIDs = append(tenantIDs, reg.tenant)
+		}
+	}
+	tenants, err := tenant.NewWithConfig(&config.Tenancy{Tenants: tenantIDs}, &config.Store{})
+	if err != nil {
+		t.Fatalf("failed to construct tenants : %v", err)
+	}
+	o := runOutbox(t, d, tenants, 1)
+
+	return func(changes ...Change) {
+		t.Helper()
+
+		for _, change := range changes {
+			for _, reg := range d.connectors {
+				if reg.tenant == change.Tenant && reg.types[change.Resource.Type] {
+					o.queue(reg, change)
+				}
+			}
+			eventually(t, "the change to be delivered", func() bool {
+				o.mu.Lock()
+				defer o.mu.Unlock()
+				return len(o.inFlight) == 0
+			})
+		}
+	}
+}
+
+// user returns a user resource.
+func user(id string, attrs map[string]any) *store.Resource {
+	return &store.Resource{ID: id, Type: store.ResourceTypeUser, Attributes: attrs}
+}
+
+// group returns a group resource with the given user members.
+func group(id string, memberIDs ...string) *store.Resource {
+	members := make([]any, 0, len(memberIDs))
+	for _, memberID := range memberIDs {
+		members = append(members, map[string]any{"value": memberID, "type": "User"})
+	}
+	return &store.Resource{
+		ID: id, Type: store.ResourceTypeGroup,
+		Attributes: map[string]any{"displayName": "Tour Guides", "members": members},
+	}
+}
+
+// TestDeliveryTracksRemoteIDs checks created resources are updated and
+// deleted by their remote id, and the status follows every operation.
+func TestDeliveryTracksRemoteIDs(t *testing.T) {
+	d, rec := newTestDispatcher(t)
+	deliver := newTestDelivery(t, d)
+
+	created := user("u1", map[string]any{"userName": "bjensen"})
+	deliver(Change{Tenant: "default", Kind: ChangeCreated, Resource: created})
+
+	updated := user("u1", map[string]any{"userName": "bjensen", "title": "Tour Guide"})
+	deliver(Change{Tenant: "default", Kind: ChangeUpdated, Resource: updated, Previous: created})
+
+	status, ok := d.Statuses().Get("default", store.ResourceTypeUser, "u1", "downstream")
+	if !ok || status.State != StateSynced || status.Operation != OperationUpdate || status.RemoteID != "remote-u1" {
+		t.Fatalf("after update : got status %+v", status)
+	}
+
+	deliver(Change{Tenant: "default", Kind: ChangeDeleted, Resource: updated})
+
+	want := []call{
+		{op: OperationCreate, id: "u1"},
+		{op: OperationUpdate, id: "u1", remoteID: "remote-u1"},
+		{op: OperationDelete, id: "u1", remoteID: "remote-u1"},
+	}
+	if got := rec.ops(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got operations %+v, want %+v", got, want)
+	}
+
+	status, _ = d.Statuses().Get("default", store.ResourceTypeUser, "u1", "downstream")
+	if status.Operation != OperationDelete || status.RemoteID != "" {
+		t.Fatalf("after delete : got status %+v", status)
+	}
+}
+
+// TestDeliveryDisablesDeactivatedUsers checks deactivating a user disables
+// it, together with an update when other attributes changed too.
+func TestDeliveryDisablesDeactivatedUsers(t *testing.T) {
+	d, rec := newTestDispatcher(t)
+	deliver := newTestDelivery(t, d)
+
+	active := user("u1", map[string]any{"userName": "bjensen", "active": true})
+	deliver(Change{Tenant: "default", Kind: ChangeCreated, Resource: active})
+	rec.ops()
+
+	inactive := user("u1", map[string]any{"userName": "bjensen", "active": false})
+	deliver(Change{Tenant: "default", Kind: ChangeUpdated, Resource: inactive, Previous: active})
+	if got := rec.ops(); len(got) != 1 || got[0].op != OperationDisable {
+		t.Fatalf("deactivated : got operations %+v, want a single disable", got)
+	}
+
+	renamed := user("u1", map[string]any{"userName": "bjensen", "title": "Retired", "active": false})
+	deliver(Change{Tenant: "default", Kind: ChangeUpdated, Resource: renamed, Previous: active})
+	if got := rec.ops(); len(got) != 2 || got[0].op != OperationUpdate || got[1].op != OperationDisable {
+		t.Fatalf("deactivated and changed : got operations %+v, want an update then a disable", got)
+	}
+
+	withGroups := user("u1", map[string]any{"userName": "bjensen", "active": true, "groups": []any{"g1"}})
+	deliver(Change{Tenant: "default", Kind: ChangeUpdated, Resource: withGroups, Previous: active})
+	if got := rec.ops(); len(got) != 0 {
+		t.Fatalf("derived groups changed : got operations %+v, want none", got)
+	}
+}
+
+// TestDeliveryMembershipChanges checks group membership changes are
+// delivered with the remote ids of the members.
+func TestDeliveryMembershipChanges(t *testing.T) {
+	d, rec := newTestDispatcher(t)
+	deliver := newTestDelivery(t, d)
+
+	for _, id := range []string{"u1", "u2"} {
+		deliver(Change{Tenant: "default", Kind: ChangeCreated, Resource: user(id, map[string]any{"userName": id})})
+	}
+	before := group("g1", "u1")
+	deliver(Change{Tenant: "default", Kind: ChangeCreated, Resource: before})
+	rec.ops()
+
+	after := group("g1", "u2", "u3")
+	deliver(Change{Tenant: "default", Kind: ChangeUpdated, Resource: after, Previous: before})
+
+	want := []call{{op: OperationMembership, id: "g1", remoteID: "remote-g1", added: []string{"remote-u2", ""}, removed: []string{"remote-u1"}}}
+	if got := rec.ops(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got operations %+v, want %+v", got, want)
+	}
+}
+
+// TestDeliveryRecordsFailures checks failed operations are recorded on the
+// resource, and a resource whose creation failed is created on its next
+// change.
+func TestDeliveryRecordsFailures(t *testing.T) {
+	d, rec := newTestDispatcher(t)
+	deliver := newTestDelivery(t, d)
+
+	rec.fail[OperationCreate] = errors.New("downstream unavailable")
+	created := user("u1", map[string]any{"userName": "bjensen"})
+	deliver(Change{Tenant: "default", Kind: ChangeCreated, Resource: created})
+
+	status, _ := d.Statuses().Get("default", store.ResourceTypeUser, "u1", "downstream")
+	if status.State != StateFailed || status.Error != "downstream unavailable" {
+		t.Fatalf("got status %+v, want a failed create", status)
+	}
+
+	delete(rec.fail, OperationCreate)
+	updated := user("u1", map[string]any{"userName": "bjensen", "title": "Tour Guide"})
+	deliver(Change{Tenant: "default", Kind: ChangeUpdated, Resource: updated, Previous: created})
+
+	if got := rec.ops(); len(got) != 2 || got[1].op != OperationCreate {
+		t.Fatalf("got operations %+v, want the create to be retried", got)
+	}
+	if status, _ := d.Statuses().Get("default", store.ResourceTypeUser, "u1", "downstream"); status.State != StateSynced {
+		t.Fatalf("got status %+v, want synced", status)
+	}
+}
+
+// TestDeliveryScopesConnectors checks connectors only receive changes of
+// their tenant and resource types.
+func TestDeliveryScopesConnectors(t *testing.T) {
+	d := NewDispatcher(&logger.Logger{SugaredLogger: zap.NewNop().Sugar()}, time.Second)
+	rec := &recorder{}
+	if err := d.Register("groups-only", "acme", []store.ResourceType{store.ResourceTypeGroup}, nil, rec); err != nil {
+		t.Fatalf("failed to register connector : %v", err)
+	}
+	if err := d.Register("groups-only", "acme", nil, nil, rec); err == nil {
+		t.Fatalf("registered a duplicate connector name")
+	}
+	deliver := newTestDelivery(t, d)
+
+	deliver(Change{Tenant: "acme", Kind: ChangeCreated, Resource: user("u1", nil)})
+	deliver(Change{Tenant: "default", Kind: ChangeCreated, Resource: group("g1")})
+	deliver(Change{Tenant: "acme", Kind: ChangeCreated, Resource: group("g2")})
+
+	if got := rec.ops(); len(got) != 1 || got[0].id != "g2" {
+		t.Fatalf("got operations %+v, want only the acme group", got)
+	}
+}
+
+// TestDeliveryFilterScope checks connectors only provision resources within
+// their scope, deprovision resources leaving it and provision resources
+// entering it.
+func TestDeliveryFilterScope(t *testing.T) {
+	scope, err := filter.Parse(`title eq "Engineer" and active eq true`)
+	if err != nil {
+		t.Fatalf("failed to parse scope : %v", err)
+	}
+	d := NewDispatcher(&logger.Logger{SugaredLogger: zap.NewNop().Sugar()}, time.Second)
+	rec := &recorder{}
+	if err := d.Register("engineering", "default", []store.ResourceType{store.ResourceTypeUser}, scope, rec); err != nil {
+		t.Fatalf("failed to register connector : %v", err)
+	}
+	deliver := newTestDelivery(t, d)
+
+	sales := user("u1", map[string]any{"userName": "bjensen", "title": "Sales", "active": true})
+	deliver(Change{Tenant: "default", Kind: ChangeCreated, Resource: sales})
+	if got := rec.ops(); len(got) != 0 {
+		t.Fatalf("created out of scope : got operations %+v, want none", got)
+	}
+
+	engineer := user("u1", map[string]any{"userName": "bjensen", "title": "Engineer", "active": true})
+	deliver(Change{Tenant: "default", Kind: ChangeUpdated, Resource: engineer, Previous: sales})
+	if got := rec.ops(); len(got) != 1 || got[0].op != OperationCreate {
+		t.Fatalf("entered scope : got operations %+v, want a create", got)
+	}
+
+	inactive := user("u1", map[string]any{"userName": "bjensen", "title": "Engineer", "active": false})
+	deliver(Change{Tenant: "default", Kind: ChangeUpdated, Resource: inactive, Previous: engineer})
+	want := []call{{op: OperationDelete, id: "u1", remoteID: "remote-u1"}}
+	if got := rec.ops(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("left scope : got operations %+v, want %+v", got, want)
+	}
+
+	deliver(Change{Tenant: "default", Kind: ChangeDeleted, Resource: inactive})
+	if got := rec.ops(); len(got) != 0 {
+		t.Fatalf("deleted out of scope : got operations %+v, want none", got)
+	}
+}
+
+// TestNewWithConfig checks connectors are registered from a configuration
+// file and invalid definitions are reported.
+func TestNewWithConfig(t *testing.T) {
+	log := &logger.Logger{SugaredLogger: zap.NewNop().Sugar()}
+	tenants, err := tenant.NewWithConfig(&config.Tenancy{Tenants: []string{"acme"}}, &config.Store{})
+	if err != nil {
+		t.Fatalf("failed to construct tenants : %v", err)
+	}
+
+	var settings []string
+	factories := Factories{
+		"test": func(name string, s Settings) (Connector, error) {
+			var decoded struct {
+				URL string `yaml:"url"`
+			}
+			if err := s.Decode(&decoded); err != nil {
+				return nil, err
+			}
+			settings = append(settings, name+"="+decoded.URL)
+			return &recorder{}, nil
+		},
+	}
+
+	path := filepath.Join(t.TempDir(), "connectors.yaml")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to write connectors file : %v", err)
+		}
+	}
+
+	write(`
+connectors:
+  - name: apps
+    type: test
+    settings:
+      url: https://apps.example.com
+  - name: acme-groups
+    type: test
+    tenant: acme
+    resourceTypes: [Group]
+`)
+	d, err := NewWithConfig(log, &config.Connectors{ConfigFile: path, Timeout: time.Second}, tenants, factories)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !d.Enabled() || len(d.connectors) != 2 || d.connectors[1].tenant != "acme" || !d.connectors[1].types[store.ResourceTypeGroup] {
+		t.Fatalf("got connectors %+v", d.connectors)
+	}
+	if !slices.Equal(settings, []string{"apps=https://apps.example.com", "acme-groups="}) {
+		t.Fatalf("got settings %v", settings)
+	}
+
+	for _, content := range []string{
+		`{"connectors": [{"name": "apps", "type": "unknown"}]}`,
+		`{"connectors": [{"type": "test"}]}`,
+		`{"connectors": [{"name": "apps", "type": "test", "tenant": "initech"}]}`,
+		`{"connectors": [{"name": "apps", "type": "test", "resourceTypes": ["Device"]}]}`,
+		`{"connectors": [{"name": "apps", "type": "test"}, {"name": "apps", "type": "test"}]}`,
+		`{"connectors": [{"name": "apps", "type": "test", "mapping": [{"target": "login"}]}]}`,
+		`{"connectors": [{"name": "apps", "type": "test", "mappingFile": "missing.yaml"}]}`,
+		`{"connectors": [{"name": "apps", "type": "test", "scope": "title eq"}]}`,
+	} {
+		write(content)
+		if _, err := NewWithConfig(log, &config.Connectors{ConfigFile: path}, tenants, factories); err == nil {
+			t.Errorf("%s : expected an error", content)
+		}
+	}
+
+	if d, err := NewWithConfig(log, &config.Connectors{}, tenants, factories); err != nil || d.Enabled() {
+		t.Fatalf("without a file : got %v, want a dispatcher without connectors", err)
+	}
+}
+
+// mappedRecorder is a connector keeping the attributes of the resources it
+// is asked to create.
+type mappedRecorder struct {
+	recorder
+	created []map[string]any
+}
+
+func (r *mappedRecorder) Create(ctx context.Context, req *Request) (string, error) {
+	r.created = append(r.created, req.Resource.Attributes)
+	return r.recorder.Create(ctx, req)
+}
+
+// TestMappedConnector checks connectors with a mapping receive mapped copies
+// of resources, leaving the resources themselves untouched.
+func TestMappedConnector(t *testing.T) {
+	log := &logger.Logger{SugaredLogger: zap.NewNop().Sugar()}
+	tenants, err := tenant.NewWithConfig(&config.Tenancy{}, &config.Store{})
+	if err != nil {
+		t.Fatalf("failed to construct tenants : %v", err)
+	}
+
+	rec := &mappedRecorder{}
+	factories := Factories{"test": func(name string, s Settings) (Connector, error) { return rec, nil }}
+
+	path := filepath.Join(t.TempDir(), "connectors.yaml")
+	content := `
+connectors:
+  - name: apps
+    type: test
+    mapping:
+      - target: login
+        source: [name.givenName, name.familyName]
+        transforms: [{concat: {separator: "."}}, lower]
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write connectors file : %v", err)
+	}
+	d, err := NewWithConfig(log, &config.Connectors{ConfigFile: path, Timeout: time.Second}, tenants, factories)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	deliver := newTestDelivery(t, d)
+
+	res := user("u1", map[string]any{"name": map[string]any{"givenName": "Alice", "familyName": "Liddell"}})
+	deliver(Change{Tenant: "default", Kind: ChangeCreated, Resource: res})
+
+	if len(rec.created) != 1 || rec.created[0]["login"] != "alice.liddell" {
+		t.Fatalf("expected the mapped login, got %v", rec.created)
+	}
+	if _, ok := res.Attributes["login"]; ok {
+		t.Fatal("expected the resource itself to be left untouched")
+	}
+	if status, _ := d.Statuses().Get("default", store.ResourceTypeUser, "u1", "apps"); status.RemoteID != "remote-u1" {
+		t.Fatalf("expected the remote id of the mapped connector, got %+v", status)
+	}
+}
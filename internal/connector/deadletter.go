@@ -0,0 +1,156 @@
+package connector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrDeadLetterNotFound is returned when a dead letter does not exist, or
+// belongs to another tenant.
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetter is a change a connector failed to provision, either because the
+// failure was permanent or because every attempt failed. It is kept until an
+// operator retries or discards it.
+type DeadLetter struct {
+	ID        string    `json:"id"`        // Unique identifier of the dead letter.
+	Connector string    `json:"connector"` // Name of the connector the change was delivered to.
+	Change    Change    `json:"change"`    // Change that could not be provisioned.
+	Attempts  int       `json:"attempts"`  // Number of attempts made.
+	Error     string    `json:"error"`     // Error of the last attempt.
+	FailedAt  time.Time `json:"failedAt"`  // Time of the last attempt.
+}
+
+// DeadLetters holds the dead letters of every tenant, in memory and
+// optionally in a file so they survive a restart.
+type DeadLetters struct {
+	path string // File the dead letters are persisted to, empty when they are not.
+
+	mu      sync.RWMutex
+	letters map[string]*DeadLetter // Dead letters keyed by id.
+}
+
+// NewDeadLetters constructs an empty dead letter store held in memory only.
+func NewDeadLetters() *DeadLetters {
+	return &DeadLetters{letters: make(map[string]*DeadLetter)}
+}
+
+// OpenDeadLetters constructs a dead letter store persisted to the file at
+// path, holding the dead letters the file already lists. The file is
+// rewritten whenever a dead letter is added or discarded.
+func OpenDeadLetters(path string) (*DeadLetters, error) {
+	s := &DeadLetters{path: path, letters: make(map[string]*DeadLetter)}
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dead letters : %w", err)
+	}
+
+	var letters []*DeadLetter
+	if err := json.Unmarshal(raw, &letters); err != nil {
+		return nil, fmt.Errorf("failed to decode dead letters : %w", err)
+	}
+	for _, letter := range letters {
+		s.letters[letter.ID] = letter
+	}
+	return s, nil
+}
+
+// List returns the dead letters of a tenant, most recent failure first.
+func (s *DeadLetters) List(tenant string) []DeadLetter {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	letters := make([]DeadLetter, 0)
+	for _, letter := range s.letters {
+		if letter.Change.Tenant == tenant {
+			letters = append(letters, *letter)
+		}
+	}
+	slices.SortFunc(letters, func(a, b DeadLetter) int {
+		return b.FailedAt.Compare(a.FailedAt)
+	})
+	return letters
+}
+
+// Get returns a dead letter of a tenant.
+func (s *DeadLetters) Get(tenant, id string) (DeadLetter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	letter, ok := s.letters[id]
+	if !ok || letter.Change.Tenant != tenant {
+		return DeadLetter{}, fmt.Errorf("dead letter %q : %w", id, ErrDeadLetterNotFound)
+	}
+	return *letter, nil
+}
+
+// Discard removes a dead letter of a tenant without provisioning its change.
+func (s *DeadLetters) Discard(tenant, id string) (DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	letter, ok := s.letters[id]
+	if !ok || letter.Change.Tenant != tenant {
+		return DeadLetter{}, fmt.Errorf("dead letter %q : %w", id, ErrDeadLetterNotFound)
+	}
+	delete(s.letters, id)
+	if err := s.save(); err != nil {
+		s.letters[id] = letter
+		return DeadLetter{}, err
+	}
+	return *letter, nil
+}
+
+// add stores a dead letter, assigning it an id when none is set. Retried
+// dead letters that fail again keep their id. A dead letter that cannot be
+// persisted is still held in memory, and the error returned along with it.
+func (s *DeadLetters) add(letter DeadLetter) (DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if letter.ID == "" {
+		letter.ID = uuid.NewString()
+	}
+	s.letters[letter.ID] = &letter
+	return letter, s.save()
+}
+
+// save replaces the file the dead letters are persisted to, when they are.
+// Callers must hold the write lock.
+func (s *DeadLetters) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	letters := make([]*DeadLetter, 0, len(s.letters))
+	for _, letter := range s.letters {
+		letters = append(letters, letter)
+	}
+	slices.SortFunc(letters, func(a, b *DeadLetter) int {
+		return a.FailedAt.Compare(b.FailedAt)
+	})
+
+	raw, err := json.Marshal(letters)
+	if err != nil {
+		return fmt.Errorf("failed to encode dead letters : %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		return fmt.Errorf("failed to write dead letters : %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to replace dead letters : %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,345 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"reflect"
+	"time"
+
+	"github.com/iamBelugaa/scim-gateway/internal/filter"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+	"github.com/iamBelugaa/scim-gateway/pkg/logger"
+)
+
+// ChangeKind is the kind of change made to a resource.
+type ChangeKind string
+
+// Supported change kinds.
+const (
+	ChangeCreated ChangeKind = "created"
+	ChangeUpdated ChangeKind = "updated"
+	ChangeDeleted ChangeKind = "deleted"
+)
+
+// Change is a successful change to a resource, to be provisioned downstream.
+type Change struct {
+	Tenant   string          `json:"tenant"`             // Tenant the resource belongs to.
+	Kind     ChangeKind      `json:"kind"`               // Kind of change.
+	Resource *store.Resource `json:"resource"`           // Resource after the change, or its last version when it was deleted.
+	Previous *store.Resource `json:"previous,omitempty"` // Resource before an update, nil for other changes.
+}
+
+// registration is a connector registered with a dispatcher.
+type registration struct {
+	name      string
+	tenant    string                      // Tenant whose resources are provisioned.
+	types     map[store.ResourceType]bool // Resource types provisioned by the connector.
+	scope     *filter.Filter              // Filter selecting the resources provisioned, nil for every resource.
+	connector Connector
+}
+
+// inScope reports whether a resource is within the scope of the connector.
+func (reg *registration) inScope(res *store.Resource) bool {
+	return reg.scope == nil || reg.scope.Matches(res.Attributes)
+}
+
+// operations returns the operations that provision a change to the
+// connector, given whether the resource is already provisioned there.
+// Resources out of the scope of the connector are deprovisioned when it holds
+// them, and never provisioned otherwise.
+func (reg *registration) operations(change Change, provisioned bool) []Operation {
+	if change.Kind == ChangeDeleted || reg.inScope(change.Resource) {
+		return Operations(change, provisioned)
+	}
+	if provisioned {
+		return []Operation{OperationDelete}
+	}
+	return nil
+}
+
+// Dispatcher holds the connectors registered for every tenant and resource
+// type, runs the operations provisioning a change on them and tracks the
+// outcome. Changes reach it through an Outbox, which retries failed
+// deliveries and delivers the changes to the same resource in order.
+type Dispatcher struct {
+	log        *logger.Logger
+	timeout    time.Duration // How long a single connector operation may take.
+	connectors []*registration
+	statuses   *Statuses
+}
+
+// NewDispatcher constructs a dispatcher without connectors.
+func NewDispatcher(log *logger.Logger, timeout time.Duration) *Dispatcher {
+	return &Dispatcher{log: log, timeout: timeout, statuses: NewStatuses()}
+}
+
+// Register adds a connector provisioning resources of the given types of a
+// tenant, limited to the resources matching scope unless it is nil. Connector
+// names must be unique.
+func (d *Dispatcher) Register(name, tenant string, types []store.ResourceType, scope *filter.Filter, connector Connector) error {
+	if _, ok := d.registration(name); ok {
+		return fmt.Errorf("connector %q is already registered", name)
+	}
+
+	reg := &registration{name: name, tenant: tenant, types: make(map[store.ResourceType]bool), scope: scope, connector: connector}
+	for _, resourceType := range types {
+		reg.types[resourceType] = true
+	}
+	d.connectors = append(d.connectors, reg)
+	return nil
+}
+
+// Enabled reports whether any connector is registered.
+func (d *Dispatcher) Enabled() bool {
+	return d != nil && len(d.connectors) > 0
+}
+
+// Statuses returns the provisioning status tracker.
+func (d *Dispatcher) Statuses() *Statuses {
+	return d.statuses
+}
+
+// deliver runs the operations a change requires on a single connector,
+// records the outcome and returns the error of the first operation that
+// failed.
+func (d *Dispatcher) deliver(ctx context.Context, reg *registration, change Change) error {
+	res := change.Resource
+	previous, _ := d.statuses.Get(change.Tenant, res.Type, res.ID, reg.name)
+
+	ops := reg.operations(change, previous.RemoteID != "")
+	if len(ops) == 0 {
+		return nil
+	}
+
+	req := &Request{Tenant: change.Tenant, Resource: res, RemoteID: previous.RemoteID}
+	if res.Type == store.ResourceTypeGroup {
+		req.Members = d.resolve(change.Tenant, reg.name, memberRefs(res))
+		if change.Previous != nil {
+			added, removed := diffMembers(change.Previous, res)
+			req.Added = d.resolve(change.Tenant, reg.name, added)
+			req.Removed = d.resolve(change.Tenant, reg.name, removed)
+		}
+	}
+
+	var err error
+	status := Status{Connector: reg.name, State: StateSynced, RemoteID: req.RemoteID}
+	for _, op := range ops {
+		status.Operation = op
+
+		// The remote id is kept even when the operation fails, since it may
+		// have moved the downstream resource before failing.
+		err = d.run(ctx, reg.connector, op, req)
+		status.RemoteID = req.RemoteID
+		if err != nil {
+			status.State = StateFailed
+			status.Error = err.Error()
+			d.log.Warnw(
+				"failed to provision resource",
+				"connector", reg.name, "tenant", change.Tenant, "resourceType", res.Type, "id", res.ID,
+				"operation", op, "error", err,
+			)
+			break
+		}
+
+		d.log.Infow(
+			"provisioned resource",
+			"connector", reg.name, "tenant", change.Tenant, "resourceType", res.Type, "id", res.ID,
+			"operation", op, "remoteId", req.RemoteID,
+		)
+	}
+
+	status.UpdatedAt = time.Now()
+	d.statuses.set(change.Tenant, res.Type, res.ID, status)
+	return err
+}
+
+// registration returns the connector registered under the given name.
+func (d *Dispatcher) registration(name string) (*registration, bool) {
+	for _, reg := range d.connectors {
+		if reg.name == name {
+			return reg, true
+		}
+	}
+	return nil, false
+}
+
+// run performs a single operation, bounded by the connector timeout. The
+// request's remote id is updated when the operation creates or deletes the
+// downstream resource.
+func (d *Dispatcher) run(ctx context.Context, connector Connector, op Operation, req *Request) error {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	switch op {
+	case OperationCreate:
+		remoteID, err := connector.Create(ctx, req)
+		if err != nil {
+			return err
+		}
+		req.RemoteID = remoteID
+		return nil
+	case OperationUpdate:
+		return connector.Update(ctx, req)
+	case OperationDisable:
+		return connector.Disable(ctx, req)
+	case OperationMembership:
+		return connector.UpdateMembership(ctx, req)
+	case OperationDelete:
+		if err := connector.Delete(ctx, req); err != nil {
+			return err
+		}
+		req.RemoteID = ""
+		return nil
+	default:
+		return fmt.Errorf("unsupported operation %q", op)
+	}
+}
+
+// resolve looks up the downstream ids of group members for a connector.
+func (d *Dispatcher) resolve(tenant, connector string, members []Member) []Member {
+	resolved := make([]Member, len(members))
+	for i, member := range members {
+		status, _ := d.statuses.Get(tenant, member.Type, member.ID, connector)
+		member.RemoteID = status.RemoteID
+		resolved[i] = member
+	}
+	return resolved
+}
+
+// Operations returns the operations that provision a change to a connector,
+// given whether the resource is already provisioned there. Resources that
+// were never provisioned are created on their next update, and deleting them
+// requires nothing. Deactivating a user disables it, and membership changes
+// of a group are provisioned apart from its other attributes.
+func Operations(change Change, provisioned bool) []Operation {
+	switch change.Kind {
+	case ChangeCreated:
+		return []Operation{OperationCreate}
+
+	case ChangeDeleted:
+		if !provisioned {
+			return nil
+		}
+		return []Operation{OperationDelete}
+
+	case ChangeUpdated:
+		if !provisioned {
+			return []Operation{OperationCreate}
+		}
+		if change.Previous == nil {
+			return []Operation{OperationUpdate}
+		}
+
+		previous, current := change.Previous.Attributes, change.Resource.Attributes
+		var ops []Operation
+
+		if change.Resource.Type == store.ResourceTypeGroup {
+			if changed(previous, current, membersAttribute) {
+				ops = append(ops, OperationUpdate)
+			}
+			if !reflect.DeepEqual(previous[membersAttribute], current[membersAttribute]) {
+				ops = append(ops, OperationMembership)
+			}
+			return ops
+		}
+
+		if active(previous) && !active(current) {
+			if changed(previous, current, activeAttribute, groupsAttribute) {
+				ops = append(ops, OperationUpdate)
+			}
+			return append(ops, OperationDisable)
+		}
+		if changed(previous, current, groupsAttribute) {
+			ops = append(ops, OperationUpdate)
+		}
+		return ops
+
+	default:
+		return nil
+	}
+}
+
+// Attributes with a meaning of their own to provisioning.
+const (
+	activeAttribute  = "active"  // Whether a user is active.
+	groupsAttribute  = "groups"  // Groups of a user, derived from group members.
+	membersAttribute = "members" // Members of a group.
+)
+
+// active reports whether a user is active. Users without the attribute are
+// active.
+func active(attrs map[string]any) bool {
+	isActive, ok := attrs[activeAttribute].(bool)
+	return !ok || isActive
+}
+
+// changed reports whether attributes other than the ignored ones differ.
+func changed(previous, current map[string]any, ignored ...string) bool {
+	previous, current = maps.Clone(previous), maps.Clone(current)
+	for _, name := range ignored {
+		delete(previous, name)
+		delete(current, name)
+	}
+	return !reflect.DeepEqual(previous, current)
+}
+
+// memberRefs returns the members of a group.
+func memberRefs(group *store.Resource) []Member {
+	entries, _ := group.Attributes[membersAttribute].([]any)
+
+	members := make([]Member, 0, len(entries))
+	for _, entry := range entries {
+		m, ok := entry.(map[string]any)
+		if !ok {
+			continue
+		}
+		id, _ := m["value"].(string)
+		if id == "" {
+			continue
+		}
+		memberType := store.ResourceTypeUser
+		if t, _ := m["type"].(string); t == string(store.ResourceTypeGroup) {
+			memberType = store.ResourceTypeGroup
+		}
+		members = append(members, Member{ID: id, Type: memberType})
+	}
+	return members
+}
+
+// withoutMember returns a copy of a group without the given member, as the
+// group was before the member was added.
+func withoutMember(group *store.Resource, memberID string) *store.Resource {
+	previous := group.Clone()
+	entries, _ := previous.Attributes[membersAttribute].([]any)
+
+	kept := make([]any, 0, len(entries))
+	for _, entry := range entries {
+		if m, ok := entry.(map[string]any); !ok || m["value"] != memberID {
+			kept = append(kept, entry)
+		}
+	}
+	previous.Attributes[membersAttribute] = kept
+	return previous
+}
+
+// diffMembers returns the members added to and removed from a group.
+func diffMembers(previous, current *store.Resource) (added, removed []Member) {
+	before := make(map[string]bool)
+	for _, member := range memberRefs(previous) {
+		before[member.ID] = true
+	}
+	after := make(map[string]bool)
+	for _, member := range memberRefs(current) {
+		after[member.ID] = true
+		if !before[member.ID] {
+			added = append(added, member)
+		}
+	}
+	for _, member := range memberRefs(previous) {
+		if !after[member.ID] {
+			removed = append(removed, member)
+		}
+	}
+	return added, removed
+}
@@ -0,0 +1,520 @@
+// Package ldap provisions Users and Groups as entries of an LDAP directory,
+// such as OpenLDAP, 389 Directory Server or Active Directory.
+package ldap
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	ldapv3 "github.com/go-ldap/ldap/v3"
+
+	"github.com/iamBelugaa/scim-gateway/internal/attribute"
+	"github.com/iamBelugaa/scim-gateway/internal/connector"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+)
+
+// Type is the connector type of LDAP connectors in connector definitions.
+const Type = "ldap"
+
+// dialTimeout bounds how long connecting to the directory may take when the
+// operation has no deadline of its own.
+const dialTimeout = time.Second * 10
+
+// placeholder matches the attribute placeholders of DN templates, such as
+// "{userName}".
+var placeholder = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// Settings are the settings of an LDAP connector definition.
+type Settings struct {
+	URL             string `yaml:"url"`             // URL of the directory, ldap:// or ldaps://.
+	StartTLS        bool   `yaml:"startTLS"`        // Whether to upgrade ldap:// connections with StartTLS.
+	CAFile          string `yaml:"caFile"`          // PEM file of the CAs the directory certificate must be signed by, the system pool when empty.
+	BindDN          string `yaml:"bindDN"`          // DN the connector binds as, anonymous when empty.
+	BindPassword    string `yaml:"bindPassword"`    // Password of the bind DN.
+	BindPasswordEnv string `yaml:"bindPasswordEnv"` // Environment variable holding the password of the bind DN, instead of BindPassword.
+
+	Users   EntrySettings   `yaml:"users"`   // Mapping of Users onto entries.
+	Groups  GroupSettings   `yaml:"groups"`  // Mapping of Groups onto entries.
+	Disable DisableSettings `yaml:"disable"` // How users are disabled.
+}
+
+// EntrySettings map a resource type onto directory entries.
+type EntrySettings struct {
+	// DN template of the entries, with SCIM attribute paths in braces, such
+	// as "uid={userName},ou=people,dc=example,dc=com". Values are escaped.
+	DN            string            `yaml:"dn"`
+	ObjectClasses []string          `yaml:"objectClasses"` // Object classes of created entries.
+	Attributes    map[string]string `yaml:"attributes"`    // SCIM attribute paths keyed by LDAP attribute.
+}
+
+// GroupSettings map Groups onto directory entries.
+type GroupSettings struct {
+	EntrySettings `yaml:",inline"`
+
+	MemberAttribute string `yaml:"memberAttribute"` // Either "member" or "uniqueMember", "member" when empty.
+	EmptyMember     string `yaml:"emptyMember"`     // DN set as only member of groups without members, for object classes requiring one.
+}
+
+// DisableSettings define the attribute marking a user as disabled, such as
+// userAccountControl in Active Directory or nsAccountLock in 389 Directory
+// Server.
+type DisableSettings struct {
+	Attribute     string `yaml:"attribute"`     // Attribute marking the user as disabled. Users cannot be disabled when empty.
+	DisabledValue string `yaml:"disabledValue"` // Value of the attribute for disabled users, such as "514" or "TRUE".
+	EnabledValue  string `yaml:"enabledValue"`  // Value of the attribute for enabled users. The attribute is removed when empty.
+}
+
+// entryMapping is a parsed EntrySettings.
+type entryMapping struct {
+	dn            string
+	objectClasses []string
+	attributes    map[string]attribute.Path // SCIM attribute paths keyed by LDAP attribute.
+}
+
+// Connector provisions resources as directory entries. The downstream id of
+// a resource is the DN of its entry. Every operation uses its own
+// connection, so the connector holds no state between operations.
+type Connector struct {
+	url          string
+	startTLS     bool
+	tls          *tls.Config
+	bindDN       string
+	bindPassword string
+
+	users   *entryMapping
+	groups  *entryMapping
+	member  string // Attribute holding the members of a group.
+	empty   string // DN set as only member of groups without members.
+	disable DisableSettings
+}
+
+// NewFactory returns the factory of LDAP connectors.
+func NewFactory() connector.Factory {
+	return func(name string, s connector.Settings) (connector.Connector, error) {
+		var settings Settings
+		if err := s.Decode(&settings); err != nil {
+			return nil, fmt.Errorf("failed to decode settings : %w", err)
+		}
+		return New(&settings)
+	}
+}
+
+// New constructs an LDAP connector from its settings.
+func New(settings *Settings) (*Connector, error) {
+	u, err := url.Parse(settings.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		return nil, fmt.Errorf("url %q must be an ldap:// or ldaps:// url", settings.URL)
+	}
+
+	c := &Connector{
+		url:          settings.URL,
+		startTLS:     settings.StartTLS,
+		tls:          &tls.Config{MinVersion: tls.VersionTLS12, ServerName: u.Hostname()},
+		bindDN:       settings.BindDN,
+		bindPassword: settings.BindPassword,
+		member:       settings.Groups.MemberAttribute,
+		empty:        settings.Groups.EmptyMember,
+		disable:      settings.Disable,
+	}
+
+	if settings.BindPasswordEnv != "" {
+		c.bindPassword = os.Getenv(settings.BindPasswordEnv)
+	}
+
+	if settings.CAFile != "" {
+		pem, err := os.ReadFile(settings.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca file : %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca file %q holds no certificates", settings.CAFile)
+		}
+		c.tls.RootCAs = pool
+	}
+
+	switch c.member {
+	case "":
+		c.member = "member"
+	case "member", "uniqueMember":
+	default:
+		return nil, fmt.Errorf("member attribute %q must be either 'member' or 'uniqueMember'", c.member)
+	}
+
+	if c.users, err = parseMapping(&settings.Users); err != nil {
+		return nil, fmt.Errorf("users : %w", err)
+	}
+	if c.groups, err = parseMapping(&settings.Groups.EntrySettings); err != nil {
+		return nil, fmt.Errorf("groups : %w", err)
+	}
+	if c.users == nil && c.groups == nil {
+		return nil, errors.New("neither users nor groups are mapped to entries")
+	}
+	if c.disable.Attribute != "" && c.disable.DisabledValue == "" {
+		return nil, errors.New("disable attribute is set without a disabled value")
+	}
+	return c, nil
+}
+
+// parseMapping parses the mapping of a resource type onto entries. It
+// returns nil when the resource type is not mapped.
+func parseMapping(settings *EntrySettings) (*entryMapping, error) {
+	if settings.DN == "" {
+		if len(settings.Attributes) > 0 {
+			return nil, errors.New("attributes are mapped without a dn template")
+		}
+		return nil, nil
+	}
+	if len(settings.ObjectClasses) == 0 {
+		return nil, errors.New("no object classes are set")
+	}
+
+	m := &entryMapping{dn: settings.DN, objectClasses: settings.ObjectClasses, attributes: make(map[string]attribute.Path)}
+	for _, match := range placeholder.FindAllStringSubmatch(settings.DN, -1) {
+		if _, err := attribute.ParsePath(match[1]); err != nil {
+			return nil, fmt.Errorf("dn template : %w", err)
+		}
+	}
+	for name, path := range settings.Attributes {
+		p, err := attribute.ParsePath(path)
+		if err != nil {
+			return nil, fmt.Errorf("attribute %q : %w", name, err)
+		}
+		m.attributes[name] = p
+	}
+	return m, nil
+}
+
+// Create adds the entry of a resource and returns its DN. An entry that
+// already exists at the DN is taken over and updated instead, so a create
+// retried after a lost response does not fail.
+func (c *Connector) Create(ctx context.Context, req *connector.Request) (string, error) {
+	m, err := c.mapping(req.Resource.Type)
+	if err != nil {
+		return "", err
+	}
+	dn, err := renderDN(m.dn, req.Resource.Attributes)
+	if err != nil {
+		return "", err
+	}
+
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	add := ldapv3.NewAddRequest(dn, nil)
+	add.Attribute("objectClass", m.objectClasses)
+	for _, attr := range c.entryAttributes(m, req) {
+		if len(attr.Vals) > 0 {
+			add.Attribute(attr.Type, attr.Vals)
+		}
+	}
+
+	err = conn.Add(add)
+	if ldapv3.IsErrorWithCode(err, ldapv3.LDAPResultEntryAlreadyExists) {
+		return dn, c.modify(conn, dn, c.entryAttributes(m, req))
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to add %q : %w", dn, err)
+	}
+	return dn, nil
+}
+
+// Update renames the entry when its DN template renders a new DN, then
+// replaces its mapped attributes. Group members are left to
+// UpdateMembership.
+func (c *Connector) Update(ctx context.Context, req *connector.Request) error {
+	m, err := c.mapping(req.Resource.Type)
+	if err != nil {
+		return err
+	}
+	dn, err := renderDN(m.dn, req.Resource.Attributes)
+	if err != nil {
+		return err
+	}
+
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if !sameDN(dn, req.RemoteID) {
+		if err := rename(conn, req.RemoteID, dn); err != nil {
+			return err
+		}
+		req.RemoteID = dn
+	}
+
+	attrs := c.entryAttributes(m, req)
+	if req.Resource.Type == store.ResourceTypeGroup {
+		attrs = slices.DeleteFunc(attrs, func(attr ldapv3.Attribute) bool { return attr.Type == c.member })
+	}
+	return c.modify(conn, req.RemoteID, attrs)
+}
+
+// Disable sets the disable attribute of a user to its disabled value.
+func (c *Connector) Disable(ctx context.Context, req *connector.Request) error {
+	if c.disable.Attribute == "" {
+		return errors.New("users cannot be disabled, no disable attribute is configured")
+	}
+
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return c.modify(conn, req.RemoteID, []ldapv3.Attribute{{Type: c.disable.Attribute, Vals: []string{c.disable.DisabledValue}}})
+}
+
+// Delete removes the entry of a resource. Entries that no longer exist are
+// considered deleted.
+func (c *Connector) Delete(ctx context.Context, req *connector.Request) error {
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	err = conn.Del(ldapv3.NewDelRequest(req.RemoteID, nil))
+	if err != nil && !ldapv3.IsErrorWithCode(err, ldapv3.LDAPResultNoSuchObject) {
+		return fmt.Errorf("failed to delete %q : %w", req.RemoteID, err)
+	}
+	return nil
+}
+
+// UpdateMembership adds and removes the DNs of the members of a group. When
+// the directory rejects the incremental change, because it is out of step
+// with the gateway, the members are replaced as a whole instead. Members that
+// were never provisioned to the directory are left out.
+func (c *Connector) UpdateMembership(ctx context.Context, req *connector.Request) error {
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	added, removed := memberDNs(req.Added), memberDNs(req.Removed)
+	if c.empty == "" && (len(added) > 0 || len(removed) > 0) {
+		modify := ldapv3.NewModifyRequest(req.RemoteID, nil)
+		if len(added) > 0 {
+			modify.Add(c.member, added)
+		}
+		if len(removed) > 0 {
+			modify.Delete(c.member, removed)
+		}
+
+		err := conn.Modify(modify)
+		if err == nil {
+			return nil
+		}
+		if !ldapv3.IsErrorWithCode(err, ldapv3.LDAPResultAttributeOrValueExists) &&
+			!ldapv3.IsErrorWithCode(err, ldapv3.LDAPResultNoSuchAttribute) &&
+			!ldapv3.IsErrorWithCode(err, ldapv3.LDAPResultObjectClassViolation) {
+			return fmt.Errorf("failed to update members of %q : %w", req.RemoteID, err)
+		}
+	}
+
+	return c.modify(conn, req.RemoteID, []ldapv3.Attribute{{Type: c.member, Vals: c.members(req.Members)}})
+}
+
+// mapping returns the entry mapping of a resource type.
+func (c *Connector) mapping(resourceType store.ResourceType) (*entryMapping, error) {
+	m := c.users
+	if resourceType == store.ResourceTypeGroup {
+		m = c.groups
+	}
+	if m == nil {
+		return nil, fmt.Errorf("%s resources are not mapped to entries", resourceType)
+	}
+	return m, nil
+}
+
+// entryAttributes returns the attributes of the entry of a resource. Mapped
+// attributes the resource does not hold have no values.
+func (c *Connector) entryAttributes(m *entryMapping, req *connector.Request) []ldapv3.Attribute {
+	attrs := make([]ldapv3.Attribute, 0, len(m.attributes)+2)
+	for name, path := range m.attributes {
+		attrs = append(attrs, ldapv3.Attribute{Type: name, Vals: values(path, req.Resource.Attributes)})
+	}
+
+	switch req.Resource.Type {
+	case store.ResourceTypeUser:
+		if c.disable.Attribute != "" {
+			vals := []string{c.disable.DisabledValue}
+			if active, ok := req.Resource.Attributes["active"].(bool); !ok || active {
+				vals = nil
+				if c.disable.EnabledValue != "" {
+					vals = []string{c.disable.EnabledValue}
+				}
+			}
+			attrs = append(attrs, ldapv3.Attribute{Type: c.disable.Attribute, Vals: vals})
+		}
+	case store.ResourceTypeGroup:
+		attrs = append(attrs, ldapv3.Attribute{Type: c.member, Vals: c.members(req.Members)})
+	}
+
+	slices.SortFunc(attrs, func(a, b ldapv3.Attribute) int { return strings.Compare(a.Type, b.Type) })
+	return attrs
+}
+
+// members returns the member DNs of a group, or the configured placeholder
+// when it has none.
+func (c *Connector) members(members []connector.Member) []string {
+	dns := memberDNs(members)
+	if len(dns) == 0 && c.empty != "" {
+		return []string{c.empty}
+	}
+	return dns
+}
+
+// modify replaces the values of attributes of an entry. Attributes without
+// values are removed.
+func (c *Connector) modify(conn *ldapv3.Conn, dn string, attrs []ldapv3.Attribute) error {
+	modify := ldapv3.NewModifyRequest(dn, nil)
+	for _, attr := range attrs {
+		modify.Replace(attr.Type, attr.Vals)
+	}
+	if err := conn.Modify(modify); err != nil {
+		return fmt.Errorf("failed to modify %q : %w", dn, err)
+	}
+	return nil
+}
+
+// connect opens a connection to the directory, bounded by the deadline of
+// ctx, and binds as the configured DN.
+func (c *Connector) connect(ctx context.Context) (*ldapv3.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	deadline, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		dialer.Deadline = deadline
+	}
+
+	conn, err := ldapv3.DialURL(c.url, ldapv3.DialWithTLSDialer(c.tls, dialer))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s : %w", c.url, err)
+	}
+	if hasDeadline {
+		conn.SetTimeout(time.Until(deadline))
+	}
+
+	if c.startTLS {
+		if err := conn.StartTLS(c.tls); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to start tls : %w", err)
+		}
+	}
+
+	if c.bindDN != "" {
+		if err := conn.Bind(c.bindDN, c.bindPassword); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to bind as %q : %w", c.bindDN, err)
+		}
+	}
+	return conn, nil
+}
+
+// rename moves an entry to a new DN, under a new parent when the parent
+// changed too.
+func rename(conn *ldapv3.Conn, from, to string) error {
+	parsed, err := ldapv3.ParseDN(to)
+	if err != nil || len(parsed.RDNs) == 0 {
+		return fmt.Errorf("invalid dn %q : %v", to, err)
+	}
+
+	rdn := parsed.RDNs[0].String()
+	parent := (&ldapv3.DN{RDNs: parsed.RDNs[1:]}).String()
+
+	var newSuperior string
+	if current, err := ldapv3.ParseDN(from); err != nil || len(current.RDNs) == 0 ||
+		!(&ldapv3.DN{RDNs: current.RDNs[1:]}).EqualFold(&ldapv3.DN{RDNs: parsed.RDNs[1:]}) {
+		newSuperior = parent
+	}
+
+	if err := conn.ModifyDN(ldapv3.NewModifyDNRequest(from, rdn, true, newSuperior)); err != nil {
+		return fmt.Errorf("failed to rename %q to %q : %w", from, to, err)
+	}
+	return nil
+}
+
+// renderDN renders a DN template with the attributes of a resource.
+func renderDN(template string, attrs map[string]any) (string, error) {
+	var missing error
+	dn := placeholder.ReplaceAllStringFunc(template, func(match string) string {
+		path, _ := attribute.ParsePath(match[1 : len(match)-1])
+		vals := values(path, attrs)
+		if len(vals) == 0 || vals[0] == "" {
+			missing = fmt.Errorf("dn template %q references %s, which the resource does not hold", template, path)
+			return ""
+		}
+		return ldapv3.EscapeDN(vals[0])
+	})
+	return dn, missing
+}
+
+// sameDN reports whether two DNs name the same entry.
+func sameDN(a, b string) bool {
+	parsedA, errA := ldapv3.ParseDN(a)
+	parsedB, errB := ldapv3.ParseDN(b)
+	if errA != nil || errB != nil {
+		return strings.EqualFold(a, b)
+	}
+	return parsedA.EqualFold(parsedB)
+}
+
+// memberDNs returns the DNs of the members that were provisioned.
+func memberDNs(members []connector.Member) []string {
+	dns := make([]string, 0, len(members))
+	for _, member := range members {
+		if member.RemoteID != "" {
+			dns = append(dns, member.RemoteID)
+		}
+	}
+	return dns
+}
+
+// values converts the value an attribute path addresses into LDAP attribute
+// values. Multi-valued attributes produce a value per element, and complex
+// values are skipped.
+func values(path attribute.Path, attrs map[string]any) []string {
+	val, ok := path.Get(attrs)
+	if !ok {
+		return nil
+	}
+
+	items, ok := val.([]any)
+	if !ok {
+		items = []any{val}
+	}
+
+	vals := make([]string, 0, len(items))
+	for _, item := range items {
+		switch v := item.(type) {
+		case string:
+			if v != "" {
+				vals = append(vals, v)
+			}
+		case bool:
+			vals = append(vals, strings.ToUpper(strconv.FormatBool(v)))
+		case float64:
+			vals = append(vals, strconv.FormatFloat(v, 'f', -1, 64))
+		case int:
+			vals = append(vals, strconv.Itoa(v))
+		}
+	}
+	return vals
+}
@@ -0,0 +1,597 @@
+package ldap
+
+import (
+	"context"
+	"maps"
+	"net"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	ldapv3 "github.com/go-ldap/ldap/v3"
+
+	"github.com/iamBelugaa/scim-gateway/internal/connector"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+)
+
+// objectClassFilter matches the object classes of search filters.
+var objectClassFilter = regexp.MustCompile(`\(objectClass=([^()]+)\)`)
+
+const (
+	bindDN       = "cn=admin,dc=example,dc=com"
+	bindPassword = "secret"
+)
+
+// directory is an in-memory LDAP server implementing the operations the
+// connector performs. Entries are keyed by their lowercased DN, and their
+// attributes by lowercased name.
+type directory struct {
+	mu      sync.Mutex
+	entries map[string]map[string][]string
+}
+
+// serve starts a directory listening on a local port and returns its URL.
+func serve(t *testing.T, dir *directory) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen : %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go dir.handle(conn)
+		}
+	}()
+	return "ldap://" + ln.Addr().String()
+}
+
+// handle answers the requests of a single connection.
+func (d *directory) handle(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id, _ := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		var code uint16
+		tag := op.Tag + 1
+		switch op.Tag {
+		case ldapv3.ApplicationBindRequest:
+			code = ldapv3.LDAPResultInvalidCredentials
+			if value(op.Children[1]) == bindDN && value(op.Children[2]) == bindPassword {
+				code = ldapv3.LDAPResultSuccess
+			}
+		case ldapv3.ApplicationUnbindRequest:
+			return
+		case ldapv3.ApplicationAddRequest:
+			code = d.add(op)
+		case ldapv3.ApplicationModifyRequest:
+			code = d.modify(op)
+		case ldapv3.ApplicationDelRequest:
+			code = d.del(value(op))
+		case ldapv3.ApplicationModifyDNRequest:
+			code = d.modifyDN(op)
+		case ldapv3.ApplicationSearchRequest:
+			tag = ldapv3.ApplicationSearchResultDone
+			code = d.search(conn, id, op)
+		default:
+			code = ldapv3.LDAPResultUnwillingToPerform
+		}
+
+		response := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+		result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+		result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, uint64(code), ""))
+		result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+		result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+		response.AppendChild(result)
+		if _, err := conn.Write(response.Bytes()); err != nil {
+			return
+		}
+	}
+}
+
+func (d *directory) add(op *ber.Packet) uint16 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dn := strings.ToLower(value(op.Children[0]))
+	if _, ok := d.entries[dn]; ok {
+		return ldapv3.LDAPResultEntryAlreadyExists
+	}
+	attrs := make(map[string][]string)
+	for _, attr := range op.Children[1].Children {
+		name, vals := partialAttribute(attr)
+		attrs[name] = vals
+	}
+	d.entries[dn] = attrs
+	return ldapv3.LDAPResultSuccess
+}
+
+func (d *directory) modify(op *ber.Packet) uint16 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entry, ok := d.entries[strings.ToLower(value(op.Children[0]))]
+	if !ok {
+		return ldapv3.LDAPResultNoSuchObject
+	}
+
+	// Changes are applied to a copy, so a failed request changes nothing.
+	attrs := make(map[string][]string, len(entry))
+	for name, vals := range entry {
+		attrs[name] = slices.Clone(vals)
+	}
+	for _, change := range op.Children[1].Children {
+		kind, _ := change.Children[0].Value.(int64)
+		name, vals := partialAttribute(change.Children[1])
+		switch kind {
+		case ldapv3.AddAttribute:
+			for _, val := range vals {
+				if slices.Contains(attrs[name], val) {
+					return ldapv3.LDAPResultAttributeOrValueExists
+				}
+				attrs[name] = append(attrs[name], val)
+			}
+		case ldapv3.DeleteAttribute:
+			if len(vals) == 0 {
+				delete(attrs, name)
+			}
+			for _, val := range vals {
+				i := slices.Index(attrs[name], val)
+				if i < 0 {
+					return ldapv3.LDAPResultNoSuchAttribute
+				}
+				attrs[name] = slices.Delete(attrs[name], i, i+1)
+			}
+		case ldapv3.ReplaceAttribute:
+			attrs[name] = vals
+		}
+		if len(attrs[name]) == 0 {
+			delete(attrs, name)
+		}
+	}
+
+	if slices.Contains(attrs["objectclass"], "groupOfNames") && len(attrs["member"]) == 0 {
+		return ldapv3.LDAPResultObjectClassViolation
+	}
+	for name := range entry {
+		delete(entry, name)
+	}
+	for name, vals := range attrs {
+		entry[name] = vals
+	}
+	return ldapv3.LDAPResultSuccess
+}
+
+func (d *directory) del(dn string) uint16 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dn = strings.ToLower(dn)
+	if _, ok := d.entries[dn]; !ok {
+		return ldapv3.LDAPResultNoSuchObject
+	}
+	delete(d.entries, dn)
+	return ldapv3.LDAPResultSuccess
+}
+
+func (d *directory) modifyDN(op *ber.Packet) uint16 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	from := value(op.Children[0])
+	entry, ok := d.entries[strings.ToLower(from)]
+	if !ok {
+		return ldapv3.LDAPResultNoSuchObject
+	}
+
+	rdn := value(op.Children[1])
+	_, parent, _ := strings.Cut(from, ",")
+	if len(op.Children) > 3 {
+		parent = value(op.Children[3])
+	}
+	to := strings.ToLower(rdn + "," + parent)
+	if _, ok := d.entries[to]; ok {
+		return ldapv3.LDAPResultEntryAlreadyExists
+	}
+
+	name, val, _ := strings.Cut(rdn, "=")
+	entry[strings.ToLower(name)] = []string{val}
+	delete(d.entries, strings.ToLower(from))
+	d.entries[to] = entry
+	return ldapv3.LDAPResultSuccess
+}
+
+// search writes the entries below the base DN of a search that hold the
+// object classes of its filter, with the requested attributes. Filters are
+// expected to be conjunctions of object classes.
+func (d *directory) search(conn net.Conn, id int64, op *ber.Packet) uint16 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	base := strings.ToLower(value(op.Children[0]))
+	filter, err := ldapv3.DecompileFilter(op.Children[6])
+	if err != nil {
+		return ldapv3.LDAPResultUnwillingToPerform
+	}
+	classes := objectClassFilter.FindAllStringSubmatch(filter, -1)
+	requested := make([]string, 0)
+	for _, attr := range op.Children[7].Children {
+		requested = append(requested, strings.ToLower(value(attr)))
+	}
+
+	for _, dn := range slices.Sorted(maps.Keys(d.entries)) {
+		entry := d.entries[dn]
+		if !strings.HasSuffix(dn, ","+base) {
+			continue
+		}
+		if slices.ContainsFunc(classes, func(class []string) bool { return !slices.Contains(entry["objectclass"], class[1]) }) {
+			continue
+		}
+
+		result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldapv3.ApplicationSearchResultEntry, nil, "")
+		result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, ""))
+		attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		for _, name := range requested {
+			if len(entry[name]) == 0 {
+				continue
+			}
+			attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+			attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+			vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+			for _, val := range entry[name] {
+				vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, val, ""))
+			}
+			attr.AppendChild(vals)
+			attrs.AppendChild(attr)
+		}
+		result.AppendChild(attrs)
+
+		response := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+		response.AppendChild(result)
+		if _, err := conn.Write(response.Bytes()); err != nil {
+			return ldapv3.LDAPResultOther
+		}
+	}
+	return ldapv3.LDAPResultSuccess
+}
+
+// entry returns the attributes of the entry at a DN, nil when there is none.
+func (d *directory) entry(dn string) map[string][]string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.entries[strings.ToLower(dn)]
+}
+
+// value returns the value of a primitive packet as a string.
+func value(packet *ber.Packet) string {
+	return packet.Data.String()
+}
+
+// partialAttribute returns the lowercased name and the values of an
+// attribute in a request.
+func partialAttribute(packet *ber.Packet) (string, []string) {
+	var vals []string
+	for _, val := range packet.Children[1].Children {
+		vals = append(vals, value(val))
+	}
+	return strings.ToLower(value(packet.Children[0])), vals
+}
+
+// newTestConnector returns a connector of an empty directory, mapping users
+// onto inetOrgPerson entries and groups onto groupOfNames entries.
+func newTestConnector(t *testing.T, emptyMember string) (*Connector, *directory) {
+	t.Helper()
+
+	dir := &directory{entries: make(map[string]map[string][]string)}
+	c, err := New(&Settings{
+		URL:          serve(t, dir),
+		BindDN:       bindDN,
+		BindPassword: bindPassword,
+		Users: EntrySettings{
+			DN:            "uid={userName},ou=people,dc=example,dc=com",
+			ObjectClasses: []string{"inetOrgPerson"},
+			Attributes:    map[string]string{"uid": "userName", "cn": "displayName", "sn": "name.familyName", "mail": "emails.value"},
+		},
+		Groups: GroupSettings{
+			EntrySettings: EntrySettings{
+				DN:            "cn={displayName},ou=groups,dc=example,dc=com",
+				ObjectClasses: []string{"groupOfNames"},
+				Attributes:    map[string]string{"cn": "displayName"},
+			},
+			EmptyMember: emptyMember,
+		},
+		Disable: DisableSettings{Attribute: "nsAccountLock", DisabledValue: "TRUE"},
+	})
+	if err != nil {
+		t.Fatalf("failed to construct connector : %v", err)
+	}
+	return c, dir
+}
+
+// user returns a user resource.
+func user(id, userName string, active bool) *store.Resource {
+	return &store.Resource{ID: id, Type: store.ResourceTypeUser, Attributes: map[string]any{
+		"userName":    userName,
+		"displayName": "Alice Liddell",
+		"name":        map[string]any{"familyName": "Liddell"},
+		"emails":      []any{map[string]any{"value": userName + "@example.com"}},
+		"active":      active,
+	}}
+}
+
+// group returns a group resource.
+func group(id string) *store.Resource {
+	return &store.Resource{ID: id, Type: store.ResourceTypeGroup, Attributes: map[string]any{"displayName": "Tour Guides"}}
+}
+
+// TestUserLifecycle checks users are added with their mapped attributes,
+// renamed when their DN changes, disabled and deleted.
+func TestUserLifecycle(t *testing.T) {
+	c, dir := newTestConnector(t, "")
+	ctx := context.Background()
+
+	req := &connector.Request{Tenant: "default", Resource: user("u1", "alice", true)}
+	dn, err := c.Create(ctx, req)
+	if err != nil {
+		t.Fatalf("failed to create user : %v", err)
+	}
+	if dn != "uid=alice,ou=people,dc=example,dc=com" {
+		t.Fatalf("expected the dn rendered from the template, got %q", dn)
+	}
+
+	want := map[string][]string{
+		"objectclass": {"inetOrgPerson"},
+		"uid":         {"alice"},
+		"cn":          {"Alice Liddell"},
+		"sn":          {"Liddell"},
+		"mail":        {"alice@example.com"},
+	}
+	if got := dir.entry(dn); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected entry %v, got %v", want, got)
+	}
+
+	req = &connector.Request{Tenant: "default", Resource: user("u1", "alicia", true), RemoteID: dn}
+	if err := c.Update(ctx, req); err != nil {
+		t.Fatalf("failed to update user : %v", err)
+	}
+	if req.RemoteID != "uid=alicia,ou=people,dc=example,dc=com" {
+		t.Fatalf("expected the remote id of the renamed entry, got %q", req.RemoteID)
+	}
+	if dir.entry(dn) != nil {
+		t.Fatal("expected the entry to be moved away from its previous dn")
+	}
+	if got := dir.entry(req.RemoteID)["mail"]; !slices.Equal(got, []string{"alicia@example.com"}) {
+		t.Fatalf("expected the mail of the renamed entry to be updated, got %v", got)
+	}
+
+	if err := c.Disable(ctx, req); err != nil {
+		t.Fatalf("failed to disable user : %v", err)
+	}
+	if got := dir.entry(req.RemoteID)["nsaccountlock"]; !slices.Equal(got, []string{"TRUE"}) {
+		t.Fatalf("expected the user to be locked, got %v", got)
+	}
+
+	req.Resource = user("u1", "alicia", true)
+	if err := c.Update(ctx, req); err != nil {
+		t.Fatalf("failed to reactivate user : %v", err)
+	}
+	if got, ok := dir.entry(req.RemoteID)["nsaccountlock"]; ok {
+		t.Fatalf("expected the lock to be removed from a reactivated user, got %v", got)
+	}
+
+	if err := c.Delete(ctx, req); err != nil {
+		t.Fatalf("failed to delete user : %v", err)
+	}
+	if dir.entry(req.RemoteID) != nil {
+		t.Fatal("expected the entry to be deleted")
+	}
+	if err := c.Delete(ctx, req); err != nil {
+		t.Fatalf("expected deleting a missing entry to succeed, got %v", err)
+	}
+}
+
+// TestCreateAdoptsExistingEntry checks an entry already at the DN of a
+// created user is updated rather than failing the create.
+func TestCreateAdoptsExistingEntry(t *testing.T) {
+	c, dir := newTestConnector(t, "")
+	dir.entries["uid=alice,ou=people,dc=example,dc=com"] = map[string][]string{
+		"objectclass": {"inetOrgPerson"}, "uid": {"alice"}, "cn": {"Someone Else"},
+	}
+
+	dn, err := c.Create(context.Background(), &connector.Request{Tenant: "default", Resource: user("u1", "alice", true)})
+	if err != nil {
+		t.Fatalf("failed to create user : %v", err)
+	}
+	if got := dir.entry(dn)["cn"]; !slices.Equal(got, []string{"Alice Liddell"}) {
+		t.Fatalf("expected the existing entry to be updated, got %v", got)
+	}
+}
+
+// TestGroupMembership checks members are added and removed incrementally,
+// and replaced as a whole when the directory is out of step.
+func TestGroupMembership(t *testing.T) {
+	c, dir := newTestConnector(t, "")
+	ctx := context.Background()
+
+	alice := connector.Member{ID: "u1", Type: store.ResourceTypeUser, RemoteID: "uid=alice,ou=people,dc=example,dc=com"}
+	bob := connector.Member{ID: "u2", Type: store.ResourceTypeUser, RemoteID: "uid=bob,ou=people,dc=example,dc=com"}
+	pending := connector.Member{ID: "u3", Type: store.ResourceTypeUser}
+
+	req := &connector.Request{Tenant: "default", Resource: group("g1"), Members: []connector.Member{alice, pending}}
+	dn, err := c.Create(ctx, req)
+	if err != nil {
+		t.Fatalf("failed to create group : %v", err)
+	}
+	if got := dir.entry(dn)["member"]; !slices.Equal(got, []string{alice.RemoteID}) {
+		t.Fatalf("expected the provisioned member only, got %v", got)
+	}
+
+	req = &connector.Request{
+		Tenant: "default", Resource: group("g1"), RemoteID: dn,
+		Members: []connector.Member{bob}, Added: []connector.Member{bob}, Removed: []connector.Member{alice},
+	}
+	if err := c.UpdateMembership(ctx, req); err != nil {
+		t.Fatalf("failed to update members : %v", err)
+	}
+	if got := dir.entry(dn)["member"]; !slices.Equal(got, []string{bob.RemoteID}) {
+		t.Fatalf("expected members to be swapped, got %v", got)
+	}
+
+	// Bob is already a member, so the directory rejects adding him again
+	// and the members are replaced instead.
+	req.Members = []connector.Member{alice, bob}
+	req.Added, req.Removed = []connector.Member{alice, bob}, nil
+	if err := c.UpdateMembership(ctx, req); err != nil {
+		t.Fatalf("failed to update members : %v", err)
+	}
+	if got := dir.entry(dn)["member"]; !slices.Equal(got, []string{alice.RemoteID, bob.RemoteID}) {
+		t.Fatalf("expected members to be replaced, got %v", got)
+	}
+}
+
+// TestGroupEmptyMember checks groups without members hold the configured
+// placeholder, as groupOfNames requires a member.
+func TestGroupEmptyMember(t *testing.T) {
+	c, dir := newTestConnector(t, "cn=nobody,dc=example,dc=com")
+	ctx := context.Background()
+
+	dn, err := c.Create(ctx, &connector.Request{Tenant: "default", Resource: group("g1")})
+	if err != nil {
+		t.Fatalf("failed to create group : %v", err)
+	}
+	if got := dir.entry(dn)["member"]; !slices.Equal(got, []string{"cn=nobody,dc=example,dc=com"}) {
+		t.Fatalf("expected the placeholder member, got %v", got)
+	}
+
+	alice := connector.Member{ID: "u1", Type: store.ResourceTypeUser, RemoteID: "uid=alice,ou=people,dc=example,dc=com"}
+	req := &connector.Request{
+		Tenant: "default", Resource: group("g1"), RemoteID: dn,
+		Members: []connector.Member{alice}, Added: []connector.Member{alice},
+	}
+	if err := c.UpdateMembership(ctx, req); err != nil {
+		t.Fatalf("failed to update members : %v", err)
+	}
+	if got := dir.entry(dn)["member"]; !slices.Equal(got, []string{alice.RemoteID}) {
+		t.Fatalf("expected the placeholder to be replaced, got %v", got)
+	}
+
+	req.Members, req.Added, req.Removed = nil, nil, []connector.Member{alice}
+	if err := c.UpdateMembership(ctx, req); err != nil {
+		t.Fatalf("failed to update members : %v", err)
+	}
+	if got := dir.entry(dn)["member"]; !slices.Equal(got, []string{"cn=nobody,dc=example,dc=com"}) {
+		t.Fatalf("expected the placeholder member, got %v", got)
+	}
+}
+
+// TestList checks the entries of a resource type are listed from below the
+// base of its DN template, with attributes comparable to the entries the
+// connector provisions.
+func TestList(t *testing.T) {
+	c, dir := newTestConnector(t, "")
+	ctx := context.Background()
+
+	alice := &connector.Request{Tenant: "default", Resource: user("u1", "alice", true)}
+	aliceDN, err := c.Create(ctx, alice)
+	if err != nil {
+		t.Fatalf("failed to create user : %v", err)
+	}
+	if _, err := c.Create(ctx, &connector.Request{Tenant: "default", Resource: user("u2", "bob", false)}); err != nil {
+		t.Fatalf("failed to create user : %v", err)
+	}
+	member := connector.Member{ID: "u1", Type: store.ResourceTypeUser, RemoteID: "UID=Alice,ou=people,dc=example,dc=com"}
+	groupReq := &connector.Request{Tenant: "default", Resource: group("g1"), Members: []connector.Member{member}}
+	if _, err := c.Create(ctx, groupReq); err != nil {
+		t.Fatalf("failed to create group : %v", err)
+	}
+
+	// Entries outside the base DN or without the object classes are left out.
+	dir.entries["uid=carol,ou=contractors,dc=example,dc=com"] = map[string][]string{"objectclass": {"inetOrgPerson"}}
+	dir.entries["cn=printer,ou=people,dc=example,dc=com"] = map[string][]string{"objectclass": {"device"}}
+
+	users, err := c.List(ctx, store.ResourceTypeUser)
+	if err != nil {
+		t.Fatalf("failed to list users : %v", err)
+	}
+	if len(users) != 2 || users[0].RemoteID != aliceDN {
+		t.Fatalf("got users %+v, want alice and bob", users)
+	}
+	expected, err := c.Expected(alice)
+	if err != nil {
+		t.Fatalf("failed to get expected user : %v", err)
+	}
+	if expected.RemoteID != aliceDN || !reflect.DeepEqual(expected.Attributes, map[string]any{
+		"uid": []any{"alice"}, "cn": []any{"Alice Liddell"}, "sn": []any{"Liddell"},
+		"mail": []any{"alice@example.com"}, "nsaccountlock": []any{},
+	}) {
+		t.Fatalf("got expected user %+v", expected)
+	}
+	for name, val := range expected.Attributes {
+		if got, ok := users[0].Attributes[name]; ok && !reflect.DeepEqual(got, val) {
+			t.Fatalf("got %s %v, want %v", name, got, val)
+		}
+	}
+	if got := users[1].Attributes["nsaccountlock"]; !reflect.DeepEqual(got, []any{"TRUE"}) {
+		t.Fatalf("got nsAccountLock %v for the disabled user, want TRUE", got)
+	}
+
+	groups, err := c.List(ctx, store.ResourceTypeGroup)
+	if err != nil {
+		t.Fatalf("failed to list groups : %v", err)
+	}
+	if len(groups) != 1 || !reflect.DeepEqual(groups[0].Attributes["member"], []any{"uid=alice,ou=people,dc=example,dc=com"}) {
+		t.Fatalf("got groups %+v, want a single group with lower-cased member dns", groups)
+	}
+}
+
+// TestConnectRejectsInvalidCredentials checks bind failures fail the
+// operation.
+func TestConnectRejectsInvalidCredentials(t *testing.T) {
+	c, _ := newTestConnector(t, "")
+	c.bindPassword = "wrong"
+
+	_, err := c.Create(context.Background(), &connector.Request{Tenant: "default", Resource: user("u1", "alice", true)})
+	if !ldapv3.IsErrorWithCode(err, ldapv3.LDAPResultInvalidCredentials) {
+		t.Fatalf("expected invalid credentials, got %v", err)
+	}
+}
+
+// TestNewValidatesSettings checks invalid settings are rejected.
+func TestNewValidatesSettings(t *testing.T) {
+	users := EntrySettings{DN: "uid={userName},dc=example,dc=com", ObjectClasses: []string{"inetOrgPerson"}}
+
+	tests := map[string]Settings{
+		"invalid url":           {URL: "http://example.com", Users: users},
+		"nothing mapped":        {URL: "ldap://localhost"},
+		"no object classes":     {URL: "ldap://localhost", Users: EntrySettings{DN: "uid={userName},dc=example,dc=com"}},
+		"invalid member":        {URL: "ldap://localhost", Users: users, Groups: GroupSettings{MemberAttribute: "memberUid"}},
+		"no disabled value":     {URL: "ldap://localhost", Users: users, Disable: DisableSettings{Attribute: "nsAccountLock"}},
+		"invalid dn path":       {URL: "ldap://localhost", Users: EntrySettings{DN: "uid={a..b},dc=example,dc=com", ObjectClasses: []string{"top"}}},
+		"attributes without dn": {URL: "ldap://localhost", Groups: GroupSettings{EntrySettings: EntrySettings{Attributes: map[string]string{"cn": "displayName"}}}},
+	}
+	for name, settings := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := New(&settings); err == nil {
+				t.Fatal("expected settings to be rejected")
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	ldapv3 "github.com/go-ldap/ldap/v3"
+
+	"github.com/iamBelugaa/scim-gateway/internal/connector"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+)
+
+// listPageSize is the number of entries requested per page when listing the
+// entries of a resource type, below the default size limit of Active
+// Directory.
+const listPageSize = 500
+
+// List returns every entry of a resource type: the entries holding all of its
+// object classes below the base of its DN template, which is the part of the
+// template following the last placeholder.
+func (c *Connector) List(ctx context.Context, resourceType store.ResourceType) ([]connector.Account, error) {
+	m, err := c.mapping(resourceType)
+	if err != nil {
+		return nil, err
+	}
+	base, err := baseDN(m.dn)
+	if err != nil {
+		return nil, err
+	}
+
+	var filter strings.Builder
+	filter.WriteString("(&")
+	for _, class := range m.objectClasses {
+		fmt.Fprintf(&filter, "(objectClass=%s)", ldapv3.EscapeFilter(class))
+	}
+	filter.WriteString(")")
+
+	names := make([]string, 0, len(m.attributes)+1)
+	for _, attr := range c.entryAttributes(m, &connector.Request{Resource: &store.Resource{Type: resourceType}}) {
+		names = append(names, attr.Type)
+	}
+
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	search := ldapv3.NewSearchRequest(
+		base, ldapv3.ScopeWholeSubtree, ldapv3.NeverDerefAliases, 0, 0, false, filter.String(), names, nil,
+	)
+	res, err := conn.SearchWithPaging(search, listPageSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search %q : %w", base, err)
+	}
+
+	accounts := make([]connector.Account, 0, len(res.Entries))
+	for _, entry := range res.Entries {
+		attrs := make(map[string]any, len(entry.Attributes))
+		for _, attr := range entry.Attributes {
+			attrs[strings.ToLower(attr.Name)] = c.normalize(attr.Name, attr.Values)
+		}
+		accounts = append(accounts, connector.Account{RemoteID: entry.DN, Attributes: attrs})
+	}
+	return accounts, nil
+}
+
+// Expected returns the entry a resource should be provisioned as, at the DN
+// its template renders. Attribute names are lower-cased and values sorted, as
+// in listed entries.
+func (c *Connector) Expected(req *connector.Request) (connector.Account, error) {
+	m, err := c.mapping(req.Resource.Type)
+	if err != nil {
+		return connector.Account{}, err
+	}
+	dn, err := renderDN(m.dn, req.Resource.Attributes)
+	if err != nil {
+		return connector.Account{}, err
+	}
+
+	attrs := make(map[string]any)
+	for _, attr := range c.entryAttributes(m, req) {
+		attrs[strings.ToLower(attr.Type)] = c.normalize(attr.Type, attr.Vals)
+	}
+	return connector.Account{RemoteID: dn, Attributes: attrs}, nil
+}
+
+// normalize returns the values of an attribute in the form they are
+// compared in: sorted, with member DNs lower-cased since DNs compare
+// regardless of case.
+func (c *Connector) normalize(name string, vals []string) []any {
+	sorted := slices.Clone(vals)
+	if strings.EqualFold(name, c.member) {
+		for i, val := range sorted {
+			sorted[i] = strings.ToLower(val)
+		}
+	}
+	slices.Sort(sorted)
+
+	res := make([]any, len(sorted))
+	for i, val := range sorted {
+		res[i] = val
+	}
+	return res
+}
+
+// baseDN returns the part of a DN template following its last placeholder,
+// below which every entry of the template lies.
+func baseDN(template string) (string, error) {
+	rest := template
+	if i := strings.LastIndex(template, "}"); i >= 0 {
+		rest = template[i+1:]
+	}
+	_, base, ok := strings.Cut(rest, ",")
+	if !ok || strings.TrimSpace(base) == "" {
+		return "", fmt.Errorf("dn template %q has no base to search entries below", template)
+	}
+	return strings.TrimSpace(base), nil
+}
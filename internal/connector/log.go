@@ -0,0 +1,61 @@
+package connector
+
+import (
+	"context"
+
+	"github.com/iamBelugaa/scim-gateway/pkg/logger"
+)
+
+// Log is a connector that only logs the operations dispatched to it. It is
+// useful to check which operations changes produce before connecting a real
+// downstream system.
+type Log struct {
+	log *logger.Logger
+}
+
+// NewLogFactory returns the factory of log connectors, which take no
+// settings.
+func NewLogFactory(log *logger.Logger) Factory {
+	return func(name string, settings Settings) (Connector, error) {
+		return &Log{log: &logger.Logger{SugaredLogger: log.With("connector", name)}}, nil
+	}
+}
+
+// Create logs the resource and uses its local id as remote id.
+func (l *Log) Create(ctx context.Context, req *Request) (string, error) {
+	l.record(OperationCreate, req)
+	return req.Resource.ID, nil
+}
+
+// Update logs the resource.
+func (l *Log) Update(ctx context.Context, req *Request) error {
+	l.record(OperationUpdate, req)
+	return nil
+}
+
+// Disable logs the resource.
+func (l *Log) Disable(ctx context.Context, req *Request) error {
+	l.record(OperationDisable, req)
+	return nil
+}
+
+// Delete logs the resource.
+func (l *Log) Delete(ctx context.Context, req *Request) error {
+	l.record(OperationDelete, req)
+	return nil
+}
+
+// UpdateMembership logs the membership changes.
+func (l *Log) UpdateMembership(ctx context.Context, req *Request) error {
+	l.record(OperationMembership, req)
+	return nil
+}
+
+// record logs a single operation.
+func (l *Log) record(op Operation, req *Request) {
+	l.log.Infow(
+		"connector operation",
+		"operation", op, "tenant", req.Tenant, "resourceType", req.Resource.Type, "id", req.Resource.ID,
+		"version", req.Resource.Version, "remoteId", req.RemoteID, "added", len(req.Added), "removed", len(req.Removed),
+	)
+}
@@ -0,0 +1,87 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/iamBelugaa/scim-gateway/internal/mapping"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+)
+
+// mapped is a connector whose resources are reshaped by a mapping before
+// every operation. The targets of the mapping are added to a copy of the
+// resource, so the connector settings address them like any other
+// attribute.
+type mapped struct {
+	Connector
+	mapping *mapping.Mapping
+}
+
+// request returns a copy of req holding the mapped resource.
+func (m *mapped) request(req *Request) (*Request, error) {
+	clone := *req
+	clone.Resource = req.Resource.Clone()
+	if clone.Resource.Attributes == nil {
+		clone.Resource.Attributes = make(map[string]any)
+	}
+	if err := m.mapping.Apply(clone.Resource.Attributes); err != nil {
+		return nil, fmt.Errorf("failed to map resource : %w", err)
+	}
+	return &clone, nil
+}
+
+func (m *mapped) Create(ctx context.Context, req *Request) (string, error) {
+	mreq, err := m.request(req)
+	if err != nil {
+		return "", err
+	}
+	return m.Connector.Create(ctx, mreq)
+}
+
+func (m *mapped) Update(ctx context.Context, req *Request) error {
+	return m.run(ctx, req, m.Connector.Update)
+}
+
+func (m *mapped) Disable(ctx context.Context, req *Request) error {
+	return m.run(ctx, req, m.Connector.Disable)
+}
+
+func (m *mapped) Delete(ctx context.Context, req *Request) error {
+	return m.run(ctx, req, m.Connector.Delete)
+}
+
+func (m *mapped) UpdateMembership(ctx context.Context, req *Request) error {
+	return m.run(ctx, req, m.Connector.UpdateMembership)
+}
+
+// run performs an operation on the mapped resource, passing back the remote
+// id the operation may have set.
+func (m *mapped) run(ctx context.Context, req *Request, op func(context.Context, *Request) error) error {
+	mreq, err := m.request(req)
+	if err != nil {
+		return err
+	}
+	err = op(ctx, mreq)
+	req.RemoteID = mreq.RemoteID
+	return err
+}
+
+// mappedLister is a mapped connector able to list its accounts. Accounts are
+// listed as they are, and the expected accounts are those of the mapped
+// resources.
+type mappedLister struct {
+	*mapped
+	lister Lister
+}
+
+func (m *mappedLister) List(ctx context.Context, resourceType store.ResourceType) ([]Account, error) {
+	return m.lister.List(ctx, resourceType)
+}
+
+func (m *mappedLister) Expected(req *Request) (Account, error) {
+	mreq, err := m.request(req)
+	if err != nil {
+		return Account{}, err
+	}
+	return m.lister.Expected(mreq)
+}
@@ -0,0 +1,464 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/iamBelugaa/scim-gateway/internal/config"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+	"github.com/iamBelugaa/scim-gateway/internal/tenant"
+	"github.com/iamBelugaa/scim-gateway/pkg/logger"
+)
+
+// readBatch is the number of events read from a store's outbox at a time.
+const readBatch = 100
+
+// Outbox delivers the changes recorded in the outbox of every tenant store to
+// the connectors of the tenant. Deliveries run on a pool of workers. A
+// delivery failing with a retryable error is retried with exponential backoff
+// and jitter, and a delivery failing permanently or running out of attempts
+// is moved to the dead letters. Deliveries to a connector for the same
+// resource run one at a time in the order the changes were made, and an
+// event is acknowledged in the store once every delivery of it is done.
+//
+// When an outbox directory is configured, the outbox of every store is
+// persisted there along with the dead letters, so changes not yet delivered
+// are delivered after a restart and dead letters can still be retried or
+// discarded. Scheduled retries start over with a fresh set of attempts.
+type Outbox struct {
+	log         *logger.Logger
+	dispatcher  *Dispatcher
+	deadLetters *DeadLetters
+	workers     int                  // Number of deliveries run concurrently.
+	maxAttempts int                  // Attempts made before a delivery is dead-lettered.
+	baseDelay   time.Duration        // Delay before the first retry.
+	maxDelay    time.Duration        // Upper bound of the delay between retries.
+	progress    map[string]*progress // Delivery progress of every tenant with connectors, keyed by tenant id.
+
+	mu       sync.Mutex
+	runnable []*delivery                 // Deliveries ready to run, in order.
+	wake     chan struct{}               // Signalled when deliveries become runnable.
+	inFlight map[deliveryKey][]*delivery // Deliveries waiting behind the one in flight for their key, present while a delivery is in flight.
+}
+
+// deliveryKey identifies the deliveries that must run one at a time.
+type deliveryKey struct {
+	connector string
+	resource  resourceKey
+}
+
+// delivery is a change to be provisioned to a single connector.
+type delivery struct {
+	reg      *registration
+	change   Change
+	seq      uint64 // Outbox event the change was read from, 0 for changes not read from an outbox.
+	attempts int    // Number of attempts made.
+	letterID string // Id of the dead letter the delivery retries.
+}
+
+// key returns the key of the deliveries that must run one at a time with d.
+func (d *delivery) key() deliveryKey {
+	res := d.change.Resource
+	return deliveryKey{connector: d.reg.name, resource: resourceKey{tenant: d.change.Tenant, resourceType: res.Type, id: res.ID}}
+}
+
+// progress tracks the events of a tenant's outbox still being delivered.
+type progress struct {
+	tenant      *tenant.Tenant
+	read        uint64         // Sequence number of the last event read.
+	acked       uint64         // Sequence number of the last event acknowledged.
+	outstanding map[uint64]int // Deliveries not done yet, keyed by event sequence number.
+}
+
+// NewOutbox constructs an outbox delivering the changes of every tenant with
+// connectors registered with the dispatcher, and enables the outbox of their
+// stores. With an outbox directory configured, store outboxes and dead
+// letters are persisted there, and those of the previous run are loaded.
+func NewOutbox(log *logger.Logger, dispatcher *Dispatcher, tenants *tenant.Registry, cfg *config.Connectors) (*Outbox, error) {
+	deadLetters := NewDeadLetters()
+	if cfg.OutboxDir != "" {
+		if err := os.MkdirAll(cfg.OutboxDir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create outbox directory : %w", err)
+		}
+		var err error
+		if deadLetters, err = OpenDeadLetters(filepath.Join(cfg.OutboxDir, "dead-letters.json")); err != nil {
+			return nil, err
+		}
+	}
+
+	o := &Outbox{
+		log:         log,
+		dispatcher:  dispatcher,
+		deadLetters: deadLetters,
+		workers:     max(cfg.Workers, 1),
+		maxAttempts: max(cfg.MaxAttempts, 1),
+		baseDelay:   cfg.RetryBaseDelay,
+		maxDelay:    cfg.RetryMaxDelay,
+		progress:    make(map[string]*progress),
+		wake:        make(chan struct{}, 1),
+		inFlight:    make(map[deliveryKey][]*delivery),
+	}
+
+	if !dispatcher.Enabled() {
+		return o, nil
+	}
+	for _, t := range tenants.All() {
+		for _, reg := range dispatcher.connectors {
+			if reg.tenant != t.ID {
+				continue
+			}
+
+			t.Store.EnableOutbox()
+			if cfg.OutboxDir != "" {
+				if err := t.Store.PersistOutbox(filepath.Join(cfg.OutboxDir, t.ID+".outbox")); err != nil {
+					return nil, fmt.Errorf("failed to persist outbox of tenant %q : %w", t.ID, err)
+				}
+			}
+			o.progress[t.ID] = &progress{tenant: t, outstanding: make(map[uint64]int)}
+			break
+		}
+	}
+	return o, nil
+}
+
+// DeadLetters returns the deliveries that could not be provisioned.
+func (o *Outbox) DeadLetters() *DeadLetters {
+	return o.deadLetters
+}
+
+// Run reads the outbox of every tenant with connectors and delivers the
+// changes until the context is cancelled. Deliveries in progress are
+// completed before Run returns, while scheduled retries are abandoned.
+func (o *Outbox) Run(ctx context.Context) {
+	if len(o.progress) == 0 {
+		o.log.Infow("connector outbox disabled")
+		return
+	}
+
+	var wg sync.WaitGroup
+	for range o.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			o.work(ctx)
+		}()
+	}
+	for _, p := range o.progress {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			o.read(ctx, p)
+		}()
+	}
+	wg.Wait()
+}
+
+// Retry removes a dead letter of a tenant and queues its change for delivery
+// again, with a fresh set of attempts. The change is delivered against the
+// current state of the resource: a created or updated resource is
+// provisioned as it is now, and a change superseded by a later delete or
+// restore is dropped, in which case Retry reports false.
+func (o *Outbox) Retry(ctx context.Context, tenantID, id string) (bool, error) {
+	letter, err := o.deadLetters.Get(tenantID, id)
+	if err != nil {
+		return false, err
+	}
+
+	reg, regOK := o.dispatcher.registration(letter.Connector)
+	p, tenantOK := o.progress[tenantID]
+	if !regOK || !tenantOK {
+		// Dead letters are only recorded for registered connectors.
+		return false, errors.New("dead letter of an unknown connector")
+	}
+
+	change, current := refresh(ctx, p.tenant.Store, letter.Change)
+	if _, err := o.deadLetters.Discard(tenantID, id); err != nil {
+		return false, err
+	}
+	if !current {
+		o.log.Infow(
+			"dropped superseded dead letter", "id", id, "connector", reg.name,
+			"tenant", tenantID, "resourceType", change.Resource.Type, "resourceId", change.Resource.ID, "change", change.Kind,
+		)
+		return false, nil
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.schedule(&delivery{reg: reg, change: change, letterID: id})
+	return true, nil
+}
+
+// queue schedules the delivery of a change that was not read from a store's
+// outbox, such as a correction found by a reconciliation.
+func (o *Outbox) queue(reg *registration, change Change) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.schedule(&delivery{reg: reg, change: change})
+}
+
+// refresh updates a change to the current state of its resource, and
+// reports whether the change still applies. Deletes apply while the resource
+// is still deleted, and other changes apply while the resource exists.
+func refresh(ctx context.Context, s *store.Memory, change Change) (Change, bool) {
+	res, err := s.Get(ctx, change.Resource.Type, change.Resource.ID)
+	exists := err == nil
+	if change.Kind == ChangeDeleted || !exists {
+		return change, change.Kind == ChangeDeleted && !exists
+	}
+
+	change.Resource = res
+	return change, true
+}
+
+// read queues the deliveries of every event in a tenant's outbox, then waits
+// for new events until the context is cancelled.
+func (o *Outbox) read(ctx context.Context, p *progress) {
+	s := p.tenant.Store
+
+	var after uint64
+	for {
+		for {
+			events := s.PendingEvents(ctx, after, readBatch)
+			for _, event := range events {
+				o.enqueue(p, event)
+				after = event.Seq
+			}
+			if len(events) < readBatch {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.OutboxReady():
+		}
+	}
+}
+
+// enqueue schedules a delivery of an event to every connector registered for
+// the tenant and type of its resource.
+func (o *Outbox) enqueue(p *progress, event *store.Event) {
+	change := ChangeOf(p.tenant.ID, event)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	p.read = event.Seq
+	for _, reg := range o.dispatcher.connectors {
+		if reg.tenant == change.Tenant && reg.types[change.Resource.Type] {
+			p.outstanding[event.Seq]++
+			o.schedule(&delivery{reg: reg, change: change, seq: event.Seq})
+		}
+	}
+	o.acknowledge(p)
+}
+
+// schedule makes a delivery runnable, or queues it behind the delivery in
+// flight for the same connector and resource. Callers must hold the lock.
+func (o *Outbox) schedule(d *delivery) {
+	k := d.key()
+	if waiting, busy := o.inFlight[k]; busy {
+		o.inFlight[k] = append(waiting, d)
+		return
+	}
+
+	o.inFlight[k] = nil
+	o.push(d)
+}
+
+// push makes a delivery runnable and wakes a worker. Callers must hold the
+// lock.
+func (o *Outbox) push(d *delivery) {
+	o.runnable = append(o.runnable, d)
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the next runnable delivery, or nil when there is none.
+func (o *Outbox) next() *delivery {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.runnable) == 0 {
+		return nil
+	}
+	d := o.runnable[0]
+	o.runnable = o.runnable[1:]
+
+	// Wakes are coalesced, so pass one on while work remains for other
+	// workers.
+	if len(o.runnable) > 0 {
+		select {
+		case o.wake <- struct{}{}:
+		default:
+		}
+	}
+	return d
+}
+
+// work runs runnable deliveries until the context is cancelled.
+func (o *Outbox) work(ctx context.Context) {
+	for ctx.Err() == nil {
+		d := o.next()
+		if d == nil {
+			select {
+			case <-ctx.Done():
+			case <-o.wake:
+			}
+			continue
+		}
+		o.attempt(ctx, d)
+	}
+}
+
+// attempt makes a single attempt at a delivery, and schedules a retry or
+// dead-letters the delivery when it fails. An attempt is not interrupted on
+// shutdown, since a cancelled operation could not be told apart from a
+// failed one.
+func (o *Outbox) attempt(ctx context.Context, d *delivery) {
+	d.attempts++
+	res := d.change.Resource
+	previous, _ := o.dispatcher.statuses.Get(d.change.Tenant, res.Type, res.ID, d.reg.name)
+
+	err := o.dispatcher.deliver(context.WithoutCancel(ctx), d.reg, d.change)
+	switch {
+	case err == nil:
+		if previous.RemoteID == "" {
+			o.requeueGroups(ctx, d)
+		}
+	case IsRetryable(err) && d.attempts < o.maxAttempts:
+		delay := o.backoff(d.attempts)
+		o.log.Infow(
+			"scheduled delivery retry", "connector", d.reg.name, "tenant", d.change.Tenant,
+			"resourceType", res.Type, "id", res.ID, "attempt", d.attempts, "delay", delay,
+		)
+		time.AfterFunc(delay, func() {
+			o.mu.Lock()
+			defer o.mu.Unlock()
+			o.push(d)
+		})
+		return
+	default:
+		letter, saveErr := o.deadLetters.add(DeadLetter{
+			ID:        d.letterID,
+			Connector: d.reg.name,
+			Change:    d.change,
+			Attempts:  d.attempts,
+			Error:     err.Error(),
+			FailedAt:  time.Now(),
+		})
+		o.log.Warnw(
+			"dead-lettered delivery", "deadLetter", letter.ID, "connector", d.reg.name, "tenant", d.change.Tenant,
+			"resourceType", res.Type, "id", res.ID, "attempts", d.attempts, "error", err,
+		)
+		if saveErr != nil {
+			o.log.Errorw("failed to persist dead letter", "deadLetter", letter.ID, "error", saveErr)
+		}
+	}
+
+	o.done(d)
+}
+
+// requeueGroups queues a membership change of every group listing a
+// resource that a delivery provisioned to its connector for the first time.
+// Groups provisioned before their members were left those members out, since
+// members are resolved to their downstream ids when a group is delivered.
+// Groups with a delivery in flight are queued behind it, while groups not
+// provisioned at all pick the member up when they are.
+func (o *Outbox) requeueGroups(ctx context.Context, d *delivery) {
+	res := d.change.Resource
+	p, ok := o.progress[d.change.Tenant]
+	if !ok || !d.reg.types[store.ResourceTypeGroup] {
+		return
+	}
+	if status, _ := o.dispatcher.statuses.Get(d.change.Tenant, res.Type, res.ID, d.reg.name); status.RemoteID == "" {
+		return
+	}
+	groups := p.tenant.Store.GroupsOf(ctx, res.ID)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, group := range groups {
+		change := Change{Tenant: d.change.Tenant, Kind: ChangeUpdated, Resource: group, Previous: withoutMember(group, res.ID)}
+		pending := &delivery{reg: d.reg, change: change}
+
+		status, _ := o.dispatcher.statuses.Get(d.change.Tenant, group.Type, group.ID, d.reg.name)
+		if _, busy := o.inFlight[pending.key()]; status.RemoteID == "" && !busy {
+			continue
+		}
+		o.log.Infow(
+			"queued membership of provisioned member", "connector", d.reg.name, "tenant", d.change.Tenant,
+			"groupId", group.ID, "resourceType", res.Type, "id", res.ID,
+		)
+		o.schedule(pending)
+	}
+}
+
+// backoff returns the delay before the retry following the given attempt:
+// the base delay doubled for every earlier retry and capped at the maximum
+// delay, less a random part of up to half of it so deliveries that failed
+// together are not retried together.
+func (o *Outbox) backoff(attempt int) time.Duration {
+	delay := o.baseDelay
+	for i := 1; i < attempt && delay < o.maxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, o.maxDelay)
+	if delay <= 0 {
+		return 0
+	}
+
+	half := delay / 2
+	return delay - rand.N(half+1)
+}
+
+// done completes a delivery, making the next delivery for its connector and
+// resource runnable and acknowledging the events fully delivered.
+func (o *Outbox) done(d *delivery) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	k := d.key()
+	if waiting := o.inFlight[k]; len(waiting) > 0 {
+		o.inFlight[k] = waiting[1:]
+		o.push(waiting[0])
+	} else {
+		delete(o.inFlight, k)
+	}
+
+	if d.seq == 0 {
+		return
+	}
+	p := o.progress[d.change.Tenant]
+	if p.outstanding[d.seq]--; p.outstanding[d.seq] == 0 {
+		delete(p.outstanding, d.seq)
+	}
+	o.acknowledge(p)
+}
+
+// acknowledge acknowledges the events of a tenant's outbox that are read and
+// fully delivered, up to the first event still being delivered. Callers must
+// hold the lock.
+func (o *Outbox) acknowledge(p *progress) {
+	acked := p.read
+	for seq := range p.outstanding {
+		acked = min(acked, seq-1)
+	}
+	if acked > p.acked {
+		p.acked = acked
+		p.tenant.Store.AckEvents(context.Background(), acked)
+	}
+}
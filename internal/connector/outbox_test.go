@@ -0,0 +1,360 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/iamBelugaa/scim-gateway/internal/config"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+	"github.com/iamBelugaa/scim-gateway/internal/tenant"
+	"github.com/iamBelugaa/scim-gateway/pkg/logger"
+)
+
+// setFail sets the error returned for an operation, nil to let it succeed.
+func (r *recorder) setFail(op Operation, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err == nil {
+		delete(r.fail, op)
+		return
+	}
+	r.fail[op] = err
+}
+
+// newTestOutbox runs an outbox delivering the changes of the default tenant
+// to a recorder, making up to the given attempts without noticeable delays.
+func newTestOutbox(t *testing.T, maxAttempts int) (*Outbox, *store.Memory, *recorder) {
+	t.Helper()
+
+	tenants, err := tenant.NewWithConfig(&config.Tenancy{}, &config.Store{})
+	if err != nil {
+		t.Fatalf("failed to construct tenants : %v", err)
+	}
+	def, err := tenants.Get("default")
+	if err != nil {
+		t.Fatalf("failed to get default tenant : %v", err)
+	}
+
+	d, rec := newTestDispatcher(t)
+	return runOutbox(t, d, tenants, maxAttempts), def.Store, rec
+}
+
+// runOutbox runs an outbox delivering changes to the connectors of a
+// dispatcher until the test ends, making up to the given attempts without
+// noticeable delays.
+func runOutbox(t *testing.T, d *Dispatcher, tenants *tenant.Registry, maxAttempts int) *Outbox {
+	t.Helper()
+
+	o, stop := startOutbox(t, d, tenants, testOutboxConfig(maxAttempts, ""))
+	t.Cleanup(stop)
+	return o
+}
+
+// testOutboxConfig returns the configuration of an outbox making up to the
+// given attempts without noticeable delays, persisted to dir unless empty.
+func testOutboxConfig(maxAttempts int, dir string) *config.Connectors {
+	return &config.Connectors{
+		OutboxDir:      dir,
+		Workers:        2,
+		MaxAttempts:    maxAttempts,
+		RetryBaseDelay: time.Millisecond,
+		RetryMaxDelay:  time.Millisecond * 5,
+	}
+}
+
+// startOutbox runs an outbox delivering changes to the connectors of a
+// dispatcher, until the returned function stops it.
+func startOutbox(t *testing.T, d *Dispatcher, tenants *tenant.Registry, cfg *config.Connectors) (*Outbox, func()) {
+	t.Helper()
+
+	o, err := NewOutbox(&logger.Logger{SugaredLogger: zap.NewNop().Sugar()}, d, tenants, cfg)
+	if err != nil {
+		t.Fatalf("failed to construct outbox : %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		o.Run(ctx)
+	}()
+	return o, func() {
+		cancel()
+		<-done
+	}
+}
+
+// eventually fails the test unless cond holds within a second.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestOutboxDeliversInOrder checks store changes are delivered to the
+// connector in order and acknowledged once delivered.
+func TestOutboxDeliversInOrder(t *testing.T) {
+	ctx := context.Background()
+	_, s, rec := newTestOutbox(t, 3)
+
+	created, err := s.Create(ctx, &store.Resource{Type: store.ResourceTypeUser, Attributes: map[string]any{"userName": "bjensen"}})
+	if err != nil {
+		t.Fatalf("failed to create user : %v", err)
+	}
+	if _, err := s.Update(ctx, store.ResourceTypeUser, created.ID, func(res *store.Resource) error {
+		res.Attributes["title"] = "Tour Guide"
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to update user : %v", err)
+	}
+	if err := s.Delete(ctx, store.ResourceTypeUser, created.ID); err != nil {
+		t.Fatalf("failed to delete user : %v", err)
+	}
+
+	var got []call
+	eventually(t, "the changes to be delivered", func() bool {
+		got = append(got, rec.ops()...)
+		return len(got) == 3
+	})
+	want := []Operation{OperationCreate, OperationUpdate, OperationDelete}
+	for i, c := range got {
+		if c.op != want[i] || c.id != created.ID {
+			t.Fatalf("got operations %+v, want %v", got, want)
+		}
+	}
+
+	eventually(t, "the events to be acknowledged", func() bool {
+		return len(s.PendingEvents(ctx, 0, 10)) == 0
+	})
+}
+
+// TestOutboxRetriesRetryableFailures checks retryable failures are retried
+// until they succeed.
+func TestOutboxRetriesRetryableFailures(t *testing.T) {
+	ctx := context.Background()
+	o, s, rec := newTestOutbox(t, 1000)
+
+	rec.setFail(OperationCreate, &RetryableError{Err: errors.New("downstream unavailable")})
+	created, err := s.Create(ctx, &store.Resource{Type: store.ResourceTypeUser, Attributes: map[string]any{"userName": "bjensen"}})
+	if err != nil {
+		t.Fatalf("failed to create user : %v", err)
+	}
+
+	calls := 0
+	eventually(t, "a retry", func() bool {
+		calls += len(rec.ops())
+		return calls >= 2
+	})
+	rec.setFail(OperationCreate, nil)
+
+	eventually(t, "the create to succeed", func() bool {
+		status, _ := o.dispatcher.Statuses().Get("default", store.ResourceTypeUser, created.ID, "downstream")
+		return status.State == StateSynced
+	})
+	if letters := o.DeadLetters().List("default"); len(letters) != 0 {
+		t.Fatalf("got dead letters %+v, want none", letters)
+	}
+}
+
+// TestOutboxDeadLetters checks deliveries are dead-lettered when their
+// attempts run out or their failure is permanent, and dead letters can be
+// retried and discarded.
+func TestOutboxDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	o, s, rec := newTestOutbox(t, 3)
+
+	rec.setFail(OperationCreate, &RetryableError{Err: errors.New("downstream unavailable")})
+	first, err := s.Create(ctx, &store.Resource{Type: store.ResourceTypeUser, Attributes: map[string]any{"userName": "bjensen"}})
+	if err != nil {
+		t.Fatalf("failed to create user : %v", err)
+	}
+	eventually(t, "the first create to be dead-lettered", func() bool {
+		return len(o.DeadLetters().List("default")) == 1
+	})
+
+	rec.setFail(OperationCreate, errors.New("invalid user"))
+	second, err := s.Create(ctx, &store.Resource{Type: store.ResourceTypeUser, Attributes: map[string]any{"userName": "jsmith"}})
+	if err != nil {
+		t.Fatalf("failed to create user : %v", err)
+	}
+	eventually(t, "the second create to be dead-lettered", func() bool {
+		return len(o.DeadLetters().List("default")) == 2
+	})
+
+	letters := o.DeadLetters().List("default")
+	if letters[0].Change.Resource.ID != second.ID || letters[0].Attempts != 1 || letters[0].Error != "invalid user" {
+		t.Fatalf("got dead letter %+v, want the permanent failure after a single attempt", letters[0])
+	}
+	if letters[1].Change.Resource.ID != first.ID || letters[1].Attempts != 3 {
+		t.Fatalf("got dead letter %+v, want the retryable failure after every attempt", letters[1])
+	}
+	if _, err := o.DeadLetters().Get("acme", letters[0].ID); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Fatalf("got %v, want dead letters to be scoped to their tenant", err)
+	}
+	eventually(t, "the dead-lettered events to be acknowledged", func() bool {
+		return len(s.PendingEvents(ctx, 0, 10)) == 0
+	})
+
+	// Retried changes are delivered again while their resource exists.
+	rec.setFail(OperationCreate, nil)
+	if _, err := s.Update(ctx, store.ResourceTypeUser, first.ID, func(res *store.Resource) error {
+		res.Attributes["title"] = "Tour Guide"
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to update user : %v", err)
+	}
+	eventually(t, "the update to be delivered", func() bool {
+		status, _ := o.dispatcher.Statuses().Get("default", store.ResourceTypeUser, first.ID, "downstream")
+		return status.State == StateSynced
+	})
+	rec.ops()
+
+	if queued, err := o.Retry(ctx, "default", letters[1].ID); err != nil || !queued {
+		t.Fatalf("got %v, %v, want the retry to be queued", queued, err)
+	}
+	eventually(t, "the retry to be delivered", func() bool {
+		return len(rec.ops()) == 1
+	})
+
+	// Changes superseded by a later delete are dropped.
+	if err := s.Delete(ctx, store.ResourceTypeUser, second.ID); err != nil {
+		t.Fatalf("failed to delete user : %v", err)
+	}
+	if queued, err := o.Retry(ctx, "default", letters[0].ID); err != nil || queued {
+		t.Fatalf("got %v, %v, want the retry to be dropped", queued, err)
+	}
+	if _, err := o.Retry(ctx, "default", letters[0].ID); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Fatalf("got %v, want retried dead letters to be removed", err)
+	}
+	if len(o.DeadLetters().List("default")) != 0 {
+		t.Fatal("expected no dead letters left")
+	}
+}
+
+// TestOutboxAddsPendingMembers checks a member whose create fails while its
+// group is provisioned is added to the group once its create succeeds.
+func TestOutboxAddsPendingMembers(t *testing.T) {
+	ctx := context.Background()
+	_, s, rec := newTestOutbox(t, 1000)
+
+	group, err := s.Create(ctx, &store.Resource{Type: store.ResourceTypeGroup, Attributes: map[string]any{"displayName": "Tour Guides"}})
+	if err != nil {
+		t.Fatalf("failed to create group : %v", err)
+	}
+	eventually(t, "the group to be created", func() bool {
+		return len(rec.ops()) == 1
+	})
+
+	rec.setFail(OperationCreate, &RetryableError{Err: errors.New("downstream unavailable")})
+	member, err := s.Create(ctx, &store.Resource{Type: store.ResourceTypeUser, Attributes: map[string]any{"userName": "bjensen"}})
+	if err != nil {
+		t.Fatalf("failed to create user : %v", err)
+	}
+	if _, err := s.Update(ctx, store.ResourceTypeGroup, group.ID, func(res *store.Resource) error {
+		res.Attributes["members"] = []any{map[string]any{"value": member.ID, "type": "User"}}
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to add member : %v", err)
+	}
+
+	// The membership change reaches the group before the member exists
+	// downstream, so it cannot name the member.
+	var calls []call
+	membership := func(added ...string) func(c call) bool {
+		return func(c call) bool {
+			return c.op == OperationMembership && c.id == group.ID && slices.Equal(c.added, added)
+		}
+	}
+	eventually(t, "the membership to be delivered", func() bool {
+		calls = append(calls, rec.ops()...)
+		return slices.ContainsFunc(calls, membership(""))
+	})
+
+	rec.setFail(OperationCreate, nil)
+	eventually(t, "the member to be added once created", func() bool {
+		calls = append(calls, rec.ops()...)
+		return slices.ContainsFunc(calls, membership("remote-"+member.ID))
+	})
+}
+
+// TestOutboxSurvivesRestart checks changes not yet delivered and dead letters
+// are picked up by the outbox of the next run when the outbox is persisted.
+func TestOutboxSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	tenants, err := tenant.NewWithConfig(&config.Tenancy{}, &config.Store{})
+	if err != nil {
+		t.Fatalf("failed to construct tenants : %v", err)
+	}
+	def, _ := tenants.Get("default")
+	d, rec := newTestDispatcher(t)
+	o, stop := startOutbox(t, d, tenants, testOutboxConfig(1000, dir))
+
+	rec.setFail(OperationCreate, errors.New("invalid user"))
+	rejected, err := def.Store.Create(ctx, &store.Resource{Type: store.ResourceTypeUser, Attributes: map[string]any{"userName": "jsmith"}})
+	if err != nil {
+		t.Fatalf("failed to create user : %v", err)
+	}
+	eventually(t, "the create to be dead-lettered", func() bool {
+		return len(o.DeadLetters().List("default")) == 1
+	})
+
+	rec.setFail(OperationCreate, &RetryableError{Err: errors.New("downstream unavailable")})
+	pending, err := def.Store.Create(ctx, &store.Resource{Type: store.ResourceTypeUser, Attributes: map[string]any{"userName": "bjensen"}})
+	if err != nil {
+		t.Fatalf("failed to create user : %v", err)
+	}
+	eventually(t, "a failed attempt", func() bool {
+		return slices.ContainsFunc(rec.ops(), func(c call) bool { return c.id == pending.ID })
+	})
+	stop()
+
+	// The next run starts with empty stores, as after a restart.
+	tenants, err = tenant.NewWithConfig(&config.Tenancy{}, &config.Store{})
+	if err != nil {
+		t.Fatalf("failed to construct tenants : %v", err)
+	}
+	d, rec = newTestDispatcher(t)
+	o, stop = startOutbox(t, d, tenants, testOutboxConfig(1000, dir))
+	t.Cleanup(stop)
+
+	var calls []call
+	eventually(t, "the pending create to be delivered", func() bool {
+		calls = append(calls, rec.ops()...)
+		return len(calls) == 1
+	})
+	if calls[0].op != OperationCreate || calls[0].id != pending.ID {
+		t.Fatalf("got operations %+v, want only the pending create", calls)
+	}
+	letters := o.DeadLetters().List("default")
+	if len(letters) != 1 || letters[0].Change.Resource.ID != rejected.ID || letters[0].Error != "invalid user" {
+		t.Fatalf("got dead letters %+v, want the rejected create", letters)
+	}
+}
+
+// TestOutboxBackoff checks retry delays grow exponentially up to the maximum
+// delay, with up to half of each delay taken off as jitter.
+func TestOutboxBackoff(t *testing.T) {
+	o := &Outbox{baseDelay: time.Second, maxDelay: time.Second * 10}
+
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: time.Second * 2, 3: time.Second * 4, 4: time.Second * 8, 5: time.Second * 10, 50: time.Second * 10} {
+		for range 100 {
+			if got := o.backoff(attempt); got > want || got < want/2 {
+				t.Fatalf("attempt %d : got delay %s, want between %s and %s", attempt, got, want/2, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package connector
+
+import (
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+)
+
+// Step is an operation a change would run on a connector, as planned by a
+// dry run.
+type Step struct {
+	Connector    string
+	Operation    Operation
+	ResourceType store.ResourceType
+	ID           string         // Id of the resource in the gateway.
+	RemoteID     string         // Id of the resource in the downstream system, empty when it is not provisioned yet.
+	Attributes   map[string]any // Attributes provisioned after the connector's mapping, nil for deletes.
+	Added        []string       // Ids of the members added to a group by a membership operation.
+	Removed      []string       // Ids of the members removed from a group by a membership operation.
+	Error        string         // Why the operation would fail before reaching the connector, such as a failed mapping.
+}
+
+// ChangeOf returns the change an outbox event of a tenant records.
+func ChangeOf(tenantID string, event *store.Event) Change {
+	return Change{
+		Tenant:   tenantID,
+		Kind:     ChangeKind(event.Kind),
+		Resource: event.Resource,
+		Previous: event.Previous,
+	}
+}
+
+// Plan returns the operations the changes would run on the connectors of
+// their tenant, in order, without running any. Changes are routed and
+// mapped as they would be when delivered, and resources created by an
+// earlier change count as provisioned by the later ones.
+func (d *Dispatcher) Plan(changes []Change) []Step {
+	steps := make([]Step, 0)
+	if !d.Enabled() {
+		return steps
+	}
+
+	// Whether resources are provisioned once the planned operations ran,
+	// keyed by connector and resource.
+	provisioned := make(map[deliveryKey]bool)
+
+	for _, change := range changes {
+		res := change.Resource
+		for _, reg := range d.connectors {
+			if reg.tenant != change.Tenant || !reg.types[res.Type] {
+				continue
+			}
+
+			k := deliveryKey{connector: reg.name, resource: resourceKey{tenant: change.Tenant, resourceType: res.Type, id: res.ID}}
+			status, _ := d.statuses.Get(change.Tenant, res.Type, res.ID, reg.name)
+			isProvisioned, planned := provisioned[k]
+			if !planned {
+				isProvisioned = status.RemoteID != ""
+			}
+
+			for _, op := range reg.operations(change, isProvisioned) {
+				step := Step{Connector: reg.name, Operation: op, ResourceType: res.Type, ID: res.ID}
+				if isProvisioned {
+					step.RemoteID = status.RemoteID
+				}
+
+				if op != OperationDelete {
+					step.Attributes, step.Error = preview(reg.connector, &Request{Tenant: change.Tenant, Resource: res})
+				}
+				isProvisioned = op != OperationDelete && (isProvisioned || op == OperationCreate)
+				if op == OperationMembership && change.Previous != nil {
+					added, removed := diffMembers(change.Previous, res)
+					step.Added, step.Removed = memberIDs(added), memberIDs(removed)
+				}
+				steps = append(steps, step)
+			}
+			provisioned[k] = isProvisioned
+		}
+	}
+	return steps
+}
+
+// preview returns the attributes a connector would be passed for a request,
+// after its mapping, or why the mapping fails.
+func preview(connector Connector, req *Request) (map[string]any, string) {
+	m, ok := connector.(interface {
+		request(req *Request) (*Request, error)
+	})
+	if !ok {
+		return req.Resource.Clone().Attributes, ""
+	}
+
+	mreq, err := m.request(req)
+	if err != nil {
+		return nil, err.Error()
+	}
+	return mreq.Resource.Attributes, ""
+}
+
+// memberIDs returns the gateway ids of group members.
+func memberIDs(members []Member) []string {
+	ids := make([]string, len(members))
+	for i, member := range members {
+		ids[i] = member.ID
+	}
+	return ids
+}
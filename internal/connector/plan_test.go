@@ -0,0 +1,77 @@
+package connector
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/iamBelugaa/scim-gateway/internal/filter"
+	"github.com/iamBelugaa/scim-gateway/internal/mapping"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+)
+
+// TestPlan plans changes to provisioned and new resources on a mapped and a
+// scoped connector, and asserts no operation is run.
+func TestPlan(t *testing.T) {
+	d, rec := newTestDispatcher(t)
+	d.connectors[0].types = map[store.ResourceType]bool{store.ResourceTypeUser: true}
+
+	m, err := mapping.New([]mapping.Rule{{Target: "login", Source: mapping.Sources{"userName"}}})
+	if err != nil {
+		t.Fatalf("failed to construct mapping : %v", err)
+	}
+	d.connectors[0].connector = &mapped{Connector: rec, mapping: m}
+
+	scope, err := filter.Parse(`active eq true`)
+	if err != nil {
+		t.Fatalf("failed to parse scope : %v", err)
+	}
+	scoped := &recorder{}
+	if err := d.Register("active-only", "default", []store.ResourceType{store.ResourceTypeUser}, scope, scoped); err != nil {
+		t.Fatalf("failed to register connector : %v", err)
+	}
+	deliver := newTestDelivery(t, d)
+
+	active := user("u1", map[string]any{"userName": "bjensen", "active": true})
+	deliver(Change{Tenant: "default", Kind: ChangeCreated, Resource: active})
+	rec.ops()
+	scoped.ops()
+
+	inactive := user("u1", map[string]any{"userName": "bjensen", "active": false})
+	created := user("u2", map[string]any{"userName": "alice", "active": true})
+	renamed := user("u2", map[string]any{"userName": "alice.liddell", "active": true})
+	steps := d.Plan([]Change{
+		{Tenant: "default", Kind: ChangeUpdated, Resource: inactive, Previous: active},
+		{Tenant: "default", Kind: ChangeCreated, Resource: created},
+		{Tenant: "default", Kind: ChangeUpdated, Resource: renamed, Previous: created},
+	})
+
+	type planned struct {
+		connector string
+		op        Operation
+		id        string
+		remoteID  string
+		login     any
+	}
+	got := make([]planned, len(steps))
+	for i, step := range steps {
+		got[i] = planned{connector: step.Connector, op: step.Operation, id: step.ID, remoteID: step.RemoteID, login: step.Attributes["login"]}
+	}
+	want := []planned{
+		{connector: "downstream", op: OperationDisable, id: "u1", remoteID: "remote-u1", login: "bjensen"},
+		{connector: "active-only", op: OperationDelete, id: "u1", remoteID: "remote-u1"},
+		{connector: "downstream", op: OperationCreate, id: "u2", login: "alice"},
+		{connector: "active-only", op: OperationCreate, id: "u2"},
+		{connector: "downstream", op: OperationUpdate, id: "u2", login: "alice.liddell"},
+		{connector: "active-only", op: OperationUpdate, id: "u2"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got steps %+v, want %+v", got, want)
+	}
+
+	if calls := append(rec.ops(), scoped.ops()...); len(calls) != 0 {
+		t.Fatalf("expected planning to run no operation, got %+v", calls)
+	}
+	if status, _ := d.Statuses().Get("default", store.ResourceTypeUser, "u1", "active-only"); status.Operation != OperationCreate {
+		t.Fatalf("expected planning to leave statuses untouched, got %+v", status)
+	}
+}
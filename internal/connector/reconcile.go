@@ -0,0 +1,508 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/iamBelugaa/scim-gateway/internal/config"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+	"github.com/iamBelugaa/scim-gateway/internal/tenant"
+	"github.com/iamBelugaa/scim-gateway/pkg/logger"
+)
+
+// maxReports is the number of reconciliation reports kept, older reports
+// being dropped first.
+const maxReports = 100
+
+// Reconciliation errors.
+var (
+	ErrConnectorNotFound     = errors.New("connector not found")
+	ErrListUnsupported       = errors.New("connector cannot list downstream accounts")
+	ErrReconciliationRunning = errors.New("reconciliation already running")
+	ErrReportNotFound        = errors.New("reconciliation report not found")
+)
+
+// Account is a resource as held by a downstream system.
+type Account struct {
+	RemoteID   string         // Id of the account in the downstream system.
+	ExternalID string         // Key of the gateway resource the account was provisioned from, when the downstream system records one.
+	Attributes map[string]any // Attributes of the account, in the form Expected returns them.
+}
+
+// Lister is implemented by connectors that can list the accounts of their
+// downstream system, so the accounts can be reconciled with the gateway.
+type Lister interface {
+	// List returns every account of a resource type.
+	List(ctx context.Context, resourceType store.ResourceType) ([]Account, error)
+
+	// Expected returns the account a resource should be provisioned as. The
+	// attributes hold the values the connector writes, in the form List
+	// returns them, and the remote and external ids are set when the
+	// connector can derive them from the resource.
+	Expected(req *Request) (Account, error)
+}
+
+// DriftKind is the kind of difference between the gateway and a downstream
+// system.
+type DriftKind string
+
+// Supported drift kinds.
+const (
+	DriftMissing  DriftKind = "missing"  // The resource has no downstream account.
+	DriftOrphaned DriftKind = "orphaned" // The account matches no resource of the gateway.
+	DriftMismatch DriftKind = "mismatch" // An attribute of the account differs from the resource.
+	DriftError    DriftKind = "error"    // The resource could not be compared, such as when its mapping fails.
+)
+
+// Drift is a single difference between the gateway and a downstream system.
+type Drift struct {
+	Kind         DriftKind
+	ResourceType store.ResourceType
+	ID           string // Id of the resource in the gateway, empty for orphaned accounts.
+	RemoteID     string // Id of the account in the downstream system, empty for missing resources.
+	Attribute    string // Attribute that differs, for mismatches.
+	Expected     any    // Value the resource holds, for mismatches.
+	Actual       any    // Value the account holds, for mismatches.
+	Detail       string // Error of resources that could not be compared.
+}
+
+// ReportState is the state of a reconciliation.
+type ReportState string
+
+// Supported report states.
+const (
+	ReportRunning   ReportState = "running"
+	ReportCompleted ReportState = "completed"
+	ReportFailed    ReportState = "failed"
+)
+
+// Report is the outcome of a reconciliation of a connector.
+type Report struct {
+	ID          string
+	Tenant      string
+	Connector   string
+	State       ReportState
+	Correct     bool // Whether corrective operations were requested.
+	StartedAt   time.Time
+	CompletedAt time.Time // Zero while the reconciliation runs.
+	Error       string    // Error the reconciliation failed with.
+	Resources   int       // Number of gateway resources compared.
+	Accounts    int       // Number of downstream accounts listed.
+	Corrections int       // Number of corrective operations queued.
+	Drifts      []Drift
+}
+
+// Reconciler compares the resources of the gateway with the accounts the
+// connectors list from their downstream systems, and reports drift: missing
+// accounts, orphaned accounts and attribute mismatches. On request it queues
+// the operations correcting missing and mismatched accounts through the
+// outbox. Orphaned accounts are only reported, since the gateway cannot tell
+// whether another system owns them.
+type Reconciler struct {
+	log        *logger.Logger
+	dispatcher *Dispatcher
+	outbox     *Outbox
+	tenants    *tenant.Registry
+	interval   time.Duration // How often every connector is reconciled, never when zero.
+	correct    bool          // Whether scheduled reconciliations queue corrective operations.
+
+	mu      sync.Mutex
+	reports []*Report       // Reports, oldest first.
+	running map[string]bool // Connectors being reconciled, keyed by name.
+}
+
+// NewReconciler constructs a reconciler of the connectors registered with
+// the dispatcher, queueing corrective operations through the outbox.
+func NewReconciler(
+	log *logger.Logger, dispatcher *Dispatcher, outbox *Outbox, tenants *tenant.Registry, cfg *config.Connectors,
+) *Reconciler {
+	return &Reconciler{
+		log:        log,
+		dispatcher: dispatcher,
+		outbox:     outbox,
+		tenants:    tenants,
+		interval:   cfg.ReconcileInterval,
+		correct:    cfg.ReconcileCorrect,
+		running:    make(map[string]bool),
+	}
+}
+
+// Run reconciles every connector able to list its accounts on every
+// interval, until the context is cancelled.
+func (r *Reconciler) Run(ctx context.Context) {
+	if r.interval <= 0 || !r.dispatcher.Enabled() {
+		r.log.Infow("scheduled reconciliation disabled")
+		return
+	}
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, reg := range r.dispatcher.connectors {
+				if _, ok := reg.connector.(Lister); !ok {
+					continue
+				}
+				report, err := r.start(reg, r.correct)
+				if err != nil {
+					r.log.Infow("skipped scheduled reconciliation", "connector", reg.name, "error", err)
+					continue
+				}
+				r.run(ctx, report, reg)
+			}
+		}
+	}
+}
+
+// Start starts reconciling a connector of a tenant in the background and
+// returns the report of the running reconciliation.
+func (r *Reconciler) Start(ctx context.Context, tenantID, name string, correct bool) (Report, error) {
+	reg, ok := r.dispatcher.registration(name)
+	if !ok || reg.tenant != tenantID {
+		return Report{}, fmt.Errorf("connector %q : %w", name, ErrConnectorNotFound)
+	}
+
+	report, err := r.start(reg, correct)
+	if err != nil {
+		return Report{}, err
+	}
+
+	started := r.snapshot(report)
+	go r.run(context.WithoutCancel(ctx), report, reg)
+	return started, nil
+}
+
+// Reports returns the reports of a tenant, most recent first.
+func (r *Reconciler) Reports(tenantID string) []Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reports := make([]Report, 0)
+	for i := len(r.reports) - 1; i >= 0; i-- {
+		if report := r.reports[i]; report.Tenant == tenantID {
+			reports = append(reports, *report)
+		}
+	}
+	return reports
+}
+
+// Report returns a report of a tenant.
+func (r *Reconciler) Report(tenantID, id string) (Report, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, report := range r.reports {
+		if report.ID == id && report.Tenant == tenantID {
+			return *report, nil
+		}
+	}
+	return Report{}, fmt.Errorf("report %q : %w", id, ErrReportNotFound)
+}
+
+// start records a running reconciliation of a connector.
+func (r *Reconciler) start(reg *registration, correct bool) (*Report, error) {
+	if _, ok := reg.connector.(Lister); !ok {
+		return nil, fmt.Errorf("connector %q : %w", reg.name, ErrListUnsupported)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.running[reg.name] {
+		return nil, fmt.Errorf("connector %q : %w", reg.name, ErrReconciliationRunning)
+	}
+	r.running[reg.name] = true
+
+	report := &Report{
+		ID:        uuid.NewString(),
+		Tenant:    reg.tenant,
+		Connector: reg.name,
+		State:     ReportRunning,
+		Correct:   correct,
+		StartedAt: time.Now(),
+	}
+	r.reports = append(r.reports, report)
+	if len(r.reports) > maxReports {
+		r.reports = slices.Delete(r.reports, 0, len(r.reports)-maxReports)
+	}
+	return report, nil
+}
+
+// snapshot returns a copy of a report.
+func (r *Reconciler) snapshot(report *Report) Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return *report
+}
+
+// run reconciles a connector and completes its report. Users are reconciled
+// before groups, so corrected groups can refer to corrected members.
+func (r *Reconciler) run(ctx context.Context, report *Report, reg *registration) {
+	result := Report{}
+	err := func() error {
+		t, err := r.tenants.Get(reg.tenant)
+		if err != nil {
+			return err
+		}
+		for _, resourceType := range []store.ResourceType{store.ResourceTypeUser, store.ResourceTypeGroup} {
+			if reg.types[resourceType] {
+				if err := r.reconcile(ctx, t, reg, resourceType, report.Correct, &result); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.running, reg.name)
+	report.CompletedAt = time.Now()
+	report.Resources, report.Accounts = result.Resources, result.Accounts
+	report.Corrections, report.Drifts = result.Corrections, result.Drifts
+	if err != nil {
+		report.State = ReportFailed
+		report.Error = err.Error()
+		r.log.Warnw("reconciliation failed", "id", report.ID, "connector", reg.name, "tenant", reg.tenant, "error", err)
+		return
+	}
+
+	report.State = ReportCompleted
+	r.log.Infow(
+		"reconciliation completed", "id", report.ID, "connector", reg.name, "tenant", reg.tenant,
+		"resources", report.Resources, "accounts", report.Accounts, "drifts", len(report.Drifts), "corrections", report.Corrections,
+	)
+}
+
+// reconcile compares the resources of a type with the accounts of a
+// connector, adding the drift found to the result and queueing the
+// corrective operations when requested.
+func (r *Reconciler) reconcile(
+	ctx context.Context, t *tenant.Tenant, reg *registration, resourceType store.ResourceType, correct bool, result *Report,
+) error {
+	lister := reg.connector.(Lister)
+
+	accounts, err := lister.List(ctx, resourceType)
+	if err != nil {
+		return fmt.Errorf("failed to list %s accounts : %w", resourceType, err)
+	}
+	all, err := t.Store.List(ctx, resourceType)
+	if err != nil {
+		return err
+	}
+
+	// Resources out of the scope of the connector are not expected
+	// downstream, so accounts left for them are reported as orphans.
+	resources := make([]*store.Resource, 0, len(all))
+	for _, res := range all {
+		if reg.inScope(res) {
+			resources = append(resources, res)
+		}
+	}
+	result.Resources += len(resources)
+	result.Accounts += len(accounts)
+
+	byRemoteID := make(map[string]int, len(accounts))
+	byExternalID := make(map[string]int, len(accounts))
+	for i, account := range accounts {
+		byRemoteID[account.RemoteID] = i
+		if account.ExternalID != "" {
+			byExternalID[account.ExternalID] = i
+		}
+	}
+	matched := make([]bool, len(accounts))
+
+	for _, res := range resources {
+		status, _ := r.dispatcher.statuses.Get(t.ID, resourceType, res.ID, reg.name)
+		req := &Request{Tenant: t.ID, Resource: res, RemoteID: status.RemoteID}
+		if resourceType == store.ResourceTypeGroup {
+			req.Members = r.dispatcher.resolve(t.ID, reg.name, memberRefs(res))
+		}
+
+		expected, err := lister.Expected(req)
+		if err != nil {
+			result.Drifts = append(result.Drifts, Drift{
+				Kind: DriftError, ResourceType: resourceType, ID: res.ID, RemoteID: status.RemoteID, Detail: err.Error(),
+			})
+			continue
+		}
+
+		i, found := lookup(byRemoteID, status.RemoteID)
+		if !found {
+			i, found = lookup(byRemoteID, expected.RemoteID)
+		}
+		if !found {
+			i, found = lookup(byExternalID, expected.ExternalID)
+		}
+		if !found {
+			result.Drifts = append(result.Drifts, Drift{Kind: DriftMissing, ResourceType: resourceType, ID: res.ID})
+			if correct {
+				r.outbox.queue(reg, Change{Tenant: t.ID, Kind: ChangeCreated, Resource: res})
+				result.Corrections++
+			}
+			continue
+		}
+		matched[i] = true
+
+		account := accounts[i]
+		mismatches := diffAttributes(expected.Attributes, account.Attributes)
+		for _, name := range mismatches {
+			result.Drifts = append(result.Drifts, Drift{
+				Kind: DriftMismatch, ResourceType: resourceType, ID: res.ID, RemoteID: account.RemoteID,
+				Attribute: name, Expected: expected.Attributes[name], Actual: account.Attributes[name],
+			})
+		}
+		if correct && len(mismatches) > 0 {
+			r.outbox.queue(reg, correction(t.ID, res))
+			result.Corrections++
+		}
+	}
+
+	for i, account := range accounts {
+		if !matched[i] {
+			result.Drifts = append(result.Drifts, Drift{Kind: DriftOrphaned, ResourceType: resourceType, RemoteID: account.RemoteID})
+		}
+	}
+	return nil
+}
+
+// lookup returns the index of the account with a key, if any.
+func lookup(index map[string]int, key string) (int, bool) {
+	if key == "" {
+		return 0, false
+	}
+	i, ok := index[key]
+	return i, ok
+}
+
+// correction returns the change rewriting every attribute of a resource
+// downstream. It is an update from an empty resource, so the operations
+// replace the attributes, disable inactive users and add every member of a
+// group.
+func correction(tenantID string, res *store.Resource) Change {
+	previous := &store.Resource{ID: res.ID, Type: res.Type, Attributes: map[string]any{}}
+	return Change{Tenant: tenantID, Kind: ChangeUpdated, Resource: res, Previous: previous}
+}
+
+// diffAttributes returns the names of the expected attributes the actual
+// attributes do not match, sorted.
+func diffAttributes(expected, actual map[string]any) []string {
+	names := make([]string, 0)
+	for _, name := range slices.Sorted(maps.Keys(expected)) {
+		if !matches(expected[name], actual[name]) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// matches reports whether an actual value matches an expected one. Complex
+// values match when every expected sub-attribute matches, so attributes the
+// downstream system adds on its own are not drift. Multi-valued attributes
+// match as sets, since SCIM does not order them and downstream systems may
+// return them in any order. Numbers match by value, text exactly and missing
+// values match empty ones.
+func matches(expected, actual any) bool {
+	if empty(expected) || empty(actual) {
+		return empty(expected) && empty(actual)
+	}
+
+	switch e := expected.(type) {
+	case map[string]any:
+		a, ok := actual.(map[string]any)
+		if !ok {
+			return false
+		}
+		for name, val := range e {
+			if !matches(val, a[name]) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		a, ok := actual.([]any)
+		return ok && len(a) == len(e) && matchesSet(e, a)
+	}
+
+	if en, ok := number(expected); ok {
+		an, ok := number(actual)
+		return ok && en == an
+	}
+	return expected == actual
+}
+
+// matchesSet reports whether every expected value matches a distinct actual
+// value, in any order. Values are paired along augmenting paths, since a
+// complex value may match several actual values and pairing it with the first
+// could take the only one another expected value matches.
+func matchesSet(expected, actual []any) bool {
+	owner := make([]int, len(actual)) // Expected value paired with each actual value, -1 when unpaired.
+	for j := range owner {
+		owner[j] = -1
+	}
+
+	var pair func(i int, seen []bool) bool
+	pair = func(i int, seen []bool) bool {
+		for j := range actual {
+			if seen[j] || !matches(expected[i], actual[j]) {
+				continue
+			}
+			seen[j] = true
+			if owner[j] < 0 || pair(owner[j], seen) {
+				owner[j] = i
+				return true
+			}
+		}
+		return false
+	}
+
+	for i := range expected {
+		if !pair(i, make([]bool, len(actual))) {
+			return false
+		}
+	}
+	return true
+}
+
+// empty reports whether a value counts as missing.
+func empty(val any) bool {
+	switch v := val.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(v) == ""
+	case []any:
+		return len(v) == 0
+	case map[string]any:
+		return len(v) == 0
+	default:
+		return false
+	}
+}
+
+// number converts a numeric value to a float.
+func number(val any) (float64, bool) {
+	switch v := val.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
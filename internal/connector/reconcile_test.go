@@ -0,0 +1,177 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/iamBelugaa/scim-gateway/internal/config"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+	"github.com/iamBelugaa/scim-gateway/internal/tenant"
+	"github.com/iamBelugaa/scim-gateway/pkg/logger"
+)
+
+// listing is a recorder able to list accounts. Accounts are found by the
+// local id of their resource, and hold its userName.
+type listing struct {
+	*recorder
+	accounts []Account
+}
+
+func (l *listing) List(ctx context.Context, resourceType store.ResourceType) ([]Account, error) {
+	if resourceType != store.ResourceTypeUser {
+		return nil, nil
+	}
+	return l.accounts, nil
+}
+
+func (l *listing) Expected(req *Request) (Account, error) {
+	if req.Resource.Attributes["userName"] == "invalid" {
+		return Account{}, errors.New("userName cannot be mapped")
+	}
+	return Account{ExternalID: req.Resource.ID, Attributes: map[string]any{"userName": req.Resource.Attributes["userName"]}}, nil
+}
+
+// TestReconcile checks missing, orphaned and mismatched accounts are
+// reported, and corrections are queued for missing and mismatched accounts.
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	log := &logger.Logger{SugaredLogger: zap.NewNop().Sugar()}
+
+	tenants, err := tenant.NewWithConfig(&config.Tenancy{}, &config.Store{})
+	if err != nil {
+		t.Fatalf("failed to construct tenants : %v", err)
+	}
+	def, err := tenants.Get("default")
+	if err != nil {
+		t.Fatalf("failed to get default tenant : %v", err)
+	}
+
+	ids := make(map[string]string)
+	for _, userName := range []string{"alice", "bob", "carol", "invalid"} {
+		res, err := def.Store.Create(ctx, &store.Resource{Type: store.ResourceTypeUser, Attributes: map[string]any{"userName": userName}})
+		if err != nil {
+			t.Fatalf("failed to create user : %v", err)
+		}
+		ids[userName] = res.ID
+	}
+
+	rec := &recorder{fail: make(map[Operation]error)}
+	lister := &listing{recorder: rec, accounts: []Account{
+		{RemoteID: "remote-alice", ExternalID: ids["alice"], Attributes: map[string]any{"userName": "alice", "title": "Tour Guide"}},
+		{RemoteID: "remote-bob", ExternalID: ids["bob"], Attributes: map[string]any{"userName": "robert"}},
+		{RemoteID: "remote-dave", Attributes: map[string]any{"userName": "dave"}},
+	}}
+	d := NewDispatcher(log, time.Second)
+	if err := d.Register("downstream", "default", []store.ResourceType{store.ResourceTypeUser}, nil, lister); err != nil {
+		t.Fatalf("failed to register connector : %v", err)
+	}
+	d.statuses.set("default", store.ResourceTypeUser, ids["bob"], Status{Connector: "downstream", State: StateSynced, RemoteID: "remote-bob"})
+
+	cfg := &config.Connectors{Workers: 1, MaxAttempts: 1}
+	o, err := NewOutbox(log, d, tenants, cfg)
+	if err != nil {
+		t.Fatalf("failed to construct outbox : %v", err)
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		o.Run(runCtx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	r := NewReconciler(log, d, o, tenants, cfg)
+	if _, err := r.Start(ctx, "acme", "downstream", false); !errors.Is(err, ErrConnectorNotFound) {
+		t.Fatalf("got %v, want connectors to be scoped to their tenant", err)
+	}
+	started, err := r.Start(ctx, "default", "downstream", true)
+	if err != nil {
+		t.Fatalf("failed to start reconciliation : %v", err)
+	}
+
+	var report Report
+	eventually(t, "the reconciliation to complete", func() bool {
+		report, err = r.Report("default", started.ID)
+		return err == nil && report.State != ReportRunning
+	})
+	if report.State != ReportCompleted || report.Resources != 4 || report.Accounts != 3 || report.Corrections != 2 {
+		t.Fatalf("got report %+v", report)
+	}
+
+	want := []Drift{
+		{Kind: DriftMismatch, ResourceType: store.ResourceTypeUser, ID: ids["bob"], RemoteID: "remote-bob", Attribute: "userName", Expected: "bob", Actual: "robert"},
+		{Kind: DriftMissing, ResourceType: store.ResourceTypeUser, ID: ids["carol"]},
+		{Kind: DriftError, ResourceType: store.ResourceTypeUser, ID: ids["invalid"], Detail: "userName cannot be mapped"},
+		{Kind: DriftOrphaned, ResourceType: store.ResourceTypeUser, RemoteID: "remote-dave"},
+	}
+	if !slices.Equal(report.Drifts, want) {
+		t.Fatalf("got drifts %+v, want %+v", report.Drifts, want)
+	}
+
+	// Bob is updated by his remote id, and carol, who was never provisioned,
+	// is created.
+	var got []call
+	eventually(t, "the corrections to be delivered", func() bool {
+		got = append(got, rec.ops()...)
+		return len(got) == 2
+	})
+	for _, c := range got {
+		if (c.op != OperationUpdate || c.id != ids["bob"] || c.remoteID != "remote-bob") && (c.op != OperationCreate || c.id != ids["carol"]) {
+			t.Fatalf("got corrections %+v", got)
+		}
+	}
+
+	if reports := r.Reports("default"); len(reports) != 1 || reports[0].ID != started.ID {
+		t.Fatalf("got reports %+v, want the single reconciliation", reports)
+	}
+}
+
+// TestMatches checks how downstream values are compared with the values of
+// the gateway.
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		name             string
+		expected, actual any
+		want             bool
+	}{
+		{"equal text", "bjensen", "bjensen", true},
+		{"different text", "bjensen", "BJensen", false},
+		{"missing and empty", "", nil, true},
+		{"missing value", "bjensen", nil, false},
+		{"numbers", 3, float64(3), true},
+		{"sub-attributes", map[string]any{"givenName": "Barbara"}, map[string]any{"givenName": "Barbara", "formatted": "Ms. Barbara"}, true},
+		{"different sub-attribute", map[string]any{"givenName": "Barbara"}, map[string]any{"givenName": "Babs"}, false},
+		{"lists", []any{"a", "b"}, []any{"a", "b"}, true},
+		{"lists of different lengths", []any{"a"}, []any{"a", "b"}, false},
+		{"reordered lists", []any{"a", "b"}, []any{"b", "a"}, true},
+		{"lists with a different value", []any{"a", "a"}, []any{"a", "b"}, false},
+		{
+			"reordered complex values",
+			[]any{map[string]any{"type": "work", "value": "b@example.com"}, map[string]any{"type": "home", "value": "b@home.com"}},
+			[]any{map[string]any{"type": "home", "value": "b@home.com"}, map[string]any{"type": "work", "value": "b@example.com", "primary": true}},
+			true,
+		},
+		{
+			"complex values matching several",
+			[]any{map[string]any{"value": "a"}, map[string]any{"value": "a", "type": "work"}},
+			[]any{map[string]any{"value": "a", "type": "work"}, map[string]any{"value": "a", "type": "home"}},
+			true,
+		},
+		{"type mismatch", true, "TRUE", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matches(tt.expected, tt.actual); got != tt.want {
+				t.Fatalf("matches(%v, %v) = %v, want %v", tt.expected, tt.actual, got, tt.want)
+			}
+		})
+	}
+}
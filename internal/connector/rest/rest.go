@@ -0,0 +1,542 @@
+// Package rest provisions Users and Groups to ad-hoc REST APIs and webhooks.
+// The requests of every operation are rendered from templates, so an
+// application is connected through configuration alone.
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/iamBelugaa/scim-gateway/internal/attribute"
+	"github.com/iamBelugaa/scim-gateway/internal/connector"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+)
+
+// Type is the connector type of REST connectors in connector definitions.
+const Type = "rest"
+
+// maxResponseSize bounds the size of the responses read from the API.
+const maxResponseSize = 1 << 20
+
+// Outcomes a response status can be mapped to.
+const (
+	OutcomeSuccess   = "success"
+	OutcomeRetryable = "retryable"
+	OutcomePermanent = "permanent"
+)
+
+// defaultStatuses map the statuses that are not mapped explicitly. Other 2xx
+// statuses succeed and other statuses fail permanently.
+var defaultStatuses = map[string]string{
+	"408": OutcomeRetryable,
+	"425": OutcomeRetryable,
+	"429": OutcomeRetryable,
+	"5xx": OutcomeRetryable,
+}
+
+// statusPattern matches the keys of status mappings, either a status such as
+// "404" or a class of statuses such as "5xx".
+var statusPattern = regexp.MustCompile(`^[1-5]([0-9]{2}|xx)$`)
+
+// Settings are the settings of a REST connector definition.
+type Settings struct {
+	Headers  map[string]string `yaml:"headers"`  // Header templates sent with every request, such as an Authorization header.
+	Statuses map[string]string `yaml:"statuses"` // Outcomes keyed by status or status class, such as "404: success" or "503: permanent".
+	Users    UserOperations    `yaml:"users"`    // Requests provisioning Users.
+	Groups   GroupOperations   `yaml:"groups"`   // Requests provisioning Groups.
+}
+
+// UserOperations are the requests provisioning Users. Users are disabled
+// through their update request when no disable request is set.
+type UserOperations struct {
+	Create  *RequestTemplate `yaml:"create"`
+	Update  *RequestTemplate `yaml:"update"`
+	Disable *RequestTemplate `yaml:"disable"`
+	Delete  *RequestTemplate `yaml:"delete"`
+}
+
+// GroupOperations are the requests provisioning Groups. Membership changes
+// are provisioned through the update request when no membership request is
+// set.
+type GroupOperations struct {
+	Create     *RequestTemplate `yaml:"create"`
+	Update     *RequestTemplate `yaml:"update"`
+	Membership *RequestTemplate `yaml:"membership"`
+	Delete     *RequestTemplate `yaml:"delete"`
+}
+
+// RequestTemplate is the request of a single operation. The URL, header and
+// body templates are Go templates rendered from the resource, see
+// templateData for the values available to them.
+type RequestTemplate struct {
+	Method   string            `yaml:"method"`   // HTTP method, POST for creates and PUT otherwise when empty.
+	URL      string            `yaml:"url"`      // URL template.
+	Headers  map[string]string `yaml:"headers"`  // Header templates, added to the headers of every request.
+	Body     string            `yaml:"body"`     // Body template, no body when empty.
+	RemoteID string            `yaml:"remoteId"` // JSON path of the remote id in the response, such as "$.data.id". Required for creates.
+}
+
+// operation is a parsed RequestTemplate.
+type operation struct {
+	method   string
+	url      *template.Template
+	headers  map[string]*template.Template
+	body     *template.Template // Nil when the request has no body.
+	remoteID jsonPath           // Nil when the response holds no remote id.
+}
+
+// Connector provisions resources to a REST API through request templates.
+// The downstream id of a resource is the one extracted from the response to
+// its create request.
+type Connector struct {
+	client     *http.Client
+	statuses   map[string]string
+	operations map[store.ResourceType]map[connector.Operation]*operation
+}
+
+// NewFactory returns the factory of REST connectors.
+func NewFactory() connector.Factory {
+	return func(name string, s connector.Settings) (connector.Connector, error) {
+		var settings Settings
+		if err := s.Decode(&settings); err != nil {
+			return nil, fmt.Errorf("failed to decode settings : %w", err)
+		}
+		return New(&settings)
+	}
+}
+
+// New constructs a REST connector from its settings.
+func New(settings *Settings) (*Connector, error) {
+	c := &Connector{
+		client:     &http.Client{},
+		statuses:   maps.Clone(defaultStatuses),
+		operations: make(map[store.ResourceType]map[connector.Operation]*operation),
+	}
+
+	for status, outcome := range settings.Statuses {
+		if !statusPattern.MatchString(status) {
+			return nil, fmt.Errorf("status %q must be a status such as '404' or a class such as '5xx'", status)
+		}
+		if outcome != OutcomeSuccess && outcome != OutcomeRetryable && outcome != OutcomePermanent {
+			return nil, fmt.Errorf("outcome %q of status %s must be one of success, retryable or permanent", outcome, status)
+		}
+		c.statuses[status] = outcome
+	}
+
+	templates := map[store.ResourceType]map[connector.Operation]*RequestTemplate{
+		store.ResourceTypeUser: {
+			connector.OperationCreate:  settings.Users.Create,
+			connector.OperationUpdate:  settings.Users.Update,
+			connector.OperationDisable: settings.Users.Disable,
+			connector.OperationDelete:  settings.Users.Delete,
+		},
+		store.ResourceTypeGroup: {
+			connector.OperationCreate:     settings.Groups.Create,
+			connector.OperationUpdate:     settings.Groups.Update,
+			connector.OperationMembership: settings.Groups.Membership,
+			connector.OperationDelete:     settings.Groups.Delete,
+		},
+	}
+	for resourceType, ops := range templates {
+		c.operations[resourceType] = make(map[connector.Operation]*operation)
+		for name, tmpl := range ops {
+			if tmpl == nil {
+				continue
+			}
+			op, err := parseOperation(name, tmpl, settings.Headers)
+			if err != nil {
+				return nil, fmt.Errorf("%s %s : %w", strings.ToLower(string(resourceType)), name, err)
+			}
+			c.operations[resourceType][name] = op
+		}
+	}
+
+	if len(c.operations[store.ResourceTypeUser]) == 0 && len(c.operations[store.ResourceTypeGroup]) == 0 {
+		return nil, errors.New("no operations are configured")
+	}
+	return c, nil
+}
+
+// parseOperation parses the request template of an operation, along with the
+// header templates common to every request.
+func parseOperation(name connector.Operation, tmpl *RequestTemplate, common map[string]string) (*operation, error) {
+	op := &operation{method: strings.ToUpper(tmpl.Method), headers: make(map[string]*template.Template)}
+	if op.method == "" {
+		op.method = http.MethodPut
+		if name == connector.OperationCreate {
+			op.method = http.MethodPost
+		}
+	}
+
+	if tmpl.URL == "" {
+		return nil, errors.New("url is not set")
+	}
+	var err error
+	if op.url, err = parseTemplate("url", tmpl.URL); err != nil {
+		return nil, err
+	}
+
+	headers := maps.Clone(common)
+	if headers == nil {
+		headers = make(map[string]string)
+	}
+	maps.Copy(headers, tmpl.Headers)
+	for header, value := range headers {
+		if op.headers[header], err = parseTemplate("header "+header, value); err != nil {
+			return nil, err
+		}
+	}
+
+	if tmpl.Body != "" {
+		if op.body, err = parseTemplate("body", tmpl.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	if tmpl.RemoteID == "" && name == connector.OperationCreate {
+		return nil, errors.New("remoteId is not set")
+	}
+	if tmpl.RemoteID != "" {
+		if op.remoteID, err = parseJSONPath(tmpl.RemoteID); err != nil {
+			return nil, err
+		}
+	}
+	return op, nil
+}
+
+// Create sends the create request of a resource and returns the remote id
+// extracted from the response.
+func (c *Connector) Create(ctx context.Context, req *connector.Request) (string, error) {
+	remoteID, err := c.do(ctx, connector.OperationCreate, req)
+	if err != nil {
+		return "", err
+	}
+	if remoteID == "" {
+		return "", errors.New("response to the create request holds no remote id")
+	}
+	return remoteID, nil
+}
+
+// Update sends the update request of a resource. When its response holds a
+// remote id, the resource is considered moved to it.
+func (c *Connector) Update(ctx context.Context, req *connector.Request) error {
+	return c.update(ctx, connector.OperationUpdate, req)
+}
+
+// Disable sends the disable request of a user, or its update request when
+// no disable request is configured.
+func (c *Connector) Disable(ctx context.Context, req *connector.Request) error {
+	if c.operations[req.Resource.Type][connector.OperationDisable] == nil {
+		return c.update(ctx, connector.OperationUpdate, req)
+	}
+	return c.update(ctx, connector.OperationDisable, req)
+}
+
+// Delete sends the delete request of a resource.
+func (c *Connector) Delete(ctx context.Context, req *connector.Request) error {
+	_, err := c.do(ctx, connector.OperationDelete, req)
+	return err
+}
+
+// UpdateMembership sends the membership request of a group, or its update
+// request when no membership request is configured.
+func (c *Connector) UpdateMembership(ctx context.Context, req *connector.Request) error {
+	if c.operations[req.Resource.Type][connector.OperationMembership] == nil {
+		return c.update(ctx, connector.OperationUpdate, req)
+	}
+	return c.update(ctx, connector.OperationMembership, req)
+}
+
+// update sends a request of an existing resource, moving it to the remote
+// id of the response when there is one.
+func (c *Connector) update(ctx context.Context, name connector.Operation, req *connector.Request) error {
+	remoteID, err := c.do(ctx, name, req)
+	if err != nil {
+		return err
+	}
+	if remoteID != "" {
+		req.RemoteID = remoteID
+	}
+	return nil
+}
+
+// do renders and sends the request of an operation, and returns the remote
+// id extracted from the response, if any. Failures are retryable when the
+// API could not be reached or the response status is mapped as retryable.
+func (c *Connector) do(ctx context.Context, name connector.Operation, req *connector.Request) (string, error) {
+	op := c.operations[req.Resource.Type][name]
+	if op == nil {
+		return "", fmt.Errorf("no %s request is configured for %s resources", name, req.Resource.Type)
+	}
+
+	data := newTemplateData(req)
+	target, err := render(op.url, data)
+	if err != nil {
+		return "", err
+	}
+	if u, err := url.Parse(target); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("rendered url %q is not an http:// or https:// url", target)
+	}
+
+	var body io.Reader
+	if op.body != nil {
+		rendered, err := render(op.body, data)
+		if err != nil {
+			return "", err
+		}
+		body = strings.NewReader(rendered)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, op.method, target, body)
+	if err != nil {
+		return "", fmt.Errorf("failed to build request : %w", err)
+	}
+	if op.body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	for header, tmpl := range op.headers {
+		value, err := render(tmpl, data)
+		if err != nil {
+			return "", err
+		}
+		httpReq.Header.Set(header, value)
+	}
+
+	res, err := c.client.Do(httpReq)
+	if err != nil {
+		return "", &connector.RetryableError{Err: fmt.Errorf("failed to send %s request : %w", name, err)}
+	}
+	defer res.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(res.Body, maxResponseSize))
+	if err != nil {
+		return "", &connector.RetryableError{Err: fmt.Errorf("failed to read response : %w", err)}
+	}
+
+	switch c.outcome(res.StatusCode) {
+	case OutcomeSuccess:
+	case OutcomeRetryable:
+		return "", &connector.RetryableError{Err: statusError(name, res.StatusCode, raw)}
+	default:
+		return "", statusError(name, res.StatusCode, raw)
+	}
+
+	if op.remoteID == nil || len(bytes.TrimSpace(raw)) == 0 {
+		return "", nil
+	}
+	// Numbers are kept as written, so large numeric ids are not rounded.
+	var decoded any
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&decoded); err != nil {
+		return "", fmt.Errorf("failed to decode response : %w", err)
+	}
+	remoteID, ok := op.remoteID.lookup(decoded)
+	if !ok {
+		return "", fmt.Errorf("response holds no remote id at %s", op.remoteID)
+	}
+	return remoteID, nil
+}
+
+// outcome returns the outcome a response status is mapped to.
+func (c *Connector) outcome(status int) string {
+	code := strconv.Itoa(status)
+	if outcome, ok := c.statuses[code]; ok {
+		return outcome
+	}
+	if outcome, ok := c.statuses[code[:1]+"xx"]; ok {
+		return outcome
+	}
+	if status >= 200 && status <= 299 {
+		return OutcomeSuccess
+	}
+	return OutcomePermanent
+}
+
+// statusError returns the failure of a request answered with an error
+// status, along with the start of the response body.
+func statusError(name connector.Operation, status int, body []byte) error {
+	detail := strings.TrimSpace(string(body[:min(len(body), 200)]))
+	if detail == "" {
+		return fmt.Errorf("%s request failed with status %d", name, status)
+	}
+	return fmt.Errorf("%s request failed with status %d : %s", name, status, detail)
+}
+
+// templateData is the data templates are rendered with.
+type templateData struct {
+	Tenant   string             // Tenant the resource belongs to.
+	ID       string             // Local id of the resource.
+	RemoteID string             // Remote id of the resource, empty before it is created.
+	Resource map[string]any     // Attributes of the resource.
+	Members  []connector.Member // Current members of a group that were provisioned.
+	Added    []connector.Member // Members added to a group that were provisioned.
+	Removed  []connector.Member // Members removed from a group that were provisioned.
+}
+
+// newTemplateData returns the data templates of a request are rendered with.
+func newTemplateData(req *connector.Request) *templateData {
+	return &templateData{
+		Tenant:   req.Tenant,
+		ID:       req.Resource.ID,
+		RemoteID: req.RemoteID,
+		Resource: req.Resource.Attributes,
+		Members:  provisioned(req.Members),
+		Added:    provisioned(req.Added),
+		Removed:  provisioned(req.Removed),
+	}
+}
+
+// Attr returns the value of the attribute at a SCIM path, such as
+// "name.familyName" or "emails.value", or an empty string when the resource
+// does not hold it.
+func (d *templateData) Attr(path string) (any, error) {
+	p, err := attribute.ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	if val, ok := p.Get(d.Resource); ok && val != nil {
+		return val, nil
+	}
+	return "", nil
+}
+
+// funcs are the functions available to templates besides the builtins.
+var funcs = template.FuncMap{
+	// json encodes a value as JSON, such as a string with its quotes.
+	"json": func(v any) (string, error) {
+		raw, err := json.Marshal(v)
+		return string(raw), err
+	},
+	// env returns the value of an environment variable, typically a secret
+	// sent in a header.
+	"env": os.Getenv,
+	// path escapes a value for use as a URL path segment.
+	"path": url.PathEscape,
+}
+
+// parseTemplate parses a template of a request.
+func parseTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(funcs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s template : %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// render renders a template of a request.
+func render(tmpl *template.Template, data *templateData) (string, error) {
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render %s template : %w", tmpl.Name(), err)
+	}
+	return buf.String(), nil
+}
+
+// provisioned returns the members that were provisioned.
+func provisioned(members []connector.Member) []connector.Member {
+	result := make([]connector.Member, 0, len(members))
+	for _, member := range members {
+		if member.RemoteID != "" {
+			result = append(result, member)
+		}
+	}
+	return result
+}
+
+// jsonPath is a path to a value in a JSON document, as a sequence of object
+// keys and array indexes.
+type jsonPath []any
+
+// jsonPathSegment matches a segment of a JSON path, a key optionally
+// followed by array indexes.
+var jsonPathSegment = regexp.MustCompile(`^([^\[\]]*)((?:\[[0-9]+\])*)$`)
+
+// parseJSONPath parses a JSON path of the form "$.data.items[0].id". The
+// leading "$." is optional.
+func parseJSONPath(text string) (jsonPath, error) {
+	trimmed := strings.TrimPrefix(strings.TrimPrefix(text, "$"), ".")
+	if trimmed == "" {
+		return nil, fmt.Errorf("json path %q is empty", text)
+	}
+
+	var path jsonPath
+	for _, segment := range strings.Split(trimmed, ".") {
+		match := jsonPathSegment.FindStringSubmatch(segment)
+		if match == nil || (match[1] == "" && match[2] == "") {
+			return nil, fmt.Errorf("invalid json path %q", text)
+		}
+		if match[1] != "" {
+			path = append(path, match[1])
+		}
+		for _, index := range strings.Split(strings.Trim(match[2], "[]"), "][") {
+			if index == "" {
+				continue
+			}
+			i, _ := strconv.Atoi(index)
+			path = append(path, i)
+		}
+	}
+	return path, nil
+}
+
+// lookup returns the string or number at the path of a decoded JSON
+// document.
+func (p jsonPath) lookup(doc any) (string, bool) {
+	current := doc
+	for _, step := range p {
+		switch key := step.(type) {
+		case string:
+			obj, ok := current.(map[string]any)
+			if !ok {
+				return "", false
+			}
+			current, ok = obj[key]
+			if !ok {
+				return "", false
+			}
+		case int:
+			arr, ok := current.([]any)
+			if !ok || key >= len(arr) {
+				return "", false
+			}
+			current = arr[key]
+		}
+	}
+
+	switch v := current.(type) {
+	case string:
+		return v, v != ""
+	case json.Number:
+		return v.String(), true
+	default:
+		return "", false
+	}
+}
+
+// String returns the path in the form it is configured in.
+func (p jsonPath) String() string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, step := range p {
+		switch key := step.(type) {
+		case string:
+			b.WriteString("." + key)
+		case int:
+			b.WriteString("[" + strconv.Itoa(key) + "]")
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,299 @@
+package rest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/iamBelugaa/scim-gateway/internal/connector"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+)
+
+// settings are the settings of the connectors under test, with "{{api}}"
+// standing for the URL of the API.
+const settings = `
+headers:
+  Authorization: 'Bearer {{env "REST_TEST_TOKEN"}}'
+statuses:
+  "404": success
+users:
+  create:
+    url: '{{api}}/users'
+    body: '{"login": {{json .Resource.userName}}, "family": {{json (.Attr "name.familyName")}}, "ref": {{json .ID}}}'
+    remoteId: $.data.users[0].id
+  update:
+    method: patch
+    url: '{{api}}/users/{{path .RemoteID}}'
+    body: '{"login": {{json .Resource.userName}}, "active": {{json .Resource.active}}}'
+  delete:
+    method: DELETE
+    url: '{{api}}/users/{{path .RemoteID}}'
+groups:
+  create:
+    url: '{{api}}/groups?tenant={{urlquery .Tenant}}'
+    headers:
+      X-Request-Id: '{{.ID}}'
+    body: '{"name": {{json .Resource.displayName}}}'
+    remoteId: id
+  membership:
+    method: POST
+    url: '{{api}}/groups/{{.RemoteID}}/members'
+    body: '{"add": [{{range $i, $m := .Added}}{{if $i}},{{end}}{{json $m.RemoteID}}{{end}}], "remove": [{{range $i, $m := .Removed}}{{if $i}},{{end}}{{json $m.RemoteID}}{{end}}]}'
+`
+
+// exchange is a request received by the API, and the response it sent.
+type exchange struct {
+	method  string
+	path    string
+	headers http.Header
+	body    string
+}
+
+// api is an API answering requests with a fixed status and body per path.
+type api struct {
+	mu        sync.Mutex
+	responses map[string]string // Response bodies keyed by method and path, such as "POST /users".
+	statuses  map[string]int    // Response statuses keyed by method and path, 200 when unset.
+	received  []exchange
+}
+
+func (a *api) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	a.received = append(a.received, exchange{method: r.Method, path: r.URL.RequestURI(), headers: r.Header, body: string(body)})
+
+	key := r.Method + " " + r.URL.Path
+	status, ok := a.statuses[key]
+	if !ok {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	_, _ = io.WriteString(w, a.responses[key])
+}
+
+// last returns the last request received.
+func (a *api) last() exchange {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.received[len(a.received)-1]
+}
+
+// newTestConnector returns a connector of an API answering with the given
+// responses.
+func newTestConnector(t *testing.T, a *api) *Connector {
+	t.Helper()
+	t.Setenv("REST_TEST_TOKEN", "secret")
+
+	srv := httptest.NewServer(a)
+	t.Cleanup(srv.Close)
+
+	var s Settings
+	if err := yaml.Unmarshal([]byte(strings.ReplaceAll(settings, "{{api}}", srv.URL)), &s); err != nil {
+		t.Fatalf("failed to decode settings : %v", err)
+	}
+	c, err := New(&s)
+	if err != nil {
+		t.Fatalf("failed to construct connector : %v", err)
+	}
+	return c
+}
+
+// user returns a user resource.
+func user(active bool) *store.Resource {
+	return &store.Resource{ID: "u1", Type: store.ResourceTypeUser, Attributes: map[string]any{
+		"userName": "alice",
+		"name":     map[string]any{"familyName": "Liddell"},
+		"active":   active,
+	}}
+}
+
+// TestUserRequests checks user requests are rendered from their templates
+// and the remote id is extracted from the create response.
+func TestUserRequests(t *testing.T) {
+	a := &api{responses: map[string]string{"POST /users": `{"data": {"users": [{"id": 12345678901234567890}]}}`}}
+	c := newTestConnector(t, a)
+	ctx := context.Background()
+
+	req := &connector.Request{Tenant: "default", Resource: user(true)}
+	remoteID, err := c.Create(ctx, req)
+	if err != nil {
+		t.Fatalf("failed to create user : %v", err)
+	}
+	if remoteID != "12345678901234567890" {
+		t.Fatalf("expected the numeric remote id as written, got %q", remoteID)
+	}
+
+	got := a.last()
+	if got.method != http.MethodPost || got.path != "/users" {
+		t.Fatalf("expected POST /users, got %s %s", got.method, got.path)
+	}
+	if want := `{"login": "alice", "family": "Liddell", "ref": "u1"}`; got.body != want {
+		t.Fatalf("expected body %s, got %s", want, got.body)
+	}
+	if got.headers.Get("Authorization") != "Bearer secret" || got.headers.Get("Content-Type") != "application/json" {
+		t.Fatalf("expected the rendered headers, got %v", got.headers)
+	}
+
+	req.RemoteID = "a/b"
+	req.Resource = user(false)
+	if err := c.Disable(ctx, req); err != nil {
+		t.Fatalf("failed to disable user : %v", err)
+	}
+	got = a.last()
+	if got.method != http.MethodPatch || got.path != "/users/a%2Fb" {
+		t.Fatalf("expected the update request with an escaped remote id, got %s %s", got.method, got.path)
+	}
+	if want := `{"login": "alice", "active": false}`; got.body != want {
+		t.Fatalf("expected body %s, got %s", want, got.body)
+	}
+	if req.RemoteID != "a/b" {
+		t.Fatalf("expected the remote id to be kept, got %q", req.RemoteID)
+	}
+
+	a.statuses = map[string]int{"DELETE /users/a/b": http.StatusNotFound}
+	if err := c.Delete(ctx, req); err != nil {
+		t.Fatalf("expected a 404 mapped as success, got %v", err)
+	}
+	if got := a.last(); got.method != http.MethodDelete || got.body != "" {
+		t.Fatalf("expected a delete without body, got %s %q", got.method, got.body)
+	}
+}
+
+// TestGroupRequests checks group requests render their members and
+// operation specific headers.
+func TestGroupRequests(t *testing.T) {
+	a := &api{responses: map[string]string{"POST /groups": `{"id": "grp-1"}`}}
+	c := newTestConnector(t, a)
+	ctx := context.Background()
+
+	group := &store.Resource{ID: "g1", Type: store.ResourceTypeGroup, Attributes: map[string]any{"displayName": "Tour Guides"}}
+	req := &connector.Request{Tenant: "acme corp", Resource: group}
+	remoteID, err := c.Create(ctx, req)
+	if err != nil {
+		t.Fatalf("failed to create group : %v", err)
+	}
+	got := a.last()
+	if remoteID != "grp-1" || got.path != "/groups?tenant=acme+corp" || got.headers.Get("X-Request-Id") != "g1" {
+		t.Fatalf("expected the group request to be rendered, got %q %s %v", remoteID, got.path, got.headers)
+	}
+
+	req.RemoteID = remoteID
+	req.Added = []connector.Member{{ID: "u1", RemoteID: "r1"}, {ID: "u2"}, {ID: "u3", RemoteID: "r3"}}
+	req.Removed = []connector.Member{{ID: "u4", RemoteID: "r4"}}
+	if err := c.UpdateMembership(ctx, req); err != nil {
+		t.Fatalf("failed to update members : %v", err)
+	}
+	got = a.last()
+	if want := `{"add": ["r1","r3"], "remove": ["r4"]}`; got.path != "/groups/grp-1/members" || got.body != want {
+		t.Fatalf("expected body %s, got %s %s", want, got.path, got.body)
+	}
+
+	if err := c.Delete(ctx, req); err == nil {
+		t.Fatal("expected deleting a group without a delete request to fail")
+	}
+}
+
+// TestStatusOutcomes checks failures are retryable or permanent according
+// to the status mapping.
+func TestStatusOutcomes(t *testing.T) {
+	tests := []struct {
+		status    int
+		retryable bool
+	}{
+		{http.StatusServiceUnavailable, true},
+		{http.StatusTooManyRequests, true},
+		{http.StatusConflict, false},
+		{http.StatusBadRequest, false},
+	}
+
+	a := &api{responses: map[string]string{"POST /users": `{"error": "nope"}`}}
+	c := newTestConnector(t, a)
+	for _, tt := range tests {
+		a.statuses = map[string]int{"POST /users": tt.status}
+		_, err := c.Create(context.Background(), &connector.Request{Tenant: "default", Resource: user(true)})
+		if err == nil || !strings.Contains(err.Error(), `{"error": "nope"}`) {
+			t.Fatalf("expected status %d to fail with the response body, got %v", tt.status, err)
+		}
+		if connector.IsRetryable(err) != tt.retryable {
+			t.Fatalf("expected status %d to be retryable %v, got %v", tt.status, tt.retryable, connector.IsRetryable(err))
+		}
+	}
+
+	c.client.Transport = roundTripFunc(func(*http.Request) (*http.Response, error) { return nil, io.ErrUnexpectedEOF })
+	_, err := c.Create(context.Background(), &connector.Request{Tenant: "default", Resource: user(true)})
+	if !connector.IsRetryable(err) {
+		t.Fatalf("expected unreachable APIs to be retryable, got %v", err)
+	}
+}
+
+// roundTripFunc adapts a function into an http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// TestMissingRemoteID checks creates fail when the response holds no
+// remote id.
+func TestMissingRemoteID(t *testing.T) {
+	a := &api{responses: map[string]string{"POST /users": `{"data": {"users": []}}`}}
+	c := newTestConnector(t, a)
+
+	_, err := c.Create(context.Background(), &connector.Request{Tenant: "default", Resource: user(true)})
+	if err == nil || !strings.Contains(err.Error(), "$.data.users[0].id") {
+		t.Fatalf("expected a missing remote id, got %v", err)
+	}
+}
+
+// TestParseJSONPath checks JSON paths are parsed into keys and indexes.
+func TestParseJSONPath(t *testing.T) {
+	tests := map[string]jsonPath{
+		"id":                 {"id"},
+		"$.id":               {"id"},
+		"$.data.items[0].id": {"data", "items", 0, "id"},
+		"$[1][2]":            {1, 2},
+	}
+	for text, want := range tests {
+		got, err := parseJSONPath(text)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Fatalf("expected %q to parse into %v, got %v, %v", text, want, got, err)
+		}
+	}
+
+	for _, text := range []string{"", "$", "a..b", "a[x]", "a]"} {
+		if _, err := parseJSONPath(text); err == nil {
+			t.Fatalf("expected %q to be rejected", text)
+		}
+	}
+}
+
+// TestNewValidatesSettings checks invalid settings are rejected.
+func TestNewValidatesSettings(t *testing.T) {
+	create := &RequestTemplate{URL: "http://localhost/users", RemoteID: "id"}
+
+	tests := map[string]Settings{
+		"no operations":     {},
+		"no url":            {Users: UserOperations{Create: &RequestTemplate{RemoteID: "id"}}},
+		"no create id":      {Users: UserOperations{Create: &RequestTemplate{URL: "http://localhost/users"}}},
+		"invalid template":  {Users: UserOperations{Create: create, Update: &RequestTemplate{URL: "{{.RemoteID"}}},
+		"invalid status":    {Users: UserOperations{Create: create}, Statuses: map[string]string{"6xx": OutcomeSuccess}},
+		"invalid outcome":   {Users: UserOperations{Create: create}, Statuses: map[string]string{"404": "ignore"}},
+		"invalid json path": {Groups: GroupOperations{Create: &RequestTemplate{URL: "http://localhost/groups", RemoteID: "a..b"}}},
+	}
+	for name, settings := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := New(&settings); err == nil {
+				t.Fatal("expected settings to be rejected")
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package scim
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/iamBelugaa/scim-gateway/internal/connector"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+)
+
+// listPageSize is the number of resources requested per page when listing
+// the resources of the service provider.
+const listPageSize = 100
+
+// List returns every resource of a type held by the service provider, paging
+// through the results. Group members are listed as their sorted ids, so they
+// compare regardless of their order.
+func (c *Connector) List(ctx context.Context, resourceType store.ResourceType) ([]connector.Account, error) {
+	accounts := make([]connector.Account, 0)
+	for start := 1; ; {
+		path := fmt.Sprintf("%s?startIndex=%d&count=%d", endpoint(resourceType), start, listPageSize)
+		body, _, err := c.send(ctx, http.MethodGet, path, nil, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %ss : %w", resourceType, err)
+		}
+
+		resources, _ := body["Resources"].([]any)
+		for _, r := range resources {
+			attrs, _ := r.(map[string]any)
+			remoteID, _ := attrs["id"].(string)
+			if remoteID == "" {
+				continue
+			}
+			externalID, _ := attrs["externalId"].(string)
+			accounts = append(accounts, connector.Account{RemoteID: remoteID, ExternalID: externalID, Attributes: normalize(attrs)})
+		}
+
+		total, _ := body["totalResults"].(float64)
+		start += len(resources)
+		if len(resources) == 0 || start > int(total) {
+			return accounts, nil
+		}
+	}
+}
+
+// Expected returns the resource the service provider should hold: the
+// representation the connector sends, found by its externalId. The password
+// is left out, since service providers never return it.
+func (c *Connector) Expected(req *connector.Request) (connector.Account, error) {
+	body := payload(req)
+	delete(body, "password")
+	return connector.Account{ExternalID: externalID(req.Resource), Attributes: normalize(body)}, nil
+}
+
+// normalize returns the attributes of a resource that are compared with the
+// gateway, with group members as their sorted ids. The groups of users are
+// left out, since service providers derive them from group members.
+func normalize(attrs map[string]any) map[string]any {
+	attrs = maps.Clone(attrs)
+	delete(attrs, "id")
+	delete(attrs, "meta")
+	delete(attrs, "schemas")
+	delete(attrs, "groups")
+
+	if list, ok := attrs["members"].([]any); ok {
+		ids := make([]string, 0, len(list))
+		for _, member := range list {
+			if m, ok := member.(map[string]any); ok {
+				if id, _ := m["value"].(string); id != "" {
+					ids = append(ids, id)
+				}
+			}
+		}
+		slices.Sort(ids)
+
+		values := make([]any, len(ids))
+		for i, id := range ids {
+			values[i] = id
+		}
+		attrs["members"] = values
+	}
+	return attrs
+}
@@ -0,0 +1,471 @@
+// Package scim provisions Users and Groups to other SCIM 2.0 service
+// providers, so a single identity provider connection can feed many
+// applications.
+package scim
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/iamBelugaa/scim-gateway/internal/connector"
+	"github.com/iamBelugaa/scim-gateway/internal/schema"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+)
+
+// Type is the connector type of SCIM connectors in connector definitions.
+const Type = "scim"
+
+// Schemas of the SCIM messages sent by the connector.
+const (
+	patchOpSchema = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	contentType   = "application/scim+json"
+)
+
+// maxResponseSize bounds the size of the responses read from the service
+// provider.
+const maxResponseSize = 1 << 20
+
+// Settings are the settings of a SCIM connector definition.
+type Settings struct {
+	URL      string `yaml:"url"`      // Base URL of the service provider, such as "https://example.com/scim/v2".
+	Token    string `yaml:"token"`    // Bearer token the connector authenticates with.
+	TokenEnv string `yaml:"tokenEnv"` // Environment variable holding the bearer token, instead of Token.
+	CAFile   string `yaml:"caFile"`   // PEM file of the CAs the service provider certificate must be signed by, the system pool when empty.
+}
+
+// StatusError is an error response of the service provider.
+type StatusError struct {
+	Status int    // HTTP status of the response.
+	Detail string // Detail of the SCIM error, or the start of the response body.
+}
+
+func (e *StatusError) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("service provider responded with status %d", e.Status)
+	}
+	return fmt.Sprintf("service provider responded with status %d : %s", e.Status, e.Detail)
+}
+
+// hasStatus reports whether err is an error response with the given status.
+func hasStatus(err error, status int) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.Status == status
+}
+
+// providerConfig are the features of the service provider the connector
+// relies on, as advertised by its ServiceProviderConfig.
+type providerConfig struct {
+	Patch  supported `json:"patch"`
+	Filter supported `json:"filter"`
+	ETag   supported `json:"etag"`
+}
+
+// supported is a feature of a ServiceProviderConfig.
+type supported struct {
+	Supported bool `json:"supported"`
+}
+
+// Connector provisions resources to a SCIM service provider. The downstream
+// id of a resource is its id at the service provider, which the dispatcher
+// tracks against the local id. Updates are sent as PATCH requests when the
+// service provider supports them and as PUT requests otherwise, and carry the
+// last ETag seen for the resource when the service provider supports ETags.
+type Connector struct {
+	url    string
+	token  string
+	client *http.Client
+
+	mu     sync.Mutex
+	config *providerConfig   // Discovered on first use, nil until then.
+	etags  map[string]string // Last ETag seen per remote id.
+}
+
+// NewFactory returns the factory of SCIM connectors.
+func NewFactory() connector.Factory {
+	return func(name string, s connector.Settings) (connector.Connector, error) {
+		var settings Settings
+		if err := s.Decode(&settings); err != nil {
+			return nil, fmt.Errorf("failed to decode settings : %w", err)
+		}
+		return New(&settings)
+	}
+}
+
+// New constructs a SCIM connector from its settings. The service provider is
+// not contacted until the first operation.
+func New(settings *Settings) (*Connector, error) {
+	u, err := url.Parse(settings.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("url %q must be an http:// or https:// url", settings.URL)
+	}
+
+	c := &Connector{
+		url:    strings.TrimSuffix(settings.URL, "/"),
+		token:  settings.Token,
+		client: &http.Client{},
+		etags:  make(map[string]string),
+	}
+	if settings.TokenEnv != "" {
+		c.token = os.Getenv(settings.TokenEnv)
+	}
+
+	if settings.CAFile != "" {
+		pem, err := os.ReadFile(settings.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca file : %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca file %q holds no certificates", settings.CAFile)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool}
+		c.client.Transport = transport
+	}
+	return c, nil
+}
+
+// Create posts a resource and returns its id at the service provider. When
+// the service provider already holds the resource, because an earlier create
+// succeeded without its response being received, the resource is looked up
+// by its externalId, taken over and updated instead.
+func (c *Connector) Create(ctx context.Context, req *connector.Request) (string, error) {
+	cfg, err := c.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	body, etag, err := c.send(ctx, http.MethodPost, endpoint(req.Resource.Type), payload(req), "")
+	if hasStatus(err, http.StatusConflict) && cfg.Filter.Supported {
+		return c.adopt(ctx, req, err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s : %w", req.Resource.Type, err)
+	}
+
+	remoteID, _ := body["id"].(string)
+	if remoteID == "" {
+		return "", fmt.Errorf("service provider returned no id for the created %s", req.Resource.Type)
+	}
+	c.remember(remoteID, etag)
+	return remoteID, nil
+}
+
+// adopt takes over the resource with the externalId of a resource whose
+// create conflicted, and updates it. The conflict is returned when there is
+// no such resource, since it was caused by another resource.
+func (c *Connector) adopt(ctx context.Context, req *connector.Request, conflict error) (string, error) {
+	filter := fmt.Sprintf("externalId eq %q", externalID(req.Resource))
+	body, _, err := c.send(ctx, http.MethodGet, endpoint(req.Resource.Type)+"?filter="+url.QueryEscape(filter), nil, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to look up conflicting %s : %w", req.Resource.Type, err)
+	}
+
+	resources, _ := body["Resources"].([]any)
+	if len(resources) != 1 {
+		return "", fmt.Errorf("failed to create %s : %w", req.Resource.Type, conflict)
+	}
+	existing, _ := resources[0].(map[string]any)
+	remoteID, _ := existing["id"].(string)
+	if remoteID == "" {
+		return "", fmt.Errorf("failed to create %s : %w", req.Resource.Type, conflict)
+	}
+
+	req.RemoteID = remoteID
+	if err := c.Update(ctx, req); err != nil {
+		return "", err
+	}
+	return remoteID, nil
+}
+
+// Update replaces the attributes of a resource. Group members are left to
+// UpdateMembership when the service provider supports PATCH, and replaced
+// along with the other attributes otherwise.
+func (c *Connector) Update(ctx context.Context, req *connector.Request) error {
+	cfg, err := c.discover(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !cfg.Patch.Supported {
+		return c.put(ctx, req)
+	}
+
+	value := payload(req)
+	delete(value, "schemas")
+	delete(value, "members")
+	return c.patch(ctx, req, []map[string]any{{"op": "replace", "value": value}})
+}
+
+// Disable sets a user inactive.
+func (c *Connector) Disable(ctx context.Context, req *connector.Request) error {
+	cfg, err := c.discover(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !cfg.Patch.Supported {
+		return c.put(ctx, req)
+	}
+	return c.patch(ctx, req, []map[string]any{{"op": "replace", "path": "active", "value": false}})
+}
+
+// Delete removes a resource. Resources the service provider no longer holds
+// are considered deleted.
+func (c *Connector) Delete(ctx context.Context, req *connector.Request) error {
+	if _, err := c.discover(ctx); err != nil {
+		return err
+	}
+
+	_, err := c.write(ctx, http.MethodDelete, req, nil)
+	if err != nil && !hasStatus(err, http.StatusNotFound) {
+		return fmt.Errorf("failed to delete %s %q : %w", req.Resource.Type, req.RemoteID, err)
+	}
+
+	c.mu.Lock()
+	delete(c.etags, req.RemoteID)
+	c.mu.Unlock()
+	return nil
+}
+
+// UpdateMembership adds and removes the members of a group, or replaces the
+// whole group when the service provider does not support PATCH. Members that
+// were never provisioned to the service provider are left out.
+func (c *Connector) UpdateMembership(ctx context.Context, req *connector.Request) error {
+	cfg, err := c.discover(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !cfg.Patch.Supported {
+		return c.put(ctx, req)
+	}
+
+	var ops []map[string]any
+	if added := members(req.Added); len(added) > 0 {
+		ops = append(ops, map[string]any{"op": "add", "path": "members", "value": added})
+	}
+	for _, member := range req.Removed {
+		if member.RemoteID != "" {
+			ops = append(ops, map[string]any{"op": "remove", "path": fmt.Sprintf("members[value eq %q]", member.RemoteID)})
+		}
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	return c.patch(ctx, req, ops)
+}
+
+// put replaces a resource as a whole.
+func (c *Connector) put(ctx context.Context, req *connector.Request) error {
+	if _, err := c.write(ctx, http.MethodPut, req, payload(req)); err != nil {
+		return fmt.Errorf("failed to replace %s %q : %w", req.Resource.Type, req.RemoteID, err)
+	}
+	return nil
+}
+
+// patch applies PATCH operations to a resource.
+func (c *Connector) patch(ctx context.Context, req *connector.Request, ops []map[string]any) error {
+	body := map[string]any{"schemas": []string{patchOpSchema}, "Operations": ops}
+	if _, err := c.write(ctx, http.MethodPatch, req, body); err != nil {
+		return fmt.Errorf("failed to patch %s %q : %w", req.Resource.Type, req.RemoteID, err)
+	}
+	return nil
+}
+
+// write sends a request modifying an existing resource. When the service
+// provider supports ETags, the request is conditional on the last ETag seen
+// for the resource. If the resource changed at the service provider since,
+// its current ETag is fetched and the request is sent once more, since the
+// gateway is the source of truth for the resources it provisions.
+func (c *Connector) write(ctx context.Context, method string, req *connector.Request, body any) (map[string]any, error) {
+	cfg, err := c.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	path := endpoint(req.Resource.Type) + "/" + url.PathEscape(req.RemoteID)
+	ifMatch := ""
+	if cfg.ETag.Supported {
+		c.mu.Lock()
+		ifMatch = c.etags[req.RemoteID]
+		c.mu.Unlock()
+	}
+
+	res, etag, err := c.send(ctx, method, path, body, ifMatch)
+	if hasStatus(err, http.StatusPreconditionFailed) {
+		if _, ifMatch, err = c.send(ctx, http.MethodGet, path, nil, ""); err != nil {
+			return nil, fmt.Errorf("failed to refresh etag : %w", err)
+		}
+		res, etag, err = c.send(ctx, method, path, body, ifMatch)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.remember(req.RemoteID, etag)
+	return res, nil
+}
+
+// remember records the last ETag seen for a resource.
+func (c *Connector) remember(remoteID, etag string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if etag == "" {
+		delete(c.etags, remoteID)
+		return
+	}
+	c.etags[remoteID] = etag
+}
+
+// discover fetches the ServiceProviderConfig of the service provider on
+// first use. A failed discovery is retried by the next operation.
+func (c *Connector) discover(ctx context.Context) (*providerConfig, error) {
+	c.mu.Lock()
+	cfg := c.config
+	c.mu.Unlock()
+	if cfg != nil {
+		return cfg, nil
+	}
+
+	body, _, err := c.send(ctx, http.MethodGet, "/ServiceProviderConfig", nil, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover service provider config : %w", err)
+	}
+
+	// Round trip the response to decode the features the connector uses.
+	raw, _ := json.Marshal(body)
+	cfg = &providerConfig{}
+	if err := json.Unmarshal(raw, cfg); err != nil {
+		return nil, fmt.Errorf("failed to decode service provider config : %w", err)
+	}
+
+	c.mu.Lock()
+	c.config = cfg
+	c.mu.Unlock()
+	return cfg, nil
+}
+
+// send sends a request to the service provider and returns the decoded
+// response body along with the ETag of the returned resource. Error
+// responses are returned as a StatusError.
+func (c *Connector) send(ctx context.Context, method, path string, body any, ifMatch string) (map[string]any, string, error) {
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to encode request : %w", err)
+		}
+		reader = bytes.NewReader(raw)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, c.url+path, reader)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to build request : %w", err)
+	}
+	httpReq.Header.Set("Accept", contentType)
+	if body != nil {
+		httpReq.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if ifMatch != "" {
+		httpReq.Header.Set("If-Match", ifMatch)
+	}
+
+	res, err := c.client.Do(httpReq)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to reach service provider : %w", err)
+	}
+	defer res.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(res.Body, maxResponseSize))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read response : %w", err)
+	}
+
+	var decoded map[string]any
+	if len(bytes.TrimSpace(raw)) > 0 {
+		if err := json.Unmarshal(raw, &decoded); err != nil && res.StatusCode < 300 {
+			return nil, "", fmt.Errorf("failed to decode response : %w", err)
+		}
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		detail, _ := decoded["detail"].(string)
+		if detail == "" && decoded == nil {
+			detail = strings.TrimSpace(string(raw[:min(len(raw), 200)]))
+		}
+		return nil, "", &StatusError{Status: res.StatusCode, Detail: detail}
+	}
+
+	etag := res.Header.Get("ETag")
+	if meta, ok := decoded["meta"].(map[string]any); ok && etag == "" {
+		etag, _ = meta["version"].(string)
+	}
+	return decoded, etag, nil
+}
+
+// endpoint returns the path of the endpoint of a resource type.
+func endpoint(resourceType store.ResourceType) string {
+	return "/" + string(resourceType) + "s"
+}
+
+// externalID returns the externalId a resource is provisioned with, which is
+// its own externalId when it has one and its local id otherwise.
+func externalID(res *store.Resource) string {
+	if id, _ := res.Attributes["externalId"].(string); id != "" {
+		return id
+	}
+	return res.ID
+}
+
+// payload returns the representation of a resource sent to the service
+// provider. Attributes assigned by the gateway are left out, and group
+// members refer to the members' ids at the service provider.
+func payload(req *connector.Request) map[string]any {
+	res := req.Resource
+	body := maps.Clone(res.Attributes)
+	delete(body, "id")
+	delete(body, "meta")
+	delete(body, "groups")
+	body["externalId"] = externalID(res)
+
+	if _, ok := body["schemas"]; !ok {
+		uri := schema.URIUser
+		if res.Type == store.ResourceTypeGroup {
+			uri = schema.URIGroup
+		}
+		body["schemas"] = []string{uri}
+	}
+	if res.Type == store.ResourceTypeGroup {
+		body["members"] = members(req.Members)
+	}
+	return body
+}
+
+// members returns the member values of the members provisioned to the
+// service provider.
+func members(members []connector.Member) []any {
+	values := make([]any, 0, len(members))
+	for _, member := range members {
+		if member.RemoteID != "" {
+			values = append(values, map[string]any{"value": member.RemoteID, "type": string(member.Type)})
+		}
+	}
+	return values
+}
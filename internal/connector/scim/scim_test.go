@@ -0,0 +1,427 @@
+package scim_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/iamBelugaa/scim-gateway/internal/config"
+	"github.com/iamBelugaa/scim-gateway/internal/connector"
+	"github.com/iamBelugaa/scim-gateway/internal/connector/scim"
+	"github.com/iamBelugaa/scim-gateway/internal/server"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+	"github.com/iamBelugaa/scim-gateway/pkg/logger"
+)
+
+const token = "downstream-token"
+
+// gateway is a SCIM gateway serving as the service provider the connector
+// provisions to. Requests are recorded before they are served, and PATCH can
+// be withheld to stand for a service provider without it.
+type gateway struct {
+	noPatch    bool // Whether PATCH is withheld from the ServiceProviderConfig and rejected.
+	maxResults int  // Maximum number of resources returned per page when listing, the default when zero.
+
+	url     string // Base URL of the SCIM API.
+	handler http.Handler
+
+	mu       sync.Mutex
+	requests []string // Method and path of every request received.
+	ifMatch  []string // If-Match header of every request received.
+}
+
+// serve starts a gateway and returns a connector provisioning to it.
+func serve(t *testing.T, gw *gateway) *scim.Connector {
+	t.Helper()
+
+	hash := sha256.Sum256([]byte(token))
+	t.Setenv("AUTH_STATIC_TOKENS", "downstream:"+hex.EncodeToString(hash[:]))
+	cfg := config.Load()
+	if gw.maxResults > 0 {
+		cfg.SCIM.MaxResults = gw.maxResults
+	}
+
+	srv, err := server.NewWithConfig(&logger.Logger{SugaredLogger: zap.NewNop().Sugar()}, cfg)
+	if err != nil {
+		t.Fatalf("failed to construct gateway : %v", err)
+	}
+	gw.handler = srv.Handler()
+
+	httpSrv := httptest.NewServer(gw)
+	t.Cleanup(httpSrv.Close)
+	gw.url = httpSrv.URL + "/scim/v2"
+
+	c, err := scim.New(&scim.Settings{URL: gw.url, Token: token})
+	if err != nil {
+		t.Fatalf("failed to construct connector : %v", err)
+	}
+	return c
+}
+
+func (gw *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	gw.mu.Lock()
+	gw.requests = append(gw.requests, r.Method+" "+r.URL.Path)
+	gw.ifMatch = append(gw.ifMatch, r.Header.Get("If-Match"))
+	gw.mu.Unlock()
+
+	switch {
+	case gw.noPatch && r.Method == http.MethodPatch:
+		http.Error(w, "patch is not supported", http.StatusNotImplemented)
+	case gw.noPatch && strings.HasSuffix(r.URL.Path, "/ServiceProviderConfig"):
+		rec := httptest.NewRecorder()
+		gw.handler.ServeHTTP(rec, r)
+
+		var body map[string]any
+		_ = json.Unmarshal(rec.Body.Bytes(), &body)
+		body["patch"] = map[string]any{"supported": false}
+		w.Header().Set("Content-Type", rec.Header().Get("Content-Type"))
+		w.WriteHeader(rec.Code)
+		_ = json.NewEncoder(w).Encode(body)
+	default:
+		gw.handler.ServeHTTP(w, r)
+	}
+}
+
+// send sends a request to the gateway as another client would, bypassing
+// the recording, and returns the decoded response body and its ETag.
+func (gw *gateway) send(t *testing.T, method, path string, body any) (map[string]any, string) {
+	t.Helper()
+
+	var raw []byte
+	if body != nil {
+		raw, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(raw))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/scim+json")
+
+	rec := httptest.NewRecorder()
+	gw.handler.ServeHTTP(rec, req)
+	if rec.Code >= 300 {
+		t.Fatalf("failed to %s %s : status %d : %s", method, path, rec.Code, rec.Body.String())
+	}
+
+	var res map[string]any
+	_ = json.Unmarshal(rec.Body.Bytes(), &res)
+	return res, rec.Header().Get("ETag")
+}
+
+// resource returns a resource held by the gateway.
+func (gw *gateway) resource(t *testing.T, path string) map[string]any {
+	t.Helper()
+
+	res, _ := gw.send(t, http.MethodGet, path, nil)
+	return res
+}
+
+// etag returns the current ETag of a resource held by the gateway.
+func (gw *gateway) etag(t *testing.T, path string) string {
+	t.Helper()
+
+	_, etag := gw.send(t, http.MethodGet, path, nil)
+	return etag
+}
+
+// received returns the requests received since the last call.
+func (gw *gateway) received() []string {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+
+	requests := gw.requests
+	gw.requests = nil
+	return requests
+}
+
+// lastIfMatch returns the If-Match header of the last request received.
+func (gw *gateway) lastIfMatch() string {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+
+	return gw.ifMatch[len(gw.ifMatch)-1]
+}
+
+// user returns a user resource, with a userName unique to its id.
+func user(id string, active bool) *store.Resource {
+	return &store.Resource{ID: id, Type: store.ResourceTypeUser, Attributes: map[string]any{
+		"id":       id,
+		"userName": id + "@example.com",
+		"active":   active,
+		"groups":   []any{map[string]any{"value": "g1"}},
+		"meta":     map[string]any{"resourceType": "User"},
+	}}
+}
+
+// group returns a group resource.
+func group(id string) *store.Resource {
+	return &store.Resource{ID: id, Type: store.ResourceTypeGroup, Attributes: map[string]any{"displayName": "Tour Guides"}}
+}
+
+// memberValues returns the sorted values of the members of a resource.
+func memberValues(res map[string]any) []string {
+	values := make([]string, 0)
+	entries, _ := res["members"].([]any)
+	for _, entry := range entries {
+		values = append(values, entry.(map[string]any)["value"].(string))
+	}
+	slices.Sort(values)
+	return values
+}
+
+// create provisions a resource and returns its id at the gateway.
+func create(t *testing.T, c *scim.Connector, req *connector.Request) string {
+	t.Helper()
+
+	remoteID, err := c.Create(context.Background(), req)
+	if err != nil {
+		t.Fatalf("failed to create %s %s : %v", req.Resource.Type, req.Resource.ID, err)
+	}
+	return remoteID
+}
+
+// TestProvisionWithPatch checks resources are patched when the service
+// provider supports PATCH, and members are added and removed incrementally.
+func TestProvisionWithPatch(t *testing.T) {
+	gw := &gateway{}
+	c := serve(t, gw)
+	ctx := context.Background()
+
+	req := &connector.Request{Tenant: "default", Resource: user("u1", true)}
+	remoteID := create(t, c, req)
+	res := gw.resource(t, "/scim/v2/Users/"+remoteID)
+	if res["externalId"] != "u1" || res["userName"] != "u1@example.com" {
+		t.Fatalf("expected the user with its local id as externalId, got %v", res)
+	}
+	if groups, _ := res["groups"].([]any); len(groups) != 0 {
+		t.Fatalf("expected the read-only groups attribute to be left out, got %v", groups)
+	}
+
+	req.RemoteID = remoteID
+	if err := c.Disable(ctx, req); err != nil {
+		t.Fatalf("failed to disable user : %v", err)
+	}
+	if res := gw.resource(t, "/scim/v2/Users/"+remoteID); res["active"] != false {
+		t.Fatalf("expected the user to be inactive, got %v", res["active"])
+	}
+
+	bobID := create(t, c, &connector.Request{Tenant: "default", Resource: user("u2", true)})
+	alice := connector.Member{ID: "u1", Type: store.ResourceTypeUser, RemoteID: remoteID}
+	bob := connector.Member{ID: "u2", Type: store.ResourceTypeUser, RemoteID: bobID}
+	pending := connector.Member{ID: "u3", Type: store.ResourceTypeUser}
+
+	greq := &connector.Request{Tenant: "default", Resource: group("g1"), Members: []connector.Member{alice, pending}}
+	greq.RemoteID = create(t, c, greq)
+	if got := memberValues(gw.resource(t, "/scim/v2/Groups/"+greq.RemoteID)); !reflect.DeepEqual(got, []string{remoteID}) {
+		t.Fatalf("expected the provisioned member only, got %v", got)
+	}
+
+	greq.Members, greq.Added, greq.Removed = []connector.Member{bob}, []connector.Member{bob}, []connector.Member{alice}
+	if err := c.UpdateMembership(ctx, greq); err != nil {
+		t.Fatalf("failed to update members : %v", err)
+	}
+	if got := memberValues(gw.resource(t, "/scim/v2/Groups/"+greq.RemoteID)); !reflect.DeepEqual(got, []string{bobID}) {
+		t.Fatalf("expected members to be swapped, got %v", got)
+	}
+
+	greq.Resource.Attributes["displayName"] = "Guides"
+	if err := c.Update(ctx, greq); err != nil {
+		t.Fatalf("failed to update group : %v", err)
+	}
+	res = gw.resource(t, "/scim/v2/Groups/"+greq.RemoteID)
+	if res["displayName"] != "Guides" || !reflect.DeepEqual(memberValues(res), []string{bobID}) {
+		t.Fatalf("expected the group to be renamed with its members kept, got %v", res)
+	}
+
+	want := []string{
+		"GET /scim/v2/ServiceProviderConfig",
+		"POST /scim/v2/Users",
+		"PATCH /scim/v2/Users/" + remoteID,
+		"POST /scim/v2/Users",
+		"POST /scim/v2/Groups",
+		"PATCH /scim/v2/Groups/" + greq.RemoteID,
+		"PATCH /scim/v2/Groups/" + greq.RemoteID,
+	}
+	if got := gw.received(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected requests %v, got %v", want, got)
+	}
+}
+
+// TestProvisionWithPut checks resources are replaced when the service
+// provider does not support PATCH.
+func TestProvisionWithPut(t *testing.T) {
+	gw := &gateway{noPatch: true}
+	c := serve(t, gw)
+
+	aliceID := create(t, c, &connector.Request{Tenant: "default", Resource: user("u1", true)})
+	greq := &connector.Request{Tenant: "default", Resource: group("g1")}
+	remoteID := create(t, c, greq)
+
+	alice := connector.Member{ID: "u1", Type: store.ResourceTypeUser, RemoteID: aliceID}
+	greq.RemoteID, greq.Members, greq.Added = remoteID, []connector.Member{alice}, []connector.Member{alice}
+	if err := c.UpdateMembership(context.Background(), greq); err != nil {
+		t.Fatalf("failed to update members : %v", err)
+	}
+	if got := memberValues(gw.resource(t, "/scim/v2/Groups/"+remoteID)); !reflect.DeepEqual(got, []string{aliceID}) {
+		t.Fatalf("expected the members to be replaced, got %v", got)
+	}
+
+	want := []string{
+		"GET /scim/v2/ServiceProviderConfig",
+		"POST /scim/v2/Users",
+		"POST /scim/v2/Groups",
+		"PUT /scim/v2/Groups/" + remoteID,
+	}
+	if got := gw.received(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected requests %v, got %v", want, got)
+	}
+}
+
+// TestETags checks writes are conditional on the last ETag seen, and are
+// sent once more with the current ETag when the resource changed at the
+// service provider.
+func TestETags(t *testing.T) {
+	gw := &gateway{}
+	c := serve(t, gw)
+	ctx := context.Background()
+
+	req := &connector.Request{Tenant: "default", Resource: user("u1", true)}
+	req.RemoteID = create(t, c, req)
+	path := "/scim/v2/Users/" + req.RemoteID
+
+	created := gw.etag(t, path)
+	if err := c.Update(ctx, req); err != nil {
+		t.Fatalf("failed to update user : %v", err)
+	}
+	if got := gw.lastIfMatch(); got != created {
+		t.Fatalf("expected the update to match the created version %q, got %q", created, got)
+	}
+
+	// Another client changes the user at the service provider.
+	_, changed := gw.send(t, http.MethodPatch, path, map[string]any{
+		"schemas":    []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		"Operations": []any{map[string]any{"op": "replace", "path": "displayName", "value": "Alice"}},
+	})
+	gw.received()
+
+	if err := c.Disable(ctx, req); err != nil {
+		t.Fatalf("failed to disable user : %v", err)
+	}
+	want := []string{"PATCH " + path, "GET " + path, "PATCH " + path}
+	if got := gw.received(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected requests %v, got %v", want, got)
+	}
+	if got := gw.lastIfMatch(); got != changed {
+		t.Fatalf("expected the retry to match the current version %q, got %q", changed, got)
+	}
+
+	disabled := gw.etag(t, path)
+	if err := c.Delete(ctx, req); err != nil {
+		t.Fatalf("failed to delete user : %v", err)
+	}
+	if got := gw.lastIfMatch(); got != disabled {
+		t.Fatalf("expected the delete to match the disabled version %q, got %q", disabled, got)
+	}
+	if err := c.Delete(ctx, req); err != nil {
+		t.Fatalf("expected deleting a missing user to succeed, got %v", err)
+	}
+}
+
+// TestCreateAdoptsExistingResource checks a resource the service provider
+// already holds with the same externalId is taken over and updated.
+func TestCreateAdoptsExistingResource(t *testing.T) {
+	gw := &gateway{}
+	c := serve(t, gw)
+
+	existing := create(t, c, &connector.Request{Tenant: "default", Resource: user("u1", true)})
+	remoteID := create(t, c, &connector.Request{Tenant: "default", Resource: user("u1", false)})
+	if remoteID != existing {
+		t.Fatalf("expected the existing user %q to be adopted, got %q", existing, remoteID)
+	}
+	if res := gw.resource(t, "/scim/v2/Users/"+remoteID); res["active"] != false {
+		t.Fatalf("expected the adopted user to be updated, got %v", res["active"])
+	}
+}
+
+// TestErrorResponses checks error responses carry the SCIM error detail.
+func TestErrorResponses(t *testing.T) {
+	gw := &gateway{}
+	serve(t, gw)
+
+	c, err := scim.New(&scim.Settings{URL: gw.url, Token: "wrong"})
+	if err != nil {
+		t.Fatalf("failed to construct connector : %v", err)
+	}
+
+	_, err = c.Create(context.Background(), &connector.Request{Tenant: "default", Resource: user("u1", true)})
+	var statusErr *scim.StatusError
+	if !errors.As(err, &statusErr) || statusErr.Status != http.StatusUnauthorized || statusErr.Detail == "" {
+		t.Fatalf("expected an unauthorized error with its detail, got %v", err)
+	}
+}
+
+// TestList checks every resource of a type is listed across pages, with
+// attributes comparable to the resources the connector provisions.
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	gw := &gateway{maxResults: 2}
+	c := serve(t, gw)
+
+	users := make(map[string]*connector.Request)
+	members := make([]connector.Member, 0)
+	for _, id := range []string{"u1", "u2", "u3"} {
+		req := &connector.Request{Tenant: "default", Resource: user(id, true)}
+		remoteID := create(t, c, req)
+		users[remoteID] = req
+		if id != "u3" {
+			members = append(members, connector.Member{Type: store.ResourceTypeUser, ID: id, RemoteID: remoteID})
+		}
+	}
+
+	accounts, err := c.List(ctx, store.ResourceTypeUser)
+	if err != nil {
+		t.Fatalf("failed to list users : %v", err)
+	}
+	if len(accounts) != 3 {
+		t.Fatalf("got %d users, want 3", len(accounts))
+	}
+	for _, account := range accounts {
+		expected, err := c.Expected(users[account.RemoteID])
+		if err != nil {
+			t.Fatalf("failed to get expected user : %v", err)
+		}
+		if account.ExternalID != expected.ExternalID || !reflect.DeepEqual(account.Attributes, expected.Attributes) {
+			t.Fatalf("got user %+v, want %+v", account, expected)
+		}
+	}
+
+	create(t, c, &connector.Request{Tenant: "default", Resource: group("g1"), Members: members})
+	groups, err := c.List(ctx, store.ResourceTypeGroup)
+	if err != nil {
+		t.Fatalf("failed to list groups : %v", err)
+	}
+	want := []any{members[0].RemoteID, members[1].RemoteID}
+	slices.SortFunc(want, func(a, b any) int { return strings.Compare(a.(string), b.(string)) })
+	if len(groups) != 1 || !reflect.DeepEqual(groups[0].Attributes["members"], want) {
+		t.Fatalf("got groups %+v, want a single group with sorted members %v", groups, want)
+	}
+}
+
+// TestNewValidatesSettings checks invalid urls are rejected.
+func TestNewValidatesSettings(t *testing.T) {
+	for _, u := range []string{"", "ldap://example.com", "https://", "://example.com"} {
+		if _, err := scim.New(&scim.Settings{URL: u}); err == nil {
+			t.Fatalf("expected url %q to be rejected", u)
+		}
+	}
+}
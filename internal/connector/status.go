@@ -0,0 +1,89 @@
+package connector
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+)
+
+// State is the provisioning state of a resource in a downstream system.
+type State string
+
+// Supported states.
+const (
+	StateSynced State = "synced" // The last operation succeeded.
+	StateFailed State = "failed" // The last operation failed.
+)
+
+// Status is the provisioning status of a resource for a single connector.
+type Status struct {
+	Connector string    // Name of the connector.
+	State     State     // Outcome of the last operation.
+	Operation Operation // Last operation dispatched to the connector.
+	RemoteID  string    // Id of the resource in the downstream system, empty when it is not provisioned.
+	Error     string    // Error of the last operation, empty when it succeeded.
+	UpdatedAt time.Time // Time the last operation completed.
+}
+
+// resourceKey identifies a resource of a tenant.
+type resourceKey struct {
+	tenant       string
+	resourceType store.ResourceType
+	id           string
+}
+
+// Statuses tracks the provisioning status of every resource per connector.
+type Statuses struct {
+	mu       sync.RWMutex
+	statuses map[resourceKey]map[string]*Status // Statuses keyed by resource, then connector name.
+}
+
+// NewStatuses constructs an empty status tracker.
+func NewStatuses() *Statuses {
+	return &Statuses{statuses: make(map[resourceKey]map[string]*Status)}
+}
+
+// Get returns the status of a resource for a connector.
+func (s *Statuses) Get(tenant string, resourceType store.ResourceType, id, connector string) (Status, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	status, ok := s.statuses[resourceKey{tenant: tenant, resourceType: resourceType, id: id}][connector]
+	if !ok {
+		return Status{}, false
+	}
+	return *status, true
+}
+
+// List returns the status of a resource for every connector it was
+// dispatched to, ordered by connector name.
+func (s *Statuses) List(tenant string, resourceType store.ResourceType, id string) []Status {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	byConnector := s.statuses[resourceKey{tenant: tenant, resourceType: resourceType, id: id}]
+
+	statuses := make([]Status, 0, len(byConnector))
+	for _, status := range byConnector {
+		statuses = append(statuses, *status)
+	}
+	slices.SortFunc(statuses, func(a, b Status) int {
+		return strings.Compare(a.Connector, b.Connector)
+	})
+	return statuses
+}
+
+// set records the status of a resource for a connector.
+func (s *Statuses) set(tenant string, resourceType store.ResourceType, id string, status Status) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := resourceKey{tenant: tenant, resourceType: resourceType, id: id}
+	if s.statuses[k] == nil {
+		s.statuses[k] = make(map[string]*Status)
+	}
+	s.statuses[k][status.Connector] = &status
+}
@@ -31,6 +31,7 @@ var JWTAuth = dsl.JWTSecurity("JWTAuth", func() {
 	dsl.Description("Secures endpoint by requiring a valid JWT token")
 	dsl.Scope("api:read", "Read only access")
 	dsl.Scope("api:write", "Read and Write access")
+	dsl.Scope("admin", "Manage gateway credentials")
 })
 
 // StaticTokenAuth defines a security scheme for the static bearer token.
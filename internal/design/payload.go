@@ -24,6 +24,18 @@ var FilterSupported = dsl.Type("FilterSupported", func() {
 	dsl.Required("supported", "maxResults")
 })
 
+// BulkSupported defines the support for Bulk requests with the limits the
+// service provider enforces on them.
+var BulkSupported = dsl.Type("BulkSupported", func() {
+	dsl.Description("Specifies whether Bulk requests are supported and the largest requests accepted.")
+	dsl.Attribute("supported", dsl.Boolean, "True if Bulk requests are supported.")
+	dsl.Attribute("maxOperations", dsl.UInt, "Maximum number of operations in a Bulk request.")
+	dsl.Attribute("maxPayloadSize", dsl.UInt, "Maximum size of a Bulk request body in bytes.")
+
+	dsl.Example(map[string]any{"supported": true, "maxOperations": 1000, "maxPayloadSize": 1048576})
+	dsl.Required("supported", "maxOperations", "maxPayloadSize")
+})
+
 // AuthenticationScheme describes a method used for client authentication.
 var AuthenticationScheme = dsl.Type("AuthenticationScheme", func() {
 	dsl.Description("Defines the authentication mechanism supported by the service provider.")
@@ -53,7 +65,7 @@ var ServiceProviderConfigResponse = dsl.Type("ServiceProviderConfigResponse", fu
 	dsl.Attribute("documentationUri", dsl.String, "URI pointing to service provider help or documentation.")
 	dsl.Attribute("authenticationSchemes", dsl.ArrayOf(AuthenticationScheme), "List of supported authentication schemes.")
 	dsl.Attribute("patch", Supported, "Indicates if PATCH operation is supported.")
-	dsl.Attribute("bulk", Supported, "Indicates if bulk operations are supported.")
+	dsl.Attribute("bulk", BulkSupported, "Indicates if bulk operations are supported and their limits.")
 	dsl.Attribute("filter", FilterSupported, "Indicates if filtering is supported and the maximum number of results.")
 	dsl.Attribute("changePassword", Supported, "Indicates if password change operation is supported.")
 	dsl.Attribute("sort", Supported, "Indicates if sorting is supported.")
@@ -73,7 +85,7 @@ var ServiceProviderConfigResponse = dsl.Type("ServiceProviderConfigResponse", fu
 			},
 		},
 		"patch":          map[string]bool{"supported": true},
-		"bulk":           map[string]bool{"supported": false},
+		"bulk":           map[string]any{"supported": true, "maxOperations": 1000, "maxPayloadSize": 1048576},
 		"changePassword": map[string]bool{"supported": false},
 		"sort":           map[string]bool{"supported": false},
 		"etag":           map[string]bool{"supported": false},
@@ -86,14 +98,66 @@ var ServiceProviderConfigResponse = dsl.Type("ServiceProviderConfigResponse", fu
 })
 
 // StaticTokenAuthRequest is used to authenticate requests to the service
-// provider using a static API key.
+// provider using a static API key or a JWT.
 var StaticTokenAuthRequest = dsl.Type("ServiceProviderRequest", func() {
-	dsl.Description("Describes the request format for service provider operations that require API key-based authentication.")
+	dsl.Description("Describes the request format for service provider operations that require API key or JWT based authentication.")
 	dsl.APIKey("StaticTokenAuth", "apiKey", dsl.String, func() {
-		dsl.Description("API Key for authentication. Pass this in the 'X-API-KEY' header as: Bearer <your-api-key>")
+		dsl.Description("API Key for authentication. Pass this in the 'Authorization' header, or the legacy 'X-API-KEY' header, as: Bearer <your-api-key>")
+	})
+	dsl.Token("token", dsl.String, func() {
+		dsl.Description("JWT for authentication. Pass this in the 'Authorization' header as: Bearer <your-jwt>")
+	})
+	dsl.Example("Authorization: Bearer <your-api-key>")
+})
+
+// DefaultTenantID is the tenant served by the unscoped /scim/v2 routes and
+// used by credentials that are not bound to a tenant.
+const DefaultTenantID = "default"
+
+// TenantID defines the attribute identifying the tenant a request is scoped to.
+func TenantID() {
+	dsl.Attribute("tenantId", dsl.String, "Identifier of the tenant the request is scoped to", func() {
+		dsl.Pattern(`^[a-z0-9][a-z0-9-]{0,62}$`)
+		dsl.Default(DefaultTenantID)
+		dsl.Example("acme")
+	})
+}
+
+// TenantRequest is used to authenticate requests scoped to a single tenant.
+var TenantRequest = dsl.Type("TenantRequest", func() {
+	dsl.Description("Describes the request format for tenant scoped operations that require API key or JWT based authentication.")
+	dsl.Extend(StaticTokenAuthRequest)
+	TenantID()
+})
+
+// JWTAuthRequest describes requests that only accept JWT based authentication.
+var JWTAuthRequest = dsl.Type("JWTAuthRequest", func() {
+	dsl.Description("Describes the request format for administrative operations that require JWT based authentication.")
+	dsl.Token("token", dsl.String, func() {
+		dsl.Description("JWT for authentication. Pass this in the 'Authorization' header as: Bearer <your-jwt>")
 	})
-	dsl.Example("X-API-KEY: Bearer <your-api-key>")
-	dsl.Required("apiKey")
+	dsl.Example("Authorization: Bearer <your-jwt>")
+})
+
+// SCIMError is the error response body defined by RFC 7644 section 3.12.
+var SCIMError = dsl.Type("SCIMError", func() {
+	dsl.Description("SCIM error response.")
+	dsl.Attribute("schemas", dsl.ArrayOf(dsl.String), "SCIM error message schema URI")
+	dsl.Attribute("status", dsl.String, "HTTP status code of the error as a string", func() {
+		// The status doubles as the error name, so every SCIM error
+		// declared on a service shares this type.
+		dsl.Meta("struct:error:name")
+	})
+	dsl.Attribute("scimType", dsl.String, "SCIM detail error keyword")
+	dsl.Attribute("detail", dsl.String, "Human readable error message")
+
+	dsl.Example(map[string]any{
+		"schemas": []string{"urn:ietf:params:scim:api:messages:2.0:Error"},
+		"status":  "401",
+		"detail":  "unauthorized : invalid bearer token",
+	})
+
+	dsl.Required("schemas", "status", "detail")
 })
 
 // SCIMAttribute defines the metadata for an attribute in a schema.
@@ -245,6 +309,9 @@ var ResourceType = dsl.Type("ResourceType", func() {
 		dsl.Description("The primary schema URI")
 		dsl.Example("urn:ietf:params:scim:schemas:core:2.0:User")
 	})
+	dsl.Attribute("schemaExtensions", dsl.ArrayOf(SchemaExtension), func() {
+		dsl.Description("Schema extensions of the resource type")
+	})
 	dsl.Attribute("meta", ResourceMeta, func() {
 		dsl.Description("Metadata about the resource")
 	})
@@ -252,6 +319,20 @@ var ResourceType = dsl.Type("ResourceType", func() {
 	dsl.Required("schemas", "id", "name", "endpoint", "description", "schema", "meta")
 })
 
+// SchemaExtension references a schema extension of a resource type.
+var SchemaExtension = dsl.Type("SchemaExtension", func() {
+	dsl.Description("SCIM schema extension of a resource type")
+	dsl.Attribute("schema", dsl.String, func() {
+		dsl.Description("The URI of the extension schema")
+		dsl.Example("urn:ietf:params:scim:schemas:extension:enterprise:2.0:User")
+	})
+	dsl.Attribute("required", dsl.Boolean, func() {
+		dsl.Description("Whether resources must include the extension")
+		dsl.Example(false)
+	})
+	dsl.Required("schema", "required")
+})
+
 var ResourceMeta = dsl.Type("ResourceMeta", func() {
 	dsl.Description("Metadata about a SCIM resource")
 	dsl.Attribute("resourceType", dsl.String, func() {
@@ -263,3 +344,600 @@ var ResourceMeta = dsl.Type("ResourceMeta", func() {
 	})
 	dsl.Required("resourceType", "location")
 })
+
+// StoredResource represents a single stored version of a SCIM resource as
+// exposed by the administrative API.
+var StoredResource = dsl.Type("StoredResource", func() {
+	dsl.Description("A version of a SCIM resource held by the gateway store.")
+	dsl.Attribute("id", dsl.String, "Unique identifier of the resource")
+	dsl.Attribute("resourceType", dsl.String, "SCIM resource type", func() {
+		dsl.Enum("User", "Group")
+	})
+	dsl.Attribute("version", dsl.UInt64, "Version number of the resource, starting at 1")
+	dsl.Attribute("created", dsl.String, "Time the resource was created", func() {
+		dsl.Format(dsl.FormatDateTime)
+	})
+	dsl.Attribute("lastModified", dsl.String, "Time the write that produced this version happened", func() {
+		dsl.Format(dsl.FormatDateTime)
+	})
+	dsl.Attribute("attributes", dsl.MapOf(dsl.String, dsl.Any), "Resource attributes as stored")
+	dsl.Attribute("connectors", dsl.ArrayOf(ConnectorStatus), "Provisioning status of the resource in each downstream system")
+	dsl.Attribute("dryRun", dsl.Boolean, "Whether the write was only planned, leaving the store and downstream systems untouched")
+	dsl.Attribute("plan", Plan, "Changes a dry run would have made, set for dry runs only")
+
+	dsl.Example(map[string]any{
+		"id":           "2819c223-7f76-453a-919d-413861904646",
+		"resourceType": "User",
+		"version":      3,
+		"created":      "2025-01-23T04:56:22Z",
+		"lastModified": "2025-02-10T11:03:41Z",
+		"attributes":   map[string]any{"userName": "bjensen@example.com", "active": true},
+		"connectors": []map[string]any{{
+			"connector": "corp-ldap",
+			"state":     "synced",
+			"operation": "update",
+			"remoteId":  "uid=bjensen,ou=people,dc=example,dc=com",
+			"updatedAt": "2025-02-10T11:03:42Z",
+		}},
+	})
+
+	dsl.Required("id", "resourceType", "version", "created", "lastModified", "attributes")
+})
+
+// ConnectorStatus is the provisioning status of a resource in a downstream
+// system.
+var ConnectorStatus = dsl.Type("ConnectorStatus", func() {
+	dsl.Description("Outcome of the last operation dispatched to a connector for a resource.")
+	dsl.Attribute("connector", dsl.String, "Name of the connector")
+	dsl.Attribute("state", dsl.String, "Outcome of the last operation", func() {
+		dsl.Enum("synced", "failed")
+	})
+	dsl.Attribute("operation", dsl.String, "Last operation dispatched to the connector", func() {
+		dsl.Enum("create", "update", "disable", "delete", "membership")
+	})
+	dsl.Attribute("remoteId", dsl.String, "Id of the resource in the downstream system")
+	dsl.Attribute("error", dsl.String, "Error of the last operation when it failed")
+	dsl.Attribute("updatedAt", dsl.String, "Time the last operation completed", func() {
+		dsl.Format(dsl.FormatDateTime)
+	})
+
+	dsl.Required("connector", "state", "operation", "updatedAt")
+})
+
+// Plan lists the changes a dry run would have made.
+var Plan = dsl.Type("Plan", func() {
+	dsl.Description("Changes a write would make to the store and the operations they would run on each downstream system.")
+	dsl.Attribute("changes", dsl.ArrayOf(PlannedChange), "Changes to the resources of the store, in order")
+	dsl.Attribute("operations", dsl.ArrayOf(PlannedOperation), "Operations the changes would run on connectors, in order")
+	dsl.Required("changes", "operations")
+})
+
+// PlannedChange is a change to a resource of the store planned by a dry run.
+var PlannedChange = dsl.Type("PlannedChange", func() {
+	dsl.Description("A change a write would make to a resource of the store.")
+	dsl.Attribute("kind", dsl.String, "Kind of change", func() {
+		dsl.Enum("created", "updated", "deleted")
+	})
+	dsl.Attribute("resourceType", dsl.String, "SCIM resource type", func() {
+		dsl.Enum("User", "Group")
+	})
+	dsl.Attribute("id", dsl.String, "Unique identifier of the resource")
+	dsl.Attribute("version", dsl.UInt64, "Version the change would write, or the last version of a deleted resource")
+	dsl.Attribute("attributes", dsl.MapOf(dsl.String, dsl.Any), "Attributes of the resource after the change")
+	dsl.Attribute("changedAttributes", dsl.ArrayOf(dsl.String), "Names of the attributes an update would change")
+
+	dsl.Example(map[string]any{
+		"kind":              "updated",
+		"resourceType":      "User",
+		"id":                "2819c223-7f76-453a-919d-413861904646",
+		"version":           4,
+		"attributes":        map[string]any{"userName": "bjensen@example.com", "active": false},
+		"changedAttributes": []string{"active"},
+	})
+
+	dsl.Required("kind", "resourceType", "id", "version", "attributes")
+})
+
+// PlannedOperation is a connector operation planned by a dry run.
+var PlannedOperation = dsl.Type("PlannedOperation", func() {
+	dsl.Description("An operation a change would run on a connector.")
+	dsl.Attribute("connector", dsl.String, "Name of the connector")
+	dsl.Attribute("operation", dsl.String, "Operation the connector would run", func() {
+		dsl.Enum("create", "update", "disable", "delete", "membership")
+	})
+	dsl.Attribute("resourceType", dsl.String, "SCIM resource type", func() {
+		dsl.Enum("User", "Group")
+	})
+	dsl.Attribute("id", dsl.String, "Unique identifier of the resource in the gateway")
+	dsl.Attribute("remoteId", dsl.String, "Id of the resource in the downstream system, unset when it is not provisioned yet")
+	dsl.Attribute("attributes", dsl.MapOf(dsl.String, dsl.Any), "Attributes the connector would be passed, after its mapping")
+	dsl.Attribute("addedMembers", dsl.ArrayOf(dsl.String), "Ids of the members a membership operation would add")
+	dsl.Attribute("removedMembers", dsl.ArrayOf(dsl.String), "Ids of the members a membership operation would remove")
+	dsl.Attribute("error", dsl.String, "Why the operation would fail before reaching the connector, such as a failed mapping")
+
+	dsl.Example(map[string]any{
+		"connector":    "corp-ldap",
+		"operation":    "disable",
+		"resourceType": "User",
+		"id":           "2819c223-7f76-453a-919d-413861904646",
+		"remoteId":     "uid=bjensen,ou=people,dc=example,dc=com",
+		"attributes":   map[string]any{"userName": "bjensen@example.com", "active": false},
+	})
+
+	dsl.Required("connector", "operation", "resourceType", "id")
+})
+
+// ResourceVersionsResponse lists the retained versions of a resource.
+var ResourceVersionsResponse = dsl.Type("ResourceVersionsResponse", func() {
+	dsl.Description("Retained versions of a SCIM resource, oldest first.")
+	dsl.Attribute("totalResults", dsl.Int, "Number of retained versions")
+	dsl.Attribute("versions", dsl.ArrayOf(StoredResource), "Retained versions of the resource")
+	dsl.Required("totalResults", "versions")
+})
+
+// ResourceRef identifies a single resource in administrative requests.
+var ResourceRef = dsl.Type("ResourceRef", func() {
+	dsl.Extend(TenantRequest)
+	dsl.Attribute("resourceType", dsl.String, "SCIM resource type", func() {
+		dsl.Enum("User", "Group")
+	})
+	dsl.Attribute("id", dsl.String, "Unique identifier of the resource")
+	dsl.Required("resourceType", "id")
+})
+
+// DeletedResource represents a soft deleted SCIM resource that can still be restored.
+var DeletedResource = dsl.Type("DeletedResource", func() {
+	dsl.Description("A soft deleted SCIM resource awaiting purge.")
+	dsl.Attribute("resource", StoredResource, "Last version of the resource before it was deleted")
+	dsl.Attribute("deletedAt", dsl.String, "Time the resource was deleted", func() {
+		dsl.Format(dsl.FormatDateTime)
+	})
+	dsl.Attribute("groups", dsl.ArrayOf(dsl.String), "Ids of the groups the deleted resource was a member of")
+	dsl.Required("resource", "deletedAt", "groups")
+})
+
+// DeletedResourcesResponse lists soft deleted resources.
+var DeletedResourcesResponse = dsl.Type("DeletedResourcesResponse", func() {
+	dsl.Description("Soft deleted SCIM resources, most recently deleted first.")
+	dsl.Attribute("totalResults", dsl.Int, "Number of deleted resources")
+	dsl.Attribute("resources", dsl.ArrayOf(DeletedResource), "Deleted resources")
+	dsl.Required("totalResults", "resources")
+})
+
+// ServiceAccountToken describes a static service account token without its secret.
+var ServiceAccountToken = dsl.Type("ServiceAccountToken", func() {
+	dsl.Description("A static bearer token issued to a service account. The token secret is never returned after creation.")
+	dsl.Attribute("id", dsl.String, "Unique identifier of the token")
+	dsl.Attribute("serviceAccount", dsl.String, "Service account the token was issued to")
+	dsl.Attribute("tenantId", dsl.String, "Tenant whose resources the token can access")
+	dsl.Attribute("hint", dsl.String, "First characters of the token, to help identify it")
+	dsl.Attribute("status", dsl.String, "Current status of the token", func() {
+		dsl.Enum("active", "expired", "revoked")
+	})
+	dsl.Attribute("createdAt", dsl.String, "Time the token was created", func() {
+		dsl.Format(dsl.FormatDateTime)
+	})
+	dsl.Attribute("expiresAt", dsl.String, "Time the token stops being accepted", func() {
+		dsl.Format(dsl.FormatDateTime)
+	})
+	dsl.Attribute("revokedAt", dsl.String, "Time the token was revoked", func() {
+		dsl.Format(dsl.FormatDateTime)
+	})
+	dsl.Attribute("lastUsedAt", dsl.String, "Time the token last authenticated a request", func() {
+		dsl.Format(dsl.FormatDateTime)
+	})
+	dsl.Attribute("lastUsedIp", dsl.String, "Client address of the last request authenticated with the token")
+	dsl.Attribute("rotatedFrom", dsl.String, "Id of the token this token replaced on rotation")
+
+	dsl.Example(map[string]any{
+		"id":             "7d0f6d0e-3c43-4f43-9a7c-1b0f3c2d9e11",
+		"serviceAccount": "okta-production",
+		"tenantId":       "acme",
+		"hint":           "sgw_Q2x5",
+		"status":         "active",
+		"createdAt":      "2025-01-23T04:56:22Z",
+		"expiresAt":      "2025-04-23T04:56:22Z",
+		"lastUsedAt":     "2025-02-10T11:03:41Z",
+		"lastUsedIp":     "203.0.113.10",
+	})
+
+	dsl.Required("id", "serviceAccount", "tenantId", "hint", "status", "createdAt")
+})
+
+// IssuedToken is returned once, when a token is created or rotated.
+var IssuedToken = dsl.Type("IssuedToken", func() {
+	dsl.Description("A newly issued service account token. The token secret is only ever returned here.")
+	dsl.Attribute("token", dsl.String, "The token secret, to be sent as: Authorization: Bearer <token>")
+	dsl.Attribute("details", ServiceAccountToken, "Details of the issued token")
+	dsl.Required("token", "details")
+})
+
+// ListTokensResponse lists service account tokens.
+var ListTokensResponse = dsl.Type("ListTokensResponse", func() {
+	dsl.Description("Service account tokens, most recently created first.")
+	dsl.Attribute("totalResults", dsl.Int, "Number of tokens")
+	dsl.Attribute("tokens", dsl.ArrayOf(ServiceAccountToken), "Service account tokens")
+	dsl.Required("totalResults", "tokens")
+})
+
+// TokenRef identifies a single service account token in administrative requests.
+var TokenRef = dsl.Type("TokenRef", func() {
+	dsl.Extend(JWTAuthRequest)
+	TenantID()
+	dsl.Attribute("id", dsl.String, "Unique identifier of the token")
+	dsl.Required("id")
+})
+
+// DeadLetter describes a change a connector failed to provision.
+var DeadLetter = dsl.Type("DeadLetter", func() {
+	dsl.Description("A change a connector failed to provision, kept until it is retried or discarded.")
+	dsl.Attribute("id", dsl.String, "Unique identifier of the dead letter")
+	dsl.Attribute("connector", dsl.String, "Name of the connector the change was delivered to")
+	dsl.Attribute("change", dsl.String, "Kind of change", func() {
+		dsl.Enum("created", "updated", "deleted")
+	})
+	dsl.Attribute("resourceType", dsl.String, "SCIM resource type", func() {
+		dsl.Enum("User", "Group")
+	})
+	dsl.Attribute("resourceId", dsl.String, "Unique identifier of the resource")
+	dsl.Attribute("attempts", dsl.Int, "Number of attempts made")
+	dsl.Attribute("error", dsl.String, "Error of the last attempt")
+	dsl.Attribute("failedAt", dsl.String, "Time of the last attempt", func() {
+		dsl.Format(dsl.FormatDateTime)
+	})
+	dsl.Attribute("resource", StoredResource, "Resource after the change, or its last version when it was deleted, only set when a single dead letter is retrieved")
+	dsl.Attribute("previous", StoredResource, "Resource before an update, only set when a single dead letter is retrieved")
+
+	dsl.Example(map[string]any{
+		"id":           "0b8f1f4e-7f6e-4d8c-9a57-5a4c1de2f0a3",
+		"connector":    "corporate-ldap",
+		"change":       "updated",
+		"resourceType": "User",
+		"resourceId":   "2819c223-7f76-453a-919d-413861904646",
+		"attempts":     8,
+		"error":        "LDAP Result Code 52 \"Unavailable\"",
+		"failedAt":     "2025-02-10T11:03:41Z",
+	})
+
+	dsl.Required("id", "connector", "change", "resourceType", "resourceId", "attempts", "error", "failedAt")
+})
+
+// DeadLettersResponse lists dead letters.
+var DeadLettersResponse = dsl.Type("DeadLettersResponse", func() {
+	dsl.Description("Dead-lettered changes, most recent failure first.")
+	dsl.Attribute("totalResults", dsl.Int, "Number of dead letters")
+	dsl.Attribute("deadLetters", dsl.ArrayOf(DeadLetter), "Dead-lettered changes")
+	dsl.Required("totalResults", "deadLetters")
+})
+
+// DeadLetterRef identifies a single dead letter in administrative requests.
+var DeadLetterRef = dsl.Type("DeadLetterRef", func() {
+	dsl.Extend(TenantRequest)
+	dsl.Attribute("id", dsl.String, "Unique identifier of the dead letter")
+	dsl.Required("id")
+})
+
+// DeadLetterRetry is the outcome of retrying a dead letter.
+var DeadLetterRetry = dsl.Type("DeadLetterRetry", func() {
+	dsl.Description("Outcome of retrying a dead-lettered change.")
+	dsl.Attribute("id", dsl.String, "Unique identifier of the retried dead letter")
+	dsl.Attribute("outcome", dsl.String, "Whether the change was queued for delivery, or dropped as superseded by a later change", func() {
+		dsl.Enum("queued", "superseded")
+	})
+	dsl.Required("id", "outcome")
+})
+
+// Drift describes a difference between the gateway and a downstream system.
+var Drift = dsl.Type("Drift", func() {
+	dsl.Description("A difference between a resource of the gateway and the account of a downstream system.")
+	dsl.Attribute("kind", dsl.String, "Kind of difference", func() {
+		dsl.Enum("missing", "orphaned", "mismatch", "error")
+	})
+	dsl.Attribute("resourceType", dsl.String, "SCIM resource type", func() {
+		dsl.Enum("User", "Group")
+	})
+	dsl.Attribute("id", dsl.String, "Unique identifier of the resource in the gateway, unset for orphaned accounts")
+	dsl.Attribute("remoteId", dsl.String, "Identifier of the account in the downstream system, unset for missing accounts")
+	dsl.Attribute("attribute", dsl.String, "Attribute that differs, for mismatches")
+	dsl.Attribute("expected", dsl.Any, "Value of the attribute the resource holds, for mismatches")
+	dsl.Attribute("actual", dsl.Any, "Value of the attribute the account holds, for mismatches")
+	dsl.Attribute("detail", dsl.String, "Why the resource could not be compared, for errors")
+
+	dsl.Example(map[string]any{
+		"kind":         "mismatch",
+		"resourceType": "User",
+		"id":           "2819c223-7f76-453a-919d-413861904646",
+		"remoteId":     "uid=bjensen,ou=people,dc=example,dc=com",
+		"attribute":    "mail",
+		"expected":     []string{"bjensen@example.com"},
+		"actual":       []string{"babs@example.com"},
+	})
+
+	dsl.Required("kind", "resourceType")
+})
+
+// Reconciliation describes a reconciliation of a connector.
+var Reconciliation = dsl.Type("Reconciliation", func() {
+	dsl.Description("Report of a comparison of the resources of the gateway with the accounts of a downstream system.")
+	dsl.Attribute("id", dsl.String, "Unique identifier of the reconciliation")
+	dsl.Attribute("connector", dsl.String, "Name of the reconciled connector")
+	dsl.Attribute("state", dsl.String, "State of the reconciliation", func() {
+		dsl.Enum("running", "completed", "failed")
+	})
+	dsl.Attribute("correct", dsl.Boolean, "Whether corrective operations were requested")
+	dsl.Attribute("startedAt", dsl.String, "Time the reconciliation started", func() {
+		dsl.Format(dsl.FormatDateTime)
+	})
+	dsl.Attribute("completedAt", dsl.String, "Time the reconciliation completed or failed", func() {
+		dsl.Format(dsl.FormatDateTime)
+	})
+	dsl.Attribute("error", dsl.String, "Error the reconciliation failed with")
+	dsl.Attribute("resources", dsl.Int, "Number of gateway resources compared")
+	dsl.Attribute("accounts", dsl.Int, "Number of downstream accounts listed")
+	dsl.Attribute("corrections", dsl.Int, "Number of corrective operations queued")
+	dsl.Attribute("driftCount", dsl.Int, "Number of differences found")
+	dsl.Attribute("drifts", dsl.ArrayOf(Drift), "Differences found, only set when a single reconciliation is retrieved")
+
+	dsl.Example(map[string]any{
+		"id":          "6f1c0a52-93a4-4d2e-8d4b-1f0e3c6d9b21",
+		"connector":   "corporate-ldap",
+		"state":       "completed",
+		"correct":     false,
+		"startedAt":   "2025-02-10T02:00:00Z",
+		"completedAt": "2025-02-10T02:00:04Z",
+		"resources":   1250,
+		"accounts":    1262,
+		"corrections": 0,
+		"driftCount":  14,
+	})
+
+	dsl.Required("id", "connector", "state", "correct", "startedAt", "resources", "accounts", "corrections", "driftCount")
+})
+
+// ReconciliationsResponse lists reconciliations.
+var ReconciliationsResponse = dsl.Type("ReconciliationsResponse", func() {
+	dsl.Description("Reports of recent reconciliations, most recent first.")
+	dsl.Attribute("totalResults", dsl.Int, "Number of reconciliations")
+	dsl.Attribute("reconciliations", dsl.ArrayOf(Reconciliation), "Reconciliation reports")
+	dsl.Required("totalResults", "reconciliations")
+})
+
+// ReconciliationRef identifies a single reconciliation in administrative
+// requests.
+var ReconciliationRef = dsl.Type("ReconciliationRef", func() {
+	dsl.Extend(TenantRequest)
+	dsl.Attribute("id", dsl.String, "Unique identifier of the reconciliation")
+	dsl.Required("id")
+})
+
+// BulkOperation is a single operation of a SCIM Bulk request.
+var BulkOperation = dsl.Type("BulkOperation", func() {
+	dsl.Description("A single operation of a SCIM Bulk request.")
+	dsl.Attribute("method", dsl.String, "HTTP method of the operation", func() {
+		dsl.Enum("POST", "PUT", "PATCH", "DELETE")
+	})
+	dsl.Attribute("bulkId", dsl.String, "Transient identifier of a created resource, referenced by later operations as 'bulkId:<bulkId>'")
+	dsl.Attribute("version", dsl.String, "Version of the resource the operation expects")
+	dsl.Attribute("path", dsl.String, "Resource endpoint or resource path the operation applies to", func() {
+		dsl.Example("/Users")
+	})
+	dsl.Attribute("data", dsl.MapOf(dsl.String, dsl.Any), "Resource attributes written by POST and PUT operations, or the PATCH request of PATCH operations")
+
+	dsl.Example(map[string]any{
+		"method": "POST",
+		"bulkId": "qwerty",
+		"path":   "/Users",
+		"data":   map[string]any{"userName": "bjensen"},
+	})
+	dsl.Required("method", "path")
+})
+
+// BulkRequest represents a SCIM Bulk request.
+var BulkRequest = dsl.Type("BulkRequest", func() {
+	dsl.Description("SCIM Bulk request applying several operations in order.")
+	dsl.Extend(TenantRequest)
+	projectionAttributes()
+	dsl.Attribute("schemas", dsl.ArrayOf(dsl.String), "SCIM Bulk request schema URI", func() {
+		dsl.Example([]string{"urn:ietf:params:scim:api:messages:2.0:BulkRequest"})
+	})
+	dsl.Attribute("failOnErrors", dsl.Int, "Number of errors after which the remaining operations are skipped", func() {
+		dsl.Minimum(1)
+	})
+	dsl.Attribute("Operations", dsl.ArrayOf(BulkOperation), "Operations to apply, in order")
+	dsl.Attribute("dryRun", dsl.Boolean, "Only plan the operations, leaving the store and downstream systems untouched", func() {
+		dsl.Default(false)
+	})
+	dsl.Required("schemas", "Operations")
+})
+
+// BulkOperationResponse reports the outcome of a single Bulk operation.
+var BulkOperationResponse = dsl.Type("BulkOperationResponse", func() {
+	dsl.Description("Outcome of a single operation of a SCIM Bulk request.")
+	dsl.Attribute("method", dsl.String, "HTTP method of the operation")
+	dsl.Attribute("bulkId", dsl.String, "Transient identifier of the operation, as sent")
+	dsl.Attribute("version", dsl.String, "Version of the written resource")
+	dsl.Attribute("location", dsl.String, "Location of the written resource")
+	dsl.Attribute("status", dsl.String, "HTTP status code of the operation as a string")
+	dsl.Attribute("response", dsl.MapOf(dsl.String, dsl.Any), "Resource the operation wrote, projected by attributes and excludedAttributes, or the error it failed with")
+
+	dsl.Example(map[string]any{
+		"method":   "POST",
+		"bulkId":   "qwerty",
+		"version":  `W/"1"`,
+		"location": "/default/scim/v2/Users/2819c223-7f76-453a-919d-413861904646",
+		"status":   "201",
+	})
+	dsl.Required("method", "status")
+})
+
+// BulkResponse represents a SCIM Bulk response.
+var BulkResponse = dsl.Type("BulkResponse", func() {
+	dsl.Description("SCIM Bulk response reporting the outcome of every operation that was processed.")
+	dsl.Attribute("schemas", dsl.ArrayOf(dsl.String), "SCIM Bulk response schema URI", func() {
+		dsl.Example([]string{"urn:ietf:params:scim:api:messages:2.0:BulkResponse"})
+	})
+	dsl.Attribute("Operations", dsl.ArrayOf(BulkOperationResponse), "Outcome of every processed operation, in order")
+	dsl.Attribute("plan", Plan, "Changes a dry run would have made, set for dry runs only")
+	dsl.Required("schemas", "Operations")
+})
+
+// CreateResourceRequest represents a request creating a User or Group.
+var CreateResourceRequest = dsl.Type("CreateResourceRequest", func() {
+	dsl.Description("Request creating a User or Group.")
+	dsl.Extend(TenantRequest)
+	projectionAttributes()
+	dsl.Attribute("resource", dsl.MapOf(dsl.String, dsl.Any), "Attributes of the resource", func() {
+		dsl.Example(map[string]any{
+			"schemas":  []string{"urn:ietf:params:scim:schemas:core:2.0:User"},
+			"userName": "bjensen",
+		})
+	})
+	dsl.Attribute("dryRun", dsl.Boolean, "Only plan the write, leaving the store and downstream systems untouched", func() {
+		dsl.Default(false)
+	})
+	dsl.Required("resource")
+})
+
+// ResourceRequest represents a request reading a single User or Group.
+var ResourceRequest = dsl.Type("ResourceRequest", func() {
+	dsl.Description("Request reading a User or Group.")
+	dsl.Extend(TenantRequest)
+	dsl.Attribute("id", dsl.String, "Identifier of the resource", func() {
+		dsl.Example("2819c223-7f76-453a-919d-413861904646")
+	})
+	dsl.Required("id")
+})
+
+// GetResourceRequest represents a request reading a single User or Group,
+// selecting the attributes returned.
+var GetResourceRequest = dsl.Type("GetResourceRequest", func() {
+	dsl.Description("Request reading a User or Group.")
+	dsl.Extend(ResourceRequest)
+	projectionAttributes()
+})
+
+// projectionAttributes declares the attributes and excludedAttributes
+// parameters of requests returning resources (RFC 7644 section 3.4.2.5).
+func projectionAttributes() {
+	dsl.Attribute("attributes", dsl.String, "Comma separated attribute paths to return instead of the default set", func() {
+		dsl.Example("userName,name.givenName")
+	})
+	dsl.Attribute("excludedAttributes", dsl.String, "Comma separated attribute paths to leave out of the default set", func() {
+		dsl.Example("groups")
+	})
+}
+
+// ReplaceResourceRequest represents a request replacing a User or Group.
+var ReplaceResourceRequest = dsl.Type("ReplaceResourceRequest", func() {
+	dsl.Description("Request replacing the attributes of a User or Group.")
+	dsl.Extend(ResourceRequest)
+	projectionAttributes()
+	dsl.Attribute("resource", dsl.MapOf(dsl.String, dsl.Any), "Attributes of the resource", func() {
+		dsl.Example(map[string]any{
+			"schemas":  []string{"urn:ietf:params:scim:schemas:core:2.0:User"},
+			"userName": "bjensen",
+			"active":   false,
+		})
+	})
+	dsl.Attribute("ifMatch", dsl.String, "Entity tag of the version the write expects the resource to be at", func() {
+		dsl.Example(`W/"3"`)
+	})
+	dsl.Attribute("dryRun", dsl.Boolean, "Only plan the write, leaving the store and downstream systems untouched", func() {
+		dsl.Default(false)
+	})
+	dsl.Required("resource")
+})
+
+// ListResourcesRequest represents a request listing Users or Groups.
+var ListResourcesRequest = dsl.Type("ListResourcesRequest", func() {
+	dsl.Description("Request listing the Users or Groups matching a filter, one page at a time.")
+	dsl.Extend(TenantRequest)
+	projectionAttributes()
+	dsl.Attribute("filter", dsl.String, "SCIM filter the resources must match", func() {
+		dsl.Example(`groups.value eq "e9e30dba-f08f-4109-8486-d5c6a331660a"`)
+	})
+	dsl.Attribute("startIndex", dsl.Int, "1-based index of the first resource of the page", func() {
+		dsl.Default(1)
+	})
+	dsl.Attribute("count", dsl.Int, "Largest number of resources to return, capped by the filter maxResults")
+})
+
+// ListResourcesResponse represents a page of Users or Groups.
+var ListResourcesResponse = dsl.Type("ListResourcesResponse", func() {
+	dsl.Description("SCIM list response holding a page of Users or Groups.")
+	dsl.Attribute("schemas", dsl.ArrayOf(dsl.String), "SCIM list response schema URI", func() {
+		dsl.Example([]string{"urn:ietf:params:scim:api:messages:2.0:ListResponse"})
+	})
+	dsl.Attribute("totalResults", dsl.Int, "Number of resources matching the filter")
+	dsl.Attribute("itemsPerPage", dsl.Int, "Number of resources in the page")
+	dsl.Attribute("startIndex", dsl.Int, "1-based index of the first resource of the page")
+	dsl.Attribute("Resources", dsl.ArrayOf(dsl.MapOf(dsl.String, dsl.Any)), "Resources of the page")
+	dsl.Required("schemas", "totalResults", "itemsPerPage", "startIndex", "Resources")
+})
+
+// DeleteResourceRequest represents a request deleting a User or Group.
+var DeleteResourceRequest = dsl.Type("DeleteResourceRequest", func() {
+	dsl.Description("Request deleting a User or Group.")
+	dsl.Extend(ResourceRequest)
+	dsl.Attribute("ifMatch", dsl.String, "Entity tag of the version the delete expects the resource to be at", func() {
+		dsl.Example(`W/"3"`)
+	})
+	dsl.Attribute("dryRun", dsl.Boolean, "Only plan the delete, leaving the store and downstream systems untouched", func() {
+		dsl.Default(false)
+	})
+})
+
+// PatchOperation represents a single operation of a PATCH request.
+var PatchOperation = dsl.Type("PatchOperation", func() {
+	dsl.Description("A single operation of a SCIM PATCH request.")
+	dsl.Attribute("op", dsl.String, "Operation to perform: add, replace or remove, in any case", func() {
+		dsl.Example("add")
+	})
+	dsl.Attribute("path", dsl.String, "Attribute path the operation applies to, optionally filtering the values of a multi-valued attribute", func() {
+		dsl.Example(`members[value eq "2819c223-7f76-453a-919d-413861904646"]`)
+	})
+	dsl.Attribute("value", dsl.Any, "Value to add or replace with, or the values to remove", func() {
+		dsl.Example([]map[string]any{{"value": "2819c223-7f76-453a-919d-413861904646"}})
+	})
+	dsl.Required("op")
+})
+
+// PatchResourceRequest represents a request patching a User or Group.
+var PatchResourceRequest = dsl.Type("PatchResourceRequest", func() {
+	dsl.Description("Request modifying some attributes of a User or Group.")
+	dsl.Extend(ResourceRequest)
+	projectionAttributes()
+	dsl.Attribute("schemas", dsl.ArrayOf(dsl.String), "SCIM PATCH request schema URI", func() {
+		dsl.Example([]string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"})
+	})
+	dsl.Attribute("Operations", dsl.ArrayOf(PatchOperation), "Operations to apply in order, all or none")
+	dsl.Attribute("ifMatch", dsl.String, "Entity tag of the version the write expects the resource to be at", func() {
+		dsl.Example(`W/"3"`)
+	})
+	dsl.Attribute("dryRun", dsl.Boolean, "Only plan the write, leaving the store and downstream systems untouched", func() {
+		dsl.Default(false)
+	})
+	dsl.Required("Operations")
+})
+
+// SCIMResource represents a User or Group as served by the SCIM API.
+var SCIMResource = dsl.Type("SCIMResource", func() {
+	dsl.Description("A User or Group with its entity tag and location.")
+	dsl.Attribute("resource", dsl.MapOf(dsl.String, dsl.Any), "Attributes of the resource, including id and meta", func() {
+		dsl.Example(map[string]any{
+			"schemas":  []string{"urn:ietf:params:scim:schemas:core:2.0:User"},
+			"id":       "2819c223-7f76-453a-919d-413861904646",
+			"userName": "bjensen",
+			"meta": map[string]any{
+				"resourceType": "User",
+				"created":      "2025-01-23T04:56:22Z",
+				"lastModified": "2025-01-23T04:56:22Z",
+				"location":     "/default/scim/v2/Users/2819c223-7f76-453a-919d-413861904646",
+				"version":      `W/"1"`,
+			},
+		})
+	})
+	dsl.Attribute("etag", dsl.String, "Version of the resource as a weak entity tag, unset for dry runs")
+	dsl.Attribute("location", dsl.String, "Location of the resource, unset for dry runs")
+	dsl.Required("resource")
+})
@@ -9,24 +9,45 @@ import (
 var _ = dsl.Service("scim", func() {
 	dsl.Description("The SCIM service implements the SCIM 2.0 protocol, including discovery and identity management features.")
 
-	// Apply static API key security scheme to all methods in this service.
+	// Accept either a static API key or a JWT granting read access on all
+	// methods in this service.
 	dsl.Security(StaticTokenAuth)
+	dsl.Security(JWTAuth, func() {
+		dsl.Scope("api:read")
+	})
+
+	dsl.Error("401", SCIMError, "Missing or invalid credentials")
+	dsl.Error("403", SCIMError, "Credentials lack a required scope or belong to another tenant")
+	dsl.Error("404", SCIMError, "Tenant, schema or resource not found")
+	dsl.Error("400", SCIMError, "Malformed request or invalid resource")
+	dsl.Error("409", SCIMError, "Resource conflicts with an existing resource")
+	dsl.Error("412", SCIMError, "Resource is not at the version the request expects")
+	dsl.Error("413", SCIMError, "Bulk request exceeds the advertised maxOperations or maxPayloadSize")
 
-	// Base path prefix for all endpoints under the SCIM v2 API.
+	// Every endpoint is scoped to a tenant. Requests to the unscoped /scim/v2/
+	// prefix are served by the default tenant.
 	dsl.HTTP(func() {
-		dsl.Path("/scim/v2/")
+		dsl.Path("/{tenantId}/scim/v2/")
+		dsl.Response("401", dsl.StatusUnauthorized)
+		dsl.Response("403", dsl.StatusForbidden)
+		dsl.Response("404", dsl.StatusNotFound)
+		dsl.Response("400", dsl.StatusBadRequest)
+		dsl.Response("409", dsl.StatusConflict)
+		dsl.Response("412", dsl.StatusPreconditionFailed)
+		dsl.Response("413", dsl.StatusRequestEntityTooLarge)
 	})
 
 	// This method returns the configuration metadata for the SCIM service provider.
 	dsl.Method("ServiceProviderConfig", func() {
 		dsl.Description("Retrieves service provider's configuration metadata including supported SCIM features and authentication schemes.")
 
-		dsl.Payload(StaticTokenAuthRequest)
+		dsl.Payload(TenantRequest)
 		dsl.Result(ServiceProviderConfigResponse)
 
 		dsl.HTTP(func() {
 			dsl.GET("/ServiceProviderConfig")
-			dsl.Header("apiKey:X-API-KEY")
+			dsl.Header("apiKey:Authorization")
+			dsl.Header("token:Authorization")
 			dsl.Response(dsl.StatusOK, func() {
 				dsl.Body(ServiceProviderConfigResponse)
 			})
@@ -37,12 +58,13 @@ var _ = dsl.Service("scim", func() {
 	dsl.Method("ListSchemas", func() {
 		dsl.Description("Retrieve the supported schemas.")
 
-		dsl.Payload(StaticTokenAuthRequest)
+		dsl.Payload(TenantRequest)
 		dsl.Result(ListSchemaResponse)
 
 		dsl.HTTP(func() {
 			dsl.GET("/Schemas")
-			dsl.Header("apiKey:X-API-KEY")
+			dsl.Header("apiKey:Authorization")
+			dsl.Header("token:Authorization")
 			dsl.Response(dsl.StatusOK, func() {
 				dsl.Body(ListSchemaResponse)
 			})
@@ -54,7 +76,7 @@ var _ = dsl.Service("scim", func() {
 		dsl.Description("Retrieve a specific schema by its ID.")
 
 		dsl.Payload(func() {
-			dsl.Extend(StaticTokenAuthRequest)
+			dsl.Extend(TenantRequest)
 			dsl.Attribute("id", dsl.String, "Schema ID")
 			dsl.Required("id")
 		})
@@ -62,7 +84,8 @@ var _ = dsl.Service("scim", func() {
 
 		dsl.HTTP(func() {
 			dsl.GET("/Schemas/{id}")
-			dsl.Header("apiKey:X-API-KEY")
+			dsl.Header("apiKey:Authorization")
+			dsl.Header("token:Authorization")
 			dsl.Response(dsl.StatusOK, func() {
 				dsl.Body(SCIMSchema)
 			})
@@ -73,15 +96,646 @@ var _ = dsl.Service("scim", func() {
 	dsl.Method("ResourceTypes", func() {
 		dsl.Description("Retrieve the supported resource types.")
 
-		dsl.Payload(StaticTokenAuthRequest)
+		dsl.Payload(TenantRequest)
 		dsl.Result(ListResourceResponse)
 
 		dsl.HTTP(func() {
 			dsl.GET("/ResourceTypes")
-			dsl.Header("apiKey:X-API-KEY")
+			dsl.Header("apiKey:Authorization")
+			dsl.Header("token:Authorization")
 			dsl.Response(dsl.StatusOK, func() {
 				dsl.Body(ListResourceResponse)
 			})
 		})
 	})
+
+	// Methods serving Users and Groups.
+	resourceMethods("User", "/Users")
+	resourceMethods("Group", "/Groups")
+
+	// Method for applying several write operations in one request.
+	dsl.Method("Bulk", func() {
+		dsl.Description("Apply several User and Group operations in order, reporting the outcome of each.")
+
+		dsl.Security(StaticTokenAuth)
+		dsl.Security(JWTAuth, func() {
+			dsl.Scope("api:write")
+		})
+
+		dsl.Payload(BulkRequest)
+		dsl.Result(BulkResponse)
+
+		dsl.HTTP(func() {
+			dsl.POST("/Bulk")
+			dsl.Header("dryRun:X-Dry-Run")
+			dsl.Param("attributes")
+			dsl.Param("excludedAttributes")
+			dsl.Header("apiKey:Authorization")
+			dsl.Header("token:Authorization")
+			dsl.Response(dsl.StatusOK, func() {
+				dsl.Body(BulkResponse)
+			})
+		})
+	})
+})
+
+// resourceMethods declares the methods serving the resources of a resource
+// type, such as "User", at its endpoint, such as "/Users". Resources are sent
+// and returned as application/scim+json documents.
+func resourceMethods(resourceType, endpoint string) {
+	dsl.Method("Create"+resourceType, func() {
+		dsl.Description("Create a " + resourceType + ". Dry runs return the " + resourceType + " as it would be created, without a location or entity tag.")
+
+		dsl.Security(StaticTokenAuth)
+		dsl.Security(JWTAuth, func() {
+			dsl.Scope("api:write")
+		})
+
+		dsl.Payload(CreateResourceRequest)
+		dsl.Result(SCIMResource)
+
+		dsl.HTTP(func() {
+			dsl.POST(endpoint)
+			dsl.Param("attributes")
+			dsl.Param("excludedAttributes")
+			dsl.Header("apiKey:Authorization")
+			dsl.Header("token:Authorization")
+			dsl.Header("dryRun:X-Dry-Run")
+			dsl.Body("resource")
+			dsl.Response(dsl.StatusCreated, func() {
+				dsl.ContentType("application/scim+json")
+				dsl.Header("etag:ETag")
+				dsl.Header("location:Location")
+				dsl.Body("resource")
+			})
+		})
+	})
+
+	dsl.Method("Get"+resourceType, func() {
+		dsl.Description("Retrieve a " + resourceType + " by its id.")
+
+		dsl.Payload(GetResourceRequest)
+		dsl.Result(SCIMResource)
+
+		dsl.HTTP(func() {
+			dsl.GET(endpoint + "/{id}")
+			dsl.Param("attributes")
+			dsl.Param("excludedAttributes")
+			dsl.Header("apiKey:Authorization")
+			dsl.Header("token:Authorization")
+			dsl.Response(dsl.StatusOK, func() {
+				dsl.ContentType("application/scim+json")
+				dsl.Header("etag:ETag")
+				dsl.Header("location:Location")
+				dsl.Body("resource")
+			})
+		})
+	})
+
+	dsl.Method("List"+resourceType+"s", func() {
+		dsl.Description("List the " + resourceType + "s matching a filter, one page at a time.")
+
+		dsl.Payload(ListResourcesRequest)
+		dsl.Result(ListResourcesResponse)
+
+		dsl.HTTP(func() {
+			dsl.GET(endpoint)
+			dsl.Header("apiKey:Authorization")
+			dsl.Header("token:Authorization")
+			dsl.Param("filter")
+			dsl.Param("startIndex")
+			dsl.Param("count")
+			dsl.Param("attributes")
+			dsl.Param("excludedAttributes")
+			dsl.Response(dsl.StatusOK, func() {
+				dsl.ContentType("application/scim+json")
+				dsl.Body(ListResourcesResponse)
+			})
+		})
+	})
+
+	dsl.Method("Replace"+resourceType, func() {
+		dsl.Description("Replace the attributes of a " + resourceType + ", optionally only when it is at the version given in If-Match.")
+
+		dsl.Security(StaticTokenAuth)
+		dsl.Security(JWTAuth, func() {
+			dsl.Scope("api:write")
+		})
+
+		dsl.Payload(ReplaceResourceRequest)
+		dsl.Result(SCIMResource)
+
+		dsl.HTTP(func() {
+			dsl.PUT(endpoint + "/{id}")
+			dsl.Param("attributes")
+			dsl.Param("excludedAttributes")
+			dsl.Header("apiKey:Authorization")
+			dsl.Header("token:Authorization")
+			dsl.Header("ifMatch:If-Match")
+			dsl.Header("dryRun:X-Dry-Run")
+			dsl.Body("resource")
+			dsl.Response(dsl.StatusOK, func() {
+				dsl.ContentType("application/scim+json")
+				dsl.Header("etag:ETag")
+				dsl.Header("location:Location")
+				dsl.Body("resource")
+			})
+		})
+	})
+
+	dsl.Method("Patch"+resourceType, func() {
+		dsl.Description("Modify some attributes of a " + resourceType + ", optionally only when it is at the version given in If-Match. The operations are applied all or none.")
+
+		dsl.Security(StaticTokenAuth)
+		dsl.Security(JWTAuth, func() {
+			dsl.Scope("api:write")
+		})
+
+		dsl.Payload(PatchResourceRequest)
+		dsl.Result(SCIMResource)
+
+		dsl.HTTP(func() {
+			dsl.PATCH(endpoint + "/{id}")
+			dsl.Param("attributes")
+			dsl.Param("excludedAttributes")
+			dsl.Header("apiKey:Authorization")
+			dsl.Header("token:Authorization")
+			dsl.Header("ifMatch:If-Match")
+			dsl.Header("dryRun:X-Dry-Run")
+			dsl.Response(dsl.StatusOK, func() {
+				dsl.ContentType("application/scim+json")
+				dsl.Header("etag:ETag")
+				dsl.Header("location:Location")
+				dsl.Body("resource")
+			})
+		})
+	})
+
+	dsl.Method("Delete"+resourceType, func() {
+		dsl.Description("Delete a " + resourceType + ", optionally only when it is at the version given in If-Match. Deleted resources can be restored through the admin API until they are purged.")
+
+		dsl.Security(StaticTokenAuth)
+		dsl.Security(JWTAuth, func() {
+			dsl.Scope("api:write")
+		})
+
+		dsl.Payload(DeleteResourceRequest)
+
+		dsl.HTTP(func() {
+			dsl.DELETE(endpoint + "/{id}")
+			dsl.Header("apiKey:Authorization")
+			dsl.Header("token:Authorization")
+			dsl.Header("ifMatch:If-Match")
+			dsl.Header("dryRun:X-Dry-Run")
+			dsl.Response(dsl.StatusNoContent)
+		})
+	})
+}
+
+// Admin describes the administrative service used by gateway operators to
+// inspect and repair the resources held by the gateway.
+var _ = dsl.Service("admin", func() {
+	dsl.Description("Administrative operations for gateway operators.")
+
+	// Accept either a static API key or a JWT. Methods that modify resources
+	// require write access.
+	dsl.Security(StaticTokenAuth)
+	dsl.Security(JWTAuth, func() {
+		dsl.Scope("api:read")
+	})
+
+	dsl.Error("401", SCIMError, "Missing or invalid credentials")
+	dsl.Error("403", SCIMError, "Credentials lack a required scope or belong to another tenant")
+	dsl.Error("not_found", dsl.ErrorResult, "Tenant, resource, version, token, dead letter, connector or reconciliation not found")
+
+	// Base path prefix for all administrative endpoints.
+	dsl.HTTP(func() {
+		dsl.Path("/admin/v1/")
+		dsl.Response("401", dsl.StatusUnauthorized)
+		dsl.Response("403", dsl.StatusForbidden)
+		dsl.Response("not_found", dsl.StatusNotFound)
+	})
+
+	// Method for listing the retained versions of a resource.
+	dsl.Method("ListVersions", func() {
+		dsl.Description("List every retained version of a User or Group, oldest first.")
+
+		dsl.Payload(ResourceRef)
+		dsl.Result(ResourceVersionsResponse)
+
+		dsl.HTTP(func() {
+			dsl.GET("/{resourceType}/{id}/versions")
+			dsl.Param("tenantId")
+			dsl.Header("apiKey:Authorization")
+			dsl.Header("token:Authorization")
+			dsl.Response(dsl.StatusOK)
+		})
+	})
+
+	// Method for reading a specific version of a resource.
+	dsl.Method("GetVersion", func() {
+		dsl.Description("Retrieve a specific retained version of a User or Group.")
+
+		dsl.Payload(func() {
+			dsl.Extend(ResourceRef)
+			dsl.Attribute("version", dsl.UInt64, "Version number to retrieve")
+			dsl.Required("version")
+		})
+		dsl.Result(StoredResource)
+
+		dsl.HTTP(func() {
+			dsl.GET("/{resourceType}/{id}/versions/{version}")
+			dsl.Param("tenantId")
+			dsl.Header("apiKey:Authorization")
+			dsl.Header("token:Authorization")
+			dsl.Response(dsl.StatusOK)
+		})
+	})
+
+	// Method for reading a resource as it was at a point in time.
+	dsl.Method("GetAsOf", func() {
+		dsl.Description("Retrieve a User or Group as it was at the given point in time.")
+
+		dsl.Payload(func() {
+			dsl.Extend(ResourceRef)
+			dsl.Attribute("at", dsl.String, "Point in time to read the resource at", func() {
+				dsl.Format(dsl.FormatDateTime)
+			})
+			dsl.Required("at")
+		})
+		dsl.Result(StoredResource)
+
+		dsl.HTTP(func() {
+			dsl.GET("/{resourceType}/{id}/history")
+			dsl.Param("tenantId")
+			dsl.Param("at")
+			dsl.Header("apiKey:Authorization")
+			dsl.Header("token:Authorization")
+			dsl.Response(dsl.StatusOK)
+		})
+	})
+
+	// Method for restoring a prior version of a resource.
+	dsl.Method("RestoreVersion", func() {
+		dsl.Description("Restore a prior version of a User or Group by writing it as a new version.")
+
+		dsl.Security(StaticTokenAuth)
+		dsl.Security(JWTAuth, func() {
+			dsl.Scope("api:write")
+		})
+
+		dsl.Payload(func() {
+			dsl.Extend(ResourceRef)
+			dsl.Attribute("version", dsl.UInt64, "Version number to restore")
+			dsl.Attribute("dryRun", dsl.Boolean, "Plan the restore without committing it", func() {
+				dsl.Default(false)
+			})
+			dsl.Required("version")
+		})
+		dsl.Result(StoredResource)
+		dsl.Error("invalid", dsl.ErrorResult, "Restored version does not conform to the schemas of the tenant")
+
+		dsl.HTTP(func() {
+			dsl.POST("/{resourceType}/{id}/versions/{version}/restore")
+			dsl.Param("tenantId")
+			dsl.Header("apiKey:Authorization")
+			dsl.Header("token:Authorization")
+			dsl.Header("dryRun:X-Dry-Run")
+			dsl.Response(dsl.StatusOK)
+			dsl.Response("invalid", dsl.StatusBadRequest)
+		})
+	})
+
+	// Method for listing soft deleted resources.
+	dsl.Method("ListDeleted", func() {
+		dsl.Description("List soft deleted Users or Groups that can still be restored.")
+
+		dsl.Payload(func() {
+			dsl.Extend(TenantRequest)
+			dsl.Attribute("resourceType", dsl.String, "SCIM resource type", func() {
+				dsl.Enum("User", "Group")
+			})
+			dsl.Required("resourceType")
+		})
+		dsl.Result(DeletedResourcesResponse)
+
+		dsl.HTTP(func() {
+			dsl.GET("/{resourceType}/deleted")
+			dsl.Param("tenantId")
+			dsl.Header("apiKey:Authorization")
+			dsl.Header("token:Authorization")
+			dsl.Response(dsl.StatusOK)
+		})
+	})
+
+	// Method for restoring a soft deleted resource.
+	dsl.Method("RestoreDeleted", func() {
+		dsl.Description("Restore a soft deleted User or Group, including its group memberships.")
+
+		dsl.Security(StaticTokenAuth)
+		dsl.Security(JWTAuth, func() {
+			dsl.Scope("api:write")
+		})
+
+		dsl.Payload(func() {
+			dsl.Extend(ResourceRef)
+			dsl.Attribute("dryRun", dsl.Boolean, "Plan the restore without committing it", func() {
+				dsl.Default(false)
+			})
+		})
+		dsl.Result(StoredResource)
+
+		dsl.HTTP(func() {
+			dsl.POST("/{resourceType}/{id}/restore")
+			dsl.Param("tenantId")
+			dsl.Header("apiKey:Authorization")
+			dsl.Header("token:Authorization")
+			dsl.Header("dryRun:X-Dry-Run")
+			dsl.Response(dsl.StatusOK)
+		})
+	})
+
+	// Method for issuing a service account token.
+	dsl.Method("CreateToken", func() {
+		dsl.Description("Issue a new static bearer token for a service account. The token is only shown in this response.")
+
+		dsl.Security(JWTAuth, func() {
+			dsl.Scope("admin")
+		})
+
+		dsl.Payload(func() {
+			dsl.Extend(JWTAuthRequest)
+			TenantID()
+			dsl.Attribute("serviceAccount", dsl.String, "Service account to issue the token to", func() {
+				dsl.MinLength(1)
+			})
+			dsl.Attribute("expiresInSeconds", dsl.Int64, "Lifetime of the token in seconds, omit for a token that never expires", func() {
+				dsl.Minimum(1)
+			})
+			dsl.Required("serviceAccount")
+		})
+		dsl.Result(IssuedToken)
+		dsl.Error("invalid", dsl.ErrorResult, "Service account name cannot be issued a token, such as one containing '/'")
+
+		dsl.HTTP(func() {
+			dsl.POST("/tokens")
+			dsl.Param("tenantId")
+			dsl.Header("token:Authorization")
+			dsl.Response(dsl.StatusCreated)
+			dsl.Response("invalid", dsl.StatusBadRequest)
+		})
+	})
+
+	// Method for listing service account tokens.
+	dsl.Method("ListTokens", func() {
+		dsl.Description("List every service account token with its status and last use.")
+
+		dsl.Security(JWTAuth, func() {
+			dsl.Scope("admin")
+		})
+
+		dsl.Payload(func() {
+			dsl.Extend(JWTAuthRequest)
+			TenantID()
+		})
+		dsl.Result(ListTokensResponse)
+
+		dsl.HTTP(func() {
+			dsl.GET("/tokens")
+			dsl.Param("tenantId")
+			dsl.Header("token:Authorization")
+			dsl.Response(dsl.StatusOK)
+		})
+	})
+
+	// Method for rotating a service account token.
+	dsl.Method("RotateToken", func() {
+		dsl.Description("Issue a replacement for an active token. The old token keeps working for the overlap window, then expires.")
+
+		dsl.Security(JWTAuth, func() {
+			dsl.Scope("admin")
+		})
+
+		dsl.Payload(func() {
+			dsl.Extend(TokenRef)
+			dsl.Attribute("overlapSeconds", dsl.Int64, "How long the old token keeps working in seconds, defaults to one day", func() {
+				dsl.Minimum(0)
+			})
+		})
+		dsl.Result(IssuedToken)
+		dsl.Error("conflict", dsl.ErrorResult, "Token is revoked or expired")
+
+		dsl.HTTP(func() {
+			dsl.POST("/tokens/{id}/rotate")
+			dsl.Param("tenantId")
+			dsl.Header("token:Authorization")
+			dsl.Response(dsl.StatusCreated)
+			dsl.Response("conflict", dsl.StatusConflict)
+		})
+	})
+
+	// Method for expiring a service account token.
+	dsl.Method("ExpireToken", func() {
+		dsl.Description("Set the time a token stops being accepted. Expiry can be brought forward but never extended.")
+
+		dsl.Security(JWTAuth, func() {
+			dsl.Scope("admin")
+		})
+
+		dsl.Payload(func() {
+			dsl.Extend(TokenRef)
+			dsl.Attribute("at", dsl.String, "Time the token expires, defaults to now", func() {
+				dsl.Format(dsl.FormatDateTime)
+			})
+		})
+		dsl.Result(ServiceAccountToken)
+
+		dsl.HTTP(func() {
+			dsl.POST("/tokens/{id}/expire")
+			dsl.Param("tenantId")
+			dsl.Header("token:Authorization")
+			dsl.Response(dsl.StatusOK)
+		})
+	})
+
+	// Method for revoking a service account token.
+	dsl.Method("RevokeToken", func() {
+		dsl.Description("Immediately and permanently revoke a token.")
+
+		dsl.Security(JWTAuth, func() {
+			dsl.Scope("admin")
+		})
+
+		dsl.Payload(TokenRef)
+		dsl.Result(ServiceAccountToken)
+
+		dsl.HTTP(func() {
+			dsl.POST("/tokens/{id}/revoke")
+			dsl.Param("tenantId")
+			dsl.Header("token:Authorization")
+			dsl.Response(dsl.StatusOK)
+		})
+	})
+
+	// Method for listing dead-lettered deliveries.
+	dsl.Method("ListDeadLetters", func() {
+		dsl.Description("List the changes connectors failed to provision once their attempts ran out, most recent failure first.")
+
+		dsl.Payload(func() {
+			dsl.Extend(TenantRequest)
+			dsl.Attribute("connector", dsl.String, "Only list the dead letters of this connector")
+		})
+		dsl.Result(DeadLettersResponse)
+
+		dsl.HTTP(func() {
+			dsl.GET("/dead-letters")
+			dsl.Param("tenantId")
+			dsl.Param("connector")
+			dsl.Header("apiKey:Authorization")
+			dsl.Header("token:Authorization")
+			dsl.Response(dsl.StatusOK)
+		})
+	})
+
+	// Method for inspecting a dead-lettered delivery.
+	dsl.Method("GetDeadLetter", func() {
+		dsl.Description("Retrieve a dead-lettered change, including the resource it was made to.")
+
+		dsl.Payload(DeadLetterRef)
+		dsl.Result(DeadLetter)
+
+		dsl.HTTP(func() {
+			dsl.GET("/dead-letters/{id}")
+			dsl.Param("tenantId")
+			dsl.Header("apiKey:Authorization")
+			dsl.Header("token:Authorization")
+			dsl.Response(dsl.StatusOK)
+		})
+	})
+
+	// Method for retrying a dead-lettered delivery.
+	dsl.Method("RetryDeadLetter", func() {
+		dsl.Description("Queue a dead-lettered change for delivery again, against the current state of its resource. Changes superseded by a later delete or restore are dropped instead.")
+
+		dsl.Security(StaticTokenAuth)
+		dsl.Security(JWTAuth, func() {
+			dsl.Scope("api:write")
+		})
+
+		dsl.Payload(DeadLetterRef)
+		dsl.Result(DeadLetterRetry)
+
+		dsl.HTTP(func() {
+			dsl.POST("/dead-letters/{id}/retry")
+			dsl.Param("tenantId")
+			dsl.Header("apiKey:Authorization")
+			dsl.Header("token:Authorization")
+			dsl.Response(dsl.StatusAccepted)
+		})
+	})
+
+	// Method for discarding a dead-lettered delivery.
+	dsl.Method("DiscardDeadLetter", func() {
+		dsl.Description("Discard a dead-lettered change without provisioning it.")
+
+		dsl.Security(StaticTokenAuth)
+		dsl.Security(JWTAuth, func() {
+			dsl.Scope("api:write")
+		})
+
+		dsl.Payload(DeadLetterRef)
+
+		dsl.HTTP(func() {
+			dsl.DELETE("/dead-letters/{id}")
+			dsl.Param("tenantId")
+			dsl.Header("apiKey:Authorization")
+			dsl.Header("token:Authorization")
+			dsl.Response(dsl.StatusNoContent)
+		})
+	})
+
+	// Method for reconciling a connector with its downstream system.
+	dsl.Method("ReconcileConnector", func() {
+		dsl.Description("Start comparing the resources of the gateway with the accounts a connector lists from its downstream system. The reconciliation runs in the background and reports missing, orphaned and mismatched accounts.")
+
+		dsl.Security(StaticTokenAuth)
+		dsl.Security(JWTAuth, func() {
+			dsl.Scope("api:write")
+		})
+
+		dsl.Payload(func() {
+			dsl.Extend(TenantRequest)
+			dsl.Attribute("connector", dsl.String, "Name of the connector")
+			dsl.Attribute("correct", dsl.Boolean, "Whether to queue the operations correcting missing and mismatched accounts. Orphaned accounts are never removed.", func() {
+				dsl.Default(false)
+			})
+			dsl.Required("connector")
+		})
+		dsl.Result(Reconciliation)
+		dsl.Error("conflict", dsl.ErrorResult, "Connector is already being reconciled, or cannot list its accounts")
+
+		dsl.HTTP(func() {
+			dsl.POST("/connectors/{connector}/reconcile")
+			dsl.Param("tenantId")
+			dsl.Param("correct")
+			dsl.Header("apiKey:Authorization")
+			dsl.Header("token:Authorization")
+			dsl.Response(dsl.StatusAccepted)
+			dsl.Response("conflict", dsl.StatusConflict)
+		})
+	})
+
+	// Method for listing reconciliation reports.
+	dsl.Method("ListReconciliations", func() {
+		dsl.Description("List the reports of recent reconciliations without their drift, most recent first.")
+
+		dsl.Payload(func() {
+			dsl.Extend(TenantRequest)
+			dsl.Attribute("connector", dsl.String, "Only list the reconciliations of this connector")
+		})
+		dsl.Result(ReconciliationsResponse)
+
+		dsl.HTTP(func() {
+			dsl.GET("/reconciliations")
+			dsl.Param("tenantId")
+			dsl.Param("connector")
+			dsl.Header("apiKey:Authorization")
+			dsl.Header("token:Authorization")
+			dsl.Response(dsl.StatusOK)
+		})
+	})
+
+	// Method for retrieving a reconciliation report.
+	dsl.Method("GetReconciliation", func() {
+		dsl.Description("Retrieve the report of a reconciliation, including the drift found.")
+
+		dsl.Payload(ReconciliationRef)
+		dsl.Result(Reconciliation)
+
+		dsl.HTTP(func() {
+			dsl.GET("/reconciliations/{id}")
+			dsl.Param("tenantId")
+			dsl.Header("apiKey:Authorization")
+			dsl.Header("token:Authorization")
+			dsl.Response(dsl.StatusOK)
+		})
+	})
+
+	// Method for exporting the drift of a reconciliation.
+	dsl.Method("ExportReconciliationDrifts", func() {
+		dsl.Description("Export the drift found by a reconciliation as CSV, one row per missing or orphaned account and per mismatched attribute.")
+
+		dsl.Payload(ReconciliationRef)
+		dsl.Result(dsl.String)
+
+		dsl.HTTP(func() {
+			dsl.GET("/reconciliations/{id}/drifts.csv")
+			dsl.Param("tenantId")
+			dsl.Header("apiKey:Authorization")
+			dsl.Header("token:Authorization")
+			dsl.Response(dsl.StatusOK, func() {
+				dsl.ContentType("text/csv")
+			})
+		})
+	})
 })
@@ -0,0 +1,519 @@
+// Package filter parses SCIM filters (RFC 7644 section 3.4.2.2) and matches
+// resources against them.
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iamBelugaa/scim-gateway/internal/attribute"
+)
+
+// Filter is a parsed SCIM filter, such as
+// `userType eq "Employee" and emails[type eq "work" and value co "@example.com"]`.
+//
+// String comparisons ignore case, as for attributes that are not caseExact,
+// and values that are both dateTimes are compared as times. Comparisons with
+// a multi-valued attribute match when any of its values matches, comparing
+// the value sub-attribute of complex values. An absent attribute matches no
+// comparison but ne, which matches whenever eq does not.
+type Filter struct {
+	raw  string
+	expr expr
+}
+
+// Parse parses a SCIM filter.
+func Parse(filter string) (*Filter, error) {
+	tokens, err := lex(filter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q : %w", filter, err)
+	}
+
+	p := &parser{tokens: tokens}
+	e, err := p.parseOr()
+	if err == nil && p.peek().kind != tokenEOF {
+		err = fmt.Errorf("unexpected %s", p.peek())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q : %w", filter, err)
+	}
+	return &Filter{raw: filter, expr: e}, nil
+}
+
+// Matches reports whether the attributes of a resource match the filter.
+func (f *Filter) Matches(attrs map[string]any) bool {
+	return f.expr.match(attrs)
+}
+
+// Equality returns the attribute path and value of a filter that only tests an
+// attribute for equality with a string, such as `groups.value eq "x"` or
+// `groups[value eq "x"]`, so the filter can be served from an index rather
+// than by matching every resource.
+func (f *Filter) Equality() (attribute.Path, string, bool) {
+	e := f.expr
+	var outer *attribute.Path
+	if vp, ok := e.(*valuePath); ok {
+		e, outer = vp.filter, &vp.path
+	}
+
+	c, ok := e.(*compare)
+	if !ok || c.op != "eq" {
+		return attribute.Path{}, "", false
+	}
+	value, ok := c.value.(string)
+	if !ok {
+		return attribute.Path{}, "", false
+	}
+
+	path := c.path
+	if outer != nil {
+		if path.Schema != "" || path.Sub != "" {
+			return attribute.Path{}, "", false
+		}
+		path = attribute.Path{Schema: outer.Schema, Name: outer.Name, Sub: path.Name}
+	}
+	return path, value, true
+}
+
+// String returns the filter as it was parsed.
+func (f *Filter) String() string {
+	return f.raw
+}
+
+// expr is a node of a parsed filter.
+type expr interface {
+	match(attrs map[string]any) bool
+}
+
+// logical joins two expressions with and or or.
+type logical struct {
+	and         bool
+	left, right expr
+}
+
+func (e *logical) match(attrs map[string]any) bool {
+	if e.and {
+		return e.left.match(attrs) && e.right.match(attrs)
+	}
+	return e.left.match(attrs) || e.right.match(attrs)
+}
+
+// not negates an expression.
+type not struct {
+	expr expr
+}
+
+func (e *not) match(attrs map[string]any) bool {
+	return !e.expr.match(attrs)
+}
+
+// present matches attributes holding a non-empty value.
+type present struct {
+	path attribute.Path
+}
+
+func (e *present) match(attrs map[string]any) bool {
+	val, ok := e.path.Get(attrs)
+	return ok && !empty(val)
+}
+
+// valuePath matches multi-valued attributes holding a value that matches a
+// filter on its sub-attributes.
+type valuePath struct {
+	path   attribute.Path
+	filter expr
+}
+
+func (e *valuePath) match(attrs map[string]any) bool {
+	val, ok := e.path.Get(attrs)
+	if !ok {
+		return false
+	}
+
+	items, ok := val.([]any)
+	if !ok {
+		items = []any{val}
+	}
+	for _, item := range items {
+		if m, ok := item.(map[string]any); ok && e.filter.match(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// compare compares an attribute with a literal value.
+type compare struct {
+	path  attribute.Path
+	op    string
+	value any // string, float64, bool or nil.
+}
+
+func (e *compare) match(attrs map[string]any) bool {
+	if e.op == "ne" {
+		return !(&compare{path: e.path, op: "eq", value: e.value}).match(attrs)
+	}
+
+	val, ok := e.path.Get(attrs)
+	if e.value == nil {
+		return e.op == "eq" && (!ok || empty(val))
+	}
+	if !ok {
+		return false
+	}
+
+	items, ok := val.([]any)
+	if !ok {
+		items = []any{val}
+	}
+	for _, item := range items {
+		if m, ok := item.(map[string]any); ok {
+			item = m["value"]
+		}
+		if compareValue(e.op, item, e.value) {
+			return true
+		}
+	}
+	return false
+}
+
+// compareValue applies a comparison operator other than ne to an attribute
+// value and a literal.
+func compareValue(op string, actual, literal any) bool {
+	switch want := literal.(type) {
+	case string:
+		got, ok := actual.(string)
+		if !ok {
+			return false
+		}
+		if gotTime, err := time.Parse(time.RFC3339Nano, got); err == nil {
+			if wantTime, err := time.Parse(time.RFC3339Nano, want); err == nil {
+				return order(op, gotTime.Compare(wantTime))
+			}
+		}
+
+		got, want = strings.ToLower(got), strings.ToLower(want)
+		switch op {
+		case "co":
+			return strings.Contains(got, want)
+		case "sw":
+			return strings.HasPrefix(got, want)
+		case "ew":
+			return strings.HasSuffix(got, want)
+		default:
+			return order(op, strings.Compare(got, want))
+		}
+
+	case float64:
+		got, ok := actual.(float64)
+		if !ok {
+			return false
+		}
+		switch {
+		case got < want:
+			return order(op, -1)
+		case got > want:
+			return order(op, 1)
+		default:
+			return order(op, 0)
+		}
+
+	case bool:
+		got, ok := actual.(bool)
+		return ok && op == "eq" && got == want
+
+	default:
+		return false
+	}
+}
+
+// order reports whether the result of comparing an attribute value with a
+// literal satisfies an ordering operator.
+func order(op string, cmp int) bool {
+	switch op {
+	case "eq":
+		return cmp == 0
+	case "gt":
+		return cmp > 0
+	case "ge":
+		return cmp >= 0
+	case "lt":
+		return cmp < 0
+	case "le":
+		return cmp <= 0
+	default:
+		return false
+	}
+}
+
+// empty reports whether a value counts as absent: null, an empty string, an
+// empty list or an empty complex value.
+func empty(val any) bool {
+	switch v := val.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case []any:
+		return len(v) == 0
+	case map[string]any:
+		return len(v) == 0
+	default:
+		return false
+	}
+}
+
+// operators are the comparison operators taking a value.
+var operators = map[string]bool{
+	"eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+	"gt": true, "ge": true, "lt": true, "le": true,
+}
+
+// tokenKind is the kind of a filter token.
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenOpen
+	tokenClose
+	tokenOpenBracket
+	tokenCloseBracket
+)
+
+// token is a lexical token of a filter. Words are attribute paths, operators
+// and literals other than strings.
+type token struct {
+	kind tokenKind
+	text string // Text of words, decoded value of strings.
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of filter"
+	case tokenString:
+		return strconv.Quote(t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+// lex splits a filter into tokens.
+func lex(filter string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(filter); {
+		switch c := filter[i]; c {
+		case ' ', '\t', '\n', '\r':
+			i++
+		case '(':
+			tokens = append(tokens, token{kind: tokenOpen, text: "("})
+			i++
+		case ')':
+			tokens = append(tokens, token{kind: tokenClose, text: ")"})
+			i++
+		case '[':
+			tokens = append(tokens, token{kind: tokenOpenBracket, text: "["})
+			i++
+		case ']':
+			tokens = append(tokens, token{kind: tokenCloseBracket, text: "]"})
+			i++
+		case '"':
+			end := i + 1
+			for end < len(filter) && filter[end] != '"' {
+				if filter[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(filter) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+
+			var s string
+			if err := json.Unmarshal([]byte(filter[i:end+1]), &s); err != nil {
+				return nil, fmt.Errorf("invalid string at offset %d", i)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: s})
+			i = end + 1
+		default:
+			end := i
+			for end < len(filter) && !strings.ContainsRune(" \t\n\r()[]\"", rune(filter[end])) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: filter[i:end]})
+			i = end
+		}
+	}
+	return append(tokens, token{kind: tokenEOF}), nil
+}
+
+// parser parses filter tokens by recursive descent, with and binding tighter
+// than or.
+type parser struct {
+	tokens    []token
+	pos       int
+	valuePath bool // Whether the parser is within the brackets of a value path.
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// keyword reports whether the next token is the given keyword, consuming it
+// when it is.
+func (p *parser) keyword(word string) bool {
+	if t := p.peek(); t.kind == tokenWord && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(kind tokenKind, text string) error {
+	if t := p.next(); t.kind != kind {
+		return fmt.Errorf("expected %q, got %s", text, t)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (expr, error) {
+	if p.keyword("not") {
+		if err := p.expect(tokenOpen, "("); err != nil {
+			return nil, err
+		}
+		e, err := p.parseGroup()
+		if err != nil {
+			return nil, err
+		}
+		return &not{expr: e}, nil
+	}
+
+	switch t := p.next(); t.kind {
+	case tokenOpen:
+		return p.parseGroup()
+	case tokenWord:
+		return p.parseAttribute(t.text)
+	default:
+		return nil, fmt.Errorf("expected an attribute, got %s", t)
+	}
+}
+
+// parseGroup parses the expression following an opening parenthesis, up to
+// the closing one.
+func (p *parser) parseGroup() (expr, error) {
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(tokenClose, ")"); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// parseAttribute parses the expression on an attribute: a presence test, a
+// comparison or a value path.
+func (p *parser) parseAttribute(name string) (expr, error) {
+	path, err := attribute.ParsePath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek().kind == tokenOpenBracket {
+		if p.valuePath || path.Sub != "" {
+			return nil, fmt.Errorf("unexpected \"[\" after %q", name)
+		}
+		p.next()
+
+		p.valuePath = true
+		filter, err := p.parseOr()
+		p.valuePath = false
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokenCloseBracket, "]"); err != nil {
+			return nil, err
+		}
+		return &valuePath{path: path, filter: filter}, nil
+	}
+
+	t := p.next()
+	op := strings.ToLower(t.text)
+	switch {
+	case t.kind == tokenWord && op == "pr":
+		return &present{path: path}, nil
+	case t.kind != tokenWord || !operators[op]:
+		return nil, fmt.Errorf("expected an operator after %q, got %s", name, t)
+	}
+
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	return &compare{path: path, op: op, value: value}, nil
+}
+
+// parseValue parses the literal a comparison compares with.
+func (p *parser) parseValue() (any, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString:
+		return t.text, nil
+	case tokenWord:
+		switch t.text {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		if n, err := strconv.ParseFloat(t.text, 64); err == nil {
+			return n, nil
+		}
+	}
+	return nil, fmt.Errorf("expected a value, got %s", t)
+}
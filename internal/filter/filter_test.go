@@ -0,0 +1,126 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/iamBelugaa/scim-gateway/internal/schema"
+)
+
+// newUser returns the attributes of a user with core, complex, multi-valued
+// and extension attributes.
+func newUser() map[string]any {
+	return map[string]any{
+		"userName": "bjensen",
+		"active":   true,
+		"userType": "Employee",
+		"name":     map[string]any{"givenName": "Barbara", "familyName": "Jensen"},
+		"emails": []any{
+			map[string]any{"value": "bjensen@example.com", "type": "work"},
+			map[string]any{"value": "babs@jensen.org", "type": "home"},
+		},
+		"meta":                   map[string]any{"lastModified": "2026-03-01T12:00:00Z"},
+		"loginCount":             float64(12),
+		schema.URIEnterpriseUser: map[string]any{"department": "Engineering"},
+	}
+}
+
+// TestMatches matches a user against filters using every operator.
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{filter: `userName eq "BJensen"`, want: true},
+		{filter: `userName ne "bjensen"`, want: false},
+		{filter: `userName co "jen"`, want: true},
+		{filter: `userName sw "bj"`, want: true},
+		{filter: `userName ew "sen"`, want: true},
+		{filter: `userName sw "jen"`, want: false},
+		{filter: `name.familyName eq "Jensen"`, want: true},
+		{filter: `title pr`, want: false},
+		{filter: `title eq null`, want: true},
+		{filter: `title ne "Manager"`, want: true},
+		{filter: `title eq "Manager"`, want: false},
+		{filter: `active eq true`, want: true},
+		{filter: `active eq false`, want: false},
+		{filter: `loginCount gt 10`, want: true},
+		{filter: `loginCount le 11.5`, want: false},
+		{filter: `meta.lastModified gt "2026-03-01T11:00:00+00:00"`, want: true},
+		{filter: `meta.lastModified lt "2026-03-01T11:00:00Z"`, want: false},
+		{filter: `emails co "jensen.org"`, want: true},
+		{filter: `emails.type eq "home"`, want: true},
+		{filter: `emails[type eq "work" and value ew "example.com"]`, want: true},
+		{filter: `emails[type eq "home" and value ew "example.com"]`, want: false},
+		{filter: schema.URIEnterpriseUser + `:department eq "Engineering" and active eq true`, want: true},
+		{filter: `userType eq "Contractor" or name.givenName sw "barb"`, want: true},
+		{filter: `userType eq "Contractor" or active eq true and loginCount lt 5`, want: false},
+		{filter: `(userType eq "Contractor" or active eq true) and loginCount gt 5`, want: true},
+		{filter: `not (userType eq "Employee")`, want: false},
+		{filter: `USERNAME EQ "bjensen" AND NOT (active eq false)`, want: true},
+	}
+
+	user := newUser()
+	for _, tt := range tests {
+		f, err := Parse(tt.filter)
+		if err != nil {
+			t.Errorf("%s : unexpected error %v", tt.filter, err)
+			continue
+		}
+		if got := f.Matches(user); got != tt.want {
+			t.Errorf("%s : expected %v, got %v", tt.filter, tt.want, got)
+		}
+	}
+}
+
+// TestEquality checks which filters are reported as a single equality test.
+func TestEquality(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   string
+		ok     bool
+	}{
+		{filter: `groups.value eq "admins"`, want: "groups.value", ok: true},
+		{filter: `groups[value eq "admins"]`, want: "groups.value", ok: true},
+		{filter: `userName eq "bjensen"`, want: "userName", ok: true},
+		{filter: `groups.value ne "admins"`},
+		{filter: `loginCount eq 12`},
+		{filter: `groups[value eq "admins" and type eq "direct"]`},
+		{filter: `groups.value eq "admins" or userName eq "bjensen"`},
+	}
+
+	for _, tt := range tests {
+		f, err := Parse(tt.filter)
+		if err != nil {
+			t.Errorf("%s : unexpected error %v", tt.filter, err)
+			continue
+		}
+		path, _, ok := f.Equality()
+		if ok != tt.ok || (ok && path.String() != tt.want) {
+			t.Errorf("%s : expected %q %v, got %q %v", tt.filter, tt.want, tt.ok, path.String(), ok)
+		}
+	}
+}
+
+// TestParseErrors checks malformed filters are rejected.
+func TestParseErrors(t *testing.T) {
+	filters := []string{
+		``,
+		`userName`,
+		`userName eq`,
+		`userName is "bjensen"`,
+		`userName eq bjensen`,
+		`userName eq "bjensen`,
+		`(userName eq "bjensen"`,
+		`userName eq "bjensen" active eq true`,
+		`not userName eq "bjensen"`,
+		`emails[type eq "work"`,
+		`emails[type[value eq "x"]]`,
+		`name.givenName[value eq "x"]`,
+	}
+
+	for _, filter := range filters {
+		if _, err := Parse(filter); err == nil {
+			t.Errorf("%s : expected an error", filter)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+// Package lock provides locks shared by the packages of the gateway.
+package lock
+
+import "sync"
+
+// Keyed hands out one mutex per key, so callers using the same key are
+// serialized without blocking callers using other keys. The zero value is
+// ready to use.
+type Keyed[K comparable] struct {
+	mu    sync.Mutex
+	locks map[K]*refMutex
+}
+
+// refMutex is a mutex that counts the callers holding or waiting for it, so
+// it can be dropped once nobody needs it.
+type refMutex struct {
+	sync.Mutex
+	refs int
+}
+
+// Lock acquires the mutex for k and returns the function that releases it.
+func (km *Keyed[K]) Lock(k K) func() {
+	km.mu.Lock()
+	if km.locks == nil {
+		km.locks = make(map[K]*refMutex)
+	}
+
+	l, ok := km.locks[k]
+	if !ok {
+		l = &refMutex{}
+		km.locks[k] = l
+	}
+	l.refs++
+	km.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		km.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(km.locks, k)
+		}
+		km.mu.Unlock()
+	}
+}
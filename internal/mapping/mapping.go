@@ -0,0 +1,209 @@
+// Package mapping reshapes SCIM resource attributes through declarative
+// rules, such as joining the given and family names into a login or looking
+// a department code up from a table. Rules read source attributes and write
+// their target by SCIM attribute path, passing the value through a chain of
+// transforms.
+package mapping
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/iamBelugaa/scim-gateway/internal/attribute"
+)
+
+// Rule defines how a single target attribute is derived from source
+// attributes, as written in a YAML or JSON mapping.
+type Rule struct {
+	Target     string      `yaml:"target"`     // Attribute path the result is written to.
+	Source     Sources     `yaml:"source"`     // Attribute path, or list of paths, the value is read from.
+	Transforms []Transform `yaml:"transforms"` // Transforms applied to the value in order.
+}
+
+// Sources are the source attribute paths of a rule, written either as a
+// single path or as a list of paths.
+type Sources []string
+
+// UnmarshalYAML decodes a single path or a list of paths.
+func (s *Sources) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*s = Sources{node.Value}
+		return nil
+	}
+
+	var paths []string
+	if err := node.Decode(&paths); err != nil {
+		return err
+	}
+	*s = paths
+	return nil
+}
+
+// Transform is a single step of a rule, written either as the name of a
+// transform without options, such as "lower", or as a map from the name of
+// the transform to its options, such as {"concat": {"separator": "."}}.
+type Transform struct {
+	Name    string
+	Options yaml.Node
+}
+
+// UnmarshalYAML decodes the name and options of a transform.
+func (t *Transform) UnmarshalYAML(node *yaml.Node) error {
+	switch {
+	case node.Kind == yaml.ScalarNode:
+		t.Name = node.Value
+		return nil
+	case node.Kind == yaml.MappingNode && len(node.Content) == 2:
+		t.Name = node.Content[0].Value
+		t.Options = *node.Content[1]
+		return nil
+	default:
+		return fmt.Errorf("line %d : transform must be a name or a map holding a single name", node.Line)
+	}
+}
+
+// Mapping is a parsed list of rules.
+type Mapping struct {
+	rules []*rule
+}
+
+// rule is a parsed Rule.
+type rule struct {
+	target     attribute.Path
+	sources    []attribute.Path
+	transforms []transformFunc
+}
+
+// New parses a list of rules.
+func New(rules []Rule) (*Mapping, error) {
+	m := &Mapping{}
+	for i, r := range rules {
+		parsed, err := parseRule(r)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d : %w", i+1, err)
+		}
+		m.rules = append(m.rules, parsed)
+	}
+	return m, nil
+}
+
+// Load reads a mapping from a YAML or JSON file of the form
+// {"mappings": [...]}.
+func Load(path string) (*Mapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Mappings []Rule `yaml:"mappings"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to decode mapping file : %w", err)
+	}
+	return New(file.Mappings)
+}
+
+// parseRule parses a single rule.
+func parseRule(r Rule) (*rule, error) {
+	if r.Target == "" {
+		return nil, errors.New("target is not set")
+	}
+	target, err := attribute.ParsePath(r.Target)
+	if err != nil {
+		return nil, fmt.Errorf("target : %w", err)
+	}
+
+	parsed := &rule{target: target}
+	for _, source := range r.Source {
+		path, err := attribute.ParsePath(source)
+		if err != nil {
+			return nil, fmt.Errorf("source : %w", err)
+		}
+		parsed.sources = append(parsed.sources, path)
+	}
+
+	for _, t := range r.Transforms {
+		newTransform, ok := transforms[t.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown transform %q", t.Name)
+		}
+		fn, err := newTransform(&t.Options)
+		if err != nil {
+			return nil, fmt.Errorf("transform %s : %w", t.Name, err)
+		}
+		parsed.transforms = append(parsed.transforms, fn)
+	}
+
+	if len(parsed.sources) == 0 && len(parsed.transforms) == 0 {
+		return nil, fmt.Errorf("target %s has neither a source nor transforms", r.Target)
+	}
+	return parsed, nil
+}
+
+// Targets returns the attribute paths the mapping writes, in rule order.
+func (m *Mapping) Targets() []attribute.Path {
+	targets := make([]attribute.Path, 0, len(m.rules))
+	for _, r := range m.rules {
+		targets = append(targets, r.target)
+	}
+	return targets
+}
+
+// Apply writes the target of every rule into attrs, in rule order, so rules
+// may read the targets of earlier rules. A rule producing no value removes
+// its target. A rule with a single source passes on the value of that
+// source, and a rule with several sources passes on the list of their
+// values, with missing values left nil.
+func (m *Mapping) Apply(attrs map[string]any) error {
+	for _, r := range m.rules {
+		var val any
+		switch len(r.sources) {
+		case 0:
+		case 1:
+			val, _ = r.sources[0].Get(attrs)
+		default:
+			values := make([]any, len(r.sources))
+			for i, source := range r.sources {
+				values[i], _ = source.Get(attrs)
+			}
+			val = values
+		}
+
+		var err error
+		for _, transform := range r.transforms {
+			if val, err = transform(val); err != nil {
+				return fmt.Errorf("target %s : %w", r.target, err)
+			}
+		}
+
+		if empty(val) {
+			r.target.Remove(attrs)
+			continue
+		}
+		r.target.Set(attrs, val)
+	}
+	return nil
+}
+
+// empty reports whether a value counts as missing.
+func empty(val any) bool {
+	switch v := val.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case []any:
+		for _, item := range v {
+			if !empty(item) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
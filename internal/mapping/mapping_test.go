@@ -0,0 +1,210 @@
+package mapping
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// parse parses a YAML list of rules.
+func parse(t *testing.T, text string) *Mapping {
+	t.Helper()
+
+	var rules []Rule
+	if err := yaml.Unmarshal([]byte(text), &rules); err != nil {
+		t.Fatalf("failed to decode rules : %v", err)
+	}
+	m, err := New(rules)
+	if err != nil {
+		t.Fatalf("failed to parse rules : %v", err)
+	}
+	return m
+}
+
+// sample returns the attributes of a sample user.
+func sample() map[string]any {
+	return map[string]any{
+		"userName": "Alice.Liddell@Example.com",
+		"name":     map[string]any{"givenName": "Alice", "familyName": "Liddell"},
+		"emails": []any{
+			map[string]any{"value": "Alice@Example.com", "primary": true},
+			map[string]any{"value": "ALICE@home.example"},
+		},
+		"title": "Engineer, Tour Guide",
+		"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": map[string]any{
+			"department":     "Engineering",
+			"employeeNumber": "EMP-00042",
+		},
+		"meta": map[string]any{"created": "2024-03-01T09:30:00Z"},
+	}
+}
+
+// TestApply checks every transform through the rules of a typical mapping.
+func TestApply(t *testing.T) {
+	m := parse(t, `
+- target: login
+  source: [name.givenName, name.familyName]
+  transforms:
+    - concat: {separator: "."}
+    - lower
+- target: emails.value
+  source: emails.value
+  transforms: [lower]
+- target: code
+  source: urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department
+  transforms:
+    - lookup: {table: {Engineering: ENG, Sales: SLS}, default: OTHER}
+- target: employeeId
+  source: urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:employeeNumber
+  transforms:
+    - regex: {pattern: '^EMP-0*([0-9]+)$'}
+- target: titles
+  source: title
+  transforms:
+    - split: {separator: ","}
+    - upper
+    - join: {separator: "|"}
+- target: nickName
+  source: nickName
+  transforms:
+    - default: {value: unknown}
+- target: hired
+  source: meta.created
+  transforms:
+    - date: {to: "02/01/2006"}
+- target: domain
+  source: userName
+  transforms:
+    - regex: {pattern: '.*@', replace: ''}
+    - lower
+- target: displayName
+  transforms:
+    - default: {value: constant}
+- target: urn:example:params:scim:schemas:extension:app:2.0:User:badge
+  source: employeeId
+`)
+
+	attrs := sample()
+	if err := m.Apply(attrs); err != nil {
+		t.Fatalf("failed to apply mapping : %v", err)
+	}
+
+	want := map[string]any{
+		"login":       "alice.liddell",
+		"code":        "ENG",
+		"employeeId":  "42",
+		"titles":      "ENGINEER|TOUR GUIDE",
+		"nickName":    "unknown",
+		"hired":       "01/03/2024",
+		"domain":      "example.com",
+		"displayName": "constant",
+		"urn:example:params:scim:schemas:extension:app:2.0:User": map[string]any{"badge": "42"},
+	}
+	for key, val := range want {
+		if !reflect.DeepEqual(attrs[key], val) {
+			t.Fatalf("expected %s to be %v, got %v", key, val, attrs[key])
+		}
+	}
+
+	emails := []any{
+		map[string]any{"value": "alice@example.com", "primary": true},
+		map[string]any{"value": "alice@home.example"},
+	}
+	if !reflect.DeepEqual(attrs["emails"], emails) {
+		t.Fatalf("expected lowercased emails, got %v", attrs["emails"])
+	}
+}
+
+// TestApplyRemovesEmptyTargets checks rules producing no value remove their
+// target.
+func TestApplyRemovesEmptyTargets(t *testing.T) {
+	m := parse(t, `
+- target: title
+  source: title
+  transforms:
+    - regex: {pattern: '^Manager'}
+- target: code
+  source: urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department
+  transforms:
+    - lookup: {table: {Sales: SLS}}
+`)
+
+	attrs := sample()
+	attrs["code"] = "stale"
+	if err := m.Apply(attrs); err != nil {
+		t.Fatalf("failed to apply mapping : %v", err)
+	}
+	for _, key := range []string{"title", "code"} {
+		if val, ok := attrs[key]; ok {
+			t.Fatalf("expected %s to be removed, got %v", key, val)
+		}
+	}
+}
+
+// TestApplyFailsOnInvalidDates checks values a transform cannot handle fail
+// the mapping.
+func TestApplyFailsOnInvalidDates(t *testing.T) {
+	m := parse(t, `
+- target: hired
+  source: title
+  transforms:
+    - date: {from: DateOnly, to: RFC3339}
+`)
+
+	if err := m.Apply(sample()); err == nil || !strings.Contains(err.Error(), "hired") {
+		t.Fatalf("expected the date to fail with its target, got %v", err)
+	}
+}
+
+// TestLoad checks mappings are read from JSON files as well as YAML.
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mapping.json")
+	data := `{"mappings": [{"target": "login", "source": "userName", "transforms": ["lower", {"regex": {"pattern": "^[^@]+"}}]}]}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("failed to write mapping : %v", err)
+	}
+
+	m, err := Load(path)
+	if err != nil {
+		t.Fatalf("failed to load mapping : %v", err)
+	}
+	attrs := sample()
+	if err := m.Apply(attrs); err != nil {
+		t.Fatalf("failed to apply mapping : %v", err)
+	}
+	if attrs["login"] != "alice.liddell" {
+		t.Fatalf("expected the mapped login, got %v", attrs["login"])
+	}
+}
+
+// TestNewValidatesRules checks invalid rules are rejected.
+func TestNewValidatesRules(t *testing.T) {
+	tests := map[string]string{
+		"no target":         `[{source: userName}]`,
+		"invalid target":    `[{target: "a.b.c", source: userName}]`,
+		"nothing to map":    `[{target: login}]`,
+		"unknown transform": `[{target: login, source: userName, transforms: [reverse]}]`,
+		"invalid pattern":   `[{target: login, source: userName, transforms: [{regex: {pattern: "("}}]}]`,
+		"invalid group":     `[{target: login, source: userName, transforms: [{regex: {pattern: "a", group: 2}}]}]`,
+		"empty table":       `[{target: login, source: userName, transforms: [{lookup: {}}]}]`,
+		"no default value":  `[{target: login, source: userName, transforms: [default]}]`,
+		"no date layout":    `[{target: login, source: userName, transforms: [{date: {from: DateOnly}}]}]`,
+		"invalid options":   `[{target: login, source: userName, transforms: [{concat: [a]}]}]`,
+	}
+	for name, text := range tests {
+		t.Run(name, func(t *testing.T) {
+			var rules []Rule
+			err := yaml.Unmarshal([]byte(text), &rules)
+			if err == nil {
+				_, err = New(rules)
+			}
+			if err == nil {
+				t.Fatal("expected rules to be rejected")
+			}
+		})
+	}
+}
@@ -0,0 +1,327 @@
+package mapping
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// transformFunc transforms the value of a rule.
+type transformFunc func(val any) (any, error)
+
+// transforms holds the constructors of transforms keyed by name. Each
+// constructor decodes the options of the transform.
+var transforms = map[string]func(options *yaml.Node) (transformFunc, error){
+	"concat":  newConcat,
+	"lower":   newLower,
+	"upper":   newUpper,
+	"regex":   newRegex,
+	"lookup":  newLookup,
+	"default": newDefault,
+	"split":   newSplit,
+	"join":    newJoin,
+	"date":    newDate,
+}
+
+// dateLayouts are the names of the date layouts accepted besides Go
+// reference layouts.
+var dateLayouts = map[string]string{
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"RFC1123":     time.RFC1123,
+	"DateTime":    time.DateTime,
+	"DateOnly":    time.DateOnly,
+}
+
+// decode decodes the options of a transform, which may be omitted.
+func decode(options *yaml.Node, v any) error {
+	if options.Kind == 0 {
+		return nil
+	}
+	if err := options.Decode(v); err != nil {
+		return fmt.Errorf("invalid options : %w", err)
+	}
+	return nil
+}
+
+// newConcat returns a transform concatenating the values of several sources
+// with a separator, skipping missing values.
+func newConcat(options *yaml.Node) (transformFunc, error) {
+	var opts struct {
+		Separator string `yaml:"separator"`
+	}
+	if err := decode(options, &opts); err != nil {
+		return nil, err
+	}
+
+	return func(val any) (any, error) {
+		items, ok := val.([]any)
+		if !ok {
+			items = []any{val}
+		}
+
+		parts := make([]string, 0, len(items))
+		for _, item := range items {
+			if s, ok := text(item); ok && s != "" {
+				parts = append(parts, s)
+			}
+		}
+		if len(parts) == 0 {
+			return nil, nil
+		}
+		return strings.Join(parts, opts.Separator), nil
+	}, nil
+}
+
+// newLower returns a transform lowercasing text.
+func newLower(options *yaml.Node) (transformFunc, error) {
+	return eachText(func(s string) (any, error) { return strings.ToLower(s), nil }), nil
+}
+
+// newUpper returns a transform uppercasing text.
+func newUpper(options *yaml.Node) (transformFunc, error) {
+	return eachText(func(s string) (any, error) { return strings.ToUpper(s), nil }), nil
+}
+
+// newRegex returns a transform matching text against a regular expression.
+// With a replacement, every match is replaced, with $1 standing for the
+// first group. Otherwise the value becomes the given group of the first
+// match, by default the first group when the expression has one and the
+// whole match when it has none. Text that does not match has no value.
+func newRegex(options *yaml.Node) (transformFunc, error) {
+	var opts struct {
+		Pattern string  `yaml:"pattern"`
+		Replace *string `yaml:"replace"`
+		Group   *int    `yaml:"group"`
+	}
+	if err := decode(options, &opts); err != nil {
+		return nil, err
+	}
+	if opts.Pattern == "" {
+		return nil, errors.New("pattern is not set")
+	}
+	re, err := regexp.Compile(opts.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern : %w", err)
+	}
+
+	group := min(re.NumSubexp(), 1)
+	if opts.Group != nil {
+		group = *opts.Group
+	}
+	if group < 0 || group > re.NumSubexp() {
+		return nil, fmt.Errorf("group %d is not a group of the pattern", group)
+	}
+
+	return eachText(func(s string) (any, error) {
+		if opts.Replace != nil {
+			return re.ReplaceAllString(s, *opts.Replace), nil
+		}
+		match := re.FindStringSubmatch(s)
+		if match == nil {
+			return nil, nil
+		}
+		return match[group], nil
+	}), nil
+}
+
+// newLookup returns a transform replacing text by its entry in a table.
+// Text without an entry becomes the default when there is one, and has no
+// value otherwise.
+func newLookup(options *yaml.Node) (transformFunc, error) {
+	var opts struct {
+		Table   map[string]string `yaml:"table"`
+		Default *string           `yaml:"default"`
+	}
+	if err := decode(options, &opts); err != nil {
+		return nil, err
+	}
+	if len(opts.Table) == 0 {
+		return nil, errors.New("table is empty")
+	}
+
+	return eachText(func(s string) (any, error) {
+		if mapped, ok := opts.Table[s]; ok {
+			return mapped, nil
+		}
+		if opts.Default != nil {
+			return *opts.Default, nil
+		}
+		return nil, nil
+	}), nil
+}
+
+// newDefault returns a transform replacing a missing value by a fixed one.
+func newDefault(options *yaml.Node) (transformFunc, error) {
+	var opts struct {
+		Value any `yaml:"value"`
+	}
+	if err := decode(options, &opts); err != nil {
+		return nil, err
+	}
+	if opts.Value == nil {
+		return nil, errors.New("value is not set")
+	}
+
+	return func(val any) (any, error) {
+		if empty(val) {
+			return opts.Value, nil
+		}
+		return val, nil
+	}, nil
+}
+
+// newSplit returns a transform splitting text into a list on a separator,
+// "," by default. Empty parts are dropped.
+func newSplit(options *yaml.Node) (transformFunc, error) {
+	opts := struct {
+		Separator string `yaml:"separator"`
+	}{Separator: ","}
+	if err := decode(options, &opts); err != nil {
+		return nil, err
+	}
+	if opts.Separator == "" {
+		return nil, errors.New("separator is empty")
+	}
+
+	return func(val any) (any, error) {
+		s, ok := text(val)
+		if !ok {
+			return val, nil
+		}
+
+		parts := make([]any, 0)
+		for _, part := range strings.Split(s, opts.Separator) {
+			if part = strings.TrimSpace(part); part != "" {
+				parts = append(parts, part)
+			}
+		}
+		return parts, nil
+	}, nil
+}
+
+// newJoin returns a transform joining the elements of a list into text with
+// a separator, "," by default.
+func newJoin(options *yaml.Node) (transformFunc, error) {
+	opts := struct {
+		Separator string `yaml:"separator"`
+	}{Separator: ","}
+	if err := decode(options, &opts); err != nil {
+		return nil, err
+	}
+
+	return func(val any) (any, error) {
+		items, ok := val.([]any)
+		if !ok {
+			return val, nil
+		}
+
+		parts := make([]string, 0, len(items))
+		for _, item := range items {
+			if s, ok := text(item); ok {
+				parts = append(parts, s)
+			}
+		}
+		return strings.Join(parts, opts.Separator), nil
+	}, nil
+}
+
+// newDate returns a transform reformatting a date from one layout to
+// another. Layouts are Go reference layouts, such as "02/01/2006", or the
+// names RFC3339, RFC3339Nano, RFC1123, DateTime and DateOnly. Dates are read
+// as RFC3339 by default, and the output layout is required.
+func newDate(options *yaml.Node) (transformFunc, error) {
+	opts := struct {
+		From     string `yaml:"from"`
+		To       string `yaml:"to"`
+		Location string `yaml:"location"` // Time zone dates are converted to, such as "Europe/Paris". Dates keep their own zone when empty.
+	}{From: "RFC3339"}
+	if err := decode(options, &opts); err != nil {
+		return nil, err
+	}
+	if opts.To == "" {
+		return nil, errors.New("output layout 'to' is not set")
+	}
+
+	from, to := layout(opts.From), layout(opts.To)
+	var loc *time.Location
+	if opts.Location != "" {
+		var err error
+		if loc, err = time.LoadLocation(opts.Location); err != nil {
+			return nil, fmt.Errorf("invalid location : %w", err)
+		}
+	}
+
+	return eachText(func(s string) (any, error) {
+		t, err := time.Parse(from, s)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse date %q : %w", s, err)
+		}
+		if loc != nil {
+			t = t.In(loc)
+		}
+		return t.Format(to), nil
+	}), nil
+}
+
+// layout resolves the name of a date layout.
+func layout(name string) string {
+	if l, ok := dateLayouts[name]; ok {
+		return l
+	}
+	return name
+}
+
+// eachText returns a transform applying fn to text, or to every element of
+// a list. Elements fn gives no value are dropped, and values that are not
+// text are kept as they are.
+func eachText(fn func(s string) (any, error)) transformFunc {
+	apply := func(val any) (any, error) {
+		s, ok := text(val)
+		if !ok {
+			return val, nil
+		}
+		return fn(s)
+	}
+
+	return func(val any) (any, error) {
+		items, ok := val.([]any)
+		if !ok {
+			return apply(val)
+		}
+
+		result := make([]any, 0, len(items))
+		for _, item := range items {
+			transformed, err := apply(item)
+			if err != nil {
+				return nil, err
+			}
+			if transformed != nil {
+				result = append(result, transformed)
+			}
+		}
+		return result, nil
+	}
+}
+
+// text returns a scalar value as text. Numbers and booleans are formatted
+// as in JSON.
+func text(val any) (string, bool) {
+	switch v := val.(type) {
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case int:
+		return strconv.Itoa(v), true
+	default:
+		return "", false
+	}
+}
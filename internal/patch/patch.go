@@ -0,0 +1,300 @@
+// Package patch applies SCIM PATCH operations (RFC 7644 section 3.5.2) to the
+// attributes of a resource.
+package patch
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/iamBelugaa/scim-gateway/internal/attribute"
+	"github.com/iamBelugaa/scim-gateway/internal/filter"
+)
+
+// Errors an operation fails with, one per SCIM error type a PATCH request is
+// rejected with.
+var (
+	ErrInvalidSyntax = errors.New("invalid patch operation") // The operation is neither add, replace nor remove.
+	ErrInvalidPath   = errors.New("invalid patch path")      // The path cannot be parsed.
+	ErrNoTarget      = errors.New("patch target not found")  // The path selects nothing to operate on.
+	ErrInvalidValue  = errors.New("invalid patch value")     // The value does not fit the operation.
+)
+
+// Operation is a single operation of a PATCH request.
+type Operation struct {
+	Op    string // "add", "replace" or "remove", in any case.
+	Path  string // Attribute path, optionally selecting values with a filter. Empty to target the whole resource.
+	Value any    // Value to add or to replace with, or the values to remove.
+}
+
+// Apply applies operations in order to attrs, which is modified in place.
+// Callers apply them to a copy of the resource, so an operation failing
+// halfway leaves the resource untouched.
+//
+// Added values are merged into complex attributes and appended to
+// multi-valued attributes unless already present, comparing complex values
+// by their value sub-attribute. Removing values that are not present does
+// nothing, so retried removals succeed.
+func Apply(attrs map[string]any, ops []Operation) error {
+	for i, op := range ops {
+		if err := apply(attrs, op); err != nil {
+			return fmt.Errorf("operation %d : %w", i, err)
+		}
+	}
+	return nil
+}
+
+// apply applies a single operation.
+func apply(attrs map[string]any, op Operation) error {
+	kind := strings.ToLower(op.Op)
+	if kind != "add" && kind != "replace" && kind != "remove" {
+		return fmt.Errorf("%w : unknown op %q", ErrInvalidSyntax, op.Op)
+	}
+
+	if op.Path == "" {
+		if kind == "remove" {
+			return fmt.Errorf("%w : remove operations require a path", ErrNoTarget)
+		}
+		values, ok := op.Value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%w : operations without a path take an object of attributes", ErrInvalidValue)
+		}
+		for name, val := range values {
+			path, err := attribute.ParsePath(name)
+			if err != nil {
+				return fmt.Errorf("%w : %v", ErrInvalidPath, err)
+			}
+			set(attrs, path, clone(val), kind == "add")
+		}
+		return nil
+	}
+
+	t, err := parseTarget(op.Path)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case kind == "remove" && t.filter != nil:
+		removeSelected(attrs, t)
+	case kind == "remove" && op.Value != nil && t.path.Sub == "":
+		removeValues(attrs, t.path, op.Value)
+	case kind == "remove":
+		t.path.Remove(attrs)
+	case t.filter != nil:
+		return setSelected(attrs, t, op.Value, kind == "add")
+	default:
+		set(attrs, t.path, clone(op.Value), kind == "add")
+	}
+	return nil
+}
+
+// target is a parsed PATCH path: an attribute, or the values of a
+// multi-valued attribute a filter selects and optionally a sub-attribute of
+// those, as in `members[value eq "2819c223"]` or `emails[type eq "work"].value`.
+type target struct {
+	path   attribute.Path // Attribute addressed, without a sub-attribute when filtered.
+	filter *filter.Filter // Filter selecting values of the attribute, nil when unfiltered.
+	sub    string         // Sub-attribute of the selected values, empty for the whole values.
+}
+
+// parseTarget parses the path of an operation.
+func parseTarget(path string) (target, error) {
+	open := strings.Index(path, "[")
+	if open < 0 {
+		p, err := attribute.ParsePath(path)
+		if err != nil {
+			return target{}, fmt.Errorf("%w : %v", ErrInvalidPath, err)
+		}
+		return target{path: p}, nil
+	}
+
+	end := strings.LastIndex(path, "]")
+	if end < open {
+		return target{}, fmt.Errorf("%w : unterminated filter in %q", ErrInvalidPath, path)
+	}
+	p, err := attribute.ParsePath(path[:open])
+	if err != nil || p.Sub != "" {
+		return target{}, fmt.Errorf("%w : %q does not filter a multi-valued attribute", ErrInvalidPath, path)
+	}
+	f, err := filter.Parse(path[open+1 : end])
+	if err != nil {
+		return target{}, fmt.Errorf("%w : %v", ErrInvalidPath, err)
+	}
+
+	t := target{path: p, filter: f}
+	if rest := path[end+1:]; rest != "" {
+		t.sub = strings.TrimPrefix(rest, ".")
+		if t.sub == rest || t.sub == "" || strings.ContainsAny(t.sub, ".[]") {
+			return target{}, fmt.Errorf("%w : invalid sub-attribute in %q", ErrInvalidPath, path)
+		}
+	}
+	return t, nil
+}
+
+// set adds or replaces the value a path addresses. Objects are merged into
+// complex attributes, and added values are appended to multi-valued
+// attributes.
+func set(attrs map[string]any, path attribute.Path, val any, add bool) {
+	current, ok := path.Get(attrs)
+	if !ok || path.Sub != "" {
+		path.Set(attrs, val)
+		return
+	}
+
+	switch cur := current.(type) {
+	case []any:
+		if !add {
+			path.Set(attrs, val)
+			return
+		}
+		items, ok := val.([]any)
+		if !ok {
+			items = []any{val}
+		}
+		for _, item := range items {
+			if !contains(cur, item) {
+				cur = append(cur, item)
+			}
+		}
+		path.Set(attrs, cur)
+
+	case map[string]any:
+		values, ok := val.(map[string]any)
+		if !ok {
+			path.Set(attrs, val)
+			return
+		}
+		for name, sub := range values {
+			attribute.Path{Name: name}.Set(cur, sub)
+		}
+
+	default:
+		path.Set(attrs, val)
+	}
+}
+
+// setSelected adds or replaces the values of a multi-valued attribute a
+// filter selects, or a sub-attribute of them. Added objects are merged into
+// the selected values, replacing objects take their place.
+func setSelected(attrs map[string]any, t target, val any, add bool) error {
+	items, _ := get(attrs, t.path).([]any)
+
+	matched := 0
+	for i, item := range items {
+		m, ok := item.(map[string]any)
+		if !ok || !t.filter.Matches(m) {
+			continue
+		}
+		matched++
+
+		switch values, isObject := val.(map[string]any); {
+		case t.sub != "":
+			attribute.Path{Name: t.sub}.Set(m, clone(val))
+		case add && isObject:
+			for name, sub := range values {
+				attribute.Path{Name: name}.Set(m, clone(sub))
+			}
+		default:
+			items[i] = clone(val)
+		}
+	}
+
+	if matched == 0 {
+		return fmt.Errorf("%w : %s[%s] selects no value", ErrNoTarget, t.path, t.filter)
+	}
+	return nil
+}
+
+// removeSelected removes the values of a multi-valued attribute a filter
+// selects, or a sub-attribute of them.
+func removeSelected(attrs map[string]any, t target) {
+	items, ok := get(attrs, t.path).([]any)
+	if !ok {
+		return
+	}
+
+	kept := make([]any, 0, len(items))
+	for _, item := range items {
+		m, ok := item.(map[string]any)
+		switch {
+		case !ok || !t.filter.Matches(m):
+			kept = append(kept, item)
+		case t.sub != "":
+			attribute.Path{Name: t.sub}.Remove(m)
+			kept = append(kept, m)
+		}
+	}
+	t.path.Set(attrs, kept)
+}
+
+// removeValues removes the listed values from a multi-valued attribute, as
+// clients such as Entra ID do to remove group members. Attributes that are
+// not multi-valued are removed as a whole.
+func removeValues(attrs map[string]any, path attribute.Path, val any) {
+	items, ok := get(attrs, path).([]any)
+	if !ok {
+		path.Remove(attrs)
+		return
+	}
+
+	removed, ok := val.([]any)
+	if !ok {
+		removed = []any{val}
+	}
+	kept := make([]any, 0, len(items))
+	for _, item := range items {
+		if !contains(removed, item) {
+			kept = append(kept, item)
+		}
+	}
+	path.Set(attrs, kept)
+}
+
+// get returns the value of an attribute, nil when it is absent.
+func get(attrs map[string]any, path attribute.Path) any {
+	val, _ := path.Get(attrs)
+	return val
+}
+
+// contains reports whether items holds val. Complex values holding a value
+// sub-attribute are compared by it alone, so a member listed again with a
+// different display name is not added twice.
+func contains(items []any, val any) bool {
+	for _, item := range items {
+		a, aOK := item.(map[string]any)
+		b, bOK := val.(map[string]any)
+		if aOK && bOK && a["value"] != nil && b["value"] != nil {
+			if reflect.DeepEqual(a["value"], b["value"]) {
+				return true
+			}
+			continue
+		}
+		if reflect.DeepEqual(item, val) {
+			return true
+		}
+	}
+	return false
+}
+
+// clone returns a deep copy of a value decoded from JSON, so a value set in
+// several places can be changed in one without affecting the others.
+func clone(val any) any {
+	switch v := val.(type) {
+	case map[string]any:
+		copied := make(map[string]any, len(v))
+		for key, item := range v {
+			copied[key] = clone(item)
+		}
+		return copied
+	case []any:
+		copied := make([]any, len(v))
+		for i, item := range v {
+			copied[i] = clone(item)
+		}
+		return copied
+	default:
+		return v
+	}
+}
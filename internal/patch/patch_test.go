@@ -0,0 +1,118 @@
+package patch
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// newGroup returns the attributes of a group with two members.
+func newGroup() map[string]any {
+	return map[string]any{
+		"displayName": "Tour Guides",
+		"members": []any{
+			map[string]any{"value": "1", "display": "Barbara"},
+			map[string]any{"value": "2", "display": "Jim"},
+		},
+	}
+}
+
+// TestApply applies every kind of operation to a group.
+func TestApply(t *testing.T) {
+	member := func(id string) map[string]any { return map[string]any{"value": id} }
+	tests := []struct {
+		name string
+		op   Operation
+		want map[string]any
+	}{
+		{
+			name: "add members",
+			op:   Operation{Op: "Add", Path: "members", Value: []any{member("2"), member("3")}},
+			want: map[string]any{"displayName": "Tour Guides", "members": []any{
+				map[string]any{"value": "1", "display": "Barbara"},
+				map[string]any{"value": "2", "display": "Jim"},
+				member("3"),
+			}},
+		},
+		{
+			name: "replace attribute",
+			op:   Operation{Op: "replace", Path: "displayName", Value: "Guides"},
+			want: map[string]any{"displayName": "Guides", "members": newGroup()["members"]},
+		},
+		{
+			name: "add without path",
+			op:   Operation{Op: "add", Value: map[string]any{"externalId": "g1", "members": []any{member("3")}}},
+			want: map[string]any{"displayName": "Tour Guides", "externalId": "g1", "members": []any{
+				map[string]any{"value": "1", "display": "Barbara"},
+				map[string]any{"value": "2", "display": "Jim"},
+				member("3"),
+			}},
+		},
+		{
+			name: "replace filtered sub-attribute",
+			op:   Operation{Op: "replace", Path: `members[value eq "2"].display`, Value: "James"},
+			want: map[string]any{"displayName": "Tour Guides", "members": []any{
+				map[string]any{"value": "1", "display": "Barbara"},
+				map[string]any{"value": "2", "display": "James"},
+			}},
+		},
+		{
+			name: "remove filtered member",
+			op:   Operation{Op: "remove", Path: `members[value eq "1"]`},
+			want: map[string]any{"displayName": "Tour Guides", "members": []any{
+				map[string]any{"value": "2", "display": "Jim"},
+			}},
+		},
+		{
+			name: "remove absent member",
+			op:   Operation{Op: "remove", Path: `members[value eq "9"]`},
+			want: newGroup(),
+		},
+		{
+			name: "remove listed members",
+			op:   Operation{Op: "remove", Path: "members", Value: []any{member("2")}},
+			want: map[string]any{"displayName": "Tour Guides", "members": []any{
+				map[string]any{"value": "1", "display": "Barbara"},
+			}},
+		},
+		{
+			name: "remove attribute",
+			op:   Operation{Op: "remove", Path: "members"},
+			want: map[string]any{"displayName": "Tour Guides"},
+		},
+	}
+
+	for _, tt := range tests {
+		attrs := newGroup()
+		if err := Apply(attrs, []Operation{tt.op}); err != nil {
+			t.Errorf("%s : unexpected error %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(attrs, tt.want) {
+			t.Errorf("%s : got %v, want %v", tt.name, attrs, tt.want)
+		}
+	}
+}
+
+// TestApplyErrors checks malformed operations and operations without a target
+// are rejected with the error matching their SCIM error type.
+func TestApplyErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		op   Operation
+		want error
+	}{
+		{name: "unknown op", op: Operation{Op: "move", Path: "displayName"}, want: ErrInvalidSyntax},
+		{name: "malformed filter", op: Operation{Op: "remove", Path: `members[value eq]`}, want: ErrInvalidPath},
+		{name: "unterminated filter", op: Operation{Op: "remove", Path: `members[value eq "1"`}, want: ErrInvalidPath},
+		{name: "remove without path", op: Operation{Op: "remove"}, want: ErrNoTarget},
+		{name: "replace unmatched", op: Operation{Op: "replace", Path: `members[value eq "9"].display`, Value: "x"}, want: ErrNoTarget},
+		{name: "add without object", op: Operation{Op: "add", Value: "x"}, want: ErrInvalidValue},
+	}
+
+	for _, tt := range tests {
+		if err := Apply(newGroup(), []Operation{tt.op}); !errors.Is(err, tt.want) {
+			t.Errorf("%s : got error %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
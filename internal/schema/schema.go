@@ -0,0 +1,266 @@
+// Package schema holds the SCIM schema and resource type definitions the
+// gateway can serve (RFC 7643 sections 4 to 7).
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Schema URIs of the built in resource schemas.
+const (
+	URIUser           = "urn:ietf:params:scim:schemas:core:2.0:User"
+	URIEnterpriseUser = "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"
+	URIGroup          = "urn:ietf:params:scim:schemas:core:2.0:Group"
+)
+
+// Attribute describes a top level attribute of a schema.
+type Attribute struct {
+	Name            string   // Name of the attribute.
+	Type            string   // Data type, e.g. "string", "boolean" or "complex".
+	MultiValued     bool     // Whether the attribute holds a list of values.
+	Description     string   // Human readable description.
+	Required        bool     // Whether the attribute must be present.
+	CaseExact       bool     // Whether string comparisons are case sensitive.
+	Mutability      string   // One of readOnly, readWrite, immutable or writeOnly.
+	Returned        string   // One of always, never, default or request.
+	Uniqueness      string   // One of none, server or global.
+	CanonicalValues []string // Suggested values of the attribute.
+	ReferenceTypes  []string // Resource types a reference attribute may point to.
+}
+
+// Schema describes a SCIM schema.
+type Schema struct {
+	ID          string      // Schema URI.
+	Name        string      // Human readable name.
+	Description string      // Human readable description.
+	Attributes  []Attribute // Top level attributes.
+}
+
+// Extension is a schema extension of a resource type.
+type Extension struct {
+	Schema   string // Schema URI of the extension.
+	Required bool   // Whether resources must include the extension.
+}
+
+// ResourceType describes a SCIM resource type.
+type ResourceType struct {
+	ID          string      // Identifier of the resource type, e.g. "User".
+	Name        string      // Name of the resource type.
+	Endpoint    string      // Endpoint relative to the SCIM base URL, e.g. "/Users".
+	Description string      // Human readable description.
+	Schema      string      // URI of the core schema.
+	Extensions  []Extension // Schema extensions of the resource type.
+}
+
+// builtin holds every schema the gateway knows, keyed by URI.
+var builtin = map[string]*Schema{
+	URIUser: {
+		ID:          URIUser,
+		Name:        "User",
+		Description: "User Account",
+		Attributes: []Attribute{
+			{Name: "userName", Type: "string", Description: "Unique identifier for the User, typically used to directly authenticate to the service provider", Required: true, Mutability: "readWrite", Returned: "default", Uniqueness: "server"},
+			{Name: "name", Type: "complex", Description: "The components of the user's real name", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+			{Name: "displayName", Type: "string", Description: "The name of the User, suitable for display to end-users", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+			{Name: "nickName", Type: "string", Description: "The casual way to address the user in real life", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+			{Name: "profileUrl", Type: "reference", Description: "A fully qualified URL pointing to a page representing the User's online profile", Mutability: "readWrite", Returned: "default", Uniqueness: "none", ReferenceTypes: []string{"external"}},
+			{Name: "title", Type: "string", Description: "The user's title, such as \"Vice President\"", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+			{Name: "userType", Type: "string", Description: "Used to identify the relationship between the organization and the user", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+			{Name: "preferredLanguage", Type: "string", Description: "Indicates the User's preferred written or spoken language", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+			{Name: "locale", Type: "string", Description: "Used to indicate the User's default location for purposes of localizing items such as currency and date time format", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+			{Name: "timezone", Type: "string", Description: "The User's time zone in the 'Olson' time zone database format", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+			{Name: "active", Type: "boolean", Description: "A Boolean value indicating the User's administrative status", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+			{Name: "password", Type: "string", Description: "The User's cleartext password, used to set or compare the password", Mutability: "writeOnly", Returned: "never", Uniqueness: "none"},
+			{Name: "emails", Type: "complex", MultiValued: true, Description: "Email addresses for the user", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+			{Name: "phoneNumbers", Type: "complex", MultiValued: true, Description: "Phone numbers for the User", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+			{Name: "ims", Type: "complex", MultiValued: true, Description: "Instant messaging addresses for the User", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+			{Name: "photos", Type: "complex", MultiValued: true, Description: "URLs of photos of the User", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+			{Name: "addresses", Type: "complex", MultiValued: true, Description: "A physical mailing address for this User", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+			{Name: "groups", Type: "complex", MultiValued: true, Description: "A list of groups to which the user belongs, either through direct membership or through nested groups", Mutability: "readOnly", Returned: "default", Uniqueness: "none"},
+			{Name: "entitlements", Type: "complex", MultiValued: true, Description: "A list of entitlements for the User that represent a thing the User has", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+			{Name: "roles", Type: "complex", MultiValued: true, Description: "A list of roles for the User that collectively represent who the User is", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+			{Name: "x509Certificates", Type: "complex", MultiValued: true, Description: "A list of certificates issued to the User", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+		},
+	},
+	URIEnterpriseUser: {
+		ID:          URIEnterpriseUser,
+		Name:        "EnterpriseUser",
+		Description: "Enterprise User",
+		Attributes: []Attribute{
+			{Name: "employeeNumber", Type: "string", Description: "Numeric or alphanumeric identifier assigned to a person", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+			{Name: "costCenter", Type: "string", Description: "Identifies the name of a cost center", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+			{Name: "organization", Type: "string", Description: "Identifies the name of an organization", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+			{Name: "division", Type: "string", Description: "Identifies the name of a division", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+			{Name: "department", Type: "string", Description: "Identifies the name of a department", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+			{Name: "manager", Type: "complex", Description: "The User's manager", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+		},
+	},
+	URIGroup: {
+		ID:          URIGroup,
+		Name:        "Group",
+		Description: "Group",
+		Attributes: []Attribute{
+			{Name: "displayName", Type: "string", Description: "A human-readable name for the Group", Required: true, Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+			{Name: "members", Type: "complex", MultiValued: true, Description: "A list of members of the Group", Mutability: "readWrite", Returned: "default", Uniqueness: "none", ReferenceTypes: []string{"User", "Group"}},
+		},
+	},
+}
+
+// resourceTypes holds the built in resource types in the order they are
+// listed.
+var resourceTypes = []ResourceType{
+	{
+		ID:          "User",
+		Name:        "User",
+		Endpoint:    "/Users",
+		Description: "User Account",
+		Schema:      URIUser,
+		Extensions:  []Extension{{Schema: URIEnterpriseUser}},
+	},
+	{
+		ID:          "Group",
+		Name:        "Group",
+		Endpoint:    "/Groups",
+		Description: "Group",
+		Schema:      URIGroup,
+	},
+}
+
+// Lookup returns the built in schema with the given URI.
+func Lookup(uri string) (*Schema, bool) {
+	s, ok := builtin[uri]
+	return s, ok
+}
+
+// URIs returns the URIs of every built in schema.
+func URIs() []string {
+	return []string{URIUser, URIEnterpriseUser, URIGroup}
+}
+
+// Set is the set of schemas served to a tenant.
+type Set struct {
+	uris []string
+}
+
+// NewSet builds a schema set from schema URIs. Every URI must refer to a
+// built in schema.
+func NewSet(uris []string) (*Set, bool) {
+	for _, uri := range uris {
+		if _, ok := builtin[uri]; !ok {
+			return nil, false
+		}
+	}
+	return &Set{uris: uris}, true
+}
+
+// Schemas returns the schemas of the set in the order they were configured.
+func (s *Set) Schemas() []*Schema {
+	schemas := make([]*Schema, len(s.uris))
+	for i, uri := range s.uris {
+		schemas[i] = builtin[uri]
+	}
+	return schemas
+}
+
+// Get returns the schema with the given URI when it belongs to the set.
+func (s *Set) Get(uri string) (*Schema, bool) {
+	for _, member := range s.uris {
+		if member == uri {
+			return builtin[uri], true
+		}
+	}
+	return nil, false
+}
+
+// ResourceTypes returns the resource types whose core schema belongs to the
+// set. Extensions missing from the set are left out.
+func (s *Set) ResourceTypes() []ResourceType {
+	var types []ResourceType
+	for _, rt := range resourceTypes {
+		if _, ok := s.Get(rt.Schema); !ok {
+			continue
+		}
+
+		var extensions []Extension
+		for _, ext := range rt.Extensions {
+			if _, ok := s.Get(ext.Schema); ok {
+				extensions = append(extensions, ext)
+			}
+		}
+		rt.Extensions = extensions
+		types = append(types, rt)
+	}
+	return types
+}
+
+// ErrInvalid is returned when a resource does not conform to its schemas.
+var ErrInvalid = errors.New("invalid resource")
+
+// Validate checks the attributes of a resource of the given type against the
+// core schema of the type: the schema must belong to the set, required
+// attributes must be present, and the attributes it declares must hold values
+// of their declared type. Attributes the schema does not declare, such as
+// extension attributes keyed by schema URI, are left unchecked.
+func (s *Set) Validate(resourceType string, attrs map[string]any) error {
+	var core *Schema
+	for _, rt := range s.ResourceTypes() {
+		if rt.ID == resourceType {
+			core = builtin[rt.Schema]
+		}
+	}
+	if core == nil {
+		return fmt.Errorf("resource type %q is not served : %w", resourceType, ErrInvalid)
+	}
+
+	for _, attr := range core.Attributes {
+		val, ok := attrs[attr.Name]
+		if !ok || val == nil {
+			if attr.Required {
+				return fmt.Errorf("attribute %q is required : %w", attr.Name, ErrInvalid)
+			}
+			continue
+		}
+
+		if attr.MultiValued {
+			items, ok := val.([]any)
+			if !ok {
+				return fmt.Errorf("attribute %q must be a list : %w", attr.Name, ErrInvalid)
+			}
+			for _, item := range items {
+				if !hasType(attr.Type, item) {
+					return fmt.Errorf("values of attribute %q must be of type %s : %w", attr.Name, attr.Type, ErrInvalid)
+				}
+			}
+			continue
+		}
+		if !hasType(attr.Type, val) {
+			return fmt.Errorf("attribute %q must be of type %s : %w", attr.Name, attr.Type, ErrInvalid)
+		}
+		if str, ok := val.(string); ok && attr.Required && strings.TrimSpace(str) == "" {
+			return fmt.Errorf("attribute %q is required : %w", attr.Name, ErrInvalid)
+		}
+	}
+	return nil
+}
+
+// hasType reports whether a value decoded from JSON is of a SCIM data type.
+func hasType(dataType string, val any) bool {
+	switch dataType {
+	case "string", "reference", "binary", "dateTime":
+		_, ok := val.(string)
+		return ok
+	case "boolean":
+		_, ok := val.(bool)
+		return ok
+	case "integer", "decimal":
+		_, ok := val.(float64)
+		return ok
+	case "complex":
+		_, ok := val.(map[string]any)
+		return ok
+	default:
+		return true
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	goahttp "goa.design/goa/v3/http"
+)
+
+// csvContentType is the content type of CSV exports.
+const csvContentType = "text/csv"
+
+// responseEncoder extends the Goa response encoder with CSV, which Goa would
+// otherwise encode as JSON. CSV responses carry their content as a string,
+// written as is.
+func responseEncoder(ctx context.Context, w http.ResponseWriter) goahttp.Encoder {
+	if ct, _ := ctx.Value(goahttp.ContentTypeKey).(string); ct == csvContentType {
+		w.Header().Set("Content-Type", csvContentType+"; charset=utf-8")
+		return &textEncoder{w: w}
+	}
+	return goahttp.ResponseEncoder(ctx, w)
+}
+
+// textEncoder writes string values as is.
+type textEncoder struct {
+	w io.Writer
+}
+
+func (e *textEncoder) Encode(v any) error {
+	s, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("cannot encode %T as text", v)
+	}
+	_, err := io.WriteString(e.w, s)
+	return err
+}
+
+// requestDecoder extends the Goa request decoder with JSON based media types
+// such as application/scim+json, which SCIM clients send and Goa would
+// otherwise reject as unsupported.
+func requestDecoder(r *http.Request) goahttp.Decoder {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && strings.HasSuffix(mediaType, "+json") {
+		return json.NewDecoder(r.Body)
+	}
+	return goahttp.RequestDecoder(r)
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iamBelugaa/scim-gateway/internal/auth"
+)
+
+// legacyAPIKeyHeader is the header the gateway originally required for
+// bearer credentials, before the standard Authorization header was accepted.
+const legacyAPIKeyHeader = "X-API-KEY"
+
+// withBearerCredentials normalizes the credentials of a request before they
+// reach the generated handlers. Credentials are read from the Authorization
+// header, falling back to the legacy X-API-KEY header, and must use the
+// Bearer scheme. Valid credentials are passed on in the Authorization header
+// and anything else is dropped, so the request is rejected as unauthenticated.
+func withBearerCredentials(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		value := r.Header.Get("Authorization")
+		if value == "" {
+			value = r.Header.Get(legacyAPIKeyHeader)
+		}
+
+		r.Header.Del("Authorization")
+		r.Header.Del(legacyAPIKeyHeader)
+
+		if token, err := auth.BearerToken(value); err == nil {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// unscopedSCIMPrefix is the path prefix of the SCIM API of the default tenant,
+// served before tenant scoped routes were introduced.
+const unscopedSCIMPrefix = "/scim/v2/"
+
+// withDefaultTenant routes requests to the unscoped SCIM API onto the tenant
+// scoped routes of the default tenant, so existing clients keep working.
+func withDefaultTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, unscopedSCIMPrefix) {
+			r.URL.Path = "/" + auth.DefaultTenant + r.URL.Path
+			if r.URL.RawPath != "" {
+				r.URL.RawPath = "/" + auth.DefaultTenant + r.URL.RawPath
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withClientIP records the address of the connecting client in the request
+// context, so credential use can be attributed to it. The gateway is expected
+// to be reached directly or through a proxy that preserves the connection
+// address, so forwarding headers are not trusted.
+func withClientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		next.ServeHTTP(w, r.WithContext(auth.WithClientIP(r.Context(), host)))
+	})
+}
+
+// withClientCertificate passes the verified TLS client certificate of the
+// connection on to the authenticator. Certificates are only present when the
+// handshake verified them against the configured client CAs.
+func withClientCertificate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+			r = r.WithContext(auth.WithClientCertificate(r.Context(), r.TLS.VerifiedChains[0][0]))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// errorSchema is the schema URI of SCIM error responses.
+const errorSchema = "urn:ietf:params:scim:api:messages:2.0:Error"
+
+// writeError writes a SCIM error response for requests rejected before they
+// reach the generated handlers.
+func writeError(w http.ResponseWriter, status int, detail string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"schemas": []string{errorSchema},
+		"status":  strconv.Itoa(status),
+		"detail":  detail,
+	})
+}
+
+// bulkPathSuffix ends the path of the SCIM Bulk endpoint of every tenant.
+const bulkPathSuffix = "/scim/v2/Bulk"
+
+// withBulkPayloadLimit rejects SCIM Bulk requests whose body is larger than
+// the advertised maxPayloadSize with 413, before they are decoded. Bodies
+// are read up to the limit, so a body sent without a Content-Length cannot
+// exceed it either.
+func withBulkPayloadLimit(maxSize int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, bulkPathSuffix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		tooLarge := fmt.Sprintf("bulk request body exceeds the maxPayloadSize of %d bytes", maxSize)
+		if r.ContentLength > maxSize {
+			writeError(w, http.StatusRequestEntityTooLarge, tooLarge)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxSize+1))
+		switch {
+		case err != nil:
+			writeError(w, http.StatusBadRequest, "failed to read bulk request body")
+			return
+		case int64(len(body)) > maxSize:
+			writeError(w, http.StatusRequestEntityTooLarge, tooLarge)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withAuthThrottling delays and rejects requests from sources of repeated
+// failed authentication attempts. A request failed authentication when it is
+// answered with 401. Locked out sources are answered with 429 and a
+// Retry-After header without reaching the handler.
+func withAuthThrottling(throttle *auth.Throttle, next http.Handler) http.Handler {
+	if !throttle.Enabled() {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := auth.ClientIPFrom(r.Context())
+
+		value := r.Header.Get("Authorization")
+		if value == "" {
+			value = r.Header.Get(legacyAPIKeyHeader)
+		}
+		token, _ := auth.BearerToken(value)
+
+		delay, retryAfter := throttle.Check(ip, token)
+		if retryAfter > 0 {
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			writeError(w, http.StatusTooManyRequests, "too many failed authentication attempts")
+			return
+		}
+
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-r.Context().Done():
+				timer.Stop()
+				return
+			}
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		if rec.status == http.StatusUnauthorized {
+			throttle.Failed(ip, token)
+		} else {
+			throttle.Succeeded(token)
+		}
+	})
+}
+
+// statusRecorder records the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code before writing it.
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap returns the underlying response writer, so response controllers can
+// reach it.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestBulkPayloadLimit sends Bulk requests around the payload limit, with
+// and without a Content-Length, and checks only those within it reach the
+// handler with their whole body.
+func TestBulkPayloadLimit(t *testing.T) {
+	tests := []struct {
+		name          string
+		path          string
+		body          string
+		contentLength bool
+		want          int
+	}{
+		{name: "within limit", path: "/default/scim/v2/Bulk", body: strings.Repeat("a", 16), contentLength: true, want: http.StatusOK},
+		{name: "over limit", path: "/default/scim/v2/Bulk", body: strings.Repeat("a", 17), contentLength: true, want: http.StatusRequestEntityTooLarge},
+		{name: "over limit chunked", path: "/default/scim/v2/Bulk", body: strings.Repeat("a", 17), want: http.StatusRequestEntityTooLarge},
+		{name: "other endpoint", path: "/default/scim/v2/Users", body: strings.Repeat("a", 17), contentLength: true, want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		handler := withBulkPayloadLimit(16, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if body, _ := io.ReadAll(r.Body); string(body) != tt.body {
+				t.Errorf("%s : got body %q, want %q", tt.name, body, tt.body)
+			}
+		}))
+
+		req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+		if !tt.contentLength {
+			req.ContentLength = -1
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s : got status %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+}
@@ -11,11 +11,22 @@ import (
 
 	goahttp "goa.design/goa/v3/http"
 
+	genadmin "github.com/iamBelugaa/scim-gateway/gen/admin"
+	genadminserver "github.com/iamBelugaa/scim-gateway/gen/http/admin/server"
 	genscimserver "github.com/iamBelugaa/scim-gateway/gen/http/scim/server"
 	genscim "github.com/iamBelugaa/scim-gateway/gen/scim"
 
+	"github.com/iamBelugaa/scim-gateway/internal/auth"
 	"github.com/iamBelugaa/scim-gateway/internal/config"
+	"github.com/iamBelugaa/scim-gateway/internal/connector"
+	"github.com/iamBelugaa/scim-gateway/internal/connector/ldap"
+	"github.com/iamBelugaa/scim-gateway/internal/connector/rest"
+	"github.com/iamBelugaa/scim-gateway/internal/connector/scim"
+	"github.com/iamBelugaa/scim-gateway/internal/services/adminsvc"
 	"github.com/iamBelugaa/scim-gateway/internal/services/scimsvc"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+	"github.com/iamBelugaa/scim-gateway/internal/tenant"
+	"github.com/iamBelugaa/scim-gateway/internal/upstream"
 	"github.com/iamBelugaa/scim-gateway/pkg/logger"
 )
 
@@ -26,44 +37,164 @@ type server struct {
 	log         *logger.Logger // Application logger
 	httpServer  *http.Server   // Underlying HTTP server
 	serverError chan error     // Channel for capturing async server errors
+
+	purgers    []*store.Purger       // Background jobs purging expired tombstones, one per tenant
+	outbox     *connector.Outbox     // Background job delivering store changes to connectors
+	reconciler *connector.Reconciler // Background job reconciling connectors on a schedule
+	syncers    []*upstream.Syncer    // Background jobs pulling from upstream SCIM servers
+	cancelJobs context.CancelFunc    // Stops background jobs on shutdown
 }
 
-func NewWithConfig(logger *logger.Logger, cfg *config.Config) *server {
+func NewWithConfig(logger *logger.Logger, cfg *config.Config) (*server, error) {
+	// Initialize the authenticator shared by all services.
+	authenticator, err := auth.NewWithConfig(logger, cfg.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct authenticator : %w", err)
+	}
+
+	// Initialize the tenants shared by all services, each with its own store.
+	tenants, err := tenant.NewWithConfig(cfg.Tenancy, cfg.Store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure tenants : %w", err)
+	}
+
+	// Initialize the connectors changes are provisioned to downstream.
+	connectors, err := connector.NewWithConfig(logger, cfg.Connectors, tenants, connector.Factories{
+		"log":     connector.NewLogFactory(logger),
+		ldap.Type: ldap.NewFactory(),
+		rest.Type: rest.NewFactory(),
+		scim.Type: scim.NewFactory(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure connectors : %w", err)
+	}
+	outbox, err := connector.NewOutbox(logger, connectors, tenants, cfg.Connectors)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure connector outbox : %w", err)
+	}
+	reconciler := connector.NewReconciler(logger, connectors, outbox, tenants, cfg.Connectors)
+
+	// Initialize the upstream SCIM servers resources are pulled from.
+	syncers, err := upstream.NewWithConfig(logger, cfg.Upstreams, tenants, connectors, cfg.Store.DryRun)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure upstreams : %w", err)
+	}
+
 	// Initialize scim service and endpoints.
-	scimsvc := scimsvc.NewService(logger)
+	scimsvc := scimsvc.NewService(logger, authenticator, tenants, connectors, cfg.Store, cfg.SCIM)
 	scimEndpoints := genscim.NewEndpoints(scimsvc)
+	scimEndpoints.Use(auth.DeclareOperations(scimsvc.Operation))
+
+	// Initialize admin service and endpoints.
+	adminsvc := adminsvc.NewService(logger, authenticator, tenants, connectors, outbox, reconciler, cfg.Store.DryRun)
+	adminEndpoints := genadmin.NewEndpoints(adminsvc)
+	adminEndpoints.Use(auth.DeclareOperations(adminsvc.Operation))
 
 	// Create Goa HTTP multiplexer.
 	mux := goahttp.NewMuxer()
 
-	// Setup and mount scim HTTP handlers.
-	scimHandlers := genscimserver.New(scimEndpoints, mux, goahttp.RequestDecoder, goahttp.ResponseEncoder, nil, nil)
+	// Setup and mount scim HTTP handlers. SCIM clients send resources as
+	// application/scim+json, which the request decoder adds.
+	scimHandlers := genscimserver.New(scimEndpoints, mux, requestDecoder, goahttp.ResponseEncoder, nil, nil)
 	genscimserver.Mount(mux, scimHandlers)
 
+	// Setup and mount admin HTTP handlers. Reconciliation drift is exported
+	// as CSV, which the response encoder adds.
+	adminHandlers := genadminserver.New(adminEndpoints, mux, goahttp.RequestDecoder, responseEncoder, nil, nil)
+	genadminserver.Mount(mux, adminHandlers)
+
 	// Log mounted scim endpoints.
 	for _, mount := range scimHandlers.Mounts {
 		log.Printf("%q mounted on %s %s", mount.Method, mount.Verb, mount.Pattern)
 	}
 
+	// Log mounted admin endpoints.
+	for _, mount := range adminHandlers.Mounts {
+		log.Printf("%q mounted on %s %s", mount.Method, mount.Verb, mount.Pattern)
+	}
+
+	// Route the OAuth token endpoint outside of the generated handlers, since
+	// it uses form encoded requests and its own client authentication.
+	handler := http.NewServeMux()
+	handler.Handle("/", withDefaultTenant(withBulkPayloadLimit(cfg.SCIM.BulkMaxPayloadSize, withBearerCredentials(mux))))
+	if issuer := authenticator.Issuer(); issuer != nil {
+		handler.HandleFunc("/oauth/token", issuer.ServeToken)
+		handler.HandleFunc("GET /oauth/jwks.json", issuer.ServeJWKS)
+		log.Printf("oauth token endpoint mounted on POST /oauth/token and GET /oauth/jwks.json")
+	}
+
+	// Slow down and lock out sources of repeated failed authentication
+	// attempts, across every API including the token endpoint.
+	throttle, err := auth.NewThrottle(logger, cfg.Throttle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure authentication throttling : %w", err)
+	}
+
+	tlsConfig, err := newTLSConfig(cfg.Server)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure tls : %w", err)
+	}
+
 	return &server{
 		cfg:         cfg,
 		log:         logger,
 		serverError: make(chan error, 1),
+		purgers:     newPurgers(logger, tenants, cfg.Store),
+		outbox:      outbox,
+		reconciler:  reconciler,
+		syncers:     syncers,
 		httpServer: &http.Server{
-			Handler:      mux,
+			Handler:      withClientIP(withClientCertificate(withAuthThrottling(throttle, handler))),
+			TLSConfig:    tlsConfig,
 			IdleTimeout:  cfg.Server.IdleTimeout,
 			ReadTimeout:  cfg.Server.ReadTimeout,
 			WriteTimeout: cfg.Server.WriteTimeout,
 			Addr:         fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port),
 		},
+	}, nil
+}
+
+// Handler returns the HTTP handler of the server, serving every API without
+// starting the server or its background jobs.
+func (s *server) Handler() http.Handler {
+	return s.httpServer.Handler
+}
+
+// newPurgers constructs a purge job for the store of every tenant.
+func newPurgers(log *logger.Logger, tenants *tenant.Registry, cfg *config.Store) []*store.Purger {
+	purgers := make([]*store.Purger, 0)
+	for _, t := range tenants.All() {
+		tenantLog := &logger.Logger{SugaredLogger: log.With("tenant", t.ID)}
+		purgers = append(purgers, store.NewPurger(tenantLog, t.Store, cfg))
 	}
+	return purgers
 }
 
-// ListenAndServe starts the HTTP server.
+// ListenAndServe starts the background jobs and the HTTP server.
 func (s *server) ListenAndServe() error {
+	jobsCtx, cancel := context.WithCancel(context.Background())
+	s.cancelJobs = cancel
+	for _, purger := range s.purgers {
+		go purger.Run(jobsCtx)
+	}
+	go s.outbox.Run(jobsCtx)
+	go s.reconciler.Run(jobsCtx)
+	for _, syncer := range s.syncers {
+		go syncer.Run(jobsCtx)
+	}
+
 	go func() {
-		s.log.Infow("starting scim http server", "address", fmt.Sprintf("%s:%d", s.cfg.Server.Host, s.cfg.Server.Port))
-		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		tls := s.httpServer.TLSConfig != nil
+		s.log.Infow("starting scim http server", "address", fmt.Sprintf("%s:%d", s.cfg.Server.Host, s.cfg.Server.Port), "tls", tls)
+
+		var err error
+		if tls {
+			// The certificate is already loaded into the TLS configuration.
+			err = s.httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = s.httpServer.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			s.serverError <- err
 		}
 	}()
@@ -76,6 +207,9 @@ func (s *server) Shutdown() error {
 	signalCh := make(chan os.Signal, 1)
 	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGTERM)
 
+	// Stop background jobs once the server stops.
+	defer s.cancelJobs()
+
 	select {
 	// Handle server startup error.
 	case err := <-s.serverError:
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/iamBelugaa/scim-gateway/internal/config"
+)
+
+// Client certificate modes of the TLS listener.
+const (
+	clientAuthRequire  = "require"
+	clientAuthOptional = "optional"
+)
+
+// newTLSConfig builds the TLS configuration of the listener. It returns nil
+// when TLS is disabled. When client CAs are configured the listener requests
+// client certificates and verifies them against those CAs, either rejecting
+// connections without one or leaving callers free to authenticate with a
+// bearer token instead.
+func newTLSConfig(cfg *config.Server) (*tls.Config, error) {
+	if cfg.TLSCertFile == "" && cfg.TLSKeyFile == "" {
+		if len(cfg.TLSClientCAFiles) > 0 {
+			return nil, errors.New("client certificate authentication requires a tls certificate and key")
+		}
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tls certificate : %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	if len(cfg.TLSClientCAFiles) == 0 {
+		return tlsConfig, nil
+	}
+
+	pool := x509.NewCertPool()
+	for _, path := range cfg.TLSClientCAFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client ca file : %w", err)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("client ca file %q contains no PEM encoded certificates", path)
+		}
+	}
+	tlsConfig.ClientCAs = pool
+
+	switch cfg.TLSClientAuth {
+	case clientAuthRequire:
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	case clientAuthOptional:
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return nil, fmt.Errorf("tls client auth must be %q or %q, got %q", clientAuthRequire, clientAuthOptional, cfg.TLSClientAuth)
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,352 @@
+package adminsvc
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"strconv"
+	"time"
+
+	"goa.design/goa/v3/security"
+
+	"github.com/iamBelugaa/scim-gateway/gen/admin"
+	"github.com/iamBelugaa/scim-gateway/internal/attribute"
+	"github.com/iamBelugaa/scim-gateway/internal/auth"
+	"github.com/iamBelugaa/scim-gateway/internal/connector"
+	"github.com/iamBelugaa/scim-gateway/internal/schema"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+	"github.com/iamBelugaa/scim-gateway/internal/tenant"
+	"github.com/iamBelugaa/scim-gateway/pkg/logger"
+)
+
+// errorSchema is the schema URI of SCIM error responses.
+const errorSchema = "urn:ietf:params:scim:api:messages:2.0:Error"
+
+type Service struct {
+	log        *logger.Logger
+	auth       *auth.Authenticator
+	tenants    *tenant.Registry
+	connectors *connector.Dispatcher
+	outbox     *connector.Outbox
+	reconciler *connector.Reconciler
+	dryRun     bool // Whether every write is only planned.
+}
+
+func NewService(
+	log *logger.Logger, authenticator *auth.Authenticator, tenants *tenant.Registry,
+	connectors *connector.Dispatcher, outbox *connector.Outbox, reconciler *connector.Reconciler, dryRun bool,
+) *Service {
+	return &Service{
+		log: log, auth: authenticator, tenants: tenants, connectors: connectors, outbox: outbox, reconciler: reconciler,
+		dryRun: dryRun,
+	}
+}
+
+// List every retained version of a User or Group, oldest first.
+func (s *Service) ListVersions(ctx context.Context, p *admin.ResourceRef) (*admin.ResourceVersionsResponse, error) {
+	t, err := s.tenant(ctx, p.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := t.Store.Versions(ctx, store.ResourceType(p.ResourceType), p.ID)
+	if err != nil {
+		return nil, toServiceError(err)
+	}
+
+	res := &admin.ResourceVersionsResponse{
+		TotalResults: len(versions),
+		Versions:     make([]*admin.StoredResource, len(versions)),
+	}
+	acl := s.auth.AttributeACL(ctx)
+	for i, version := range versions {
+		res.Versions[i] = s.toStoredResource(t, version, acl)
+	}
+	return res, nil
+}
+
+// Retrieve a specific retained version of a User or Group.
+func (s *Service) GetVersion(ctx context.Context, p *admin.GetVersionPayload) (*admin.StoredResource, error) {
+	t, err := s.tenant(ctx, p.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := t.Store.GetVersion(ctx, store.ResourceType(p.ResourceType), p.ID, p.Version)
+	if err != nil {
+		return nil, toServiceError(err)
+	}
+	return s.toStoredResource(t, version, s.auth.AttributeACL(ctx)), nil
+}
+
+// Retrieve a User or Group as it was at the given point in time.
+func (s *Service) GetAsOf(ctx context.Context, p *admin.GetAsOfPayload) (*admin.StoredResource, error) {
+	t, err := s.tenant(ctx, p.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The format is validated by the transport layer.
+	at, err := time.Parse(time.RFC3339, p.At)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := t.Store.GetAsOf(ctx, store.ResourceType(p.ResourceType), p.ID, at)
+	if err != nil {
+		return nil, toServiceError(err)
+	}
+	return s.toStoredResource(t, version, s.auth.AttributeACL(ctx)), nil
+}
+
+// Restore a prior version of a User or Group by writing it as a new version.
+func (s *Service) RestoreVersion(ctx context.Context, p *admin.RestoreVersionPayload) (*admin.StoredResource, error) {
+	t, err := s.tenant(ctx, p.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := t.Store.GetVersion(ctx, store.ResourceType(p.ResourceType), p.ID, p.Version)
+	if err != nil {
+		return nil, toServiceError(err)
+	}
+
+	// The restored attributes are written as a new version, so the version
+	// being replaced stays in the history and the restore can be undone. The
+	// caller's attribute ACL and the schemas of the tenant apply as they
+	// would to any other write.
+	acl := s.auth.AttributeACL(ctx)
+	st, dryRun := s.writeStore(t, p.DryRun)
+	restored, err := st.Update(ctx, version.Type, version.ID, func(res *store.Resource) error {
+		// The groups attribute is derived from group members rather than
+		// stored, so it is left out of both sides of the ACL check.
+		current := maps.Clone(res.Attributes)
+		delete(current, "groups")
+		attrs := version.Clone().Attributes
+		delete(attrs, "groups")
+		if err := acl.Write(current, attrs); err != nil {
+			return err
+		}
+		if err := t.Schemas.Validate(string(res.Type), attrs); err != nil {
+			return err
+		}
+		res.Attributes = attrs
+		return nil
+	})
+	if errors.Is(err, attribute.ErrProtected) {
+		s.log.Infow("rejected protected attribute change", "tenant", t.ID, "resourceType", version.Type, "id", version.ID, "error", err)
+		return nil, s.authError(err)
+	}
+	if errors.Is(err, schema.ErrInvalid) {
+		s.log.Infow("rejected invalid resource version", "tenant", t.ID, "resourceType", version.Type, "id", version.ID, "error", err)
+		return nil, admin.MakeInvalid(err)
+	}
+	if err != nil {
+		return nil, toServiceError(err)
+	}
+
+	if dryRun {
+		s.log.Infow(
+			"planned resource version restore",
+			"tenant", t.ID, "resourceType", restored.Type, "id", restored.ID, "restoredVersion", p.Version,
+		)
+		return s.toPlannedResource(ctx, t, st, restored, acl), nil
+	}
+
+	s.log.Infow(
+		"restored resource version",
+		"tenant", t.ID, "resourceType", restored.Type, "id", restored.ID,
+		"restoredVersion", p.Version, "version", restored.Version,
+	)
+	return s.toStoredResource(t, restored, acl), nil
+}
+
+// List soft deleted Users or Groups that can still be restored.
+func (s *Service) ListDeleted(ctx context.Context, p *admin.ListDeletedPayload) (*admin.DeletedResourcesResponse, error) {
+	t, err := s.tenant(ctx, p.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	tombstones, err := t.Store.ListDeleted(ctx, store.ResourceType(p.ResourceType))
+	if err != nil {
+		return nil, toServiceError(err)
+	}
+
+	res := &admin.DeletedResourcesResponse{
+		TotalResults: len(tombstones),
+		Resources:    make([]*admin.DeletedResource, len(tombstones)),
+	}
+	acl := s.auth.AttributeACL(ctx)
+	for i, tombstone := range tombstones {
+		res.Resources[i] = &admin.DeletedResource{
+			Resource:  s.toStoredResource(t, tombstone.Resource, acl),
+			DeletedAt: tombstone.DeletedAt.Format(time.RFC3339),
+			Groups:    tombstone.Groups,
+		}
+	}
+	return res, nil
+}
+
+// Restore a soft deleted User or Group, including its group memberships.
+func (s *Service) RestoreDeleted(ctx context.Context, p *admin.RestoreDeletedPayload) (*admin.StoredResource, error) {
+	t, err := s.tenant(ctx, p.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	st, dryRun := s.writeStore(t, p.DryRun)
+	restored, err := st.Undelete(ctx, store.ResourceType(p.ResourceType), p.ID)
+	if err != nil {
+		return nil, toServiceError(err)
+	}
+
+	if dryRun {
+		s.log.Infow("planned deleted resource restore", "tenant", t.ID, "resourceType", restored.Type, "id", restored.ID)
+		return s.toPlannedResource(ctx, t, st, restored, s.auth.AttributeACL(ctx)), nil
+	}
+
+	s.log.Infow("restored deleted resource", "tenant", t.ID, "resourceType", restored.Type, "id", restored.ID, "version", restored.Version)
+	return s.toStoredResource(t, restored, s.auth.AttributeACL(ctx)), nil
+}
+
+// Operation returns the operation a method of the service performs, to be
+// checked against the caller's policy.
+func (s *Service) Operation(method string, payload any) (auth.Operation, bool) {
+	switch p := payload.(type) {
+	case *admin.ResourceRef:
+		return auth.Operation{Resource: p.ResourceType, Action: auth.ActionRead}, true
+	case *admin.RestoreDeletedPayload:
+		return auth.Operation{Resource: p.ResourceType, Action: auth.ActionCreate}, true
+	case *admin.GetVersionPayload:
+		return auth.Operation{Resource: p.ResourceType, Action: auth.ActionRead}, true
+	case *admin.GetAsOfPayload:
+		return auth.Operation{Resource: p.ResourceType, Action: auth.ActionRead}, true
+	case *admin.ListDeletedPayload:
+		return auth.Operation{Resource: p.ResourceType, Action: auth.ActionRead}, true
+	case *admin.RestoreVersionPayload:
+		return auth.Operation{Resource: p.ResourceType, Action: auth.ActionUpdate}, true
+	case *admin.CreateTokenPayload:
+		return auth.Operation{Resource: auth.ResourceToken, Action: auth.ActionCreate}, true
+	case *admin.ListTokensPayload:
+		return auth.Operation{Resource: auth.ResourceToken, Action: auth.ActionRead}, true
+	case *admin.RotateTokenPayload, *admin.ExpireTokenPayload:
+		return auth.Operation{Resource: auth.ResourceToken, Action: auth.ActionUpdate}, true
+	case *admin.TokenRef:
+		return auth.Operation{Resource: auth.ResourceToken, Action: auth.ActionDelete}, true
+	case *admin.ListDeadLettersPayload:
+		return auth.Operation{Resource: auth.ResourceDeadLetter, Action: auth.ActionRead}, true
+	case *admin.DeadLetterRef:
+		switch method {
+		case "RetryDeadLetter":
+			return auth.Operation{Resource: auth.ResourceDeadLetter, Action: auth.ActionUpdate}, true
+		case "DiscardDeadLetter":
+			return auth.Operation{Resource: auth.ResourceDeadLetter, Action: auth.ActionDelete}, true
+		}
+		return auth.Operation{Resource: auth.ResourceDeadLetter, Action: auth.ActionRead}, true
+	case *admin.ReconcileConnectorPayload:
+		return auth.Operation{Resource: auth.ResourceReconciliation, Action: auth.ActionCreate}, true
+	case *admin.ListReconciliationsPayload, *admin.ReconciliationRef:
+		return auth.Operation{Resource: auth.ResourceReconciliation, Action: auth.ActionRead}, true
+	default:
+		return auth.Operation{}, false
+	}
+}
+
+// tenant returns the tenant a request is scoped to, once the caller is known
+// to belong to it.
+func (s *Service) tenant(ctx context.Context, id string) (*tenant.Tenant, error) {
+	t, err := s.tenants.Authorize(ctx, id)
+	switch {
+	case errors.Is(err, tenant.ErrNotFound):
+		return nil, admin.MakeNotFound(err)
+	case err != nil:
+		s.log.Infow("rejected request", "tenant", id, "error", err)
+		return nil, s.authError(err)
+	}
+	return t, nil
+}
+
+// APIKeyAuth implements the authorization logic for the APIKey security scheme.
+func (s *Service) APIKeyAuth(ctx context.Context, key string, schema *security.APIKeyScheme) (context.Context, error) {
+	// Failures are not logged here since the JWT scheme is tried next and
+	// reports the outcome.
+	ctx, err := s.auth.AuthenticateToken(ctx, key)
+	if err != nil {
+		return ctx, s.authError(err)
+	}
+	return ctx, nil
+}
+
+// JWTAuth implements the authorization logic for the JWT security scheme.
+func (s *Service) JWTAuth(ctx context.Context, token string, schema *security.JWTScheme) (context.Context, error) {
+	ctx, err := s.auth.AuthenticateJWT(ctx, token, schema.RequiredScopes)
+	if err != nil {
+		s.log.Infow("rejected request", "error", err)
+		return ctx, s.authError(err)
+	}
+	return ctx, nil
+}
+
+// authError converts an authentication error into a SCIM error response.
+func (s *Service) authError(err error) *admin.SCIMError {
+	status := http.StatusUnauthorized
+	if errors.Is(err, auth.ErrInsufficientScope) || errors.Is(err, auth.ErrWrongTenant) ||
+		errors.Is(err, auth.ErrForbidden) || errors.Is(err, attribute.ErrProtected) {
+		status = http.StatusForbidden
+	}
+
+	return &admin.SCIMError{
+		Schemas: []string{errorSchema},
+		Status:  strconv.Itoa(status),
+		Detail:  err.Error(),
+	}
+}
+
+// toStoredResource converts a store resource into its transport
+// representation, leaving out the attributes hidden by the caller's ACL and
+// adding the provisioning status of the resource in each downstream system.
+func (s *Service) toStoredResource(t *tenant.Tenant, res *store.Resource, acl *attribute.ACL) *admin.StoredResource {
+	stored := &admin.StoredResource{
+		ID:           res.ID,
+		ResourceType: string(res.Type),
+		Version:      res.Version,
+		Created:      res.Created.Format(time.RFC3339),
+		LastModified: res.LastModified.Format(time.RFC3339),
+		Attributes:   acl.Mask(res.Attributes),
+	}
+
+	if s.connectors.Enabled() {
+		for _, status := range s.connectors.Statuses().List(t.ID, res.Type, res.ID) {
+			stored.Connectors = append(stored.Connectors, toConnectorStatus(status))
+		}
+	}
+	return stored
+}
+
+// toConnectorStatus converts a provisioning status into its transport
+// representation.
+func toConnectorStatus(status connector.Status) *admin.ConnectorStatus {
+	res := &admin.ConnectorStatus{
+		Connector: status.Connector,
+		State:     string(status.State),
+		Operation: string(status.Operation),
+		UpdatedAt: status.UpdatedAt.Format(time.RFC3339),
+	}
+	if status.RemoteID != "" {
+		res.RemoteID = &status.RemoteID
+	}
+	if status.Error != "" {
+		res.Error = &status.Error
+	}
+	return res
+}
+
+// toServiceError maps store errors onto the errors declared in the design.
+func toServiceError(err error) error {
+	if errors.Is(err, store.ErrNotFound) || errors.Is(err, store.ErrVersionNotFound) {
+		return admin.MakeNotFound(err)
+	}
+	return err
+}
@@ -0,0 +1,119 @@
+package adminsvc
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/iamBelugaa/scim-gateway/gen/admin"
+	"github.com/iamBelugaa/scim-gateway/internal/connector"
+	"github.com/iamBelugaa/scim-gateway/internal/tenant"
+)
+
+// List the changes connectors failed to provision once their attempts ran out.
+func (s *Service) ListDeadLetters(ctx context.Context, p *admin.ListDeadLettersPayload) (*admin.DeadLettersResponse, error) {
+	if _, err := s.tenant(ctx, p.TenantID); err != nil {
+		return nil, err
+	}
+
+	res := &admin.DeadLettersResponse{DeadLetters: make([]*admin.DeadLetter, 0)}
+	for _, letter := range s.outbox.DeadLetters().List(p.TenantID) {
+		if p.Connector != nil && letter.Connector != *p.Connector {
+			continue
+		}
+		res.DeadLetters = append(res.DeadLetters, toDeadLetter(letter))
+	}
+	res.TotalResults = len(res.DeadLetters)
+	return res, nil
+}
+
+// Retrieve a dead-lettered change, including the resource it was made to.
+func (s *Service) GetDeadLetter(ctx context.Context, p *admin.DeadLetterRef) (*admin.DeadLetter, error) {
+	t, err := s.tenant(ctx, p.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	letter, err := s.outbox.DeadLetters().Get(t.ID, p.ID)
+	if err != nil {
+		return nil, toDeadLetterError(err)
+	}
+	return s.toDeadLetterDetails(ctx, t, letter), nil
+}
+
+// Queue a dead-lettered change for delivery again.
+func (s *Service) RetryDeadLetter(ctx context.Context, p *admin.DeadLetterRef) (*admin.DeadLetterRetry, error) {
+	if _, err := s.tenant(ctx, p.TenantID); err != nil {
+		return nil, err
+	}
+
+	queued, err := s.outbox.Retry(ctx, p.TenantID, p.ID)
+	if err != nil {
+		return nil, toDeadLetterError(err)
+	}
+
+	res := &admin.DeadLetterRetry{ID: p.ID, Outcome: "queued"}
+	if !queued {
+		res.Outcome = "superseded"
+	}
+	s.log.Infow("retried dead letter", "id", p.ID, "tenant", p.TenantID, "outcome", res.Outcome, "by", principalName(ctx))
+	return res, nil
+}
+
+// Discard a dead-lettered change without provisioning it.
+func (s *Service) DiscardDeadLetter(ctx context.Context, p *admin.DeadLetterRef) error {
+	if _, err := s.tenant(ctx, p.TenantID); err != nil {
+		return err
+	}
+
+	letter, err := s.outbox.DeadLetters().Discard(p.TenantID, p.ID)
+	if err != nil {
+		return toDeadLetterError(err)
+	}
+
+	res := letter.Change.Resource
+	s.log.Infow(
+		"discarded dead letter",
+		"id", letter.ID, "tenant", p.TenantID, "connector", letter.Connector,
+		"resourceType", res.Type, "resourceId", res.ID, "change", letter.Change.Kind, "by", principalName(ctx),
+	)
+	return nil
+}
+
+// toDeadLetter converts a dead letter into its transport representation,
+// without the resources of its change.
+func toDeadLetter(letter connector.DeadLetter) *admin.DeadLetter {
+	res := letter.Change.Resource
+	return &admin.DeadLetter{
+		ID:           letter.ID,
+		Connector:    letter.Connector,
+		Change:       string(letter.Change.Kind),
+		ResourceType: string(res.Type),
+		ResourceID:   res.ID,
+		Attempts:     letter.Attempts,
+		Error:        letter.Error,
+		FailedAt:     letter.FailedAt.Format(time.RFC3339),
+	}
+}
+
+// toDeadLetterDetails converts a dead letter into its transport
+// representation, including the resources of its change as seen through the
+// caller's attribute ACL.
+func (s *Service) toDeadLetterDetails(ctx context.Context, t *tenant.Tenant, letter connector.DeadLetter) *admin.DeadLetter {
+	acl := s.auth.AttributeACL(ctx)
+
+	res := toDeadLetter(letter)
+	res.Resource = s.toStoredResource(t, letter.Change.Resource, acl)
+	if letter.Change.Previous != nil {
+		res.Previous = s.toStoredResource(t, letter.Change.Previous, acl)
+	}
+	return res
+}
+
+// toDeadLetterError converts a dead letter error into a service error.
+func toDeadLetterError(err error) error {
+	if errors.Is(err, connector.ErrDeadLetterNotFound) {
+		return admin.MakeNotFound(err)
+	}
+	return err
+}
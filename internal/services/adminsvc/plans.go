@@ -0,0 +1,89 @@
+package adminsvc
+
+import (
+	"context"
+	"reflect"
+	"sort"
+
+	"github.com/iamBelugaa/scim-gateway/gen/admin"
+	"github.com/iamBelugaa/scim-gateway/internal/attribute"
+	"github.com/iamBelugaa/scim-gateway/internal/connector"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+	"github.com/iamBelugaa/scim-gateway/internal/tenant"
+)
+
+// writeStore returns the store a write goes to, and whether the write is a
+// dry run. Dry runs, requested per request or configured for every write,
+// write to a fork of the tenant store that is dropped once planned.
+func (s *Service) writeStore(t *tenant.Tenant, dryRun bool) (*store.Memory, bool) {
+	if dryRun || s.dryRun {
+		return t.Store.Fork(), true
+	}
+	return t.Store, false
+}
+
+// toPlannedResource converts a resource written by a dry run into its
+// transport representation, with the plan of the changes made to the fork
+// and the connector operations they would run.
+func (s *Service) toPlannedResource(
+	ctx context.Context, t *tenant.Tenant, fork *store.Memory, res *store.Resource, acl *attribute.ACL,
+) *admin.StoredResource {
+	dryRun := true
+	stored := s.toStoredResource(t, res, acl)
+	stored.DryRun = &dryRun
+	stored.Plan = &admin.Plan{Changes: make([]*admin.PlannedChange, 0), Operations: make([]*admin.PlannedOperation, 0)}
+
+	events := fork.Changes(ctx)
+	changes := make([]connector.Change, len(events))
+	for i, event := range events {
+		changes[i] = connector.ChangeOf(t.ID, event)
+
+		planned := &admin.PlannedChange{
+			Kind:         string(event.Kind),
+			ResourceType: string(event.Resource.Type),
+			ID:           event.Resource.ID,
+			Version:      event.Resource.Version,
+			Attributes:   acl.Mask(event.Resource.Attributes),
+		}
+		if event.Previous != nil {
+			planned.ChangedAttributes = changedAttributes(acl.Mask(event.Previous.Attributes), planned.Attributes)
+		}
+		stored.Plan.Changes = append(stored.Plan.Changes, planned)
+	}
+
+	for _, step := range s.connectors.Plan(changes) {
+		op := &admin.PlannedOperation{
+			Connector:      step.Connector,
+			Operation:      string(step.Operation),
+			ResourceType:   string(step.ResourceType),
+			ID:             step.ID,
+			RemoteID:       optional(step.RemoteID),
+			AddedMembers:   step.Added,
+			RemovedMembers: step.Removed,
+			Error:          optional(step.Error),
+		}
+		if step.Attributes != nil {
+			op.Attributes = acl.Mask(step.Attributes)
+		}
+		stored.Plan.Operations = append(stored.Plan.Operations, op)
+	}
+	return stored
+}
+
+// changedAttributes returns the sorted names of the attributes that differ
+// between two versions of a resource.
+func changedAttributes(previous, current map[string]any) []string {
+	changed := make([]string, 0)
+	for name, val := range current {
+		if prev, ok := previous[name]; !ok || !reflect.DeepEqual(prev, val) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range previous {
+		if _, ok := current[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
@@ -0,0 +1,170 @@
+package adminsvc
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/iamBelugaa/scim-gateway/gen/admin"
+	"github.com/iamBelugaa/scim-gateway/internal/connector"
+)
+
+// driftColumns are the columns of exported drift.
+var driftColumns = []string{"kind", "resourceType", "id", "remoteId", "attribute", "expected", "actual", "detail"}
+
+// Start comparing the resources of the gateway with the accounts a connector
+// lists from its downstream system.
+func (s *Service) ReconcileConnector(ctx context.Context, p *admin.ReconcileConnectorPayload) (*admin.Reconciliation, error) {
+	if _, err := s.tenant(ctx, p.TenantID); err != nil {
+		return nil, err
+	}
+
+	report, err := s.reconciler.Start(ctx, p.TenantID, p.Connector, p.Correct)
+	if err != nil {
+		return nil, toReconciliationError(err)
+	}
+
+	s.log.Infow(
+		"started reconciliation", "id", report.ID, "tenant", p.TenantID, "connector", p.Connector,
+		"correct", p.Correct, "by", principalName(ctx),
+	)
+	return toReconciliation(report, false), nil
+}
+
+// List the reports of recent reconciliations without their drift, most recent
+// first.
+func (s *Service) ListReconciliations(ctx context.Context, p *admin.ListReconciliationsPayload) (*admin.ReconciliationsResponse, error) {
+	if _, err := s.tenant(ctx, p.TenantID); err != nil {
+		return nil, err
+	}
+
+	res := &admin.ReconciliationsResponse{Reconciliations: make([]*admin.Reconciliation, 0)}
+	for _, report := range s.reconciler.Reports(p.TenantID) {
+		if p.Connector != nil && report.Connector != *p.Connector {
+			continue
+		}
+		res.Reconciliations = append(res.Reconciliations, toReconciliation(report, false))
+	}
+	res.TotalResults = len(res.Reconciliations)
+	return res, nil
+}
+
+// Retrieve the report of a reconciliation, including the drift found.
+func (s *Service) GetReconciliation(ctx context.Context, p *admin.ReconciliationRef) (*admin.Reconciliation, error) {
+	if _, err := s.tenant(ctx, p.TenantID); err != nil {
+		return nil, err
+	}
+
+	report, err := s.reconciler.Report(p.TenantID, p.ID)
+	if err != nil {
+		return nil, toReconciliationError(err)
+	}
+	return toReconciliation(report, true), nil
+}
+
+// Export the drift found by a reconciliation as CSV.
+func (s *Service) ExportReconciliationDrifts(ctx context.Context, p *admin.ReconciliationRef) (string, error) {
+	if _, err := s.tenant(ctx, p.TenantID); err != nil {
+		return "", err
+	}
+
+	report, err := s.reconciler.Report(p.TenantID, p.ID)
+	if err != nil {
+		return "", toReconciliationError(err)
+	}
+
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	if err := w.Write(driftColumns); err != nil {
+		return "", err
+	}
+	for _, drift := range report.Drifts {
+		record := []string{
+			string(drift.Kind), string(drift.ResourceType), drift.ID, drift.RemoteID, drift.Attribute,
+			formatValue(drift.Expected), formatValue(drift.Actual), drift.Detail,
+		}
+		if err := w.Write(record); err != nil {
+			return "", err
+		}
+	}
+	w.Flush()
+	return b.String(), w.Error()
+}
+
+// toReconciliation converts a reconciliation report into its transport
+// representation, with its drift when requested.
+func toReconciliation(report connector.Report, drifts bool) *admin.Reconciliation {
+	res := &admin.Reconciliation{
+		ID:          report.ID,
+		Connector:   report.Connector,
+		State:       string(report.State),
+		Correct:     report.Correct,
+		StartedAt:   report.StartedAt.Format(time.RFC3339),
+		CompletedAt: formatTime(report.CompletedAt),
+		Resources:   report.Resources,
+		Accounts:    report.Accounts,
+		Corrections: report.Corrections,
+		DriftCount:  len(report.Drifts),
+	}
+	if report.Error != "" {
+		res.Error = &report.Error
+	}
+	if !drifts {
+		return res
+	}
+
+	res.Drifts = make([]*admin.Drift, len(report.Drifts))
+	for i, drift := range report.Drifts {
+		res.Drifts[i] = &admin.Drift{
+			Kind:         string(drift.Kind),
+			ResourceType: string(drift.ResourceType),
+			ID:           optional(drift.ID),
+			RemoteID:     optional(drift.RemoteID),
+			Attribute:    optional(drift.Attribute),
+			Expected:     drift.Expected,
+			Actual:       drift.Actual,
+			Detail:       optional(drift.Detail),
+		}
+	}
+	return res
+}
+
+// optional returns a pointer to a string, nil when it is empty.
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// formatValue formats an attribute value for a CSV cell: text as is, and
+// other values as JSON.
+func formatValue(val any) string {
+	switch v := val.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	raw, err := json.Marshal(val)
+	if err != nil {
+		return ""
+	}
+	return string(raw)
+}
+
+// toReconciliationError maps reconciliation errors onto the errors declared in
+// the design.
+func toReconciliationError(err error) error {
+	switch {
+	case errors.Is(err, connector.ErrConnectorNotFound), errors.Is(err, connector.ErrReportNotFound):
+		return admin.MakeNotFound(err)
+	case errors.Is(err, connector.ErrReconciliationRunning), errors.Is(err, connector.ErrListUnsupported):
+		return admin.MakeConflict(err)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,393 @@
+package scimsvc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/iamBelugaa/scim-gateway/gen/scim"
+	"github.com/iamBelugaa/scim-gateway/internal/attribute"
+	"github.com/iamBelugaa/scim-gateway/internal/auth"
+	"github.com/iamBelugaa/scim-gateway/internal/patch"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+	"github.com/iamBelugaa/scim-gateway/internal/tenant"
+)
+
+// bulkIDPrefix prefixes references to the resource created by an earlier
+// operation of the same Bulk request.
+const bulkIDPrefix = "bulkId:"
+
+// errBulkStopped rolls back the transaction of an atomic Bulk request that
+// reached its failOnErrors threshold.
+var errBulkStopped = errors.New("bulk request reached its failOnErrors threshold")
+
+// writer is what writes go to: the tenant store or a fork of it for dry runs,
+// or a transaction on either when Bulk requests are atomic.
+type writer interface {
+	Create(ctx context.Context, res *store.Resource) (*store.Resource, error)
+	Update(ctx context.Context, resourceType store.ResourceType, id string, fn func(res *store.Resource) error) (*store.Resource, error)
+	Delete(ctx context.Context, resourceType store.ResourceType, id string) error
+	DeleteIfVersion(ctx context.Context, resourceType store.ResourceType, id string, expected uint64) error
+}
+
+// Apply several User and Group operations in order, reporting the outcome of
+// each.
+func (s *Service) Bulk(ctx context.Context, p *scim.BulkRequest) (*scim.BulkResponse, error) {
+	t, err := s.tenant(ctx, p.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.Operations) > s.bulkMaxOperations {
+		return nil, scimError(
+			http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("bulk request holds %d operations, more than the maxOperations of %d", len(p.Operations), s.bulkMaxOperations),
+		)
+	}
+
+	proj, serr := projection(p.Attributes, p.ExcludedAttributes)
+	if serr != nil {
+		return nil, serr
+	}
+
+	// Dry runs write to a fork of the tenant store that is dropped once the
+	// request has been planned on it.
+	st, dryRun := s.writeStore(t, p.DryRun)
+	b := &bulk{
+		auth:         s.auth,
+		tenant:       t,
+		acl:          s.auth.AttributeACL(ctx),
+		projection:   proj,
+		dryRun:       dryRun,
+		failOnErrors: p.FailOnErrors,
+		ids:          make(map[string]string),
+	}
+
+	if !s.atomicBulk {
+		b.run(ctx, st, p.Operations)
+		s.log.Infow(
+			"applied bulk request",
+			"tenant", t.ID, "operations", len(b.results), "errors", b.errors, "dryRun", dryRun,
+		)
+		return s.bulkResponse(ctx, t, st, b), nil
+	}
+
+	// Atomic Bulk requests run in one transaction, so a request that stops at
+	// its failOnErrors threshold leaves the store as it found it. The outbox
+	// events of the writes are rolled back with them, which keeps connectors
+	// from seeing any change before the transaction commits.
+	err = st.InTx(ctx, func(tx *store.Tx) error {
+		if b.run(ctx, tx, p.Operations) {
+			return errBulkStopped
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errBulkStopped):
+		b.rollBack()
+		s.log.Infow(
+			"rolled back bulk request",
+			"tenant", t.ID, "operations", len(b.results), "errors", b.errors, "dryRun", dryRun,
+		)
+	case err != nil:
+		s.log.Errorw("failed to apply bulk request", "tenant", t.ID, "error", err)
+		return nil, scimError(http.StatusInternalServerError, "failed to apply bulk request")
+	default:
+		s.log.Infow(
+			"applied bulk request",
+			"tenant", t.ID, "operations", len(b.results), "errors", b.errors, "atomic", true, "dryRun", dryRun,
+		)
+	}
+	return s.bulkResponse(ctx, t, st, b), nil
+}
+
+// bulkResponse returns the response to a Bulk request applied to a store.
+// Dry runs applied it to a fork, whose changes are returned as the plan of
+// the request.
+func (s *Service) bulkResponse(ctx context.Context, t *tenant.Tenant, st *store.Memory, b *bulk) *scim.BulkResponse {
+	res := b.response()
+	if b.dryRun {
+		res.Plan = s.plan(ctx, t, st, b.acl)
+	}
+	return res
+}
+
+// bulk applies the operations of a single Bulk request.
+type bulk struct {
+	auth         *auth.Authenticator
+	tenant       *tenant.Tenant
+	acl          *attribute.ACL                // Attribute ACL of the caller, nil when unrestricted.
+	projection   attribute.Projection          // Attributes returned for the written resources.
+	dryRun       bool                          // Whether the request writes to a fork, so written resources have no location.
+	failOnErrors *int                          // Number of errors after which the request stops, nil to never stop.
+	ids          map[string]string             // Ids of the created resources keyed by bulkId.
+	results      []*scim.BulkOperationResponse // Outcome of every operation applied so far.
+	errors       int                           // Number of operations that failed so far.
+}
+
+// run applies operations in order until they run out or the failOnErrors
+// threshold is reached. It reports whether the request stopped at the
+// threshold.
+func (b *bulk) run(ctx context.Context, w writer, ops []*scim.BulkOperation) bool {
+	for _, op := range ops {
+		res := &scim.BulkOperationResponse{Method: op.Method, BulkID: op.BulkID}
+		b.results = append(b.results, res)
+
+		written, location, status, err := b.apply(ctx, w, op)
+		if err != nil {
+			res.Status = err.Status
+			res.Response = errorBody(err)
+
+			b.errors++
+			if b.failOnErrors != nil && b.errors >= *b.failOnErrors {
+				return true
+			}
+			continue
+		}
+
+		res.Status = strconv.Itoa(status)
+		if written == nil {
+			continue
+		}
+		res.Response = toSCIMResource(b.tenant, written, b.projection, b.acl, b.dryRun).Resource
+		if !b.dryRun {
+			version := entityTag(written.Version)
+			res.Version = &version
+			res.Location = &location
+		}
+	}
+	return false
+}
+
+// rollBack reports the operations that succeeded before an atomic request
+// stopped as rolled back.
+func (b *bulk) rollBack() {
+	for _, res := range b.results {
+		if status, _ := strconv.Atoi(res.Status); status >= http.StatusBadRequest {
+			continue
+		}
+
+		res.Status = strconv.Itoa(http.StatusFailedDependency)
+		res.Response = errorBody(scimError(http.StatusFailedDependency, "rolled back : "+errBulkStopped.Error()))
+		res.Version = nil
+		res.Location = nil
+	}
+}
+
+// response returns the Bulk response reporting every applied operation.
+func (b *bulk) response() *scim.BulkResponse {
+	res := &scim.BulkResponse{Schemas: []string{bulkResponseSchema}, Operations: b.results}
+	if res.Operations == nil {
+		res.Operations = make([]*scim.BulkOperationResponse, 0)
+	}
+	return res
+}
+
+// apply applies a single operation and returns the resource it wrote, its
+// location and the status of the operation.
+func (b *bulk) apply(ctx context.Context, w writer, op *scim.BulkOperation) (
+	*store.Resource, string, int, *scim.SCIMError,
+) {
+	path, err := b.resolve(op.Path)
+	if err != nil {
+		return nil, "", 0, resourceError(err)
+	}
+	resourceType, endpoint, id, serr := b.target(path)
+	if serr != nil {
+		return nil, "", 0, serr
+	}
+
+	switch op.Method {
+	case http.MethodPost:
+		if id != "" {
+			return nil, "", 0, invalid("invalidPath", "POST operations must target a resource endpoint")
+		}
+		if op.BulkID == nil || *op.BulkID == "" {
+			return nil, "", 0, invalid("invalidValue", "POST operations require a bulkId")
+		}
+		if err := b.authorize(ctx, resourceType, auth.ActionCreate); err != nil {
+			return nil, "", 0, resourceError(err)
+		}
+
+		attrs, err := b.attributes(op.Data)
+		if err != nil {
+			return nil, "", 0, resourceError(err)
+		}
+		created, err := createResource(ctx, w, b.tenant, b.acl, resourceType, attrs)
+		if err != nil {
+			return nil, "", 0, resourceError(err)
+		}
+		b.ids[*op.BulkID] = created.ID
+		return created, endpoint + "/" + created.ID, http.StatusCreated, nil
+
+	case http.MethodPut:
+		if id == "" {
+			return nil, "", 0, invalid("invalidPath", "PUT operations must target a resource")
+		}
+		if err := b.authorize(ctx, resourceType, auth.ActionUpdate); err != nil {
+			return nil, "", 0, resourceError(err)
+		}
+
+		attrs, err := b.attributes(op.Data)
+		if err != nil {
+			return nil, "", 0, resourceError(err)
+		}
+		replaced, err := replaceResource(ctx, w, b.tenant, b.acl, resourceType, id, op.Version, attrs)
+		if err != nil {
+			return nil, "", 0, resourceError(err)
+		}
+		return replaced, endpoint + "/" + id, http.StatusOK, nil
+
+	case http.MethodDelete:
+		if id == "" {
+			return nil, "", 0, invalid("invalidPath", "DELETE operations must target a resource")
+		}
+		if err := b.authorize(ctx, resourceType, auth.ActionDelete); err != nil {
+			return nil, "", 0, resourceError(err)
+		}
+
+		if err := deleteResource(ctx, w, resourceType, id, op.Version); err != nil {
+			return nil, "", 0, resourceError(err)
+		}
+		return nil, "", http.StatusNoContent, nil
+
+	case http.MethodPatch:
+		if id == "" {
+			return nil, "", 0, invalid("invalidPath", "PATCH operations must target a resource")
+		}
+		if err := b.authorize(ctx, resourceType, auth.ActionUpdate); err != nil {
+			return nil, "", 0, resourceError(err)
+		}
+
+		ops, err := b.patchOperations(op.Data)
+		if err != nil {
+			return nil, "", 0, resourceError(err)
+		}
+		patched, err := patchResource(ctx, w, b.tenant, b.acl, resourceType, id, op.Version, ops)
+		if err != nil {
+			return nil, "", 0, resourceError(err)
+		}
+		return patched, endpoint + "/" + id, http.StatusOK, nil
+
+	default:
+		return nil, "", 0, scimError(http.StatusNotImplemented, op.Method+" operations are not supported")
+	}
+}
+
+// authorize checks the caller may perform an action on a resource type
+// through a Bulk request. The bulk action grants every write through Bulk
+// requests, so bulk-only credentials need nothing else, and credentials
+// granted the action itself but not bulk are refused.
+func (b *bulk) authorize(ctx context.Context, resourceType store.ResourceType, action auth.Action) error {
+	if err := b.auth.Authorize(ctx, auth.Operation{Resource: string(resourceType), Action: auth.ActionBulk}); err != nil {
+		return fmt.Errorf("%w : needed to %s through a bulk request", err, action)
+	}
+	return nil
+}
+
+// patchOperations returns the operations of the PATCH request a PATCH
+// operation carries as its data, with references to resources created
+// earlier in the request resolved.
+func (b *bulk) patchOperations(data map[string]any) ([]patch.Operation, error) {
+	resolved, err := b.resolveValue(data)
+	if err != nil {
+		return nil, err
+	}
+
+	request, _ := resolved.(map[string]any)
+	items, ok := request["Operations"].([]any)
+	if !ok {
+		return nil, fmt.Errorf("%w : PATCH operations require the Operations of a PATCH request as data", patch.ErrInvalidSyntax)
+	}
+	ops := make([]patch.Operation, len(items))
+	for i, item := range items {
+		m, _ := item.(map[string]any)
+		name, _ := m["op"].(string)
+		path, _ := m["path"].(string)
+		ops[i] = patch.Operation{Op: name, Path: path, Value: m["value"]}
+	}
+	return ops, nil
+}
+
+// attributes returns the attributes an operation writes, with references to
+// resources created earlier in the request resolved. Attributes owned by the
+// gateway are left out.
+func (b *bulk) attributes(data map[string]any) (map[string]any, error) {
+	resolved, err := b.resolveValue(data)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, _ := resolved.(map[string]any)
+	return writable(attrs), nil
+}
+
+// resolveValue returns a copy of val with every "bulkId:<bulkId>" string
+// replaced by the id of the resource created under that bulkId.
+func (b *bulk) resolveValue(val any) (any, error) {
+	switch v := val.(type) {
+	case string:
+		return b.resolve(v)
+	case map[string]any:
+		resolved := make(map[string]any, len(v))
+		for name, item := range v {
+			r, err := b.resolveValue(item)
+			if err != nil {
+				return nil, err
+			}
+			resolved[name] = r
+		}
+		return resolved, nil
+	case []any:
+		resolved := make([]any, len(v))
+		for i, item := range v {
+			r, err := b.resolveValue(item)
+			if err != nil {
+				return nil, err
+			}
+			resolved[i] = r
+		}
+		return resolved, nil
+	default:
+		return val, nil
+	}
+}
+
+// errUnresolvedBulkID is returned when an operation references a bulkId no
+// earlier operation of the request created a resource under.
+var errUnresolvedBulkID = errors.New("unresolved bulkId")
+
+// resolve replaces a "bulkId:<bulkId>" reference, either a whole string or
+// the last segment of a path, with the id of the resource it refers to.
+func (b *bulk) resolve(s string) (string, error) {
+	i := strings.LastIndex(s, bulkIDPrefix)
+	if i < 0 || (i > 0 && s[i-1] != '/') {
+		return s, nil
+	}
+
+	bulkID := s[i+len(bulkIDPrefix):]
+	id, ok := b.ids[bulkID]
+	if !ok {
+		return "", fmt.Errorf("%w %q", errUnresolvedBulkID, bulkID)
+	}
+	return s[:i] + id, nil
+}
+
+// target returns the resource type, the location of its endpoint and the id
+// of the resource an operation path refers to. The id is empty for paths of
+// resource endpoints.
+func (b *bulk) target(path string) (store.ResourceType, string, string, *scim.SCIMError) {
+	endpoint, id, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if strings.Contains(id, "/") {
+		return "", "", "", invalid("invalidPath", "path "+strconv.Quote(path)+" is not a resource or resource endpoint")
+	}
+
+	for _, rt := range b.tenant.Schemas.ResourceTypes() {
+		if rt.Endpoint == "/"+endpoint {
+			return store.ResourceType(rt.ID), basePath(b.tenant.ID) + rt.Endpoint, id, nil
+		}
+	}
+	return "", "", "", scimError(http.StatusNotFound, "resource endpoint "+strconv.Quote("/"+endpoint)+" not found")
+}
@@ -0,0 +1,317 @@
+package scimsvc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	goahttp "goa.design/goa/v3/http"
+
+	genscimserver "github.com/iamBelugaa/scim-gateway/gen/http/scim/server"
+	genscim "github.com/iamBelugaa/scim-gateway/gen/scim"
+	"github.com/iamBelugaa/scim-gateway/internal/auth"
+	"github.com/iamBelugaa/scim-gateway/internal/config"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+	"github.com/iamBelugaa/scim-gateway/internal/tenant"
+	"github.com/iamBelugaa/scim-gateway/pkg/logger"
+)
+
+// bulkRequest creates a user and a group listing it, then fails to create a
+// user missing its userName.
+const bulkRequest = `{
+	"schemas": ["urn:ietf:params:scim:api:messages:2.0:BulkRequest"],
+	"failOnErrors": %s,
+	"Operations": [
+		{"method": "POST", "bulkId": "bjensen", "path": "/Users", "data": {"userName": "bjensen"}},
+		{"method": "POST", "bulkId": "tour-guides", "path": "/Groups", "data": {
+			"displayName": "Tour Guides",
+			"members": [{"value": "bulkId:bjensen"}]
+		}},
+		{"method": "POST", "bulkId": "nameless", "path": "/Users", "data": {"title": "Tour Guide"}}
+	]
+}`
+
+// newTestServer serves the SCIM API of the default tenant, accepting the
+// static token "okta-token". The outbox of the returned store records
+// changes as it would for connectors.
+func newTestServer(t *testing.T, cfg *config.Store, scimCfg *config.SCIM) (*httptest.Server, *store.Memory) {
+	t.Helper()
+	return newTestServerWithAuth(t, &config.Auth{StaticTokens: []string{staticToken("okta", "okta-token")}}, cfg, scimCfg)
+}
+
+// staticToken returns the static token entry of a service account of the
+// default tenant.
+func staticToken(serviceAccount, token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return serviceAccount + ":" + hex.EncodeToString(hash[:])
+}
+
+// newTestServerWithAuth serves the SCIM API of the default tenant, accepting
+// the credentials of authCfg.
+func newTestServerWithAuth(
+	t *testing.T, authCfg *config.Auth, cfg *config.Store, scimCfg *config.SCIM,
+) (*httptest.Server, *store.Memory) {
+	t.Helper()
+
+	log := &logger.Logger{SugaredLogger: zap.NewNop().Sugar()}
+	authenticator, err := auth.NewWithConfig(log, authCfg)
+	if err != nil {
+		t.Fatalf("failed to construct authenticator : %v", err)
+	}
+	tenants, err := tenant.NewWithConfig(&config.Tenancy{}, cfg)
+	if err != nil {
+		t.Fatalf("failed to construct tenants : %v", err)
+	}
+	def, err := tenants.Get(auth.DefaultTenant)
+	if err != nil {
+		t.Fatalf("failed to get default tenant : %v", err)
+	}
+	def.Store.EnableOutbox()
+
+	svc := NewService(log, authenticator, tenants, nil, cfg, scimCfg)
+	endpoints := genscim.NewEndpoints(svc)
+	endpoints.Use(auth.DeclareOperations(svc.Operation))
+
+	mux := goahttp.NewMuxer()
+	genscimserver.Mount(mux, genscimserver.New(endpoints, mux, goahttp.RequestDecoder, goahttp.ResponseEncoder, nil, nil))
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, def.Store
+}
+
+// TestBulk sends a Bulk request that reaches or stays below its failOnErrors
+// threshold, with and without atomic Bulk requests, and checks which writes
+// and connector changes are kept.
+func TestBulk(t *testing.T) {
+	tests := []struct {
+		name         string
+		atomic       bool
+		failOnErrors string
+		wantStatuses []string
+		wantKept     bool
+	}{
+		{name: "non atomic", atomic: false, failOnErrors: "1", wantStatuses: []string{"201", "201", "400"}, wantKept: true},
+		{name: "atomic rolled back", atomic: true, failOnErrors: "1", wantStatuses: []string{"424", "424", "400"}, wantKept: false},
+		{name: "atomic below threshold", atomic: true, failOnErrors: "2", wantStatuses: []string{"201", "201", "400"}, wantKept: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			srv, s := newTestServer(t, &config.Store{AtomicBulk: tt.atomic}, &config.SCIM{MaxResults: 100, BulkMaxOperations: 10})
+
+			body := strings.Replace(bulkRequest, "%s", tt.failOnErrors, 1)
+			req, err := http.NewRequest(http.MethodPost, srv.URL+"/default/scim/v2/Bulk", strings.NewReader(body))
+			if err != nil {
+				t.Fatalf("failed to build request : %v", err)
+			}
+			req.Header.Set("Authorization", "Bearer okta-token")
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatalf("failed to send request : %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("got status %d, want %d", resp.StatusCode, http.StatusOK)
+			}
+
+			var got struct {
+				Operations []struct {
+					Status string `json:"status"`
+				} `json:"Operations"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response : %v", err)
+			}
+			statuses := make([]string, len(got.Operations))
+			for i, op := range got.Operations {
+				statuses[i] = op.Status
+			}
+			if !reflect.DeepEqual(statuses, tt.wantStatuses) {
+				t.Fatalf("got statuses %v, want %v", statuses, tt.wantStatuses)
+			}
+
+			users, err := s.List(ctx, store.ResourceTypeUser)
+			if err != nil {
+				t.Fatalf("failed to list users : %v", err)
+			}
+			groups, err := s.List(ctx, store.ResourceTypeGroup)
+			if err != nil {
+				t.Fatalf("failed to list groups : %v", err)
+			}
+			events := s.PendingEvents(ctx, 0, 10)
+
+			if !tt.wantKept {
+				if len(users) != 0 || len(groups) != 0 || len(events) != 0 {
+					t.Fatalf("got %d users, %d groups and %d events, want the request rolled back", len(users), len(groups), len(events))
+				}
+				return
+			}
+
+			if len(users) != 1 || len(groups) != 1 || len(events) != 2 {
+				t.Fatalf("got %d users, %d groups and %d events, want the successful writes kept", len(users), len(groups), len(events))
+			}
+			members, _ := groups[0].Attributes["members"].([]any)
+			if len(members) != 1 || members[0].(map[string]any)["value"] != users[0].ID {
+				t.Fatalf("got members %v, want the bulkId resolved to user %q", members, users[0].ID)
+			}
+		})
+	}
+}
+
+// TestBulkDryRun sends a Bulk request asking for a dry run, and checks it
+// returns the planned changes without locations and leaves the store and the
+// outbox untouched.
+func TestBulkDryRun(t *testing.T) {
+	ctx := context.Background()
+	srv, s := newTestServer(t, &config.Store{}, &config.SCIM{MaxResults: 100, BulkMaxOperations: 10})
+
+	body := strings.Replace(bulkRequest, "%s", "5", 1)
+	status, _, got := send(t, srv, http.MethodPost, "/default/scim/v2/Bulk", body, map[string]string{"X-Dry-Run": "true"})
+	if status != http.StatusOK {
+		t.Fatalf("got status %d, want %d : %v", status, http.StatusOK, got)
+	}
+
+	ops, _ := got["Operations"].([]any)
+	if len(ops) != 3 {
+		t.Fatalf("got %d operations, want 3", len(ops))
+	}
+	for i, op := range ops[:2] {
+		op := op.(map[string]any)
+		if op["status"] != "201" || op["location"] != nil || op["version"] != nil {
+			t.Fatalf("got operation %d %v, want it planned without a location or version", i, op)
+		}
+	}
+
+	plan, _ := got["plan"].(map[string]any)
+	changes, _ := plan["changes"].([]any)
+	kinds := make([]string, len(changes))
+	for i, change := range changes {
+		kinds[i], _ = change.(map[string]any)["resourceType"].(string)
+	}
+	if !reflect.DeepEqual(kinds, []string{"User", "Group"}) {
+		t.Fatalf("got planned changes to %v, want the user and the group", kinds)
+	}
+
+	users, err := s.List(ctx, store.ResourceTypeUser)
+	if err != nil {
+		t.Fatalf("failed to list users : %v", err)
+	}
+	if len(users) != 0 || len(s.PendingEvents(ctx, 0, 10)) != 0 {
+		t.Fatalf("got %d users, want the dry run to leave the store untouched", len(users))
+	}
+}
+
+// TestBulkPatch adds a user created earlier in a Bulk request to a group
+// with a PATCH operation, then deletes the user at a stale version.
+func TestBulkPatch(t *testing.T) {
+	srv, _ := newTestServer(t, &config.Store{}, &config.SCIM{MaxResults: 100, BulkMaxOperations: 10})
+
+	_, _, group := send(t, srv, http.MethodPost, "/default/scim/v2/Groups", `{"displayName": "Tour Guides"}`, nil)
+	groupID, _ := group["id"].(string)
+
+	body := `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:BulkRequest"],
+		"Operations": [
+			{"method": "POST", "bulkId": "bjensen", "path": "/Users", "data": {"userName": "bjensen"}},
+			{"method": "PATCH", "path": "/Groups/` + groupID + `", "data": {
+				"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+				"Operations": [{"op": "add", "path": "members", "value": [{"value": "bulkId:bjensen"}]}]
+			}},
+			{"method": "PATCH", "path": "/Groups/` + groupID + `", "data": {"displayName": "Guides"}},
+			{"method": "DELETE", "path": "/Users/bulkId:bjensen", "version": "W/\"7\""}
+		]
+	}`
+	_, _, got := send(t, srv, http.MethodPost, "/default/scim/v2/Bulk", body, nil)
+
+	ops, _ := got["Operations"].([]any)
+	statuses := make([]string, len(ops))
+	for i, op := range ops {
+		statuses[i], _ = op.(map[string]any)["status"].(string)
+	}
+	if want := []string{"201", "200", "400", "412"}; !reflect.DeepEqual(statuses, want) {
+		t.Fatalf("got statuses %v, want %v", statuses, want)
+	}
+
+	patched, _ := ops[1].(map[string]any)["response"].(map[string]any)
+	members, _ := patched["members"].([]any)
+	userID, _ := ops[0].(map[string]any)["response"].(map[string]any)["id"].(string)
+	if len(members) != 1 || members[0].(map[string]any)["value"] != userID {
+		t.Fatalf("got members %v, want the bulkId resolved to user %q", members, userID)
+	}
+}
+
+// TestBulkMaxOperations checks a Bulk request holding more operations than
+// the advertised maxOperations is rejected with 413, and that the limits are
+// advertised.
+func TestBulkMaxOperations(t *testing.T) {
+	srv, s := newTestServer(t, &config.Store{}, &config.SCIM{MaxResults: 100, BulkMaxOperations: 2, BulkMaxPayloadSize: 1024})
+
+	body := strings.Replace(bulkRequest, "%s", "5", 1)
+	if status, _, _ := send(t, srv, http.MethodPost, "/default/scim/v2/Bulk", body, nil); status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("got status %d, want %d", status, http.StatusRequestEntityTooLarge)
+	}
+	users, err := s.List(context.Background(), store.ResourceTypeUser)
+	if err != nil {
+		t.Fatalf("failed to list users : %v", err)
+	}
+	if len(users) != 0 {
+		t.Fatalf("got %d users, want the rejected request to write nothing", len(users))
+	}
+
+	_, _, spc := send(t, srv, http.MethodGet, "/default/scim/v2/ServiceProviderConfig", "", nil)
+	want := map[string]any{"supported": true, "maxOperations": float64(2), "maxPayloadSize": float64(1024)}
+	if got := spc["bulk"]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got bulk %v, want %v", got, want)
+	}
+}
+
+// TestBulkPolicy sends the same writes through Bulk and through the resource
+// endpoints with a bulk-only credential and with one granted the writes but
+// not bulk, and checks each is only allowed what its policy grants.
+func TestBulkPolicy(t *testing.T) {
+	srv, _ := newTestServerWithAuth(t, &config.Auth{
+		StaticTokens: []string{staticToken("hr-sync", "hr-sync-token"), staticToken("okta", "okta-token")},
+		Policies:     []string{"default/hr-sync:*:bulk", "default/okta:*:create read update delete"},
+	}, &config.Store{}, &config.SCIM{MaxResults: 100, BulkMaxOperations: 10})
+
+	tests := []struct {
+		name         string
+		token        string
+		wantStatuses []string
+		wantCreate   int
+	}{
+		{name: "bulk only", token: "hr-sync-token", wantStatuses: []string{"201", "201", "400"}, wantCreate: http.StatusForbidden},
+		{name: "no bulk", token: "okta-token", wantStatuses: []string{"403", "403", "403"}, wantCreate: http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		header := map[string]string{"Authorization": "Bearer " + tt.token}
+
+		body := strings.Replace(bulkRequest, "%s", "5", 1)
+		_, _, got := send(t, srv, http.MethodPost, "/default/scim/v2/Bulk", body, header)
+		ops, _ := got["Operations"].([]any)
+		statuses := make([]string, len(ops))
+		for i, op := range ops {
+			statuses[i], _ = op.(map[string]any)["status"].(string)
+		}
+		if !reflect.DeepEqual(statuses, tt.wantStatuses) {
+			t.Errorf("%s : got bulk statuses %v, want %v", tt.name, statuses, tt.wantStatuses)
+		}
+
+		user := `{"userName": "` + tt.token + `"}`
+		if status, _, _ := send(t, srv, http.MethodPost, "/default/scim/v2/Users", user, header); status != tt.wantCreate {
+			t.Errorf("%s : got create status %d, want %d", tt.name, status, tt.wantCreate)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package scimsvc
+
+import (
+	"context"
+	"reflect"
+	"sort"
+
+	"github.com/iamBelugaa/scim-gateway/gen/scim"
+	"github.com/iamBelugaa/scim-gateway/internal/attribute"
+	"github.com/iamBelugaa/scim-gateway/internal/connector"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+	"github.com/iamBelugaa/scim-gateway/internal/tenant"
+)
+
+// plan returns the changes a dry run made to a fork of the tenant store and
+// the connector operations they would run.
+func (s *Service) plan(ctx context.Context, t *tenant.Tenant, fork *store.Memory, acl *attribute.ACL) *scim.Plan {
+	plan := &scim.Plan{Changes: make([]*scim.PlannedChange, 0), Operations: make([]*scim.PlannedOperation, 0)}
+
+	events := fork.Changes(ctx)
+	changes := make([]connector.Change, len(events))
+	for i, event := range events {
+		changes[i] = connector.ChangeOf(t.ID, event)
+
+		planned := &scim.PlannedChange{
+			Kind:         string(event.Kind),
+			ResourceType: string(event.Resource.Type),
+			ID:           event.Resource.ID,
+			Version:      event.Resource.Version,
+			Attributes:   acl.Mask(event.Resource.Attributes),
+		}
+		if event.Previous != nil {
+			planned.ChangedAttributes = changedAttributes(acl.Mask(event.Previous.Attributes), planned.Attributes)
+		}
+		plan.Changes = append(plan.Changes, planned)
+	}
+
+	for _, step := range s.connectors.Plan(changes) {
+		op := &scim.PlannedOperation{
+			Connector:      step.Connector,
+			Operation:      string(step.Operation),
+			ResourceType:   string(step.ResourceType),
+			ID:             step.ID,
+			RemoteID:       optional(step.RemoteID),
+			AddedMembers:   step.Added,
+			RemovedMembers: step.Removed,
+			Error:          optional(step.Error),
+		}
+		if step.Attributes != nil {
+			op.Attributes = acl.Mask(step.Attributes)
+		}
+		plan.Operations = append(plan.Operations, op)
+	}
+	return plan
+}
+
+// changedAttributes returns the sorted names of the attributes that differ
+// between two versions of a resource.
+func changedAttributes(previous, current map[string]any) []string {
+	changed := make([]string, 0)
+	for name, val := range current {
+		if prev, ok := previous[name]; !ok || !reflect.DeepEqual(prev, val) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range previous {
+		if _, ok := current[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// optional returns a pointer to s, or nil when s is empty.
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
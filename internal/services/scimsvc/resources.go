@@ -0,0 +1,590 @@
+package scimsvc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iamBelugaa/scim-gateway/gen/scim"
+	"github.com/iamBelugaa/scim-gateway/internal/attribute"
+	"github.com/iamBelugaa/scim-gateway/internal/auth"
+	"github.com/iamBelugaa/scim-gateway/internal/filter"
+	"github.com/iamBelugaa/scim-gateway/internal/patch"
+	"github.com/iamBelugaa/scim-gateway/internal/schema"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+	"github.com/iamBelugaa/scim-gateway/internal/tenant"
+)
+
+// errVersionMismatch is returned when a write expects a version other than
+// the current version of its resource.
+var errVersionMismatch = errors.New("resource version mismatch")
+
+// resourceOperations maps the methods serving Users and Groups to the
+// operation they perform.
+var resourceOperations = map[string]auth.Operation{
+	"CreateUser":   {Resource: string(store.ResourceTypeUser), Action: auth.ActionCreate},
+	"GetUser":      {Resource: string(store.ResourceTypeUser), Action: auth.ActionRead},
+	"ListUsers":    {Resource: string(store.ResourceTypeUser), Action: auth.ActionRead},
+	"ReplaceUser":  {Resource: string(store.ResourceTypeUser), Action: auth.ActionUpdate},
+	"PatchUser":    {Resource: string(store.ResourceTypeUser), Action: auth.ActionUpdate},
+	"DeleteUser":   {Resource: string(store.ResourceTypeUser), Action: auth.ActionDelete},
+	"CreateGroup":  {Resource: string(store.ResourceTypeGroup), Action: auth.ActionCreate},
+	"GetGroup":     {Resource: string(store.ResourceTypeGroup), Action: auth.ActionRead},
+	"ListGroups":   {Resource: string(store.ResourceTypeGroup), Action: auth.ActionRead},
+	"ReplaceGroup": {Resource: string(store.ResourceTypeGroup), Action: auth.ActionUpdate},
+	"PatchGroup":   {Resource: string(store.ResourceTypeGroup), Action: auth.ActionUpdate},
+	"DeleteGroup":  {Resource: string(store.ResourceTypeGroup), Action: auth.ActionDelete},
+}
+
+// Create a User.
+func (s *Service) CreateUser(ctx context.Context, p *scim.CreateResourceRequest) (*scim.SCIMResource, error) {
+	return s.create(ctx, store.ResourceTypeUser, p)
+}
+
+// Retrieve a User by its id.
+func (s *Service) GetUser(ctx context.Context, p *scim.GetResourceRequest) (*scim.SCIMResource, error) {
+	return s.get(ctx, store.ResourceTypeUser, p)
+}
+
+// List the Users matching a filter.
+func (s *Service) ListUsers(ctx context.Context, p *scim.ListResourcesRequest) (*scim.ListResourcesResponse, error) {
+	return s.list(ctx, store.ResourceTypeUser, p)
+}
+
+// Replace the attributes of a User.
+func (s *Service) ReplaceUser(ctx context.Context, p *scim.ReplaceResourceRequest) (*scim.SCIMResource, error) {
+	return s.replace(ctx, store.ResourceTypeUser, p)
+}
+
+// Modify some attributes of a User.
+func (s *Service) PatchUser(ctx context.Context, p *scim.PatchResourceRequest) (*scim.SCIMResource, error) {
+	return s.patch(ctx, store.ResourceTypeUser, p)
+}
+
+// Delete a User.
+func (s *Service) DeleteUser(ctx context.Context, p *scim.DeleteResourceRequest) error {
+	return s.delete(ctx, store.ResourceTypeUser, p)
+}
+
+// Create a Group.
+func (s *Service) CreateGroup(ctx context.Context, p *scim.CreateResourceRequest) (*scim.SCIMResource, error) {
+	return s.create(ctx, store.ResourceTypeGroup, p)
+}
+
+// Retrieve a Group by its id.
+func (s *Service) GetGroup(ctx context.Context, p *scim.GetResourceRequest) (*scim.SCIMResource, error) {
+	return s.get(ctx, store.ResourceTypeGroup, p)
+}
+
+// List the Groups matching a filter.
+func (s *Service) ListGroups(ctx context.Context, p *scim.ListResourcesRequest) (*scim.ListResourcesResponse, error) {
+	return s.list(ctx, store.ResourceTypeGroup, p)
+}
+
+// Replace the attributes of a Group.
+func (s *Service) ReplaceGroup(ctx context.Context, p *scim.ReplaceResourceRequest) (*scim.SCIMResource, error) {
+	return s.replace(ctx, store.ResourceTypeGroup, p)
+}
+
+// Modify some attributes of a Group.
+func (s *Service) PatchGroup(ctx context.Context, p *scim.PatchResourceRequest) (*scim.SCIMResource, error) {
+	return s.patch(ctx, store.ResourceTypeGroup, p)
+}
+
+// Delete a Group.
+func (s *Service) DeleteGroup(ctx context.Context, p *scim.DeleteResourceRequest) error {
+	return s.delete(ctx, store.ResourceTypeGroup, p)
+}
+
+// create stores a new resource. Like every write to the tenant store, it is
+// recorded in the outbox and so provisioned to the connectors in scope.
+func (s *Service) create(
+	ctx context.Context, resourceType store.ResourceType, p *scim.CreateResourceRequest,
+) (*scim.SCIMResource, error) {
+	t, err := s.tenant(ctx, p.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	proj, serr := projection(p.Attributes, p.ExcludedAttributes)
+	if serr != nil {
+		return nil, serr
+	}
+
+	acl := s.auth.AttributeACL(ctx)
+	st, dryRun := s.writeStore(t, p.DryRun)
+	created, err := createResource(ctx, st, t, acl, resourceType, writable(p.Resource))
+	if err != nil {
+		s.log.Infow("rejected resource create", "tenant", t.ID, "resourceType", resourceType, "error", err)
+		return nil, resourceError(err)
+	}
+
+	if dryRun {
+		s.log.Infow("planned resource create", "tenant", t.ID, "resourceType", created.Type, "id", created.ID)
+	} else {
+		s.log.Infow("created resource", "tenant", t.ID, "resourceType", created.Type, "id", created.ID)
+	}
+	return toSCIMResource(t, created, proj, acl, dryRun), nil
+}
+
+// get returns the current version of a resource.
+func (s *Service) get(
+	ctx context.Context, resourceType store.ResourceType, p *scim.GetResourceRequest,
+) (*scim.SCIMResource, error) {
+	t, err := s.tenant(ctx, p.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	proj, serr := projection(p.Attributes, p.ExcludedAttributes)
+	if serr != nil {
+		return nil, serr
+	}
+
+	res, err := t.Store.Get(ctx, resourceType, p.ID)
+	if err != nil {
+		return nil, resourceError(err)
+	}
+	return toSCIMResource(t, res, proj, s.auth.AttributeACL(ctx), false), nil
+}
+
+// list returns a page of the resources matching a filter, in creation order.
+// A startIndex below 1 is read as 1, and the count is capped by maxResults.
+func (s *Service) list(
+	ctx context.Context, resourceType store.ResourceType, p *scim.ListResourcesRequest,
+) (*scim.ListResourcesResponse, error) {
+	t, err := s.tenant(ctx, p.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	var f *filter.Filter
+	if p.Filter != nil && *p.Filter != "" {
+		if f, err = filter.Parse(*p.Filter); err != nil {
+			return nil, invalid("invalidFilter", err.Error())
+		}
+	}
+	proj, serr := projection(p.Attributes, p.ExcludedAttributes)
+	if serr != nil {
+		return nil, serr
+	}
+
+	candidates, err := s.candidates(ctx, t, resourceType, f)
+	if err != nil {
+		return nil, resourceError(err)
+	}
+	matched := make([]*store.Resource, 0, len(candidates))
+	for _, res := range candidates {
+		if f == nil || f.Matches(res.Attributes) {
+			matched = append(matched, res)
+		}
+	}
+
+	count := s.maxResults
+	if p.Count != nil && *p.Count < count {
+		count = max(*p.Count, 0)
+	}
+	start := min(max(p.StartIndex, 1), len(matched)+1)
+	page := matched[start-1 : min(start-1+count, len(matched))]
+
+	acl := s.auth.AttributeACL(ctx)
+	res := &scim.ListResourcesResponse{
+		Schemas:      []string{listResponseSchema},
+		TotalResults: len(matched),
+		ItemsPerPage: len(page),
+		StartIndex:   start,
+		Resources:    make([]map[string]any, len(page)),
+	}
+	for i, r := range page {
+		res.Resources[i] = toSCIMResource(t, r, proj, acl, false).Resource
+	}
+	return res, nil
+}
+
+// candidates returns the resources a filter is matched against. Filters on
+// the groups of users are served by the membership index of the store, which
+// walks the group rather than every user.
+func (s *Service) candidates(
+	ctx context.Context, t *tenant.Tenant, resourceType store.ResourceType, f *filter.Filter,
+) ([]*store.Resource, error) {
+	if resourceType != store.ResourceTypeUser || f == nil {
+		return t.Store.List(ctx, resourceType)
+	}
+
+	path, groupID, ok := f.Equality()
+	if !ok || (path.Schema != "" && path.Schema != schema.URIUser) ||
+		!strings.EqualFold(path.Name, "groups") || !strings.EqualFold(path.Sub, "value") {
+		return t.Store.List(ctx, resourceType)
+	}
+
+	users, err := t.Store.UsersInGroup(ctx, groupID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+
+	// Pages follow creation order, as they do for every other list.
+	slices.SortFunc(users, func(a, b *store.Resource) int {
+		if c := a.Created.Compare(b.Created); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return users, err
+}
+
+// replace writes new attributes as the next version of a resource.
+func (s *Service) replace(
+	ctx context.Context, resourceType store.ResourceType, p *scim.ReplaceResourceRequest,
+) (*scim.SCIMResource, error) {
+	t, err := s.tenant(ctx, p.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	proj, serr := projection(p.Attributes, p.ExcludedAttributes)
+	if serr != nil {
+		return nil, serr
+	}
+
+	acl := s.auth.AttributeACL(ctx)
+	st, dryRun := s.writeStore(t, p.DryRun)
+	replaced, err := replaceResource(ctx, st, t, acl, resourceType, p.ID, p.IfMatch, writable(p.Resource))
+	if err != nil {
+		s.log.Infow("rejected resource replace", "tenant", t.ID, "resourceType", resourceType, "id", p.ID, "error", err)
+		return nil, resourceError(err)
+	}
+
+	if dryRun {
+		s.log.Infow("planned resource replace", "tenant", t.ID, "resourceType", replaced.Type, "id", replaced.ID)
+	} else {
+		s.log.Infow("replaced resource", "tenant", t.ID, "resourceType", replaced.Type, "id", replaced.ID, "version", replaced.Version)
+	}
+	return toSCIMResource(t, replaced, proj, acl, dryRun), nil
+}
+
+// patch applies PATCH operations to the current version of a resource and
+// writes the outcome as its next version.
+func (s *Service) patch(
+	ctx context.Context, resourceType store.ResourceType, p *scim.PatchResourceRequest,
+) (*scim.SCIMResource, error) {
+	t, err := s.tenant(ctx, p.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	proj, serr := projection(p.Attributes, p.ExcludedAttributes)
+	if serr != nil {
+		return nil, serr
+	}
+
+	ops := make([]patch.Operation, len(p.Operations))
+	for i, op := range p.Operations {
+		ops[i] = patch.Operation{Op: op.Op, Value: op.Value}
+		if op.Path != nil {
+			ops[i].Path = *op.Path
+		}
+	}
+
+	acl := s.auth.AttributeACL(ctx)
+	st, dryRun := s.writeStore(t, p.DryRun)
+	patched, err := patchResource(ctx, st, t, acl, resourceType, p.ID, p.IfMatch, ops)
+	if err != nil {
+		s.log.Infow("rejected resource patch", "tenant", t.ID, "resourceType", resourceType, "id", p.ID, "error", err)
+		return nil, resourceError(err)
+	}
+
+	if dryRun {
+		s.log.Infow("planned resource patch", "tenant", t.ID, "resourceType", patched.Type, "id", patched.ID)
+	} else {
+		s.log.Infow("patched resource", "tenant", t.ID, "resourceType", patched.Type, "id", patched.ID, "version", patched.Version)
+	}
+	return toSCIMResource(t, patched, proj, acl, dryRun), nil
+}
+
+// delete soft deletes a resource. Later reads answer 404 until the resource is
+// restored through the admin API.
+func (s *Service) delete(ctx context.Context, resourceType store.ResourceType, p *scim.DeleteResourceRequest) error {
+	t, err := s.tenant(ctx, p.TenantID)
+	if err != nil {
+		return err
+	}
+
+	st, dryRun := s.writeStore(t, p.DryRun)
+	if err := deleteResource(ctx, st, resourceType, p.ID, p.IfMatch); err != nil {
+		s.log.Infow("rejected resource delete", "tenant", t.ID, "resourceType", resourceType, "id", p.ID, "error", err)
+		return resourceError(err)
+	}
+
+	if dryRun {
+		s.log.Infow("planned resource delete", "tenant", t.ID, "resourceType", resourceType, "id", p.ID)
+	} else {
+		s.log.Infow("deleted resource", "tenant", t.ID, "resourceType", resourceType, "id", p.ID)
+	}
+	return nil
+}
+
+// writeStore returns the store a write goes to, and whether the write is a
+// dry run. Dry runs, requested per request or configured for every write,
+// write to a fork of the tenant store that is dropped once planned.
+func (s *Service) writeStore(t *tenant.Tenant, dryRun bool) (*store.Memory, bool) {
+	if dryRun || s.dryRun {
+		return t.Store.Fork(), true
+	}
+	return t.Store, false
+}
+
+// createResource checks new attributes against the caller's attribute ACL
+// and the schemas of the tenant, and stores them as a new resource.
+func createResource(
+	ctx context.Context, w writer, t *tenant.Tenant, acl *attribute.ACL,
+	resourceType store.ResourceType, attrs map[string]any,
+) (*store.Resource, error) {
+	if err := acl.Write(map[string]any{}, attrs); err != nil {
+		return nil, err
+	}
+	if err := t.Schemas.Validate(string(resourceType), attrs); err != nil {
+		return nil, err
+	}
+	return w.Create(ctx, &store.Resource{Type: resourceType, Attributes: attrs})
+}
+
+// replaceResource checks new attributes against the caller's attribute ACL
+// and the schemas of the tenant, and writes them as the next version of a
+// resource. The version check is made against the version being replaced,
+// so a concurrent write between the check and the replace fails it.
+func replaceResource(
+	ctx context.Context, w writer, t *tenant.Tenant, acl *attribute.ACL,
+	resourceType store.ResourceType, id string, version *string, attrs map[string]any,
+) (*store.Resource, error) {
+	return w.Update(ctx, resourceType, id, func(res *store.Resource) error {
+		if err := checkVersion(res, version); err != nil {
+			return err
+		}
+
+		// The groups attribute is derived from group members rather than
+		// stored, so it is left out of both sides of the ACL check.
+		current := maps.Clone(res.Attributes)
+		delete(current, "groups")
+		if err := acl.Write(current, attrs); err != nil {
+			return err
+		}
+		if err := t.Schemas.Validate(string(res.Type), attrs); err != nil {
+			return err
+		}
+		res.Attributes = attrs
+		return nil
+	})
+}
+
+// patchResource applies PATCH operations to the current version of a
+// resource, checks the outcome like a replace, and writes it as the next
+// version. The operations are applied to the version being replaced, so
+// concurrent patches of the same resource, such as member additions to a
+// group, are applied one after the other and none is lost.
+func patchResource(
+	ctx context.Context, w writer, t *tenant.Tenant, acl *attribute.ACL,
+	resourceType store.ResourceType, id string, version *string, ops []patch.Operation,
+) (*store.Resource, error) {
+	return w.Update(ctx, resourceType, id, func(res *store.Resource) error {
+		if err := checkVersion(res, version); err != nil {
+			return err
+		}
+
+		// The groups attribute is derived from group members rather than
+		// stored, so it is left out of both sides of the ACL check.
+		current := maps.Clone(res.Attributes)
+		delete(current, "groups")
+		patched := res.Clone().Attributes
+		if patched == nil {
+			patched = make(map[string]any)
+		}
+		if err := patch.Apply(patched, ops); err != nil {
+			return err
+		}
+
+		attrs := writable(patched)
+		if err := acl.Write(current, attrs); err != nil {
+			return err
+		}
+		if err := t.Schemas.Validate(string(res.Type), attrs); err != nil {
+			return err
+		}
+		res.Attributes = attrs
+		return nil
+	})
+}
+
+// deleteResource soft deletes a resource. A delete expecting a version
+// compares it with the current version and deletes in one step, so a
+// concurrent write in between fails the delete rather than being lost.
+func deleteResource(ctx context.Context, w writer, resourceType store.ResourceType, id string, version *string) error {
+	if version == nil || *version == "*" {
+		return w.Delete(ctx, resourceType, id)
+	}
+
+	expected, ok := parseEntityTag(*version)
+	if !ok {
+		return fmt.Errorf("%w : %s %q cannot be at version %s", errVersionMismatch, resourceType, id, *version)
+	}
+	return w.DeleteIfVersion(ctx, resourceType, id, expected)
+}
+
+// writable returns a copy of the attributes a client sent without those owned
+// by the gateway.
+func writable(attrs map[string]any) map[string]any {
+	writable := maps.Clone(attrs)
+	if writable == nil {
+		writable = make(map[string]any)
+	}
+	for _, name := range []string{"id", "meta", "groups"} {
+		delete(writable, name)
+	}
+	return writable
+}
+
+// projection returns the attributes a request selects for the resources it
+// returns, from its comma separated attributes and excludedAttributes
+// parameters.
+func projection(attributes, excluded *string) (attribute.Projection, *scim.SCIMError) {
+	var proj attribute.Projection
+	for _, param := range []struct {
+		value *string
+		paths *[]attribute.Path
+	}{
+		{value: attributes, paths: &proj.Attributes},
+		{value: excluded, paths: &proj.Excluded},
+	} {
+		if param.value == nil || *param.value == "" {
+			continue
+		}
+		paths, err := attribute.ParsePaths(strings.Split(*param.value, ","))
+		if err != nil {
+			return attribute.Projection{}, invalid("invalidPath", err.Error())
+		}
+		*param.paths = paths
+	}
+	return proj, nil
+}
+
+// toSCIMResource converts a resource into its SCIM representation, holding
+// the attributes the request selects without those hidden from the caller.
+// Resources written by dry runs were never stored, so they get neither a
+// location nor an entity tag.
+func toSCIMResource(
+	t *tenant.Tenant, res *store.Resource, proj attribute.Projection, acl *attribute.ACL, dryRun bool,
+) *scim.SCIMResource {
+	attrs := maps.Clone(res.Attributes)
+	if attrs == nil {
+		attrs = make(map[string]any)
+	}
+	attrs["id"] = res.ID
+
+	meta := map[string]any{
+		"resourceType": string(res.Type),
+		"created":      res.Created.Format(time.RFC3339),
+		"lastModified": res.LastModified.Format(time.RFC3339),
+	}
+	attrs["meta"] = meta
+
+	endpoint := ""
+	for _, rt := range t.Schemas.ResourceTypes() {
+		if rt.ID != string(res.Type) {
+			continue
+		}
+		endpoint = rt.Endpoint
+		if _, ok := attrs["schemas"]; !ok {
+			attrs["schemas"] = []any{rt.Schema}
+		}
+	}
+
+	out := &scim.SCIMResource{}
+	if !dryRun {
+		location := basePath(t.ID) + endpoint + "/" + res.ID
+		version := entityTag(res.Version)
+		meta["location"] = location
+		meta["version"] = version
+		out.Location = &location
+		out.Etag = &version
+	}
+	out.Resource = proj.Apply(attrs, acl)
+	return out
+}
+
+// entityTag returns the weak entity tag of a resource version.
+func entityTag(version uint64) string {
+	return fmt.Sprintf("W/%q", strconv.FormatUint(version, 10))
+}
+
+// parseEntityTag returns the resource version an entity tag, weak or not,
+// refers to.
+func parseEntityTag(tag string) (uint64, bool) {
+	version, err := strconv.ParseUint(strings.Trim(strings.TrimPrefix(tag, "W/"), `"`), 10, 64)
+	return version, err == nil
+}
+
+// checkVersion checks a resource is at the version a write expects, if it
+// expects one. The "*" entity tag matches any version.
+func checkVersion(res *store.Resource, version *string) error {
+	if version == nil || *version == "*" {
+		return nil
+	}
+
+	if want, ok := parseEntityTag(*version); !ok || want != res.Version {
+		return fmt.Errorf("%w : %s %q is at version %d, expected %s", errVersionMismatch, res.Type, res.ID, res.Version, *version)
+	}
+	return nil
+}
+
+// resourceError converts the error a read or write failed with into a SCIM
+// error response.
+func resourceError(err error) *scim.SCIMError {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return scimError(http.StatusNotFound, err.Error())
+	case errors.Is(err, store.ErrAlreadyExists):
+		return withSCIMType(scimError(http.StatusConflict, err.Error()), "uniqueness")
+	case errors.Is(err, errUnresolvedBulkID):
+		return withSCIMType(scimError(http.StatusConflict, err.Error()), "invalidValue")
+	case errors.Is(err, errVersionMismatch), errors.Is(err, store.ErrVersionConflict):
+		return scimError(http.StatusPreconditionFailed, err.Error())
+	case errors.Is(err, schema.ErrInvalid), errors.Is(err, patch.ErrInvalidValue):
+		return invalid("invalidValue", err.Error())
+	case errors.Is(err, patch.ErrInvalidPath):
+		return invalid("invalidPath", err.Error())
+	case errors.Is(err, patch.ErrNoTarget):
+		return invalid("noTarget", err.Error())
+	case errors.Is(err, patch.ErrInvalidSyntax):
+		return invalid("invalidSyntax", err.Error())
+	case errors.Is(err, attribute.ErrProtected), errors.Is(err, auth.ErrForbidden):
+		return scimError(http.StatusForbidden, err.Error())
+	default:
+		return scimError(http.StatusInternalServerError, err.Error())
+	}
+}
+
+// invalid builds a SCIM error response for a malformed request.
+func invalid(scimType, detail string) *scim.SCIMError {
+	return withSCIMType(scimError(http.StatusBadRequest, detail), scimType)
+}
+
+// errorBody returns the JSON body of an error response, as embedded in the
+// outcome of a failed Bulk operation.
+func errorBody(err *scim.SCIMError) map[string]any {
+	body := map[string]any{"schemas": err.Schemas, "status": err.Status, "detail": err.Detail}
+	if err.ScimType != nil {
+		body["scimType"] = *err.ScimType
+	}
+	return body
+}
+
+// withSCIMType sets the SCIM detail error keyword of an error response.
+func withSCIMType(err *scim.SCIMError, scimType string) *scim.SCIMError {
+	err.ScimType = &scimType
+	return err
+}
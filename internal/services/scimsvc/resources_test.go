@@ -0,0 +1,377 @@
+package scimsvc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/iamBelugaa/scim-gateway/internal/config"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+)
+
+// send sends a request authenticated with the static token "okta-token" and
+// returns the response status, headers and decoded JSON body.
+func send(t *testing.T, srv *httptest.Server, method, path, body string, header map[string]string) (
+	int, http.Header, map[string]any,
+) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to build request : %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer okta-token")
+	req.Header.Set("Content-Type", "application/json")
+	for name, val := range header {
+		req.Header.Set(name, val)
+	}
+
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("failed to send request : %v", err)
+	}
+	defer resp.Body.Close()
+
+	var decoded map[string]any
+	if resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+			t.Fatalf("failed to decode response : %v", err)
+		}
+	}
+	return resp.StatusCode, resp.Header, decoded
+}
+
+// TestResources creates, reads and replaces a user over HTTP, and checks each
+// write is versioned and recorded in the outbox connectors are provisioned
+// from.
+func TestResources(t *testing.T) {
+	ctx := context.Background()
+	srv, s := newTestServer(t, &config.Store{}, &config.SCIM{MaxResults: 100})
+
+	status, header, created := send(t, srv, http.MethodPost, "/default/scim/v2/Users", `{"userName": "bjensen"}`, nil)
+	if status != http.StatusCreated {
+		t.Fatalf("got status %d, want %d : %v", status, http.StatusCreated, created)
+	}
+	id, _ := created["id"].(string)
+	location := "/default/scim/v2/Users/" + id
+	if header.Get("Location") != location || header.Get("ETag") != `W/"1"` {
+		t.Fatalf("got location %q and etag %q, want %q and %q", header.Get("Location"), header.Get("ETag"), location, `W/"1"`)
+	}
+
+	status, _, _ = send(t, srv, http.MethodPost, "/default/scim/v2/Users", `{"userName": "BJensen"}`, nil)
+	if status != http.StatusConflict {
+		t.Fatalf("got status %d for a taken userName, want %d", status, http.StatusConflict)
+	}
+
+	status, _, got := send(t, srv, http.MethodGet, location, "", nil)
+	if status != http.StatusOK || got["userName"] != "bjensen" {
+		t.Fatalf("got status %d and user %v, want %d and the created user", status, got, http.StatusOK)
+	}
+
+	replacement := `{"userName": "bjensen", "title": "Tour Guide"}`
+	status, _, _ = send(t, srv, http.MethodPut, location, replacement, map[string]string{"If-Match": `W/"7"`})
+	if status != http.StatusPreconditionFailed {
+		t.Fatalf("got status %d for a stale If-Match, want %d", status, http.StatusPreconditionFailed)
+	}
+	status, header, got = send(t, srv, http.MethodPut, location, replacement, map[string]string{"If-Match": `W/"1"`})
+	if status != http.StatusOK || got["title"] != "Tour Guide" || header.Get("ETag") != `W/"2"` {
+		t.Fatalf("got status %d, etag %q and user %v, want the replaced user at version 2", status, header.Get("ETag"), got)
+	}
+
+	events := s.PendingEvents(ctx, 0, 10)
+	if len(events) != 2 || events[0].Kind != store.EventCreated || events[1].Kind != store.EventUpdated {
+		t.Fatalf("got %d events, want the create and the replace recorded for connectors", len(events))
+	}
+}
+
+// TestResourcesDryRun checks writes asking for a dry run leave the store and
+// the outbox untouched.
+func TestResourcesDryRun(t *testing.T) {
+	ctx := context.Background()
+	srv, s := newTestServer(t, &config.Store{}, &config.SCIM{MaxResults: 100})
+
+	status, header, created := send(
+		t, srv, http.MethodPost, "/default/scim/v2/Users", `{"userName": "bjensen"}`, map[string]string{"X-Dry-Run": "true"},
+	)
+	if status != http.StatusCreated || header.Get("Location") != "" || created["userName"] != "bjensen" {
+		t.Fatalf("got status %d, location %q and user %v, want the planned user without a location", status, header.Get("Location"), created)
+	}
+
+	users, err := s.List(ctx, store.ResourceTypeUser)
+	if err != nil {
+		t.Fatalf("failed to list users : %v", err)
+	}
+	if len(users) != 0 || len(s.PendingEvents(ctx, 0, 10)) != 0 {
+		t.Fatalf("got %d users, want the dry run to leave the store untouched", len(users))
+	}
+}
+
+// TestDeleteAndRestore deletes a group member over HTTP, checks later reads
+// answer 404 and that a restore brings the user back into its group.
+func TestDeleteAndRestore(t *testing.T) {
+	ctx := context.Background()
+	srv, s := newTestServer(t, &config.Store{}, &config.SCIM{MaxResults: 100})
+
+	_, _, user := send(t, srv, http.MethodPost, "/default/scim/v2/Users", `{"userName": "bjensen"}`, nil)
+	userID, _ := user["id"].(string)
+	_, _, group := send(
+		t, srv, http.MethodPost, "/default/scim/v2/Groups",
+		`{"displayName": "Tour Guides", "members": [{"value": "`+userID+`"}]}`, nil,
+	)
+	groupID, _ := group["id"].(string)
+
+	userPath := "/default/scim/v2/Users/" + userID
+	if status, _, _ := send(t, srv, http.MethodDelete, userPath, "", map[string]string{"If-Match": `W/"2"`}); status != http.StatusPreconditionFailed {
+		t.Fatalf("got status %d for a stale If-Match, want %d", status, http.StatusPreconditionFailed)
+	}
+	if status, _, _ := send(t, srv, http.MethodDelete, userPath, "", map[string]string{"If-Match": `W/"1"`}); status != http.StatusNoContent {
+		t.Fatalf("got status %d, want %d", status, http.StatusNoContent)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		if status, _, _ := send(t, srv, method, userPath, "", nil); status != http.StatusNotFound {
+			t.Fatalf("got status %d for %s after delete, want %d", status, method, http.StatusNotFound)
+		}
+	}
+	_, _, group = send(t, srv, http.MethodGet, "/default/scim/v2/Groups/"+groupID, "", nil)
+	if members, _ := group["members"].([]any); len(members) != 0 {
+		t.Fatalf("got members %v, want the deleted user removed from its group", members)
+	}
+
+	if _, err := s.Undelete(ctx, store.ResourceTypeUser, userID); err != nil {
+		t.Fatalf("failed to restore user : %v", err)
+	}
+	status, _, user := send(t, srv, http.MethodGet, userPath, "", nil)
+	groups, _ := user["groups"].([]any)
+	if status != http.StatusOK || len(groups) != 1 || groups[0].(map[string]any)["value"] != groupID {
+		t.Fatalf("got status %d and groups %v, want the restored user back in group %q", status, groups, groupID)
+	}
+}
+
+// TestListUsers lists users one page at a time and by the groups they belong
+// to, directly or through a nested group, as group membership changes.
+func TestListUsers(t *testing.T) {
+	srv, _ := newTestServer(t, &config.Store{}, &config.SCIM{MaxResults: 2})
+
+	create := func(path, body string) string {
+		t.Helper()
+		status, _, created := send(t, srv, http.MethodPost, "/default/scim/v2"+path, body, nil)
+		if status != http.StatusCreated {
+			t.Fatalf("got status %d, want %d : %v", status, http.StatusCreated, created)
+		}
+		id, _ := created["id"].(string)
+		return id
+	}
+	list := func(query string) []string {
+		t.Helper()
+		status, _, page := send(t, srv, http.MethodGet, "/default/scim/v2/Users?"+query, "", nil)
+		if status != http.StatusOK {
+			t.Fatalf("got status %d, want %d : %v", status, http.StatusOK, page)
+		}
+		resources, _ := page["Resources"].([]any)
+		ids := make([]string, len(resources))
+		for i, res := range resources {
+			ids[i], _ = res.(map[string]any)["id"].(string)
+		}
+		return ids
+	}
+	member := func(id string) string { return `{"value": "` + id + `"}` }
+
+	alice := create("/Users", `{"userName": "alice"}`)
+	bob := create("/Users", `{"userName": "bob"}`)
+	carol := create("/Users", `{"userName": "carol"}`)
+	inner := create("/Groups", `{"displayName": "Inner", "members": [`+member(bob)+`]}`)
+	outer := create("/Groups", `{"displayName": "Outer", "members": [`+member(alice)+`, `+member(inner)+`]}`)
+
+	if got := list("startIndex=3"); !reflect.DeepEqual(got, []string{carol}) {
+		t.Fatalf("got page %v, want the third user", got)
+	}
+	if got := list("count=5"); len(got) != 2 {
+		t.Fatalf("got %d users, want pages capped at maxResults", len(got))
+	}
+
+	byGroup := url.Values{"filter": {`groups.value eq "` + outer + `"`}}.Encode()
+	if got := list(byGroup); !reflect.DeepEqual(got, []string{alice, bob}) {
+		t.Fatalf("got members %v, want the direct and the nested member", got)
+	}
+
+	_, _, user := send(t, srv, http.MethodGet, "/default/scim/v2/Users/"+bob, "", nil)
+	if groups, _ := user["groups"].([]any); len(groups) != 2 {
+		t.Fatalf("got groups %v, want the direct and the indirect group", groups)
+	}
+
+	send(t, srv, http.MethodPut, "/default/scim/v2/Groups/"+inner, `{"displayName": "Inner", "members": [`+member(carol)+`]}`, nil)
+	if got := list(byGroup); !reflect.DeepEqual(got, []string{alice, carol}) {
+		t.Fatalf("got members %v after replacing the nested group, want its new member", got)
+	}
+
+	send(t, srv, http.MethodDelete, "/default/scim/v2/Users/"+alice, "", nil)
+	send(t, srv, http.MethodDelete, "/default/scim/v2/Groups/"+inner, "", nil)
+	if got := list(byGroup); len(got) != 0 {
+		t.Fatalf("got members %v, want none once the user and the nested group are deleted", got)
+	}
+
+	if status, _, _ := send(t, srv, http.MethodGet, "/default/scim/v2/Users?filter=userName", "", nil); status != http.StatusBadRequest {
+		t.Fatalf("got status %d for a malformed filter, want %d", status, http.StatusBadRequest)
+	}
+}
+
+// TestConcurrentPatch adds members to a group with concurrent PATCH requests,
+// as Entra ID sends them when assigning many users at once, and checks none
+// of the additions is lost.
+func TestConcurrentPatch(t *testing.T) {
+	ctx := context.Background()
+	srv, s := newTestServer(t, &config.Store{}, &config.SCIM{MaxResults: 100})
+
+	const users = 200
+	ids := make([]string, users)
+	for i := range ids {
+		created, err := s.Create(ctx, &store.Resource{
+			Type:       store.ResourceTypeUser,
+			Attributes: map[string]any{"userName": fmt.Sprintf("user%d", i)},
+		})
+		if err != nil {
+			t.Fatalf("failed to create user : %v", err)
+		}
+		ids[i] = created.ID
+	}
+	_, _, group := send(t, srv, http.MethodPost, "/default/scim/v2/Groups", `{"displayName": "Tour Guides"}`, nil)
+	groupPath := "/default/scim/v2/Groups/" + group["id"].(string)
+
+	var wg sync.WaitGroup
+	statuses := make([]int, users)
+	for i, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body := `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [` +
+				`{"op": "add", "path": "members", "value": [{"value": "` + id + `"}]}]}`
+			statuses[i], _, _ = send(t, srv, http.MethodPatch, groupPath, body, nil)
+		}()
+	}
+	wg.Wait()
+
+	for i, status := range statuses {
+		if status != http.StatusOK {
+			t.Fatalf("got status %d for patch %d, want %d", status, i, http.StatusOK)
+		}
+	}
+	_, header, got := send(t, srv, http.MethodGet, groupPath, "", nil)
+	if members, _ := got["members"].([]any); len(members) != users {
+		t.Fatalf("got %d members, want %d", len(members), users)
+	}
+	if want := entityTag(users + 1); header.Get("ETag") != want {
+		t.Fatalf("got etag %q, want %q once every patch is applied", header.Get("ETag"), want)
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		header map[string]string
+		want   int
+	}{
+		{
+			name:   "stale If-Match",
+			body:   `{"Operations": [{"op": "replace", "path": "displayName", "value": "Guides"}]}`,
+			header: map[string]string{"If-Match": `W/"1"`},
+			want:   http.StatusPreconditionFailed,
+		},
+		{
+			name: "no target",
+			body: `{"Operations": [{"op": "replace", "path": "members[value eq \"missing\"].display", "value": "x"}]}`,
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "remove member",
+			body: `{"Operations": [{"op": "remove", "path": "members[value eq \"` + ids[0] + `\"]"}]}`,
+			want: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		if status, _, _ := send(t, srv, http.MethodPatch, groupPath, tt.body, tt.header); status != tt.want {
+			t.Errorf("%s : got status %d, want %d", tt.name, status, tt.want)
+		}
+	}
+
+	_, _, user := send(t, srv, http.MethodGet, "/default/scim/v2/Users/"+ids[0], "", nil)
+	if groups, _ := user["groups"].([]any); len(groups) != 0 {
+		t.Fatalf("got groups %v, want the removed member out of the group", groups)
+	}
+}
+
+// TestProjection reads, lists and writes users over HTTP selecting the
+// attributes returned with attributes and excludedAttributes, including in
+// Bulk responses.
+func TestProjection(t *testing.T) {
+	srv, _ := newTestServer(t, &config.Store{}, &config.SCIM{MaxResults: 100, BulkMaxOperations: 10})
+
+	user := `{"userName": "bjensen", "title": "Tour Guide", "name": {"givenName": "Barbara", "familyName": "Jensen"}}`
+	status, _, created := send(t, srv, http.MethodPost, "/default/scim/v2/Users?attributes=userName", user, nil)
+	if status != http.StatusCreated {
+		t.Fatalf("got status %d, want %d : %v", status, http.StatusCreated, created)
+	}
+	id, _ := created["id"].(string)
+
+	keys := func(attrs map[string]any) []string {
+		names := make([]string, 0, len(attrs))
+		for name := range attrs {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		return names
+	}
+	bulk := func(query string) map[string]any {
+		body := `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:BulkRequest"], "Operations": [` +
+			`{"method": "PUT", "path": "/Users/` + id + `", "data": ` + user + `}]}`
+		_, _, res := send(t, srv, http.MethodPost, "/default/scim/v2/Bulk?"+query, body, nil)
+		ops, _ := res["Operations"].([]any)
+		if len(ops) != 1 {
+			t.Fatalf("got %d bulk operations, want 1 : %v", len(ops), res)
+		}
+		written, _ := ops[0].(map[string]any)["response"].(map[string]any)
+		return written
+	}
+	list := func(query string) map[string]any {
+		_, _, page := send(t, srv, http.MethodGet, "/default/scim/v2/Users?"+query, "", nil)
+		resources, _ := page["Resources"].([]any)
+		if len(resources) != 1 {
+			t.Fatalf("got %d users, want 1 : %v", len(resources), page)
+		}
+		return resources[0].(map[string]any)
+	}
+	get := func(query string) map[string]any {
+		_, _, got := send(t, srv, http.MethodGet, "/default/scim/v2/Users/"+id+"?"+query, "", nil)
+		return got
+	}
+
+	tests := []struct {
+		name string
+		got  map[string]any
+		want []string
+	}{
+		{name: "create", got: created, want: []string{"id", "meta", "schemas", "userName"}},
+		{name: "get", got: get("attributes=name.givenName"), want: []string{"id", "meta", "name", "schemas"}},
+		{name: "get excluded", got: get("excludedAttributes=name,title,groups,id"), want: []string{"id", "meta", "schemas", "userName"}},
+		{name: "list", got: list("attributes=title"), want: []string{"id", "meta", "schemas", "title"}},
+		{name: "bulk", got: bulk("excludedAttributes=name,userName"), want: []string{"id", "meta", "schemas", "title"}},
+	}
+	for _, tt := range tests {
+		if got := keys(tt.got); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s : got attributes %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if name, _ := get("attributes=name.givenName")["name"].(map[string]any); !reflect.DeepEqual(name, map[string]any{"givenName": "Barbara"}) {
+		t.Fatalf("got name %v, want the given name alone", name)
+	}
+	if status, _, _ := send(t, srv, http.MethodGet, "/default/scim/v2/Users/"+id+"?attributes=name.given.name", "", nil); status != http.StatusBadRequest {
+		t.Fatalf("got status %d for a malformed attribute path, want %d", status, http.StatusBadRequest)
+	}
+}
@@ -2,44 +2,259 @@ package scimsvc
 
 import (
 	"context"
+	"errors"
+	"net/http"
+	"strconv"
 
 	"github.com/iamBelugaa/scim-gateway/gen/scim"
+	"github.com/iamBelugaa/scim-gateway/internal/auth"
+	"github.com/iamBelugaa/scim-gateway/internal/config"
+	"github.com/iamBelugaa/scim-gateway/internal/connector"
+	"github.com/iamBelugaa/scim-gateway/internal/schema"
+	"github.com/iamBelugaa/scim-gateway/internal/tenant"
 	"github.com/iamBelugaa/scim-gateway/pkg/logger"
 	"goa.design/goa/v3/security"
 )
 
+// SCIM schema URIs of the messages and resources served by this service.
+const (
+	bulkResponseSchema          = "urn:ietf:params:scim:api:messages:2.0:BulkResponse"
+	errorSchema                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	listResponseSchema          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	resourceTypeSchema          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	serviceProviderConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+)
+
 type Service struct {
-	log *logger.Logger
+	log                *logger.Logger
+	auth               *auth.Authenticator
+	tenants            *tenant.Registry
+	connectors         *connector.Dispatcher // Connectors the operations of dry run plans are planned on.
+	dryRun             bool                  // Whether writes are only planned, leaving the tenant stores untouched.
+	atomicBulk         bool                  // Whether Bulk requests run in one store transaction.
+	maxResults         int                   // Largest page of resources a list request returns.
+	bulkMaxOperations  int                   // Most operations a Bulk request may hold.
+	bulkMaxPayloadSize int64                 // Largest Bulk request body accepted, in bytes.
 }
 
-func NewService(log *logger.Logger) *Service {
-	return &Service{log: log}
+func NewService(
+	log *logger.Logger, authenticator *auth.Authenticator, tenants *tenant.Registry, connectors *connector.Dispatcher,
+	storeCfg *config.Store, scimCfg *config.SCIM,
+) *Service {
+	return &Service{
+		log:                log,
+		auth:               authenticator,
+		tenants:            tenants,
+		connectors:         connectors,
+		dryRun:             storeCfg.DryRun,
+		atomicBulk:         storeCfg.AtomicBulk,
+		maxResults:         scimCfg.MaxResults,
+		bulkMaxOperations:  scimCfg.BulkMaxOperations,
+		bulkMaxPayloadSize: scimCfg.BulkMaxPayloadSize,
+	}
 }
 
 // Retrieves service provider's configuration metadata including supported SCIM
 // features and authentication schemes.
-func (s *Service) ServiceProviderConfig(context.Context, *scim.ServiceProviderRequest) (
+func (s *Service) ServiceProviderConfig(ctx context.Context, p *scim.TenantRequest) (
 	*scim.ServiceProviderConfigResponse, error,
 ) {
-	return &scim.ServiceProviderConfigResponse{}, nil
+	if _, err := s.tenant(ctx, p.TenantID); err != nil {
+		return nil, err
+	}
+
+	res := &scim.ServiceProviderConfigResponse{
+		Schemas:               []string{serviceProviderConfigSchema},
+		AuthenticationSchemes: make([]*scim.AuthenticationScheme, 0),
+		Patch:                 &scim.Supported{Supported: true},
+		Bulk:                  &scim.BulkSupported{Supported: true, MaxOperations: uint(s.bulkMaxOperations), MaxPayloadSize: uint(s.bulkMaxPayloadSize)},
+		Filter:                &scim.FilterSupported{Supported: true, MaxResults: uint(s.maxResults)},
+		ChangePassword:        &scim.Supported{Supported: false},
+		Sort:                  &scim.Supported{Supported: false},
+		Etag:                  &scim.Supported{Supported: true},
+	}
+
+	// Advertise the enabled authentication schemes, the first one being primary.
+	for i, scheme := range s.auth.Schemes() {
+		res.AuthenticationSchemes = append(res.AuthenticationSchemes, &scim.AuthenticationScheme{
+			Type:        scheme.Type,
+			Name:        scheme.Name,
+			Description: scheme.Description,
+			SpecURI:     scheme.SpecURI,
+			Primary:     i == 0,
+		})
+	}
+	return res, nil
 }
 
 // Retrieve the supported schemas.
-func (s *Service) ListSchemas(context.Context, *scim.ServiceProviderRequest) (*scim.ListSchemaResponse, error) {
-	return &scim.ListSchemaResponse{}, nil
+func (s *Service) ListSchemas(ctx context.Context, p *scim.TenantRequest) (*scim.ListSchemaResponse, error) {
+	t, err := s.tenant(ctx, p.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	schemas := t.Schemas.Schemas()
+	res := &scim.ListSchemaResponse{
+		Schemas:      []string{listResponseSchema},
+		TotalResults: len(schemas),
+		ItemsPerPage: len(schemas),
+		StartIndex:   1,
+		Resources:    make([]*scim.SCIMSchema, len(schemas)),
+	}
+	for i, sch := range schemas {
+		res.Resources[i] = toSCIMSchema(t.ID, sch)
+	}
+	return res, nil
 }
 
 // Retrieve a specific schema by its ID.
-func (s *Service) GetSchema(context.Context, *scim.GetSchemaPayload) (*scim.SCIMSchema, error) {
-	return &scim.SCIMSchema{}, nil
+func (s *Service) GetSchema(ctx context.Context, p *scim.GetSchemaPayload) (*scim.SCIMSchema, error) {
+	t, err := s.tenant(ctx, p.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	sch, ok := t.Schemas.Get(p.ID)
+	if !ok {
+		return nil, scimError(http.StatusNotFound, "schema "+strconv.Quote(p.ID)+" not found")
+	}
+	return toSCIMSchema(t.ID, sch), nil
 }
 
 // Retrieve the supported resource types.
-func (s *Service) ResourceTypes(context.Context, *scim.ServiceProviderRequest) (*scim.ListResourceResponse, error) {
-	return &scim.ListResourceResponse{}, nil
+func (s *Service) ResourceTypes(ctx context.Context, p *scim.TenantRequest) (*scim.ListResourceResponse, error) {
+	t, err := s.tenant(ctx, p.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	types := t.Schemas.ResourceTypes()
+	res := &scim.ListResourceResponse{
+		Schemas:      []string{listResponseSchema},
+		TotalResults: uint(len(types)),
+		ItemsPerPage: uint(len(types)),
+		StartIndex:   1,
+		Resources:    make([]*scim.ResourceType, len(types)),
+	}
+	for i, rt := range types {
+		res.Resources[i] = &scim.ResourceType{
+			Schemas:     []string{resourceTypeSchema},
+			ID:          rt.ID,
+			Name:        rt.Name,
+			Endpoint:    rt.Endpoint,
+			Description: rt.Description,
+			Schema:      rt.Schema,
+			Meta: &scim.ResourceMeta{
+				ResourceType: "ResourceType",
+				Location:     basePath(t.ID) + "/ResourceTypes/" + rt.ID,
+			},
+		}
+		for _, ext := range rt.Extensions {
+			res.Resources[i].SchemaExtensions = append(res.Resources[i].SchemaExtensions, &scim.SchemaExtension{
+				Schema:   ext.Schema,
+				Required: ext.Required,
+			})
+		}
+	}
+	return res, nil
+}
+
+// Operation returns the operation a method of the service performs, to be
+// checked against the caller's policy. Discovery methods are open to every
+// authenticated caller, since clients need them to find out what they may do.
+// Methods serving Users and Groups declare the action they perform on their
+// resource type. Bulk requests perform an operation per entry, so each entry is checked as
+// it is applied instead.
+func (s *Service) Operation(method string, payload any) (auth.Operation, bool) {
+	op, ok := resourceOperations[method]
+	return op, ok
+}
+
+// tenant returns the tenant a request is scoped to, once the caller is known
+// to belong to it.
+func (s *Service) tenant(ctx context.Context, id string) (*tenant.Tenant, error) {
+	t, err := s.tenants.Authorize(ctx, id)
+	switch {
+	case errors.Is(err, tenant.ErrNotFound):
+		return nil, scimError(http.StatusNotFound, err.Error())
+	case err != nil:
+		s.log.Infow("rejected request", "tenant", id, "error", err)
+		return nil, s.authError(err)
+	}
+	return t, nil
 }
 
 // APIKeyAuth implements the authorization logic for the APIKey security scheme.
 func (s *Service) APIKeyAuth(ctx context.Context, key string, schema *security.APIKeyScheme) (context.Context, error) {
+	// Failures are not logged here since the JWT scheme is tried next and
+	// reports the outcome.
+	ctx, err := s.auth.AuthenticateToken(ctx, key)
+	if err != nil {
+		return ctx, s.authError(err)
+	}
 	return ctx, nil
 }
+
+// JWTAuth implements the authorization logic for the JWT security scheme.
+func (s *Service) JWTAuth(ctx context.Context, token string, schema *security.JWTScheme) (context.Context, error) {
+	ctx, err := s.auth.AuthenticateJWT(ctx, token, schema.RequiredScopes)
+	if err != nil {
+		s.log.Infow("rejected request", "error", err)
+		return ctx, s.authError(err)
+	}
+	return ctx, nil
+}
+
+// authError converts an authentication error into a SCIM error response.
+func (s *Service) authError(err error) *scim.SCIMError {
+	status := http.StatusUnauthorized
+	if errors.Is(err, auth.ErrInsufficientScope) || errors.Is(err, auth.ErrWrongTenant) || errors.Is(err, auth.ErrForbidden) {
+		status = http.StatusForbidden
+	}
+	return scimError(status, err.Error())
+}
+
+// scimError builds a SCIM error response with the given status.
+func scimError(status int, detail string) *scim.SCIMError {
+	return &scim.SCIMError{
+		Schemas: []string{errorSchema},
+		Status:  strconv.Itoa(status),
+		Detail:  detail,
+	}
+}
+
+// basePath returns the path under which the SCIM API of a tenant is served.
+func basePath(tenantID string) string {
+	return "/" + tenantID + "/scim/v2"
+}
+
+// toSCIMSchema converts a schema definition into its transport representation.
+func toSCIMSchema(tenantID string, sch *schema.Schema) *scim.SCIMSchema {
+	res := &scim.SCIMSchema{
+		ID:          sch.ID,
+		Name:        sch.Name,
+		Description: sch.Description,
+		Attributes:  make([]*scim.SCIMAttribute, len(sch.Attributes)),
+		Meta: &scim.SCIMMeta{
+			ResourceType: "Schema",
+			Location:     basePath(tenantID) + "/Schemas/" + sch.ID,
+		},
+	}
+	for i, attr := range sch.Attributes {
+		res.Attributes[i] = &scim.SCIMAttribute{
+			Name:            attr.Name,
+			Type:            attr.Type,
+			MultiValued:     attr.MultiValued,
+			Description:     attr.Description,
+			Required:        attr.Required,
+			CaseExact:       &attr.CaseExact,
+			Mutability:      attr.Mutability,
+			Returned:        attr.Returned,
+			Uniqueness:      &attr.Uniqueness,
+			CanonicalValues: attr.CanonicalValues,
+			ReferenceTypes:  attr.ReferenceTypes,
+		}
+	}
+	return res
+}
@@ -0,0 +1,41 @@
+package store
+
+import (
+	"context"
+	"maps"
+	"math"
+	"slices"
+)
+
+// Fork returns a copy of the store whose writes leave m untouched, for dry
+// runs. Writes to the fork go through the same checks as writes to m, and
+// every change they make is recorded in the outbox of the fork, starting
+// empty, so the fork reports what the writes would have changed.
+func (m *Memory) Fork() *Memory {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// Stored resources and index sets are never modified in place, so shallow
+	// copies of the maps are enough. Histories are clipped so appending to
+	// them in the fork never writes to the arrays m appends to.
+	history := make(map[key][]*Resource, len(m.history))
+	for k, versions := range m.history {
+		history[k] = slices.Clip(versions)
+	}
+
+	return &Memory{
+		retention:  m.retention,
+		resources:  maps.Clone(m.resources),
+		history:    history,
+		tombstones: maps.Clone(m.tombstones),
+		index:      maps.Clone(m.index),
+		outbox:     outbox{enabled: true, seq: m.outbox.seq, ready: make(chan struct{}, 1)},
+		now:        m.now,
+	}
+}
+
+// Changes returns every event recorded in the outbox of the store, oldest
+// first. On a fork, these are the changes made since it was forked.
+func (m *Memory) Changes(ctx context.Context) []*Event {
+	return m.PendingEvents(ctx, 0, math.MaxInt)
+}
@@ -0,0 +1,166 @@
+package store
+
+import (
+	"maps"
+	"sort"
+)
+
+// groupsAttribute is the read-only User attribute listing the groups a user
+// belongs to, derived from Group membership.
+const groupsAttribute = "groups"
+
+// Membership types reported in the groups attribute of a user.
+const (
+	membershipDirect   = "direct"
+	membershipIndirect = "indirect"
+)
+
+// membershipIndex maps a member id, a user or a nested group, to the set of
+// groups that list it directly as a member. Sets are copied on write so the
+// index can be snapshotted with a shallow copy.
+type membershipIndex map[string]map[string]struct{}
+
+// groupsOf returns the ids of the groups that list id directly as a member.
+func (idx membershipIndex) groupsOf(id string) map[string]struct{} {
+	return idx[id]
+}
+
+// update replaces the indexed members of a group. A nil attribute map removes
+// the group from the index.
+func (idx membershipIndex) update(groupID string, previous, current map[string]any) {
+	before := memberIDs(previous)
+	after := memberIDs(current)
+
+	for memberID := range before {
+		if _, ok := after[memberID]; !ok {
+			idx.unlink(memberID, groupID)
+		}
+	}
+	for memberID := range after {
+		if _, ok := before[memberID]; !ok {
+			idx.link(memberID, groupID)
+		}
+	}
+}
+
+// updateIndex replaces the indexed members of a group, recording the index
+// entries it changes in the journal of the running transaction. Callers must
+// hold the write lock.
+func (m *Memory) updateIndex(groupID string, previous, current map[string]any) {
+	if m.journal != nil {
+		for memberID := range memberIDs(previous) {
+			m.saveIndex(memberID)
+		}
+		for memberID := range memberIDs(current) {
+			m.saveIndex(memberID)
+		}
+	}
+	m.index.update(groupID, previous, current)
+}
+
+// link records groupID as a direct group of memberID.
+func (idx membershipIndex) link(memberID, groupID string) {
+	groups := maps.Clone(idx[memberID])
+	if groups == nil {
+		groups = make(map[string]struct{})
+	}
+
+	groups[groupID] = struct{}{}
+	idx[memberID] = groups
+}
+
+// unlink removes groupID from the direct groups of memberID.
+func (idx membershipIndex) unlink(memberID, groupID string) {
+	groups := maps.Clone(idx[memberID])
+	delete(groups, groupID)
+
+	if len(groups) == 0 {
+		delete(idx, memberID)
+		return
+	}
+	idx[memberID] = groups
+}
+
+// memberIDs returns the set of ids listed in a group's members.
+func memberIDs(attrs map[string]any) map[string]struct{} {
+	ids := make(map[string]struct{})
+	for _, entry := range members(attrs) {
+		if value := memberValue(entry); value != "" {
+			ids[value] = struct{}{}
+		}
+	}
+	return ids
+}
+
+// userGroups computes the groups attribute of a member: the groups that list
+// it directly, followed by the groups it belongs to through nested groups.
+// Callers must hold the lock.
+func (m *Memory) userGroups(memberID string) []any {
+	type membership struct {
+		groupID string
+		kind    string
+	}
+
+	var found []membership
+	visited := map[string]struct{}{memberID: {}}
+
+	// Walk up the membership graph breadth first, so every group is reported
+	// with the shortest path to the member and cycles are visited once.
+	frontier := []string{memberID}
+	for depth := 0; len(frontier) > 0; depth++ {
+		kind := membershipDirect
+		if depth > 0 {
+			kind = membershipIndirect
+		}
+
+		var next []string
+		for _, id := range frontier {
+			parents := make([]string, 0, len(m.index.groupsOf(id)))
+			for groupID := range m.index.groupsOf(id) {
+				parents = append(parents, groupID)
+			}
+			sort.Strings(parents)
+
+			for _, groupID := range parents {
+				if _, ok := visited[groupID]; ok {
+					continue
+				}
+				visited[groupID] = struct{}{}
+				found = append(found, membership{groupID: groupID, kind: kind})
+				next = append(next, groupID)
+			}
+		}
+		frontier = next
+	}
+
+	groups := make([]any, 0, len(found))
+	for _, membership := range found {
+		entry := map[string]any{
+			"value": membership.groupID,
+			"$ref":  "../Groups/" + membership.groupID,
+			"type":  membership.kind,
+		}
+		if group, ok := m.resources[key{resourceType: ResourceTypeGroup, id: membership.groupID}]; ok {
+			if display, ok := group.Attributes["displayName"].(string); ok {
+				entry["display"] = display
+			}
+		}
+		groups = append(groups, entry)
+	}
+	return groups
+}
+
+// withGroups returns a copy of a user with its computed groups attribute set.
+// Other resource types are returned as a plain copy. Callers must hold the lock.
+func (m *Memory) withGroups(res *Resource) *Resource {
+	clone := res.Clone()
+	if clone.Type != ResourceTypeUser {
+		return clone
+	}
+
+	if clone.Attributes == nil {
+		clone.Attributes = make(map[string]any)
+	}
+	clone.Attributes[groupsAttribute] = m.userGroups(clone.ID)
+	return clone
+}
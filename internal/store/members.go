@@ -0,0 +1,61 @@
+package store
+
+// membersAttribute is the Group attribute holding the group's members.
+const membersAttribute = "members"
+
+// members returns the member entries of a group's attributes.
+func members(attrs map[string]any) []any {
+	entries, _ := attrs[membersAttribute].([]any)
+	return entries
+}
+
+// memberValue returns the id a member entry refers to.
+func memberValue(entry any) string {
+	member, ok := entry.(map[string]any)
+	if !ok {
+		return ""
+	}
+
+	value, _ := member["value"].(string)
+	return value
+}
+
+// removeMember removes every entry referring to id from a group's members and
+// returns the removed entries.
+func removeMember(attrs map[string]any, id string) []any {
+	entries := members(attrs)
+
+	var kept, removed []any
+	for _, entry := range entries {
+		if memberValue(entry) == id {
+			removed = append(removed, entry)
+			continue
+		}
+		kept = append(kept, entry)
+	}
+
+	if len(removed) > 0 {
+		attrs[membersAttribute] = kept
+	}
+	return removed
+}
+
+// hasMember reports whether a group's members contain an entry referring to id.
+func hasMember(attrs map[string]any, id string) bool {
+	for _, entry := range members(attrs) {
+		if memberValue(entry) == id {
+			return true
+		}
+	}
+	return false
+}
+
+// addMember appends entry to a group's members unless the member is already present.
+func addMember(attrs map[string]any, entry any) bool {
+	if hasMember(attrs, memberValue(entry)) {
+		return false
+	}
+
+	attrs[membersAttribute] = append(members(attrs), entry)
+	return true
+}
@@ -0,0 +1,606 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/iamBelugaa/scim-gateway/internal/config"
+	"github.com/iamBelugaa/scim-gateway/internal/lock"
+)
+
+// key uniquely identifies a resource within the store.
+type key struct {
+	resourceType ResourceType
+	id           string
+}
+
+// Memory is an in-memory, concurrency safe resource store. Every write is
+// recorded as a new version so previous states of a resource can be listed
+// and read back until they fall outside the configured retention window.
+// Deleted resources are kept as tombstones until they are purged. Group
+// membership is indexed in reverse so a user's groups can be computed without
+// scanning every group.
+type Memory struct {
+	mu         sync.RWMutex
+	retention  time.Duration       // How long superseded versions are kept.
+	resources  map[key]*Resource   // Current version of every live resource.
+	history    map[key][]*Resource // All retained versions, oldest first.
+	tombstones map[key]*Tombstone  // Soft deleted resources awaiting purge.
+	index      membershipIndex     // Reverse index from member id to the groups listing it.
+	outbox     outbox              // Changes awaiting delivery to downstream systems.
+	journal    *journal            // What the running transaction overwrote, nil outside transactions.
+	writers    lock.Keyed[key]     // Serializes read-modify-write updates per resource.
+	now        func() time.Time    // Clock used to stamp writes.
+}
+
+// NewMemory constructs an empty in-memory store using the provided configuration.
+func NewMemory(cfg *config.Store) *Memory {
+	return &Memory{
+		retention:  cfg.HistoryRetention,
+		resources:  make(map[key]*Resource),
+		history:    make(map[key][]*Resource),
+		tombstones: make(map[key]*Tombstone),
+		index:      make(membershipIndex),
+		outbox:     outbox{ready: make(chan struct{}, 1)},
+		now:        func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// Create stores a new resource, assigning it an id when none is set.
+func (m *Memory) Create(ctx context.Context, res *Resource) (created *Resource, err error) {
+	err = m.locked(func() error {
+		created, err = m.create(res)
+		return err
+	})
+	return created, err
+}
+
+// create stores a new resource. Callers must hold the write lock.
+func (m *Memory) create(res *Resource) (*Resource, error) {
+	stored := res.Clone()
+	if stored.ID == "" {
+		stored.ID = uuid.NewString()
+	}
+
+	k := key{resourceType: stored.Type, id: stored.ID}
+	if _, ok := m.resources[k]; ok {
+		return nil, fmt.Errorf("%s %q : %w", stored.Type, stored.ID, ErrAlreadyExists)
+	}
+	if _, ok := m.tombstones[k]; ok {
+		return nil, fmt.Errorf("%s %q : %w", stored.Type, stored.ID, ErrAlreadyExists)
+	}
+	if err := m.checkUnique(stored); err != nil {
+		return nil, err
+	}
+
+	now := m.now()
+	stored.Version = 1
+	stored.Created = now
+	stored.LastModified = now
+
+	m.commit(k, stored)
+	return stored.Clone(), nil
+}
+
+// checkUnique returns ErrAlreadyExists when res is a user whose userName is
+// held by another live user. The User schema declares userName unique on the
+// server, and user names are compared case insensitively. Callers must hold
+// the lock.
+func (m *Memory) checkUnique(res *Resource) error {
+	name, _ := res.Attributes["userName"].(string)
+	if res.Type != ResourceTypeUser || name == "" {
+		return nil
+	}
+
+	for k, other := range m.resources {
+		if k.resourceType != ResourceTypeUser || k.id == res.ID {
+			continue
+		}
+		if taken, _ := other.Attributes["userName"].(string); strings.EqualFold(taken, name) {
+			return fmt.Errorf("%s userName %q is taken by %q : %w", res.Type, name, k.id, ErrAlreadyExists)
+		}
+	}
+	return nil
+}
+
+// Replace writes a new version of an existing resource.
+func (m *Memory) Replace(ctx context.Context, res *Resource) (replaced *Resource, err error) {
+	err = m.locked(func() error {
+		replaced, err = m.replace(res)
+		return err
+	})
+	return replaced, err
+}
+
+// replace writes a new version of an existing resource. Callers must hold the
+// write lock.
+func (m *Memory) replace(res *Resource) (*Resource, error) {
+	k := key{resourceType: res.Type, id: res.ID}
+	current, ok := m.resources[k]
+	if !ok {
+		return nil, fmt.Errorf("%s %q : %w", res.Type, res.ID, ErrNotFound)
+	}
+	if err := m.checkUnique(res); err != nil {
+		return nil, err
+	}
+
+	stored := res.Clone()
+	stored.Created = current.Created
+	stored.Origin = current.Origin
+
+	return m.write(k, stored).Clone(), nil
+}
+
+// ReplaceIfVersion writes a new version of an existing resource only when its
+// current version matches expected. It returns ErrVersionConflict otherwise.
+func (m *Memory) ReplaceIfVersion(ctx context.Context, res *Resource, expected uint64) (replaced *Resource, err error) {
+	err = m.locked(func() error {
+		replaced, err = m.replaceIfVersion(res, expected)
+		return err
+	})
+	return replaced, err
+}
+
+// replaceIfVersion writes a new version of an existing resource at the
+// expected version. Callers must hold the write lock.
+func (m *Memory) replaceIfVersion(res *Resource, expected uint64) (*Resource, error) {
+	current, ok := m.resources[key{resourceType: res.Type, id: res.ID}]
+	if !ok {
+		return nil, fmt.Errorf("%s %q : %w", res.Type, res.ID, ErrNotFound)
+	}
+	if current.Version != expected {
+		return nil, fmt.Errorf(
+			"%s %q is at version %d, expected %d : %w", res.Type, res.ID, current.Version, expected, ErrVersionConflict,
+		)
+	}
+
+	return m.replace(res)
+}
+
+// Update applies fn to the current version of a resource and writes the
+// result as a new version. Updates to the same resource are applied one at a
+// time in the order they acquire the resource, so concurrent read-modify-write
+// cycles such as PATCH requests never overwrite each other. If the resource is
+// changed by a writer that bypasses Update, the update is retried against the
+// newer version.
+func (m *Memory) Update(
+	ctx context.Context, resourceType ResourceType, id string, fn func(res *Resource) error,
+) (*Resource, error) {
+	unlock := m.writers.Lock(key{resourceType: resourceType, id: id})
+	defer unlock()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		current, err := m.Get(ctx, resourceType, id)
+		if err != nil {
+			return nil, err
+		}
+
+		updated := current.Clone()
+		if err := fn(updated); err != nil {
+			return nil, err
+		}
+
+		// Identity and bookkeeping fields are owned by the store.
+		updated.ID = current.ID
+		updated.Type = current.Type
+
+		stored, err := m.ReplaceIfVersion(ctx, updated, current.Version)
+		if errors.Is(err, ErrVersionConflict) {
+			continue
+		}
+		return stored, err
+	}
+}
+
+// Get returns the current version of a resource.
+func (m *Memory) Get(ctx context.Context, resourceType ResourceType, id string) (*Resource, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.get(resourceType, id)
+}
+
+// get returns the current version of a resource. Callers must hold the lock.
+func (m *Memory) get(resourceType ResourceType, id string) (*Resource, error) {
+	res, ok := m.resources[key{resourceType: resourceType, id: id}]
+	if !ok {
+		return nil, fmt.Errorf("%s %q : %w", resourceType, id, ErrNotFound)
+	}
+	return m.withGroups(res), nil
+}
+
+// UsersInGroup returns the users that are members of a group, directly or
+// through nested groups. It walks the group's membership down from the group
+// rather than scanning every user.
+func (m *Memory) UsersInGroup(ctx context.Context, groupID string) ([]*Resource, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.resources[key{resourceType: ResourceTypeGroup, id: groupID}]; !ok {
+		return nil, fmt.Errorf("%s %q : %w", ResourceTypeGroup, groupID, ErrNotFound)
+	}
+
+	users := make([]*Resource, 0)
+	visited := map[string]struct{}{groupID: {}}
+
+	pending := []string{groupID}
+	for len(pending) > 0 {
+		group := m.resources[key{resourceType: ResourceTypeGroup, id: pending[0]}]
+		pending = pending[1:]
+
+		for memberID := range memberIDs(group.Attributes) {
+			if _, ok := visited[memberID]; ok {
+				continue
+			}
+			visited[memberID] = struct{}{}
+
+			if user, ok := m.resources[key{resourceType: ResourceTypeUser, id: memberID}]; ok {
+				users = append(users, m.withGroups(user))
+			}
+			if _, ok := m.resources[key{resourceType: ResourceTypeGroup, id: memberID}]; ok {
+				pending = append(pending, memberID)
+			}
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+// GroupsOf returns the groups that list a user or group directly as a
+// member, ordered by id. It reads the membership index rather than scanning
+// every group.
+func (m *Memory) GroupsOf(ctx context.Context, memberID string) []*Resource {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	groups := make([]*Resource, 0, len(m.index.groupsOf(memberID)))
+	for groupID := range m.index.groupsOf(memberID) {
+		if group, ok := m.resources[key{resourceType: ResourceTypeGroup, id: groupID}]; ok {
+			groups = append(groups, group.Clone())
+		}
+	}
+
+	sort.Slice(groups, func(i, j int) bool { return groups[i].ID < groups[j].ID })
+	return groups
+}
+
+// List returns the current version of every resource of the given type.
+func (m *Memory) List(ctx context.Context, resourceType ResourceType) ([]*Resource, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	resources := make([]*Resource, 0)
+	for k, res := range m.resources {
+		if k.resourceType == resourceType {
+			resources = append(resources, m.withGroups(res))
+		}
+	}
+
+	// Order by creation time so results are stable between calls.
+	sort.Slice(resources, func(i, j int) bool {
+		if resources[i].Created.Equal(resources[j].Created) {
+			return resources[i].ID < resources[j].ID
+		}
+		return resources[i].Created.Before(resources[j].Created)
+	})
+	return resources, nil
+}
+
+// Delete soft deletes a resource. The resource is hidden from reads and kept
+// as a tombstone, together with its version history, until it is purged.
+// Deleting a user or a nested group also removes it from the members of every
+// group, and the removed memberships are recorded on the tombstone so they can
+// be restored.
+func (m *Memory) Delete(ctx context.Context, resourceType ResourceType, id string) error {
+	return m.locked(func() error {
+		return m.remove(resourceType, id)
+	})
+}
+
+// DeleteIfVersion soft deletes a resource only when its current version
+// matches expected. It returns ErrVersionConflict otherwise.
+func (m *Memory) DeleteIfVersion(ctx context.Context, resourceType ResourceType, id string, expected uint64) error {
+	return m.locked(func() error {
+		return m.removeIfVersion(resourceType, id, expected)
+	})
+}
+
+// removeIfVersion soft deletes a resource at the expected version. Callers
+// must hold the write lock.
+func (m *Memory) removeIfVersion(resourceType ResourceType, id string, expected uint64) error {
+	current, ok := m.resources[key{resourceType: resourceType, id: id}]
+	if !ok {
+		return fmt.Errorf("%s %q : %w", resourceType, id, ErrNotFound)
+	}
+	if current.Version != expected {
+		return fmt.Errorf(
+			"%s %q is at version %d, expected %d : %w", resourceType, id, current.Version, expected, ErrVersionConflict,
+		)
+	}
+	return m.remove(resourceType, id)
+}
+
+// remove soft deletes a resource. Callers must hold the write lock.
+func (m *Memory) remove(resourceType ResourceType, id string) error {
+	k := key{resourceType: resourceType, id: id}
+	res, ok := m.resources[k]
+	if !ok {
+		return fmt.Errorf("%s %q : %w", resourceType, id, ErrNotFound)
+	}
+
+	tombstone := &Tombstone{
+		Resource:    res,
+		DeletedAt:   m.now(),
+		memberships: make(map[string][]any),
+	}
+
+	// Remove the resource from every group listing it as a member.
+	for groupID := range m.index.groupsOf(id) {
+		groupKey := key{resourceType: ResourceTypeGroup, id: groupID}
+		group, ok := m.resources[groupKey]
+		if !ok {
+			continue
+		}
+
+		updated := group.Clone()
+		tombstone.memberships[groupID] = removeMember(updated.Attributes, id)
+		tombstone.Groups = append(tombstone.Groups, groupID)
+		m.write(groupKey, updated)
+	}
+	sort.Strings(tombstone.Groups)
+
+	// A deleted group no longer grants membership to its own members.
+	if resourceType == ResourceTypeGroup {
+		m.updateIndex(id, res.Attributes, nil)
+	}
+
+	m.save(k)
+	delete(m.resources, k)
+	m.tombstones[k] = tombstone
+	m.record(EventDeleted, res, nil)
+	return nil
+}
+
+// ListDeleted returns every tombstone of the given resource type, most
+// recently deleted first.
+func (m *Memory) ListDeleted(ctx context.Context, resourceType ResourceType) ([]*Tombstone, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tombstones := make([]*Tombstone, 0)
+	for k, tombstone := range m.tombstones {
+		if k.resourceType == resourceType {
+			tombstones = append(tombstones, tombstone.Clone())
+		}
+	}
+
+	sort.Slice(tombstones, func(i, j int) bool {
+		return tombstones[i].DeletedAt.After(tombstones[j].DeletedAt)
+	})
+	return tombstones, nil
+}
+
+// Undelete restores a soft deleted resource as a new version. The group
+// memberships removed on delete are restored on groups that still exist.
+func (m *Memory) Undelete(ctx context.Context, resourceType ResourceType, id string) (restored *Resource, err error) {
+	err = m.locked(func() error {
+		restored, err = m.undelete(resourceType, id)
+		return err
+	})
+	return restored, err
+}
+
+// undelete restores a soft deleted resource. Callers must hold the write
+// lock.
+func (m *Memory) undelete(resourceType ResourceType, id string) (*Resource, error) {
+	k := key{resourceType: resourceType, id: id}
+	tombstone, ok := m.tombstones[k]
+	if !ok {
+		return nil, fmt.Errorf("deleted %s %q : %w", resourceType, id, ErrNotFound)
+	}
+	if err := m.checkUnique(tombstone.Resource); err != nil {
+		return nil, err
+	}
+
+	// The resource is restored before its memberships, so the changes reach
+	// the outbox in an order downstream systems can apply.
+	m.save(k)
+	delete(m.tombstones, k)
+	restored := m.write(k, tombstone.Resource.Clone())
+
+	// Groups are restored in id order, so the outbox records the same events
+	// in the same order for every restore.
+	for _, groupID := range slices.Sorted(maps.Keys(tombstone.memberships)) {
+		entries := tombstone.memberships[groupID]
+		groupKey := key{resourceType: ResourceTypeGroup, id: groupID}
+		group, ok := m.resources[groupKey]
+		if !ok {
+			continue
+		}
+
+		updated := group.Clone()
+		changed := false
+		for _, entry := range entries {
+			changed = addMember(updated.Attributes, entry) || changed
+		}
+		if changed {
+			m.write(groupKey, updated)
+		}
+	}
+
+	return restored.Clone(), nil
+}
+
+// Purge hard deletes tombstones, and their version history, for resources
+// deleted before the given time. It returns the number of resources purged.
+func (m *Memory) Purge(ctx context.Context, deletedBefore time.Time) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	purged := 0
+	for k, tombstone := range m.tombstones {
+		if tombstone.DeletedAt.Before(deletedBefore) {
+			delete(m.tombstones, k)
+			delete(m.history, k)
+			purged++
+		}
+	}
+	return purged
+}
+
+// Versions returns every retained version of a resource, oldest first.
+func (m *Memory) Versions(ctx context.Context, resourceType ResourceType, id string) ([]*Resource, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	history, ok := m.history[key{resourceType: resourceType, id: id}]
+	if !ok {
+		return nil, fmt.Errorf("%s %q : %w", resourceType, id, ErrNotFound)
+	}
+
+	versions := make([]*Resource, len(history))
+	for i, res := range history {
+		versions[i] = res.Clone()
+	}
+	return versions, nil
+}
+
+// GetVersion returns a specific retained version of a resource.
+func (m *Memory) GetVersion(ctx context.Context, resourceType ResourceType, id string, version uint64) (*Resource, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	history, ok := m.history[key{resourceType: resourceType, id: id}]
+	if !ok {
+		return nil, fmt.Errorf("%s %q : %w", resourceType, id, ErrNotFound)
+	}
+
+	for _, res := range history {
+		if res.Version == version {
+			return res.Clone(), nil
+		}
+	}
+	return nil, fmt.Errorf("%s %q version %d : %w", resourceType, id, version, ErrVersionNotFound)
+}
+
+// GetAsOf returns the version of a resource that was current at the given time.
+func (m *Memory) GetAsOf(ctx context.Context, resourceType ResourceType, id string, at time.Time) (*Resource, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	history, ok := m.history[key{resourceType: resourceType, id: id}]
+	if !ok {
+		return nil, fmt.Errorf("%s %q : %w", resourceType, id, ErrNotFound)
+	}
+
+	// History is ordered oldest first, so the last version written at or
+	// before the requested time is the one that was current.
+	for i := len(history) - 1; i >= 0; i-- {
+		if !history[i].LastModified.After(at) {
+			return history[i].Clone(), nil
+		}
+	}
+	return nil, fmt.Errorf("%s %q as of %s : %w", resourceType, id, at.Format(time.RFC3339), ErrVersionNotFound)
+}
+
+// PruneHistory drops versions that were superseded before the retention
+// window and returns the number of versions removed. The latest version of a
+// resource is always kept.
+func (m *Memory) PruneHistory(ctx context.Context) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pruned := 0
+	for k := range m.history {
+		pruned += m.prune(k)
+	}
+	return pruned
+}
+
+// write stores res as the next version of k, following the latest version in
+// the history. Callers must hold the write lock.
+func (m *Memory) write(k key, res *Resource) *Resource {
+	res.Version = 1
+	if history := m.history[k]; len(history) > 0 {
+		res.Version = history[len(history)-1].Version + 1
+	}
+	res.LastModified = m.now()
+
+	m.commit(k, res)
+	return res
+}
+
+// commit records res as the current version of k, appends it to the version
+// history and records the change in the outbox. Callers must hold the write
+// lock.
+func (m *Memory) commit(k key, res *Resource) {
+	previous, exists := m.resources[k]
+
+	switch k.resourceType {
+	case ResourceTypeUser:
+		// Group membership is derived from the groups, never stored on users.
+		delete(res.Attributes, groupsAttribute)
+	case ResourceTypeGroup:
+		var attrs map[string]any
+		if exists {
+			attrs = previous.Attributes
+		}
+		m.updateIndex(k.id, attrs, res.Attributes)
+	}
+
+	m.save(k)
+	m.resources[k] = res
+	m.history[k] = append(m.history[k], res)
+	m.prune(k)
+
+	// Restored tombstones are not current resources, so they are recorded as
+	// created, just like new resources.
+	if exists {
+		m.record(EventUpdated, res, previous)
+	} else {
+		m.record(EventCreated, res, nil)
+	}
+}
+
+// prune drops expired versions of a single resource. A version expires once
+// the version that replaced it is older than the retention window. Callers
+// must hold the write lock.
+func (m *Memory) prune(k key) int {
+	history := m.history[k]
+	if m.retention <= 0 || len(history) == 0 {
+		return 0
+	}
+
+	cutoff := m.now().Add(-m.retention)
+
+	// Find the first version that is still needed. Version i is needed when
+	// it was still current at the cutoff, i.e. its successor came after it.
+	keepFrom := 0
+	for i := 0; i < len(history)-1; i++ {
+		if history[i+1].LastModified.After(cutoff) {
+			break
+		}
+		keepFrom = i + 1
+	}
+
+	if keepFrom == 0 {
+		return 0
+	}
+
+	m.history[k] = append([]*Resource(nil), history[keepFrom:]...)
+	return keepFrom
+}
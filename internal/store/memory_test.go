@@ -0,0 +1,922 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/iamBelugaa/scim-gateway/internal/config"
+	"github.com/iamBelugaa/scim-gateway/pkg/logger"
+)
+
+// TestUpdateConcurrentMemberAdds fires hundreds of concurrent member adds at
+// the same group and asserts that none of them are lost. Run with -race.
+func TestUpdateConcurrentMemberAdds(t *testing.T) {
+	// Every add copies the whole group, so the cost grows with the square of
+	// the writer count. A few hundred writers already contend on every write.
+	const writers = 200
+
+	ctx := context.Background()
+
+	// Keep only the latest version so hundreds of large group versions are
+	// not retained in memory for the duration of the test.
+	memory := NewMemory(&config.Store{HistoryRetention: time.Nanosecond})
+
+	group, err := memory.Create(ctx, &Resource{
+		Type:       ResourceTypeGroup,
+		Attributes: map[string]any{"displayName": "Engineering"},
+	})
+	if err != nil {
+		t.Fatalf("failed to create group : %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+
+	start := make(chan struct{})
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			_, err := memory.Update(ctx, ResourceTypeGroup, group.ID, func(res *Resource) error {
+				addMember(res.Attributes, map[string]any{"value": fmt.Sprintf("user-%d", i)})
+				return nil
+			})
+			if err != nil {
+				errs <- err
+			}
+		}()
+	}
+
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("update failed : %v", err)
+	}
+
+	updated, err := memory.Get(ctx, ResourceTypeGroup, group.ID)
+	if err != nil {
+		t.Fatalf("failed to get group : %v", err)
+	}
+
+	if got := len(members(updated.Attributes)); got != writers {
+		t.Errorf("expected %d members, got %d", writers, got)
+	}
+	for i := range writers {
+		if !hasMember(updated.Attributes, fmt.Sprintf("user-%d", i)) {
+			t.Errorf("member user-%d was lost", i)
+		}
+	}
+	if want := uint64(writers + 1); updated.Version != want {
+		t.Errorf("expected version %d, got %d", want, updated.Version)
+	}
+}
+
+// TestUpdateConcurrentWithDirectWrites interleaves Update calls with
+// compare-and-swap writes that bypass the per-resource lock, and asserts that
+// every write from both paths is kept.
+func TestUpdateConcurrentWithDirectWrites(t *testing.T) {
+	const writers = 50
+
+	ctx := context.Background()
+	memory := NewMemory(&config.Store{HistoryRetention: time.Nanosecond})
+
+	group, err := memory.Create(ctx, &Resource{Type: ResourceTypeGroup, Attributes: map[string]any{}})
+	if err != nil {
+		t.Fatalf("failed to create group : %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range writers {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			_, err := memory.Update(ctx, ResourceTypeGroup, group.ID, func(res *Resource) error {
+				addMember(res.Attributes, map[string]any{"value": fmt.Sprintf("updated-%d", i)})
+				return nil
+			})
+			if err != nil {
+				t.Errorf("update failed : %v", err)
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			for {
+				current, err := memory.Get(ctx, ResourceTypeGroup, group.ID)
+				if err != nil {
+					t.Errorf("get failed : %v", err)
+					return
+				}
+
+				addMember(current.Attributes, map[string]any{"value": fmt.Sprintf("swapped-%d", i)})
+				_, err = memory.ReplaceIfVersion(ctx, current, current.Version)
+				if errors.Is(err, ErrVersionConflict) {
+					// Let the writer that won run rather than spinning
+					// against it.
+					runtime.Gosched()
+					continue
+				}
+				if err != nil {
+					t.Errorf("replace failed : %v", err)
+				}
+				return
+			}
+		}()
+	}
+	wg.Wait()
+
+	updated, err := memory.Get(ctx, ResourceTypeGroup, group.ID)
+	if err != nil {
+		t.Fatalf("failed to get group : %v", err)
+	}
+
+	if got := len(members(updated.Attributes)); got != writers*2 {
+		t.Errorf("expected %d members, got %d", writers*2, got)
+	}
+}
+
+// TestReplaceIfVersionConflict asserts stale writes are rejected.
+func TestReplaceIfVersionConflict(t *testing.T) {
+	ctx := context.Background()
+	memory := NewMemory(&config.Store{})
+
+	user, err := memory.Create(ctx, &Resource{Type: ResourceTypeUser, Attributes: map[string]any{"userName": "bjensen"}})
+	if err != nil {
+		t.Fatalf("failed to create user : %v", err)
+	}
+
+	if _, err := memory.ReplaceIfVersion(ctx, user, user.Version); err != nil {
+		t.Fatalf("failed to replace user : %v", err)
+	}
+
+	if _, err := memory.ReplaceIfVersion(ctx, user, user.Version); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("expected version conflict, got %v", err)
+	}
+}
+
+// TestDeleteIfVersionConflict asserts deletes expecting a stale version are
+// rejected and leave the resource in place.
+func TestDeleteIfVersionConflict(t *testing.T) {
+	ctx := context.Background()
+	memory := NewMemory(&config.Store{})
+
+	user, err := memory.Create(ctx, &Resource{Type: ResourceTypeUser, Attributes: map[string]any{"userName": "bjensen"}})
+	if err != nil {
+		t.Fatalf("failed to create user : %v", err)
+	}
+	if _, err := memory.Replace(ctx, user); err != nil {
+		t.Fatalf("failed to replace user : %v", err)
+	}
+
+	if err := memory.DeleteIfVersion(ctx, ResourceTypeUser, user.ID, user.Version); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("expected version conflict, got %v", err)
+	}
+	if _, err := memory.Get(ctx, ResourceTypeUser, user.ID); err != nil {
+		t.Fatalf("expected the user to survive a stale delete, got %v", err)
+	}
+	if err := memory.DeleteIfVersion(ctx, ResourceTypeUser, user.ID, user.Version+1); err != nil {
+		t.Errorf("failed to delete user at its current version : %v", err)
+	}
+}
+
+// TestUniqueUserName asserts user names stay unique across creates, replaces
+// and restores, ignoring case.
+func TestUniqueUserName(t *testing.T) {
+	ctx := context.Background()
+	memory := NewMemory(&config.Store{})
+
+	user, err := memory.Create(ctx, &Resource{Type: ResourceTypeUser, Attributes: map[string]any{"userName": "bjensen"}})
+	if err != nil {
+		t.Fatalf("failed to create user : %v", err)
+	}
+	if _, err := memory.Create(ctx, &Resource{Type: ResourceTypeUser, Attributes: map[string]any{"userName": "BJensen"}}); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("expected a taken userName to be rejected on create, got %v", err)
+	}
+
+	other, err := memory.Create(ctx, &Resource{Type: ResourceTypeUser, Attributes: map[string]any{"userName": "jsmith"}})
+	if err != nil {
+		t.Fatalf("failed to create user : %v", err)
+	}
+	other.Attributes["userName"] = "bjensen"
+	if _, err := memory.Replace(ctx, other); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("expected a taken userName to be rejected on replace, got %v", err)
+	}
+
+	if err := memory.Delete(ctx, ResourceTypeUser, user.ID); err != nil {
+		t.Fatalf("failed to delete user : %v", err)
+	}
+	if _, err := memory.Replace(ctx, other); err != nil {
+		t.Fatalf("failed to take the userName of a deleted user : %v", err)
+	}
+	if _, err := memory.Undelete(ctx, ResourceTypeUser, user.ID); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("expected a restore to a taken userName to be rejected, got %v", err)
+	}
+}
+
+// TestOutboxFollowsTransactions checks writes record outbox events that are
+// rolled back with their transaction and removed once acknowledged.
+func TestOutboxFollowsTransactions(t *testing.T) {
+	ctx := context.Background()
+	memory := NewMemory(&config.Store{})
+
+	// Writes made before the outbox is enabled are not recorded.
+	user, err := memory.Create(ctx, &Resource{Type: ResourceTypeUser, Attributes: map[string]any{"userName": "alice"}})
+	if err != nil {
+		t.Fatalf("failed to create user : %v", err)
+	}
+	memory.EnableOutbox()
+
+	err = memory.InTx(ctx, func(tx *Tx) error {
+		if _, err := tx.Create(ctx, &Resource{Type: ResourceTypeUser, Attributes: map[string]any{"userName": "bob"}}); err != nil {
+			return err
+		}
+		return errors.New("abort")
+	})
+	if err == nil {
+		t.Fatal("expected the transaction to fail")
+	}
+	if events := memory.PendingEvents(ctx, 0, 10); len(events) != 0 {
+		t.Fatalf("expected rolled back writes to record no events, got %d", len(events))
+	}
+
+	group, err := memory.Create(ctx, &Resource{Type: ResourceTypeGroup, Attributes: map[string]any{
+		"displayName": "Tour Guides",
+		"members":     []any{map[string]any{"value": user.ID}},
+	}})
+	if err != nil {
+		t.Fatalf("failed to create group : %v", err)
+	}
+	if err := memory.Delete(ctx, ResourceTypeUser, user.ID); err != nil {
+		t.Fatalf("failed to delete user : %v", err)
+	}
+	select {
+	case <-memory.OutboxReady():
+	default:
+		t.Fatal("expected the outbox to signal recorded events")
+	}
+
+	events := memory.PendingEvents(ctx, 0, 10)
+	want := []struct {
+		kind EventKind
+		id   string
+	}{
+		{EventCreated, group.ID},
+		{EventUpdated, group.ID}, // The deleted user is removed from the group.
+		{EventDeleted, user.ID},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("expected %d events, got %d", len(want), len(events))
+	}
+	for i, event := range events {
+		if event.Kind != want[i].kind || event.Resource.ID != want[i].id || event.Seq != uint64(i+1) {
+			t.Fatalf("expected event %d to be %s %s, got %d %s %s", i, want[i].kind, want[i].id, event.Seq, event.Kind, event.Resource.ID)
+		}
+	}
+	if events[1].Previous == nil || len(members(events[1].Previous.Attributes)) != 1 || len(members(events[1].Resource.Attributes)) != 0 {
+		t.Fatal("expected the group update to record the group before and after the change")
+	}
+
+	memory.AckEvents(ctx, 2)
+	if events := memory.PendingEvents(ctx, 0, 10); len(events) != 1 || events[0].Seq != 3 {
+		t.Fatalf("expected only the unacknowledged event to remain, got %d events", len(events))
+	}
+
+	restored, err := memory.Undelete(ctx, ResourceTypeUser, user.ID)
+	if err != nil {
+		t.Fatalf("failed to restore user : %v", err)
+	}
+	events = memory.PendingEvents(ctx, 3, 10)
+	if len(events) != 2 || events[0].Kind != EventCreated || events[0].Resource.ID != restored.ID || events[1].Resource.ID != group.ID {
+		t.Fatalf("expected the restored user to be recorded as created before its group, got %d events", len(events))
+	}
+}
+
+// TestPersistedOutbox checks a persisted outbox hands the events left
+// unacknowledged to the next store opening it, and that a write whose events
+// cannot be persisted is rolled back.
+func TestPersistedOutbox(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "default.outbox")
+
+	memory := NewMemory(&config.Store{})
+	if err := memory.PersistOutbox(path); err != nil {
+		t.Fatalf("failed to persist outbox : %v", err)
+	}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		if _, err := memory.Create(ctx, &Resource{Type: ResourceTypeUser, Attributes: map[string]any{"userName": name}}); err != nil {
+			t.Fatalf("failed to create user : %v", err)
+		}
+	}
+	memory.AckEvents(ctx, 1)
+
+	// The file is closed, as if the disk failed, so the next write fails.
+	memory.outbox.file.Close()
+	if _, err := memory.Create(ctx, &Resource{Type: ResourceTypeUser, Attributes: map[string]any{"userName": "dave"}}); err == nil {
+		t.Fatal("expected the write to fail when its event cannot be persisted")
+	}
+	if users, _ := memory.List(ctx, ResourceTypeUser); len(users) != 3 {
+		t.Fatalf("got %d users, want the failed write rolled back", len(users))
+	}
+
+	restarted := NewMemory(&config.Store{})
+	if err := restarted.PersistOutbox(path); err != nil {
+		t.Fatalf("failed to reopen outbox : %v", err)
+	}
+	events := restarted.PendingEvents(ctx, 0, 10)
+	if len(events) != 2 || events[0].Seq != 2 || events[0].Resource.Attributes["userName"] != "bob" || events[1].Seq != 3 {
+		t.Fatalf("got %d events, want the unacknowledged events of bob and carol", len(events))
+	}
+
+	if _, err := restarted.Create(ctx, &Resource{Type: ResourceTypeUser, Attributes: map[string]any{"userName": "erin"}}); err != nil {
+		t.Fatalf("failed to create user : %v", err)
+	}
+	restarted.AckEvents(ctx, 4)
+	reopened := NewMemory(&config.Store{})
+	if err := reopened.PersistOutbox(path); err != nil {
+		t.Fatalf("failed to reopen outbox : %v", err)
+	}
+	if events := reopened.PendingEvents(ctx, 0, 10); len(events) != 0 {
+		t.Fatalf("got %d events, want none once every event is acknowledged", len(events))
+	}
+}
+
+// TestForkLeavesStoreUntouched checks writes to a fork are recorded as its
+// changes without reaching the store it was forked from.
+func TestForkLeavesStoreUntouched(t *testing.T) {
+	ctx := context.Background()
+	memory := NewMemory(&config.Store{})
+
+	user, err := memory.Create(ctx, &Resource{Type: ResourceTypeUser, Attributes: map[string]any{"userName": "alice"}})
+	if err != nil {
+		t.Fatalf("failed to create user : %v", err)
+	}
+	group, err := memory.Create(ctx, &Resource{Type: ResourceTypeGroup, Attributes: map[string]any{
+		"displayName": "Tour Guides",
+		"members":     []any{map[string]any{"value": user.ID}},
+	}})
+	if err != nil {
+		t.Fatalf("failed to create group : %v", err)
+	}
+
+	fork := memory.Fork()
+	if _, err := fork.Update(ctx, ResourceTypeUser, user.ID, func(res *Resource) error {
+		res.Attributes["title"] = "Guide"
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to update user in fork : %v", err)
+	}
+	if err := fork.Delete(ctx, ResourceTypeGroup, group.ID); err != nil {
+		t.Fatalf("failed to delete group in fork : %v", err)
+	}
+	if _, err := fork.Create(ctx, &Resource{ID: user.ID, Type: ResourceTypeUser}); !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("expected the fork to check writes like the store, got %v", err)
+	}
+
+	changes := fork.Changes(ctx)
+	if len(changes) != 2 || changes[0].Kind != EventUpdated || changes[1].Kind != EventDeleted {
+		t.Fatalf("expected an update and a delete, got %d changes", len(changes))
+	}
+
+	current, err := memory.Get(ctx, ResourceTypeUser, user.ID)
+	if err != nil {
+		t.Fatalf("failed to get user : %v", err)
+	}
+	if _, ok := current.Attributes["title"]; ok || current.Version != 1 {
+		t.Fatalf("expected the store to keep the user unchanged, got version %d", current.Version)
+	}
+	if groups, _ := current.Attributes["groups"].([]any); len(groups) != 1 {
+		t.Fatalf("expected the store to keep the group, got groups %v", current.Attributes["groups"])
+	}
+	if versions, err := memory.Versions(ctx, ResourceTypeUser, user.ID); err != nil || len(versions) != 1 {
+		t.Fatalf("expected the store history to be unchanged, got %d versions", len(versions))
+	}
+}
+
+// clock is a manually advanced clock for stamping store writes.
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time { return c.now }
+
+// newTestMemory constructs a store stamping writes with a manual clock.
+func newTestMemory(cfg *config.Store) (*Memory, *clock) {
+	c := &clock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	memory := NewMemory(cfg)
+	memory.now = c.Now
+	return memory, c
+}
+
+// TestVersionHistory writes several versions of a user and reads them back by
+// number and by point in time.
+func TestVersionHistory(t *testing.T) {
+	ctx := context.Background()
+	memory, c := newTestMemory(&config.Store{})
+
+	user, err := memory.Create(ctx, &Resource{Type: ResourceTypeUser, Attributes: map[string]any{"userName": "bjensen"}})
+	if err != nil {
+		t.Fatalf("failed to create user : %v", err)
+	}
+	created := c.now
+
+	for _, title := range []string{"Engineer", "Manager"} {
+		c.now = c.now.Add(time.Hour)
+		if _, err := memory.Update(ctx, ResourceTypeUser, user.ID, func(res *Resource) error {
+			res.Attributes["title"] = title
+			return nil
+		}); err != nil {
+			t.Fatalf("failed to update user : %v", err)
+		}
+	}
+
+	versions, err := memory.Versions(ctx, ResourceTypeUser, user.ID)
+	if err != nil {
+		t.Fatalf("failed to list versions : %v", err)
+	}
+	if len(versions) != 3 || versions[0].Version != 1 || versions[2].Version != 3 || versions[2].Attributes["title"] != "Manager" {
+		t.Fatalf("expected three versions oldest first, got %+v", versions)
+	}
+	if !versions[1].Created.Equal(created) || !versions[1].LastModified.Equal(created.Add(time.Hour)) {
+		t.Fatalf("expected versions to keep the creation time, got %+v", versions[1])
+	}
+
+	second, err := memory.GetVersion(ctx, ResourceTypeUser, user.ID, 2)
+	if err != nil {
+		t.Fatalf("failed to get version : %v", err)
+	}
+	if second.Attributes["title"] != "Engineer" {
+		t.Fatalf("expected version 2 to be the engineer, got %v", second.Attributes["title"])
+	}
+	if _, err := memory.GetVersion(ctx, ResourceTypeUser, user.ID, 4); !errors.Is(err, ErrVersionNotFound) {
+		t.Fatalf("expected a missing version, got %v", err)
+	}
+	if _, err := memory.Versions(ctx, ResourceTypeUser, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a missing resource, got %v", err)
+	}
+
+	tests := []struct {
+		at      time.Time
+		version uint64
+	}{
+		{at: created, version: 1},
+		{at: created.Add(time.Minute * 90), version: 2},
+		{at: created.Add(time.Hour * 2), version: 3},
+		{at: created.Add(time.Hour * 24), version: 3},
+	}
+	for _, tt := range tests {
+		res, err := memory.GetAsOf(ctx, ResourceTypeUser, user.ID, tt.at)
+		if err != nil {
+			t.Fatalf("failed to get user as of %s : %v", tt.at, err)
+		}
+		if res.Version != tt.version {
+			t.Errorf("expected version %d as of %s, got %d", tt.version, tt.at, res.Version)
+		}
+	}
+	if _, err := memory.GetAsOf(ctx, ResourceTypeUser, user.ID, created.Add(-time.Second)); !errors.Is(err, ErrVersionNotFound) {
+		t.Fatalf("expected no version before creation, got %v", err)
+	}
+
+	// Reads hand out copies, so changing them leaves the history untouched.
+	second.Attributes["title"] = "Changed"
+	if again, _ := memory.GetVersion(ctx, ResourceTypeUser, user.ID, 2); again.Attributes["title"] != "Engineer" {
+		t.Fatalf("expected history to be immutable, got %v", again.Attributes["title"])
+	}
+}
+
+// TestPruneHistory asserts versions are dropped once superseded for longer
+// than the retention window, keeping what GetAsOf needs within it.
+func TestPruneHistory(t *testing.T) {
+	ctx := context.Background()
+	memory, c := newTestMemory(&config.Store{HistoryRetention: time.Hour * 24})
+
+	user, err := memory.Create(ctx, &Resource{Type: ResourceTypeUser, Attributes: map[string]any{"userName": "bjensen"}})
+	if err != nil {
+		t.Fatalf("failed to create user : %v", err)
+	}
+	update := func(title string) {
+		t.Helper()
+		if _, err := memory.Update(ctx, ResourceTypeUser, user.ID, func(res *Resource) error {
+			res.Attributes["title"] = title
+			return nil
+		}); err != nil {
+			t.Fatalf("failed to update user : %v", err)
+		}
+	}
+
+	c.now = c.now.Add(time.Hour)
+	update("Engineer")
+	c.now = c.now.Add(time.Hour * 30)
+	update("Manager")
+
+	// Writes prune the history of the resource they write. Version 1 was
+	// superseded 30 hours ago, version 2 is still needed to read the user as
+	// of 24 hours ago.
+	versions, _ := memory.Versions(ctx, ResourceTypeUser, user.ID)
+	if len(versions) != 2 || versions[0].Version != 2 {
+		t.Fatalf("expected versions 2 and 3 to be kept, got %d versions", len(versions))
+	}
+	if _, err := memory.GetVersion(ctx, ResourceTypeUser, user.ID, 1); !errors.Is(err, ErrVersionNotFound) {
+		t.Fatalf("expected pruned version to be gone, got %v", err)
+	}
+	if pruned := memory.PruneHistory(ctx); pruned != 0 {
+		t.Fatalf("expected nothing left to prune, got %d", pruned)
+	}
+
+	// Resources that are not written are pruned by PruneHistory. The latest
+	// version is kept however old it is, and writes keep numbering versions
+	// after it.
+	c.now = c.now.Add(time.Hour * 24 * 365)
+	if pruned := memory.PruneHistory(ctx); pruned != 1 {
+		t.Fatalf("expected one pruned version, got %d", pruned)
+	}
+	versions, _ = memory.Versions(ctx, ResourceTypeUser, user.ID)
+	if len(versions) != 1 || versions[0].Version != 3 {
+		t.Fatalf("expected only the latest version to be kept, got %d versions", len(versions))
+	}
+	update("Director")
+	if current, _ := memory.Get(ctx, ResourceTypeUser, user.ID); current.Version != 4 {
+		t.Fatalf("expected version 4 after pruning, got %d", current.Version)
+	}
+}
+
+// TestSoftDelete deletes a user and a group it belongs to, and asserts
+// tombstones hide them from reads until the user is restored with the
+// memberships that still can be.
+func TestSoftDelete(t *testing.T) {
+	ctx := context.Background()
+	memory := NewMemory(&config.Store{})
+
+	user, err := memory.Create(ctx, &Resource{Type: ResourceTypeUser, Attributes: map[string]any{"userName": "bjensen"}})
+	if err != nil {
+		t.Fatalf("failed to create user : %v", err)
+	}
+	var groups []*Resource
+	for _, name := range []string{"Tour Guides", "Admins"} {
+		group, err := memory.Create(ctx, &Resource{Type: ResourceTypeGroup, Attributes: map[string]any{
+			"displayName": name,
+			"members":     []any{map[string]any{"value": user.ID, "display": "bjensen"}},
+		}})
+		if err != nil {
+			t.Fatalf("failed to create group : %v", err)
+		}
+		groups = append(groups, group)
+	}
+
+	if err := memory.Delete(ctx, ResourceTypeUser, user.ID); err != nil {
+		t.Fatalf("failed to delete user : %v", err)
+	}
+	if _, err := memory.Get(ctx, ResourceTypeUser, user.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected deleted user to be hidden, got %v", err)
+	}
+	if users, _ := memory.List(ctx, ResourceTypeUser); len(users) != 0 {
+		t.Fatalf("expected deleted user to be left out of lists, got %d users", len(users))
+	}
+	if err := memory.Delete(ctx, ResourceTypeUser, user.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected deleting twice to fail, got %v", err)
+	}
+	if _, err := memory.Create(ctx, &Resource{ID: user.ID, Type: ResourceTypeUser}); !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("expected the tombstone to reserve the id, got %v", err)
+	}
+	if versions, err := memory.Versions(ctx, ResourceTypeUser, user.ID); err != nil || len(versions) != 1 {
+		t.Fatalf("expected the history of the deleted user to be kept, got %d versions : %v", len(versions), err)
+	}
+
+	tombstones, err := memory.ListDeleted(ctx, ResourceTypeUser)
+	if err != nil {
+		t.Fatalf("failed to list deleted users : %v", err)
+	}
+	wantGroups := []string{groups[0].ID, groups[1].ID}
+	sort.Strings(wantGroups)
+	if len(tombstones) != 1 || tombstones[0].Resource.ID != user.ID || !slices.Equal(tombstones[0].Groups, wantGroups) {
+		t.Fatalf("expected a tombstone listing both groups, got %+v", tombstones)
+	}
+	for _, group := range groups {
+		current, _ := memory.Get(ctx, ResourceTypeGroup, group.ID)
+		if hasMember(current.Attributes, user.ID) {
+			t.Fatalf("expected the deleted user to be removed from group %s", group.ID)
+		}
+	}
+
+	// Memberships are only restored on groups that still exist.
+	if err := memory.Delete(ctx, ResourceTypeGroup, groups[1].ID); err != nil {
+		t.Fatalf("failed to delete group : %v", err)
+	}
+
+	restored, err := memory.Undelete(ctx, ResourceTypeUser, user.ID)
+	if err != nil {
+		t.Fatalf("failed to restore user : %v", err)
+	}
+	if restored.Version != 2 || restored.Attributes["userName"] != "bjensen" {
+		t.Fatalf("expected the user restored as version 2, got %+v", restored)
+	}
+	current, err := memory.Get(ctx, ResourceTypeUser, user.ID)
+	if err != nil {
+		t.Fatalf("failed to get restored user : %v", err)
+	}
+	userGroups, _ := current.Attributes["groups"].([]any)
+	if len(userGroups) != 1 || userGroups[0].(map[string]any)["value"] != groups[0].ID {
+		t.Fatalf("expected the user to be back in the remaining group, got %v", userGroups)
+	}
+	group, _ := memory.Get(ctx, ResourceTypeGroup, groups[0].ID)
+	if entries := members(group.Attributes); len(entries) != 1 || entries[0].(map[string]any)["display"] != "bjensen" {
+		t.Fatalf("expected the original member entry to be restored, got %v", entries)
+	}
+
+	if tombstones, _ := memory.ListDeleted(ctx, ResourceTypeUser); len(tombstones) != 0 {
+		t.Fatalf("expected no deleted users left, got %d", len(tombstones))
+	}
+	if _, err := memory.Undelete(ctx, ResourceTypeUser, user.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected restoring a live user to fail, got %v", err)
+	}
+}
+
+// TestUndeleteRestoresGroupsInOrder asserts the memberships of a restored
+// user reach the outbox in group id order.
+func TestUndeleteRestoresGroupsInOrder(t *testing.T) {
+	ctx := context.Background()
+	memory := NewMemory(&config.Store{})
+	memory.EnableOutbox()
+
+	user, err := memory.Create(ctx, &Resource{Type: ResourceTypeUser, Attributes: map[string]any{"userName": "bjensen"}})
+	if err != nil {
+		t.Fatalf("failed to create user : %v", err)
+	}
+	var groupIDs []string
+	for i := range 10 {
+		group, err := memory.Create(ctx, &Resource{Type: ResourceTypeGroup, Attributes: map[string]any{
+			"displayName": fmt.Sprintf("Group %d", i),
+			"members":     []any{map[string]any{"value": user.ID}},
+		}})
+		if err != nil {
+			t.Fatalf("failed to create group : %v", err)
+		}
+		groupIDs = append(groupIDs, group.ID)
+	}
+	sort.Strings(groupIDs)
+
+	if err := memory.Delete(ctx, ResourceTypeUser, user.ID); err != nil {
+		t.Fatalf("failed to delete user : %v", err)
+	}
+	seq := memory.PendingEvents(ctx, 0, 100)
+	last := seq[len(seq)-1].Seq
+
+	if _, err := memory.Undelete(ctx, ResourceTypeUser, user.ID); err != nil {
+		t.Fatalf("failed to restore user : %v", err)
+	}
+
+	var restored []string
+	for _, event := range memory.PendingEvents(ctx, last, 100) {
+		if event.Resource.Type == ResourceTypeGroup {
+			restored = append(restored, event.Resource.ID)
+		}
+	}
+	if !slices.Equal(restored, groupIDs) {
+		t.Fatalf("expected group restores in id order\ngot  %v\nwant %v", restored, groupIDs)
+	}
+}
+
+// TestPurgeTombstones asserts the purge job hard deletes tombstones, and
+// their history, once they are older than the retention period.
+func TestPurgeTombstones(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Store{TombstoneRetention: time.Hour * 24}
+	memory, c := newTestMemory(cfg)
+	purger := NewPurger(&logger.Logger{SugaredLogger: zap.NewNop().Sugar()}, memory, cfg)
+
+	var ids []string
+	for _, name := range []string{"alice", "bob"} {
+		user, err := memory.Create(ctx, &Resource{Type: ResourceTypeUser, Attributes: map[string]any{"userName": name}})
+		if err != nil {
+			t.Fatalf("failed to create user : %v", err)
+		}
+		if err := memory.Delete(ctx, ResourceTypeUser, user.ID); err != nil {
+			t.Fatalf("failed to delete user : %v", err)
+		}
+		ids = append(ids, user.ID)
+		c.now = c.now.Add(time.Hour * 12)
+	}
+
+	// Alice was deleted 24 hours ago and bob 12 hours ago.
+	purger.PurgeOnce(ctx)
+	if tombstones, _ := memory.ListDeleted(ctx, ResourceTypeUser); len(tombstones) != 2 {
+		t.Fatalf("expected tombstones within the retention period to be kept, got %d", len(tombstones))
+	}
+
+	c.now = c.now.Add(time.Second)
+	purger.PurgeOnce(ctx)
+	tombstones, _ := memory.ListDeleted(ctx, ResourceTypeUser)
+	if len(tombstones) != 1 || tombstones[0].Resource.ID != ids[1] {
+		t.Fatalf("expected only bob's tombstone to be kept, got %+v", tombstones)
+	}
+	if _, err := memory.Undelete(ctx, ResourceTypeUser, ids[0]); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a purged user to be gone, got %v", err)
+	}
+	if _, err := memory.Versions(ctx, ResourceTypeUser, ids[0]); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected the history of a purged user to be gone, got %v", err)
+	}
+
+	// Purged ids can be reused.
+	if _, err := memory.Create(ctx, &Resource{ID: ids[0], Type: ResourceTypeUser}); err != nil {
+		t.Fatalf("failed to create user with a purged id : %v", err)
+	}
+}
+
+// snapshotStore returns the users, groups and tombstones of a store and the
+// versions of each, for comparing the store before and after a transaction.
+func snapshotStore(t *testing.T, memory *Memory) map[string]any {
+	t.Helper()
+
+	ctx := context.Background()
+	state := make(map[string]any)
+	for _, resourceType := range []ResourceType{ResourceTypeUser, ResourceTypeGroup} {
+		resources, _ := memory.List(ctx, resourceType)
+		for _, res := range resources {
+			versions, _ := memory.Versions(ctx, resourceType, res.ID)
+			state[string(resourceType)+"/"+res.ID] = []any{res, len(versions)}
+		}
+		tombstones, _ := memory.ListDeleted(ctx, resourceType)
+		for _, tombstone := range tombstones {
+			state["deleted/"+tombstone.Resource.ID] = tombstone.Groups
+		}
+	}
+	state["events"] = len(memory.PendingEvents(ctx, 0, 100))
+	return state
+}
+
+// TestTxRollback makes every kind of write in a failing transaction and
+// asserts the store, its membership index and its outbox are left as they
+// were.
+func TestTxRollback(t *testing.T) {
+	ctx := context.Background()
+	memory := NewMemory(&config.Store{})
+	memory.EnableOutbox()
+
+	user, err := memory.Create(ctx, &Resource{Type: ResourceTypeUser, Attributes: map[string]any{"userName": "alice"}})
+	if err != nil {
+		t.Fatalf("failed to create user : %v", err)
+	}
+	group, err := memory.Create(ctx, &Resource{Type: ResourceTypeGroup, Attributes: map[string]any{
+		"displayName": "Tour Guides",
+		"members":     []any{map[string]any{"value": user.ID}},
+	}})
+	if err != nil {
+		t.Fatalf("failed to create group : %v", err)
+	}
+	deleted, err := memory.Create(ctx, &Resource{Type: ResourceTypeUser, Attributes: map[string]any{"userName": "carol"}})
+	if err != nil {
+		t.Fatalf("failed to create user : %v", err)
+	}
+	if err := memory.Delete(ctx, ResourceTypeUser, deleted.ID); err != nil {
+		t.Fatalf("failed to delete user : %v", err)
+	}
+	before := snapshotStore(t, memory)
+
+	errAbort := errors.New("abort")
+	err = memory.InTx(ctx, func(tx *Tx) error {
+		bob, err := tx.Create(ctx, &Resource{Type: ResourceTypeUser, Attributes: map[string]any{"userName": "bob"}})
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Update(ctx, ResourceTypeGroup, group.ID, func(res *Resource) error {
+			addMember(res.Attributes, map[string]any{"value": bob.ID})
+			return nil
+		}); err != nil {
+			return err
+		}
+		if _, err := tx.Create(ctx, &Resource{Type: ResourceTypeGroup, Attributes: map[string]any{
+			"displayName": "Nested",
+			"members":     []any{map[string]any{"value": group.ID, "type": "Group"}},
+		}}); err != nil {
+			return err
+		}
+		if err := tx.Delete(ctx, ResourceTypeUser, user.ID); err != nil {
+			return err
+		}
+
+		// Writes are visible within the transaction.
+		if _, err := tx.Get(ctx, ResourceTypeUser, bob.ID); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("expected the transaction error, got %v", err)
+	}
+
+	if after := snapshotStore(t, memory); !reflect.DeepEqual(before, after) {
+		t.Fatalf("expected the store to be rolled back\nbefore %v\nafter  %v", before, after)
+	}
+	if users, _ := memory.List(ctx, ResourceTypeUser); len(users) != 1 {
+		t.Fatalf("expected the created user to be rolled back, got %d users", len(users))
+	}
+
+	// Writes after a rollback number versions and events as if the
+	// transaction never ran.
+	updated, err := memory.Update(ctx, ResourceTypeGroup, group.ID, func(res *Resource) error {
+		res.Attributes["displayName"] = "Guides"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to update group : %v", err)
+	}
+	if updated.Version != 2 {
+		t.Fatalf("expected version 2, got %d", updated.Version)
+	}
+	if events := memory.PendingEvents(ctx, 0, 100); events[len(events)-1].Seq != uint64(len(events)) {
+		t.Fatalf("expected event sequence numbers without gaps, got %d for %d events", events[len(events)-1].Seq, len(events))
+	}
+}
+
+// TestTxPanicRollback asserts a panicking transaction is rolled back and the
+// panic reaches the caller with the store unlocked.
+func TestTxPanicRollback(t *testing.T) {
+	ctx := context.Background()
+	memory := NewMemory(&config.Store{})
+
+	user, err := memory.Create(ctx, &Resource{Type: ResourceTypeUser, Attributes: map[string]any{"userName": "alice"}})
+	if err != nil {
+		t.Fatalf("failed to create user : %v", err)
+	}
+
+	func() {
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Fatalf("expected the panic to be resumed, got %v", p)
+			}
+		}()
+
+		_ = memory.InTx(ctx, func(tx *Tx) error {
+			if _, err := tx.Replace(ctx, &Resource{ID: user.ID, Type: ResourceTypeUser, Attributes: map[string]any{"userName": "mallory"}}); err != nil {
+				return err
+			}
+			panic("boom")
+		})
+	}()
+
+	current, err := memory.Get(ctx, ResourceTypeUser, user.ID)
+	if err != nil {
+		t.Fatalf("failed to get user : %v", err)
+	}
+	if current.Version != 1 || current.Attributes["userName"] != "alice" {
+		t.Fatalf("expected the replace to be rolled back, got %+v", current)
+	}
+}
+
+// TestTxAfterCommit asserts callbacks run in order once the writes are
+// visible, and never for transactions that roll back.
+func TestTxAfterCommit(t *testing.T) {
+	ctx := context.Background()
+	memory := NewMemory(&config.Store{})
+
+	var calls []string
+	err := memory.InTx(ctx, func(tx *Tx) error {
+		for _, name := range []string{"alice", "bob"} {
+			res, err := tx.Create(ctx, &Resource{Type: ResourceTypeUser, Attributes: map[string]any{"userName": name}})
+			if err != nil {
+				return err
+			}
+			tx.AfterCommit(func() {
+				// Reading through the store would deadlock before commit.
+				if _, err := memory.Get(ctx, ResourceTypeUser, res.ID); err != nil {
+					t.Errorf("expected %s to be committed : %v", name, err)
+				}
+				calls = append(calls, name)
+			})
+		}
+		if len(calls) != 0 {
+			t.Errorf("expected no callback before commit, got %v", calls)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to run transaction : %v", err)
+	}
+	if !slices.Equal(calls, []string{"alice", "bob"}) {
+		t.Fatalf("expected callbacks in registration order, got %v", calls)
+	}
+
+	calls = nil
+	_ = memory.InTx(ctx, func(tx *Tx) error {
+		tx.AfterCommit(func() { calls = append(calls, "rolled back") })
+		return errors.New("abort")
+	})
+	if len(calls) != 0 {
+		t.Fatalf("expected no callback after a rollback, got %v", calls)
+	}
+}
@@ -0,0 +1,275 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// EventKind is the kind of change an outbox event records.
+type EventKind string
+
+// Supported event kinds.
+const (
+	EventCreated EventKind = "created"
+	EventUpdated EventKind = "updated"
+	EventDeleted EventKind = "deleted"
+)
+
+// Event is a change to a resource recorded in the store's outbox. Events are
+// recorded by the write that makes the change, so they are committed and
+// rolled back together with it.
+type Event struct {
+	Seq      uint64    `json:"seq"`                // Position of the event in the outbox, increasing with every event.
+	Kind     EventKind `json:"kind"`               // Kind of change.
+	Resource *Resource `json:"resource"`           // Resource after the change, or its last version when it was deleted.
+	Previous *Resource `json:"previous,omitempty"` // Resource before an update, nil for other changes.
+	At       time.Time `json:"at"`                 // Time of the change.
+}
+
+// outbox holds the events of a store awaiting delivery. Recording is off
+// until a consumer enables it, so stores nobody reads events from do not
+// accumulate them. Events are held in memory, and also appended to a file
+// when the outbox is persisted.
+type outbox struct {
+	enabled bool
+	seq     uint64        // Sequence number of the last recorded event.
+	events  []*Event      // Unacknowledged events, ordered by sequence number.
+	ready   chan struct{} // Signalled whenever an event is recorded.
+	file    *os.File      // File the outbox is persisted to, nil when it is not.
+	saved   uint64        // Sequence number of the last event persisted.
+}
+
+// outboxEntry is a line of a persisted outbox: an event, or the
+// acknowledgement of every event up to a sequence number.
+type outboxEntry struct {
+	Event *Event `json:"event,omitempty"`
+	Ack   uint64 `json:"ack,omitempty"`
+}
+
+// EnableOutbox starts recording every change to the store as an event in its
+// outbox. Changes made before are not recorded.
+func (m *Memory) EnableOutbox() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.outbox.enabled = true
+}
+
+// PersistOutbox enables the outbox and persists it to the file at path, so
+// events not yet acknowledged survive a restart. Events an earlier run left
+// unacknowledged in the file are loaded back, to be delivered again. From
+// then on, a write fails and is rolled back when the events it records
+// cannot be appended to the file. It must be called before the first write.
+func (m *Memory) PersistOutbox(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	events, err := loadOutbox(path)
+	if err != nil {
+		return err
+	}
+	file, err := rewriteOutbox(path, events)
+	if err != nil {
+		return err
+	}
+
+	m.outbox.enabled = true
+	m.outbox.file = file
+	m.outbox.events = events
+	if len(events) > 0 {
+		m.outbox.seq = events[len(events)-1].Seq
+		m.outbox.saved = m.outbox.seq
+	}
+	return nil
+}
+
+// loadOutbox reads the events of a persisted outbox that were not
+// acknowledged. Reading stops at a line cut short by a crash, since no write
+// recording it returned.
+func loadOutbox(path string) ([]*Event, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open outbox : %w", err)
+	}
+	defer file.Close()
+
+	events := make([]*Event, 0)
+	dec := json.NewDecoder(file)
+	for {
+		var entry outboxEntry
+		if err := dec.Decode(&entry); err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, fmt.Errorf("failed to read outbox : %w", err)
+			}
+			return events, nil
+		}
+
+		if entry.Event != nil {
+			events = append(events, entry.Event)
+		}
+		for len(events) > 0 && events[0].Seq <= entry.Ack {
+			events = events[1:]
+		}
+	}
+}
+
+// rewriteOutbox replaces a persisted outbox with the given events, dropping
+// acknowledged ones, and opens it for appending.
+func rewriteOutbox(path string, events []*Event) (*os.File, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, event := range events {
+		if err := enc.Encode(outboxEntry{Event: event}); err != nil {
+			return nil, fmt.Errorf("failed to encode outbox event : %w", err)
+		}
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write outbox : %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, fmt.Errorf("failed to replace outbox : %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open outbox : %w", err)
+	}
+	return file, nil
+}
+
+// persistEvents appends the events recorded since the last call to the
+// outbox file, when the outbox is persisted. The file is cut back when the
+// append fails, so it never holds events of a write that is rolled back.
+// Callers must hold the write lock.
+func (m *Memory) persistEvents() error {
+	if m.outbox.file == nil || m.outbox.saved == m.outbox.seq {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, event := range m.outbox.events {
+		if event.Seq <= m.outbox.saved {
+			continue
+		}
+		if err := enc.Encode(outboxEntry{Event: event}); err != nil {
+			return fmt.Errorf("failed to encode outbox event : %w", err)
+		}
+	}
+
+	info, err := m.outbox.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to persist outbox : %w", err)
+	}
+	if _, err = m.outbox.file.Write(buf.Bytes()); err == nil {
+		err = m.outbox.file.Sync()
+	}
+	if err != nil {
+		_ = m.outbox.file.Truncate(info.Size())
+		return fmt.Errorf("failed to persist outbox : %w", err)
+	}
+
+	m.outbox.saved = m.outbox.seq
+	return nil
+}
+
+// OutboxReady returns a channel that receives a value whenever events may be
+// waiting in the outbox. Signals are coalesced, so a consumer must read every
+// pending event after each one.
+func (m *Memory) OutboxReady() <-chan struct{} {
+	return m.outbox.ready
+}
+
+// PendingEvents returns up to limit events recorded after the given sequence
+// number, oldest first. Events stay in the outbox until they are
+// acknowledged.
+func (m *Memory) PendingEvents(ctx context.Context, after uint64, limit int) []*Event {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	events := make([]*Event, 0)
+	for _, event := range m.outbox.events {
+		if len(events) == limit {
+			break
+		}
+		if event.Seq > after {
+			events = append(events, event.clone())
+		}
+	}
+	return events
+}
+
+// AckEvents removes the events up to and including the given sequence number
+// from the outbox, once they no longer need to be delivered.
+func (m *Memory) AckEvents(ctx context.Context, seq uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	acked := 0
+	for acked < len(m.outbox.events) && m.outbox.events[acked].Seq <= seq {
+		acked++
+	}
+	// Copy the remaining events so acknowledged ones can be garbage
+	// collected.
+	m.outbox.events = append([]*Event(nil), m.outbox.events[acked:]...)
+
+	// A lost acknowledgement only gets events delivered again, so failures
+	// to record one are ignored. The file is emptied once every event is
+	// acknowledged, so it does not grow while the outbox keeps up.
+	if m.outbox.file == nil || acked == 0 {
+		return
+	}
+	if len(m.outbox.events) == 0 {
+		_ = m.outbox.file.Truncate(0)
+		return
+	}
+	if raw, err := json.Marshal(outboxEntry{Ack: seq}); err == nil {
+		_, _ = m.outbox.file.Write(append(raw, '\n'))
+	}
+}
+
+// record appends an event for a change to the outbox, when it is enabled.
+// Callers must hold the write lock.
+func (m *Memory) record(kind EventKind, res, previous *Resource) {
+	if !m.outbox.enabled {
+		return
+	}
+
+	event := &Event{Kind: kind, Resource: m.withGroups(res), At: m.now()}
+	if previous != nil {
+		event.Previous = m.withGroups(previous)
+	}
+
+	m.outbox.seq++
+	event.Seq = m.outbox.seq
+	m.outbox.events = append(m.outbox.events, event)
+
+	// A signal for an event that is later rolled back only makes the
+	// consumer look for events it will not find.
+	select {
+	case m.outbox.ready <- struct{}{}:
+	default:
+	}
+}
+
+// clone returns a copy of the event whose resources can be modified without
+// affecting the outbox.
+func (e *Event) clone() *Event {
+	clone := *e
+	clone.Resource = e.Resource.Clone()
+	if e.Previous != nil {
+		clone.Previous = e.Previous.Clone()
+	}
+	return &clone
+}
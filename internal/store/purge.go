@@ -0,0 +1,58 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/iamBelugaa/scim-gateway/internal/config"
+	"github.com/iamBelugaa/scim-gateway/pkg/logger"
+)
+
+// Purger periodically hard deletes tombstones that are older than the
+// configured retention period and drops expired version history.
+type Purger struct {
+	log       *logger.Logger
+	store     *Memory
+	interval  time.Duration // How often a purge runs.
+	retention time.Duration // How long tombstones are kept before being purged.
+}
+
+// NewPurger constructs a purge job for the given store.
+func NewPurger(log *logger.Logger, store *Memory, cfg *config.Store) *Purger {
+	return &Purger{
+		log:       log,
+		store:     store,
+		interval:  cfg.PurgeInterval,
+		retention: cfg.TombstoneRetention,
+	}
+}
+
+// Run purges the store on every interval until the context is cancelled.
+func (p *Purger) Run(ctx context.Context) {
+	if p.interval <= 0 {
+		p.log.Infow("store purge job disabled")
+		return
+	}
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.PurgeOnce(ctx)
+		}
+	}
+}
+
+// PurgeOnce runs a single purge pass.
+func (p *Purger) PurgeOnce(ctx context.Context) {
+	purged := p.store.Purge(ctx, p.store.now().Add(-p.retention))
+	pruned := p.store.PruneHistory(ctx)
+
+	if purged > 0 || pruned > 0 {
+		p.log.Infow("store purge completed", "purgedResources", purged, "prunedVersions", pruned)
+	}
+}
@@ -0,0 +1,97 @@
+// Package store provides the persistence layer for SCIM resources managed by
+// the gateway.
+package store
+
+import (
+	"errors"
+	"maps"
+	"time"
+)
+
+// ResourceType identifies the SCIM resource type a stored resource belongs to.
+type ResourceType string
+
+// Supported resource type constants.
+var (
+	ResourceTypeUser  ResourceType = "User"
+	ResourceTypeGroup ResourceType = "Group"
+)
+
+// Common store errors.
+var (
+	ErrNotFound        = errors.New("resource not found")
+	ErrVersionNotFound = errors.New("resource version not found")
+	ErrAlreadyExists   = errors.New("resource already exists")
+	ErrVersionConflict = errors.New("resource version conflict")
+)
+
+// Resource is a single stored SCIM resource. Attributes holds the resource
+// body as decoded from JSON, keyed by attribute name.
+type Resource struct {
+	ID           string         `json:"id"`               // Server assigned unique identifier.
+	Type         ResourceType   `json:"resourceType"`     // SCIM resource type (User, Group).
+	Version      uint64         `json:"version"`          // Monotonically increasing version, starting at 1.
+	Created      time.Time      `json:"created"`          // Time the resource was first created.
+	LastModified time.Time      `json:"lastModified"`     // Time of the write that produced this version.
+	Attributes   map[string]any `json:"attributes"`       // Resource attributes.
+	Origin       string         `json:"origin,omitempty"` // Upstream server the resource was pulled from, empty for resources written by clients.
+}
+
+// Clone returns a copy of the resource whose attribute map can be modified
+// without affecting the original.
+func (r *Resource) Clone() *Resource {
+	clone := *r
+	clone.Attributes = cloneAttributes(r.Attributes)
+	return &clone
+}
+
+// cloneAttributes deep copies nested maps and slices of an attribute map.
+func cloneAttributes(attrs map[string]any) map[string]any {
+	if attrs == nil {
+		return nil
+	}
+
+	clone := maps.Clone(attrs)
+	for key, val := range clone {
+		clone[key] = cloneValue(val)
+	}
+	return clone
+}
+
+// cloneValue deep copies a single JSON decoded value.
+func cloneValue(val any) any {
+	switch v := val.(type) {
+	case map[string]any:
+		return cloneAttributes(v)
+	case []any:
+		clone := make([]any, len(v))
+		for i := range v {
+			clone[i] = cloneValue(v[i])
+		}
+		return clone
+	default:
+		return v
+	}
+}
+
+// Tombstone is a soft deleted resource. It is hidden from regular reads but
+// can be restored until it is purged.
+type Tombstone struct {
+	Resource  *Resource `json:"resource"`  // Last version of the resource before it was deleted.
+	DeletedAt time.Time `json:"deletedAt"` // Time the resource was deleted.
+	Groups    []string  `json:"groups"`    // Ids of the groups the deleted resource was a member of.
+
+	memberships map[string][]any // Member entries removed from each group, keyed by group id.
+}
+
+// Clone returns a copy of the tombstone.
+func (t *Tombstone) Clone() *Tombstone {
+	clone := *t
+	clone.Resource = t.Resource.Clone()
+	clone.Groups = append([]string(nil), t.Groups...)
+	clone.memberships = make(map[string][]any, len(t.memberships))
+	for groupID, entries := range t.memberships {
+		clone.memberships[groupID] = cloneValue(entries).([]any)
+	}
+	return &clone
+}
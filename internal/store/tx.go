@@ -0,0 +1,212 @@
+package store
+
+import (
+	"context"
+)
+
+// Tx is a store transaction. Writes made through a transaction become visible
+// to other callers only when the transaction commits, and are discarded as a
+// whole when it rolls back.
+type Tx struct {
+	store       *Memory
+	afterCommit []func() // Callbacks run once the transaction has committed.
+}
+
+// Create stores a new resource within the transaction.
+func (tx *Tx) Create(ctx context.Context, res *Resource) (*Resource, error) {
+	return tx.store.create(res)
+}
+
+// Replace writes a new version of an existing resource within the transaction.
+func (tx *Tx) Replace(ctx context.Context, res *Resource) (*Resource, error) {
+	return tx.store.replace(res)
+}
+
+// Update applies fn to the current version of a resource and writes the
+// result as a new version within the transaction. No other writer can change
+// the resource in between, since the transaction holds the write lock.
+func (tx *Tx) Update(
+	ctx context.Context, resourceType ResourceType, id string, fn func(res *Resource) error,
+) (*Resource, error) {
+	current, err := tx.store.get(resourceType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := current.Clone()
+	if err := fn(updated); err != nil {
+		return nil, err
+	}
+
+	// Identity and bookkeeping fields are owned by the store.
+	updated.ID = current.ID
+	updated.Type = current.Type
+	return tx.store.replace(updated)
+}
+
+// Get returns the current version of a resource, including writes already
+// made within the transaction.
+func (tx *Tx) Get(ctx context.Context, resourceType ResourceType, id string) (*Resource, error) {
+	return tx.store.get(resourceType, id)
+}
+
+// Delete soft deletes a resource within the transaction.
+func (tx *Tx) Delete(ctx context.Context, resourceType ResourceType, id string) error {
+	return tx.store.remove(resourceType, id)
+}
+
+// DeleteIfVersion soft deletes a resource within the transaction only when its
+// current version matches expected. It returns ErrVersionConflict otherwise.
+func (tx *Tx) DeleteIfVersion(ctx context.Context, resourceType ResourceType, id string, expected uint64) error {
+	return tx.store.removeIfVersion(resourceType, id, expected)
+}
+
+// AfterCommit registers fn to run after the transaction commits. Callbacks
+// are dropped when the transaction rolls back, which makes them the place for
+// side effects that must only follow committed writes.
+func (tx *Tx) AfterCommit(fn func()) {
+	tx.afterCommit = append(tx.afterCommit, fn)
+}
+
+// journal records the parts of the store a transaction overwrites, so a
+// rollback restores just those rather than the transaction copying the whole
+// store up front. Nil entries record what did not exist before.
+type journal struct {
+	resources  map[key]*Resource
+	history    map[key][]*Resource
+	tombstones map[key]*Tombstone
+	index      map[string]map[string]struct{} // Index entries keyed by member id.
+	outbox     outbox                         // Outbox before the transaction.
+}
+
+// InTx runs fn inside a transaction. When fn returns an error or panics,
+// every write it made is rolled back, including the outbox events recording
+// them, and the error is returned or the panic resumed; otherwise the writes
+// are committed and the callbacks registered with AfterCommit are run in
+// order.
+//
+// Transactions hold the store's write lock for their whole duration, so fn
+// must use tx rather than the store itself and should not perform slow work
+// such as network calls. Outbox consumers cannot read events until the
+// transaction commits.
+func (m *Memory) InTx(ctx context.Context, fn func(tx *Tx) error) error {
+	tx := &Tx{store: m}
+
+	if err := m.runTx(tx, fn); err != nil {
+		return err
+	}
+
+	for _, callback := range tx.afterCommit {
+		callback()
+	}
+	return nil
+}
+
+// runTx runs fn under the write lock and rolls the store back when it fails.
+func (m *Memory) runTx(tx *Tx, fn func(tx *Tx) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.journaled(func() error { return fn(tx) })
+}
+
+// locked runs a single write under the write lock. When the outbox is
+// persisted, the write is journaled like a transaction, so it is rolled back
+// when the events it records cannot be persisted.
+func (m *Memory) locked(fn func() error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.outbox.file == nil {
+		return fn()
+	}
+	return m.journaled(fn)
+}
+
+// journaled runs fn and persists the outbox events it records, rolling the
+// store back when either fails. Callers must hold the write lock.
+func (m *Memory) journaled(fn func() error) (err error) {
+	// Outbox events are only ever appended while journaled, so a copy of
+	// the outbox header drops the events the write recorded.
+	m.journal = &journal{
+		resources:  make(map[key]*Resource),
+		history:    make(map[key][]*Resource),
+		tombstones: make(map[key]*Tombstone),
+		index:      make(map[string]map[string]struct{}),
+		outbox:     m.outbox,
+	}
+
+	defer func() {
+		j := m.journal
+		m.journal = nil
+
+		if p := recover(); p != nil {
+			m.rollback(j)
+			panic(p)
+		}
+		if err != nil {
+			m.rollback(j)
+		}
+	}()
+
+	if err := fn(); err != nil {
+		return err
+	}
+	return m.persistEvents()
+}
+
+// save records the state of k in the journal before a transaction first
+// changes it. It does nothing outside transactions. Callers must hold the
+// write lock.
+func (m *Memory) save(k key) {
+	if m.journal == nil {
+		return
+	}
+	if _, ok := m.journal.resources[k]; ok {
+		return
+	}
+
+	m.journal.resources[k] = m.resources[k]
+	m.journal.history[k] = m.history[k]
+	m.journal.tombstones[k] = m.tombstones[k]
+}
+
+// saveIndex records the index entry of a member in the journal before a
+// transaction first changes it. Index sets are copied on write, so keeping
+// the set is enough. Callers must hold the write lock.
+func (m *Memory) saveIndex(memberID string) {
+	if m.journal == nil {
+		return
+	}
+	if _, ok := m.journal.index[memberID]; ok {
+		return
+	}
+	m.journal.index[memberID] = m.index[memberID]
+}
+
+// rollback restores what a transaction overwrote. Callers must hold the
+// write lock.
+func (m *Memory) rollback(j *journal) {
+	for k, res := range j.resources {
+		restoreEntry(m.resources, k, res, res == nil)
+	}
+	for k, history := range j.history {
+		restoreEntry(m.history, k, history, history == nil)
+	}
+	for k, tombstone := range j.tombstones {
+		restoreEntry(m.tombstones, k, tombstone, tombstone == nil)
+	}
+	for memberID, groups := range j.index {
+		restoreEntry(m.index, memberID, groups, groups == nil)
+	}
+	m.outbox = j.outbox
+}
+
+// restoreEntry sets m[k] back to val, or deletes it when it was absent.
+func restoreEntry[K comparable, V any](m map[K]V, k K, val V, absent bool) {
+	if absent {
+		delete(m, k)
+		return
+	}
+	m[k] = val
+}
@@ -0,0 +1,104 @@
+// Package tenant holds the tenants served by the gateway. Every tenant has its
+// own resource store and schema set, so resources of one tenant are never
+// visible to another.
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/iamBelugaa/scim-gateway/internal/auth"
+	"github.com/iamBelugaa/scim-gateway/internal/config"
+	"github.com/iamBelugaa/scim-gateway/internal/schema"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+)
+
+// ErrNotFound is returned when a tenant is not served by the gateway.
+var ErrNotFound = errors.New("tenant not found")
+
+// validID matches the tenant ids accepted in request paths.
+var validID = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// Tenant is an isolated namespace of SCIM resources.
+type Tenant struct {
+	ID      string        // Identifier of the tenant, as used in request paths.
+	Schemas *schema.Set   // Schemas served to the tenant.
+	Store   *store.Memory // Resources of the tenant.
+}
+
+// Registry holds every tenant served by the gateway. The default tenant is
+// always present.
+type Registry struct {
+	tenants map[string]*Tenant // Tenants keyed by id.
+}
+
+// NewWithConfig constructs the registry from the tenancy configuration. Each
+// tenant gets its own store built from the store configuration.
+func NewWithConfig(cfg *config.Tenancy, storeCfg *config.Store) (*Registry, error) {
+	r := &Registry{tenants: make(map[string]*Tenant)}
+
+	all, _ := schema.NewSet(schema.URIs())
+	r.tenants[auth.DefaultTenant] = &Tenant{ID: auth.DefaultTenant, Schemas: all, Store: store.NewMemory(storeCfg)}
+
+	for _, entry := range cfg.Tenants {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		id, uris, _ := strings.Cut(entry, "=")
+		if !validID.MatchString(id) {
+			return nil, fmt.Errorf("tenant id %q must be lowercase letters, digits and dashes", id)
+		}
+		if _, ok := r.tenants[id]; ok {
+			return nil, fmt.Errorf("tenant %q is configured more than once", id)
+		}
+
+		schemas := all
+		if fields := strings.Fields(uris); len(fields) > 0 {
+			var ok bool
+			if schemas, ok = schema.NewSet(fields); !ok {
+				return nil, fmt.Errorf("tenant %q lists an unknown schema", id)
+			}
+		}
+
+		r.tenants[id] = &Tenant{ID: id, Schemas: schemas, Store: store.NewMemory(storeCfg)}
+	}
+
+	return r, nil
+}
+
+// Get returns the tenant with the given id.
+func (r *Registry) Get(id string) (*Tenant, error) {
+	t, ok := r.tenants[id]
+	if !ok {
+		return nil, fmt.Errorf("tenant %q : %w", id, ErrNotFound)
+	}
+	return t, nil
+}
+
+// Authorize returns the tenant with the given id once the principal
+// authenticated in ctx is known to belong to it. Credentials of another tenant
+// are rejected before the tenant is looked up, so they cannot be used to
+// probe which tenants exist.
+func (r *Registry) Authorize(ctx context.Context, id string) (*Tenant, error) {
+	if err := auth.RequireTenant(ctx, id); err != nil {
+		return nil, err
+	}
+	return r.Get(id)
+}
+
+// All returns every tenant, ordered by id.
+func (r *Registry) All() []*Tenant {
+	tenants := make([]*Tenant, 0, len(r.tenants))
+	for _, t := range r.tenants {
+		tenants = append(tenants, t)
+	}
+
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].ID < tenants[j].ID })
+	return tenants
+}
@@ -0,0 +1,182 @@
+package tenant
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/iamBelugaa/scim-gateway/internal/auth"
+	"github.com/iamBelugaa/scim-gateway/internal/config"
+	"github.com/iamBelugaa/scim-gateway/internal/schema"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+)
+
+// newRegistry constructs a registry serving the acme and globex tenants next
+// to the default tenant.
+func newRegistry(t *testing.T) *Registry {
+	t.Helper()
+
+	registry, err := NewWithConfig(
+		&config.Tenancy{Tenants: []string{"acme", "globex=" + schema.URIUser}},
+		&config.Store{HistoryRetention: time.Hour},
+	)
+	if err != nil {
+		t.Fatalf("failed to construct registry : %v", err)
+	}
+	return registry
+}
+
+// tokenEntry returns a static token configuration entry for the token.
+func tokenEntry(account, token, tenant string) string {
+	hash := sha256.Sum256([]byte(token))
+	return account + ":" + hex.EncodeToString(hash[:]) + ":" + tenant
+}
+
+// TestAuthorizeIsolatesTenants authenticates static tokens of different
+// tenants and asserts each can only reach its own tenant.
+func TestAuthorizeIsolatesTenants(t *testing.T) {
+	registry := newRegistry(t)
+
+	tokens, err := auth.NewServiceTokens([]string{
+		tokenEntry("acme-idp", "acme-token", "acme"),
+		tokenEntry("globex-idp", "globex-token", "globex"),
+		tokenEntry("legacy-idp", "legacy-token", ""),
+	})
+	if err != nil {
+		t.Fatalf("failed to load tokens : %v", err)
+	}
+
+	tests := []struct {
+		token   string
+		tenant  string
+		wantErr error
+	}{
+		{token: "acme-token", tenant: "acme"},
+		{token: "acme-token", tenant: "globex", wantErr: auth.ErrWrongTenant},
+		{token: "acme-token", tenant: auth.DefaultTenant, wantErr: auth.ErrWrongTenant},
+		{token: "globex-token", tenant: "globex"},
+		{token: "globex-token", tenant: "acme", wantErr: auth.ErrWrongTenant},
+		{token: "legacy-token", tenant: auth.DefaultTenant},
+		{token: "legacy-token", tenant: "acme", wantErr: auth.ErrWrongTenant},
+
+		// Unknown tenants are reported as forbidden rather than missing, so
+		// credentials cannot be used to discover which tenants exist.
+		{token: "acme-token", tenant: "initech", wantErr: auth.ErrWrongTenant},
+	}
+
+	for _, tt := range tests {
+		principal, err := tokens.Authenticate(context.Background(), tt.token)
+		if err != nil {
+			t.Fatalf("failed to authenticate %s : %v", tt.token, err)
+		}
+		ctx := auth.WithPrincipal(context.Background(), principal)
+
+		got, err := registry.Authorize(ctx, tt.tenant)
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s on tenant %s : got error %v, want %v", tt.token, tt.tenant, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s on tenant %s : unexpected error %v", tt.token, tt.tenant, err)
+			continue
+		}
+		if got.ID != tt.tenant {
+			t.Errorf("%s on tenant %s : got tenant %s", tt.token, tt.tenant, got.ID)
+		}
+	}
+}
+
+// TestAuthorizeRequiresPrincipal asserts unauthenticated requests never
+// reach a tenant.
+func TestAuthorizeRequiresPrincipal(t *testing.T) {
+	registry := newRegistry(t)
+
+	if _, err := registry.Authorize(context.Background(), auth.DefaultTenant); !errors.Is(err, auth.ErrUnauthorized) {
+		t.Fatalf("got error %v, want %v", err, auth.ErrUnauthorized)
+	}
+}
+
+// TestStoresAreIsolated writes resources with the same id to two tenants and
+// asserts neither tenant can read, list or modify the other's resource.
+func TestStoresAreIsolated(t *testing.T) {
+	ctx := context.Background()
+	registry := newRegistry(t)
+
+	acme, _ := registry.Get("acme")
+	globex, _ := registry.Get("globex")
+
+	if _, err := acme.Store.Create(ctx, &store.Resource{
+		ID:         "shared-id",
+		Type:       store.ResourceTypeUser,
+		Attributes: map[string]any{"userName": "wile@acme.example"},
+	}); err != nil {
+		t.Fatalf("failed to create acme user : %v", err)
+	}
+
+	if _, err := globex.Store.Get(ctx, store.ResourceTypeUser, "shared-id"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("globex read acme user : got error %v, want %v", err, store.ErrNotFound)
+	}
+	if err := globex.Store.Delete(ctx, store.ResourceTypeUser, "shared-id"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("globex deleted acme user : got error %v, want %v", err, store.ErrNotFound)
+	}
+	if _, err := globex.Store.Versions(ctx, store.ResourceTypeUser, "shared-id"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("globex read acme history : got error %v, want %v", err, store.ErrNotFound)
+	}
+
+	if _, err := globex.Store.Create(ctx, &store.Resource{
+		ID:         "shared-id",
+		Type:       store.ResourceTypeUser,
+		Attributes: map[string]any{"userName": "hank@globex.example"},
+	}); err != nil {
+		t.Fatalf("failed to create globex user with the same id : %v", err)
+	}
+
+	for _, tenant := range []*Tenant{acme, globex} {
+		users, err := tenant.Store.List(ctx, store.ResourceTypeUser)
+		if err != nil {
+			t.Fatalf("failed to list %s users : %v", tenant.ID, err)
+		}
+		if len(users) != 1 || users[0].Version != 1 {
+			t.Errorf("%s : got %d users, want a single unmodified user", tenant.ID, len(users))
+		}
+	}
+}
+
+// TestSchemaSets asserts tenants only serve the schemas configured for them.
+func TestSchemaSets(t *testing.T) {
+	registry := newRegistry(t)
+
+	acme, _ := registry.Get("acme")
+	if got := len(acme.Schemas.Schemas()); got != len(schema.URIs()) {
+		t.Errorf("acme : got %d schemas, want every built in schema", got)
+	}
+
+	globex, _ := registry.Get("globex")
+	if _, ok := globex.Schemas.Get(schema.URIGroup); ok {
+		t.Errorf("globex : serves the group schema it was not configured with")
+	}
+	if types := globex.Schemas.ResourceTypes(); len(types) != 1 || types[0].ID != "User" || len(types[0].Extensions) != 0 {
+		t.Errorf("globex : got resource types %+v, want only User without extensions", types)
+	}
+}
+
+// TestNewWithConfigRejectsInvalidTenants asserts misconfigured tenants are
+// reported at startup.
+func TestNewWithConfigRejectsInvalidTenants(t *testing.T) {
+	for _, tenants := range [][]string{
+		{"Acme"},
+		{"acme/eu"},
+		{"acme", "acme"},
+		{auth.DefaultTenant},
+		{"acme=urn:example:unknown"},
+	} {
+		if _, err := NewWithConfig(&config.Tenancy{Tenants: tenants}, &config.Store{}); err == nil {
+			t.Errorf("%v : expected an error", tenants)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/iamBelugaa/scim-gateway/internal/connector"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+	"github.com/iamBelugaa/scim-gateway/internal/tenant"
+	"github.com/iamBelugaa/scim-gateway/pkg/logger"
+)
+
+// errNoID is returned for pulled resources without an id.
+var errNoID = errors.New("resource has no id")
+
+// Syncer pulls the resources of an upstream SCIM server into a tenant.
+//
+// Incremental pulls request the resources modified since the most recent
+// modification already pulled, with a meta.lastModified filter. Full sweeps
+// pull every resource and delete the pulled resources the upstream no longer
+// holds, which incremental pulls cannot see. The first pull is a full sweep,
+// as is every pull once the full sync interval has passed, and incremental
+// pulls fall back to full sweeps until the upstream reports modification
+// times.
+//
+// Pulled resources are stored under an id derived from their URL at the
+// upstream, so the same upstream resource always maps onto the same gateway
+// resource, and with the upstream as their origin, so full sweeps find the
+// resources pulled before from the store itself. Group members refer to the
+// derived ids of the pulled members.
+type Syncer struct {
+	log          *logger.Logger
+	tenant       *tenant.Tenant
+	types        []store.ResourceType // Resource types pulled, users first.
+	url          string
+	token        string
+	client       *http.Client
+	interval     time.Duration // How often changes are pulled.
+	fullInterval time.Duration // How often every resource is pulled.
+	pageSize     int
+	connectors   *connector.Dispatcher // Connectors dry runs plan the operations of.
+	dryRun       bool                  // Whether pulls are only planned.
+
+	mu         sync.Mutex                       // Held while a pull runs, so pulls never overlap.
+	watermarks map[store.ResourceType]time.Time // Most recent modification pulled, per resource type.
+	lastFull   time.Time                        // Time the last full sweep completed.
+}
+
+// Result counts the outcome of a pull.
+type Result struct {
+	Full      bool // Whether the pull was a full sweep.
+	Created   int  // Resources created in the gateway.
+	Updated   int  // Resources updated in the gateway.
+	Deleted   int  // Resources deleted from the gateway, found by full sweeps.
+	Unchanged int  // Resources already up to date.
+	Rejected  int  // Resources that failed validation or could not be written.
+}
+
+// Run pulls from the upstream on every interval until the context is
+// cancelled, starting with a full sweep.
+func (s *Syncer) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.Sync(ctx, false); err != nil && ctx.Err() == nil {
+			s.log.Warnw("failed to pull from upstream", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync pulls from the upstream, with a full sweep when requested or due. A
+// failed pull leaves the watermarks unchanged, so the next pull requests the
+// same changes again.
+func (s *Syncer) Sync(ctx context.Context, full bool) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := Result{Full: full || time.Since(s.lastFull) >= s.fullInterval}
+	for _, resourceType := range s.types {
+		if _, ok := s.watermarks[resourceType]; !ok {
+			result.Full = true
+		}
+	}
+
+	st := s.tenant.Store
+	if s.dryRun {
+		// Dry runs pull into a fork of the store and forget what they pulled,
+		// so every pull plans what a real pull would change.
+		st = st.Fork()
+		defer func(watermarks map[store.ResourceType]time.Time) {
+			s.watermarks = watermarks
+		}(maps.Clone(s.watermarks))
+	}
+
+	started := time.Now()
+	for _, resourceType := range s.types {
+		if err := s.pull(ctx, st, resourceType, &result); err != nil {
+			return result, err
+		}
+	}
+
+	if s.dryRun {
+		s.logPlan(ctx, st, result)
+		return result, nil
+	}
+	if result.Full {
+		s.lastFull = started
+	}
+
+	s.log.Infow(
+		"pulled from upstream", "full", result.Full, "created", result.Created, "updated", result.Updated,
+		"deleted", result.Deleted, "unchanged", result.Unchanged, "rejected", result.Rejected,
+	)
+	return result, nil
+}
+
+// pull pulls the resources of a type modified since its watermark, or every
+// resource on full sweeps, and applies them to the tenant store.
+func (s *Syncer) pull(ctx context.Context, st *store.Memory, resourceType store.ResourceType, result *Result) error {
+	watermark := s.watermarks[resourceType]
+
+	filter := ""
+	if !result.Full {
+		filter = fmt.Sprintf("meta.lastModified gt %q", watermark.UTC().Format(time.RFC3339Nano))
+	}
+	resources, err := s.list(ctx, resourceType, filter)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]struct{}, len(resources))
+	for _, remote := range resources {
+		id, err := s.apply(ctx, st, resourceType, remote, result)
+		if id != "" {
+			seen[id] = struct{}{}
+		}
+		if err != nil {
+			result.Rejected++
+			s.log.Warnw("rejected upstream resource", "resourceType", resourceType, "upstreamId", remote["id"], "error", err)
+		}
+
+		if modified, ok := lastModified(remote); ok && modified.After(watermark) {
+			watermark = modified
+		}
+	}
+
+	if result.Full {
+		if err := s.sweep(ctx, st, resourceType, seen, result); err != nil {
+			return err
+		}
+	}
+	if !watermark.IsZero() {
+		s.watermarks[resourceType] = watermark
+	}
+	return nil
+}
+
+// apply creates or updates the gateway resource of a pulled resource, and
+// returns the id of the gateway resource. The resource is validated against
+// the schemas of the tenant first, and left untouched when it is already up
+// to date.
+func (s *Syncer) apply(
+	ctx context.Context, st *store.Memory, resourceType store.ResourceType, remote map[string]any, result *Result,
+) (string, error) {
+	upstreamID, _ := remote["id"].(string)
+	if upstreamID == "" {
+		return "", errNoID
+	}
+	id := s.localID(resourceType, upstreamID)
+
+	attrs := s.attributes(remote)
+	if err := s.tenant.Schemas.Validate(string(resourceType), attrs); err != nil {
+		return id, err
+	}
+
+	current, err := st.Get(ctx, resourceType, id)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		_, err = st.Create(ctx, &store.Resource{ID: id, Type: resourceType, Attributes: attrs, Origin: s.url})
+		if errors.Is(err, store.ErrAlreadyExists) {
+			// The resource was deleted from the gateway but exists upstream,
+			// so it is restored and brought up to date.
+			if _, err := st.Undelete(ctx, resourceType, id); err != nil {
+				return id, err
+			}
+			return id, s.update(ctx, st, resourceType, id, attrs, result)
+		}
+		if err != nil {
+			return id, err
+		}
+		result.Created++
+		return id, nil
+	case err != nil:
+		return id, err
+	}
+
+	if equal(current.Attributes, attrs) {
+		result.Unchanged++
+		return id, nil
+	}
+	return id, s.update(ctx, st, resourceType, id, attrs, result)
+}
+
+// update replaces the attributes of a gateway resource with pulled ones.
+func (s *Syncer) update(
+	ctx context.Context, st *store.Memory, resourceType store.ResourceType, id string, attrs map[string]any, result *Result,
+) error {
+	_, err := st.Update(ctx, resourceType, id, func(res *store.Resource) error {
+		res.Attributes = maps.Clone(attrs)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	result.Updated++
+	return nil
+}
+
+// sweep deletes the gateway resources of a type that were pulled from the
+// upstream but were not seen by a full sweep, since the upstream deleted them.
+// Pulled resources are found by their origin in the store, so resources pulled
+// before a restart are swept too.
+func (s *Syncer) sweep(
+	ctx context.Context, st *store.Memory, resourceType store.ResourceType, seen map[string]struct{}, result *Result,
+) error {
+	resources, err := st.List(ctx, resourceType)
+	if err != nil {
+		return fmt.Errorf("failed to list %s resources : %w", resourceType, err)
+	}
+
+	for _, res := range resources {
+		if _, ok := seen[res.ID]; ok || res.Origin != s.url {
+			continue
+		}
+
+		err := st.Delete(ctx, resourceType, res.ID)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return fmt.Errorf("failed to delete %s %q : %w", resourceType, res.ID, err)
+		}
+		if err == nil {
+			result.Deleted++
+		}
+	}
+	return nil
+}
+
+// logPlan logs the changes a dry run made to its fork of the store, and the
+// connector operations they would run.
+func (s *Syncer) logPlan(ctx context.Context, fork *store.Memory, result Result) {
+	events := fork.Changes(ctx)
+	changes := make([]connector.Change, len(events))
+	for i, event := range events {
+		changes[i] = connector.ChangeOf(s.tenant.ID, event)
+		s.log.Infow("planned change", "kind", event.Kind, "resourceType", event.Resource.Type, "id", event.Resource.ID)
+	}
+	for _, step := range s.connectors.Plan(changes) {
+		s.log.Infow(
+			"planned operation", "connector", step.Connector, "operation", step.Operation,
+			"resourceType", step.ResourceType, "id", step.ID, "remoteId", step.RemoteID, "error", step.Error,
+		)
+	}
+
+	s.log.Infow(
+		"planned pull from upstream", "full", result.Full, "created", result.Created, "updated", result.Updated,
+		"deleted", result.Deleted, "unchanged", result.Unchanged, "rejected", result.Rejected,
+	)
+}
+
+// localID returns the id of the gateway resource of an upstream resource,
+// derived from the URL of the upstream resource.
+func (s *Syncer) localID(resourceType store.ResourceType, upstreamID string) string {
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte(s.url+endpoint(resourceType)+"/"+upstreamID)).String()
+}
+
+// attributes returns the attributes a pulled resource is stored with.
+// Attributes assigned by the upstream are left out, as is the groups
+// attribute the gateway derives from group members, and group members refer
+// to the gateway ids of the pulled members.
+func (s *Syncer) attributes(remote map[string]any) map[string]any {
+	attrs := maps.Clone(remote)
+	delete(attrs, "id")
+	delete(attrs, "meta")
+	delete(attrs, "groups")
+
+	entries, ok := attrs["members"].([]any)
+	if !ok {
+		return attrs
+	}
+	members := make([]any, 0, len(entries))
+	for _, entry := range entries {
+		member, ok := entry.(map[string]any)
+		if !ok {
+			continue
+		}
+		value, _ := member["value"].(string)
+		if value == "" {
+			continue
+		}
+
+		memberType := store.ResourceTypeUser
+		if t, _ := member["type"].(string); t == string(store.ResourceTypeGroup) {
+			memberType = store.ResourceTypeGroup
+		}
+		translated := map[string]any{"value": s.localID(memberType, value), "type": string(memberType)}
+		if display, ok := member["display"].(string); ok {
+			translated["display"] = display
+		}
+		members = append(members, translated)
+	}
+	attrs["members"] = members
+	return attrs
+}
+
+// equal reports whether the stored attributes of a resource match pulled
+// ones, ignoring the groups attribute the gateway derives.
+func equal(stored, pulled map[string]any) bool {
+	stored = maps.Clone(stored)
+	delete(stored, "groups")
+	return reflect.DeepEqual(stored, pulled)
+}
+
+// lastModified returns the modification time of a pulled resource.
+func lastModified(remote map[string]any) (time.Time, bool) {
+	meta, _ := remote["meta"].(map[string]any)
+	raw, _ := meta["lastModified"].(string)
+	modified, err := time.Parse(time.RFC3339Nano, raw)
+	return modified, err == nil
+}
@@ -0,0 +1,291 @@
+// Package upstream pulls Users and Groups from upstream SCIM servers, for
+// identity sources that expose a SCIM server but cannot push to the gateway.
+// Pulled resources are written to the tenant store like any other change, so
+// they are validated and provisioned to the connectors of the tenant.
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/iamBelugaa/scim-gateway/internal/auth"
+	"github.com/iamBelugaa/scim-gateway/internal/config"
+	"github.com/iamBelugaa/scim-gateway/internal/connector"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+	"github.com/iamBelugaa/scim-gateway/internal/tenant"
+	"github.com/iamBelugaa/scim-gateway/pkg/logger"
+)
+
+// Defaults of upstream definitions.
+const (
+	defaultInterval     = time.Minute * 5
+	defaultFullInterval = time.Hour
+	defaultPageSize     = 100
+)
+
+// maxResponseSize bounds the size of the responses read from an upstream.
+const maxResponseSize = 8 << 20
+
+// Definition defines an upstream in the upstreams configuration file.
+type Definition struct {
+	Name          string        `yaml:"name"`             // Unique name of the upstream, used in logs.
+	Tenant        string        `yaml:"tenant"`           // Tenant resources are pulled into, the default tenant when empty.
+	URL           string        `yaml:"url"`              // Base URL of the upstream SCIM server, such as "https://example.com/scim/v2".
+	Token         string        `yaml:"token"`            // Bearer token the gateway authenticates with.
+	TokenEnv      string        `yaml:"tokenEnv"`         // Environment variable holding the bearer token, instead of Token.
+	CAFile        string        `yaml:"caFile"`           // PEM file of the CAs the upstream certificate must be signed by, the system pool when empty.
+	ResourceTypes []string      `yaml:"resourceTypes"`    // Resource types pulled, every type when empty.
+	Interval      time.Duration `yaml:"interval"`         // How often changes are pulled, five minutes when zero.
+	FullInterval  time.Duration `yaml:"fullSyncInterval"` // How often every resource is pulled to find deletes, hourly when zero.
+	PageSize      int           `yaml:"pageSize"`         // Number of resources requested per page, 100 when zero.
+}
+
+// LoadDefinitions reads upstream definitions from a YAML or JSON file of the
+// form {"upstreams": [...]}.
+func LoadDefinitions(path string) ([]Definition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Upstreams []Definition `yaml:"upstreams"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to decode upstreams file : %w", err)
+	}
+	return file.Upstreams, nil
+}
+
+// NewWithConfig constructs a syncer for every upstream defined in the
+// configured file. There are none when no file is configured. With dryRun,
+// the syncers only log the changes they would make, planning their operations
+// on the connectors.
+func NewWithConfig(
+	log *logger.Logger, cfg *config.Upstreams, tenants *tenant.Registry, connectors *connector.Dispatcher, dryRun bool,
+) ([]*Syncer, error) {
+	syncers := make([]*Syncer, 0)
+	if cfg.ConfigFile == "" {
+		return syncers, nil
+	}
+
+	definitions, err := LoadDefinitions(cfg.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for _, def := range definitions {
+		if def.Name == "" {
+			return nil, fmt.Errorf("upstream %q has no name", def.URL)
+		}
+		if names[def.Name] {
+			return nil, fmt.Errorf("upstream %q is defined more than once", def.Name)
+		}
+		names[def.Name] = true
+
+		if def.Tenant == "" {
+			def.Tenant = auth.DefaultTenant
+		}
+		t, err := tenants.Get(def.Tenant)
+		if err != nil {
+			return nil, fmt.Errorf("upstream %q : %w", def.Name, err)
+		}
+
+		upstreamLog := &logger.Logger{SugaredLogger: log.With("upstream", def.Name, "tenant", t.ID)}
+		s, err := New(upstreamLog, &def, t, cfg.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("upstream %q : %w", def.Name, err)
+		}
+		s.connectors, s.dryRun = connectors, dryRun
+		syncers = append(syncers, s)
+		log.Infow(
+			"registered upstream", "upstream", def.Name, "url", def.URL, "tenant", t.ID, "resourceTypes", s.types,
+			"dryRun", dryRun,
+		)
+	}
+	return syncers, nil
+}
+
+// New constructs the syncer of an upstream pulling into a tenant. Requests
+// to the upstream may take up to timeout.
+func New(log *logger.Logger, def *Definition, t *tenant.Tenant, timeout time.Duration) (*Syncer, error) {
+	u, err := url.Parse(def.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("url %q must be an http:// or https:// url", def.URL)
+	}
+
+	types, err := resourceTypes(def.ResourceTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Syncer{
+		log:          log,
+		tenant:       t,
+		types:        types,
+		url:          strings.TrimSuffix(def.URL, "/"),
+		token:        def.Token,
+		client:       &http.Client{Timeout: timeout},
+		interval:     def.Interval,
+		fullInterval: def.FullInterval,
+		pageSize:     def.PageSize,
+		watermarks:   make(map[store.ResourceType]time.Time),
+	}
+	if s.interval <= 0 {
+		s.interval = defaultInterval
+	}
+	if s.fullInterval <= 0 {
+		s.fullInterval = defaultFullInterval
+	}
+	if s.pageSize <= 0 {
+		s.pageSize = defaultPageSize
+	}
+	if def.TokenEnv != "" {
+		s.token = os.Getenv(def.TokenEnv)
+	}
+
+	if def.CAFile != "" {
+		pem, err := os.ReadFile(def.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca file : %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca file %q holds no certificates", def.CAFile)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool}
+		s.client.Transport = transport
+	}
+	return s, nil
+}
+
+// resourceTypes parses the resource types of an upstream definition, users
+// first so groups can refer to pulled members.
+func resourceTypes(names []string) ([]store.ResourceType, error) {
+	if len(names) == 0 {
+		return []store.ResourceType{store.ResourceTypeUser, store.ResourceTypeGroup}, nil
+	}
+
+	var users, groups bool
+	for _, name := range names {
+		switch store.ResourceType(name) {
+		case store.ResourceTypeUser:
+			users = true
+		case store.ResourceTypeGroup:
+			groups = true
+		default:
+			return nil, fmt.Errorf("unknown resource type %q", name)
+		}
+	}
+
+	types := make([]store.ResourceType, 0, 2)
+	if users {
+		types = append(types, store.ResourceTypeUser)
+	}
+	if groups {
+		types = append(types, store.ResourceTypeGroup)
+	}
+	return types, nil
+}
+
+// StatusError is an error response of an upstream.
+type StatusError struct {
+	Status int    // HTTP status of the response.
+	Detail string // Detail of the SCIM error, or the start of the response body.
+}
+
+func (e *StatusError) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("upstream responded with status %d", e.Status)
+	}
+	return fmt.Sprintf("upstream responded with status %d : %s", e.Status, e.Detail)
+}
+
+// page is a page of a SCIM list response.
+type page struct {
+	TotalResults int              `json:"totalResults"`
+	Resources    []map[string]any `json:"Resources"`
+}
+
+// list fetches every resource of a type matching a filter, every resource
+// when the filter is empty, paging through the results.
+func (s *Syncer) list(ctx context.Context, resourceType store.ResourceType, filter string) ([]map[string]any, error) {
+	resources := make([]map[string]any, 0)
+	for start := 1; ; {
+		query := url.Values{}
+		query.Set("startIndex", fmt.Sprint(start))
+		query.Set("count", fmt.Sprint(s.pageSize))
+		if filter != "" {
+			query.Set("filter", filter)
+		}
+
+		var p page
+		if err := s.get(ctx, endpoint(resourceType)+"?"+query.Encode(), &p); err != nil {
+			return nil, fmt.Errorf("failed to list %ss : %w", resourceType, err)
+		}
+		resources = append(resources, p.Resources...)
+
+		start += len(p.Resources)
+		if len(p.Resources) == 0 || start > p.TotalResults {
+			return resources, nil
+		}
+	}
+}
+
+// get sends a GET request to the upstream and decodes the response into v.
+// Error responses are returned as a StatusError.
+func (s *Syncer) get(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url+path, nil)
+	if err != nil {
+		return fmt.Errorf("failed to build request : %w", err)
+	}
+	req.Header.Set("Accept", "application/scim+json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach upstream : %w", err)
+	}
+	defer res.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(res.Body, maxResponseSize))
+	if err != nil {
+		return fmt.Errorf("failed to read response : %w", err)
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		var scimErr struct {
+			Detail string `json:"detail"`
+		}
+		if err := json.Unmarshal(raw, &scimErr); err != nil || scimErr.Detail == "" {
+			scimErr.Detail = strings.TrimSpace(string(raw[:min(len(raw), 200)]))
+		}
+		return &StatusError{Status: res.StatusCode, Detail: scimErr.Detail}
+	}
+
+	if err := json.NewDecoder(bytes.NewReader(raw)).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode response : %w", err)
+	}
+	return nil
+}
+
+// endpoint returns the path of the endpoint of a resource type.
+func endpoint(resourceType store.ResourceType) string {
+	return "/" + string(resourceType) + "s"
+}
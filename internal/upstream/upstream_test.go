@@ -0,0 +1,316 @@
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/iamBelugaa/scim-gateway/internal/config"
+	"github.com/iamBelugaa/scim-gateway/internal/store"
+	"github.com/iamBelugaa/scim-gateway/internal/tenant"
+	"github.com/iamBelugaa/scim-gateway/pkg/logger"
+)
+
+// server is an upstream SCIM server holding resources in memory. It pages
+// list responses and supports meta.lastModified gt filters only.
+type server struct {
+	mu        sync.Mutex
+	resources map[string]map[string]map[string]any // Resources keyed by endpoint and id.
+	clock     time.Time                            // Modification time of the next write.
+	filters   []string                             // Filters of the list requests received.
+}
+
+func newServer(t *testing.T) (*server, *httptest.Server) {
+	t.Helper()
+
+	s := &server{
+		resources: map[string]map[string]map[string]any{"Users": {}, "Groups": {}},
+		clock:     time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return s, srv
+}
+
+// put creates or replaces a resource, marking it modified now.
+func (s *server) put(endpoint, id string, attrs map[string]any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clock = s.clock.Add(time.Second)
+	res := map[string]any{"id": id, "meta": map[string]any{"lastModified": s.clock.Format(time.RFC3339)}}
+	for name, val := range attrs {
+		res[name] = val
+	}
+	s.resources[endpoint][id] = res
+}
+
+func (s *server) remove(endpoint, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.resources[endpoint], id)
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]any{"detail": "invalid token"})
+		return
+	}
+	resources, ok := s.resources[strings.TrimPrefix(r.URL.Path, "/")]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := query.Get("filter")
+	s.filters = append(s.filters, filter)
+
+	var since time.Time
+	if filter != "" {
+		raw, ok := strings.CutPrefix(filter, "meta.lastModified gt ")
+		parsed, err := time.Parse(time.RFC3339Nano, strings.Trim(raw, `"`))
+		if !ok || err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]any{"detail": "unsupported filter"})
+			return
+		}
+		since = parsed
+	}
+
+	matched := make([]map[string]any, 0)
+	for _, res := range resources {
+		modified, _ := lastModified(res)
+		if filter == "" || modified.After(since) {
+			matched = append(matched, res)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i]["id"].(string) < matched[j]["id"].(string) })
+
+	start, _ := strconv.Atoi(query.Get("startIndex"))
+	count, _ := strconv.Atoi(query.Get("count"))
+	from := min(max(start-1, 0), len(matched))
+	to := min(from+count, len(matched))
+	_ = json.NewEncoder(w).Encode(map[string]any{"totalResults": len(matched), "Resources": matched[from:to]})
+}
+
+// newTestSyncer constructs a syncer pulling from the server into the default
+// tenant, two resources per page.
+func newTestSyncer(t *testing.T, url string) (*Syncer, *tenant.Tenant) {
+	t.Helper()
+
+	tenants, err := tenant.NewWithConfig(&config.Tenancy{}, &config.Store{})
+	if err != nil {
+		t.Fatalf("failed to construct tenants : %v", err)
+	}
+	def, err := tenants.Get("default")
+	if err != nil {
+		t.Fatalf("failed to get default tenant : %v", err)
+	}
+
+	s, err := New(
+		&logger.Logger{SugaredLogger: zap.NewNop().Sugar()},
+		&Definition{Name: "idp", URL: url + "/", Token: "secret", PageSize: 2},
+		def, time.Second*5,
+	)
+	if err != nil {
+		t.Fatalf("failed to construct syncer : %v", err)
+	}
+	return s, def
+}
+
+// TestSync pulls from an upstream and asserts that creates, updates, invalid
+// resources and deletes reach the tenant store.
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	upstream, srv := newServer(t)
+	s, def := newTestSyncer(t, srv.URL)
+
+	upstream.put("Users", "u1", map[string]any{"userName": "alice"})
+	upstream.put("Users", "u2", map[string]any{"userName": "bob"})
+	upstream.put("Users", "u3", map[string]any{"userName": "carol"})
+	upstream.put("Users", "u4", map[string]any{"displayName": "no user name"})
+	upstream.put("Groups", "g1", map[string]any{
+		"displayName": "admins",
+		"members":     []any{map[string]any{"value": "u1", "display": "alice"}, map[string]any{"value": "u2"}},
+	})
+
+	result, err := s.Sync(ctx, false)
+	if err != nil {
+		t.Fatalf("failed to sync : %v", err)
+	}
+	if want := (Result{Full: true, Created: 4, Rejected: 1}); result != want {
+		t.Fatalf("expected first sync %+v, got %+v", want, result)
+	}
+
+	alice := s.localID(store.ResourceTypeUser, "u1")
+	group, err := def.Store.Get(ctx, store.ResourceTypeGroup, s.localID(store.ResourceTypeGroup, "g1"))
+	if err != nil {
+		t.Fatalf("failed to get pulled group : %v", err)
+	}
+	members, _ := group.Attributes["members"].([]any)
+	if len(members) != 2 || members[0].(map[string]any)["value"] != alice {
+		t.Fatalf("expected members to refer to pulled users, got %v", members)
+	}
+
+	// Incremental pulls only request what changed since the first pull.
+	upstream.put("Users", "u1", map[string]any{"userName": "alice", "displayName": "Alice"})
+	result, err = s.Sync(ctx, false)
+	if err != nil {
+		t.Fatalf("failed to sync : %v", err)
+	}
+	if want := (Result{Updated: 1}); result != want {
+		t.Fatalf("expected incremental sync %+v, got %+v", want, result)
+	}
+	user, err := def.Store.Get(ctx, store.ResourceTypeUser, alice)
+	if err != nil {
+		t.Fatalf("failed to get pulled user : %v", err)
+	}
+	if user.Attributes["displayName"] != "Alice" {
+		t.Fatalf("expected updated display name, got %v", user.Attributes["displayName"])
+	}
+	if filter := upstream.filters[len(upstream.filters)-1]; !strings.HasPrefix(filter, "meta.lastModified gt ") {
+		t.Fatalf("expected incremental pulls to filter on lastModified, got %q", filter)
+	}
+
+	// Deletes are only found by full sweeps.
+	upstream.remove("Users", "u3")
+	result, err = s.Sync(ctx, false)
+	if err != nil {
+		t.Fatalf("failed to sync : %v", err)
+	}
+	if result.Deleted != 0 {
+		t.Fatalf("expected incremental sync to delete nothing, got %+v", result)
+	}
+
+	result, err = s.Sync(ctx, true)
+	if err != nil {
+		t.Fatalf("failed to sync : %v", err)
+	}
+	if want := (Result{Full: true, Deleted: 1, Unchanged: 3, Rejected: 1}); result != want {
+		t.Fatalf("expected full sync %+v, got %+v", want, result)
+	}
+	if _, err := def.Store.Get(ctx, store.ResourceTypeUser, s.localID(store.ResourceTypeUser, "u3")); err == nil {
+		t.Fatalf("expected user deleted upstream to be deleted")
+	}
+
+	// Resources the syncer did not pull survive full sweeps.
+	local, err := def.Store.Create(ctx, &store.Resource{Type: store.ResourceTypeUser, Attributes: map[string]any{"userName": "dave"}})
+	if err != nil {
+		t.Fatalf("failed to create user : %v", err)
+	}
+	if _, err := s.Sync(ctx, true); err != nil {
+		t.Fatalf("failed to sync : %v", err)
+	}
+	if _, err := def.Store.Get(ctx, store.ResourceTypeUser, local.ID); err != nil {
+		t.Fatalf("expected local user to survive full sweeps : %v", err)
+	}
+}
+
+// TestSyncAfterRestart asserts full sweeps delete resources pulled by an
+// earlier syncer, even once clients replaced them, since ownership is read
+// from the store.
+func TestSyncAfterRestart(t *testing.T) {
+	ctx := context.Background()
+	upstream, srv := newServer(t)
+	s, def := newTestSyncer(t, srv.URL)
+
+	upstream.put("Users", "u1", map[string]any{"userName": "alice"})
+	upstream.put("Users", "u2", map[string]any{"userName": "bob"})
+	if _, err := s.Sync(ctx, false); err != nil {
+		t.Fatalf("failed to sync : %v", err)
+	}
+
+	alice := s.localID(store.ResourceTypeUser, "u1")
+	replaced, err := def.Store.Replace(ctx, &store.Resource{
+		ID: alice, Type: store.ResourceTypeUser, Attributes: map[string]any{"userName": "alice", "title": "Guide"},
+	})
+	if err != nil {
+		t.Fatalf("failed to replace user : %v", err)
+	}
+	if replaced.Origin != s.url {
+		t.Fatalf("expected replaced user to keep origin %q, got %q", s.url, replaced.Origin)
+	}
+
+	restarted, err := New(
+		&logger.Logger{SugaredLogger: zap.NewNop().Sugar()},
+		&Definition{Name: "idp", URL: srv.URL, Token: "secret", PageSize: 2},
+		def, time.Second*5,
+	)
+	if err != nil {
+		t.Fatalf("failed to construct syncer : %v", err)
+	}
+
+	upstream.remove("Users", "u1")
+	result, err := restarted.Sync(ctx, false)
+	if err != nil {
+		t.Fatalf("failed to sync : %v", err)
+	}
+	if want := (Result{Full: true, Deleted: 1, Unchanged: 1}); result != want {
+		t.Fatalf("expected sync after restart %+v, got %+v", want, result)
+	}
+	if _, err := def.Store.Get(ctx, store.ResourceTypeUser, alice); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expected user deleted upstream to be deleted, got %v", err)
+	}
+}
+
+// TestSyncError asserts upstream errors fail the pull without moving the
+// watermarks.
+func TestSyncError(t *testing.T) {
+	_, srv := newServer(t)
+	s, _ := newTestSyncer(t, srv.URL)
+	s.token = "wrong"
+
+	_, err := s.Sync(context.Background(), false)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Status != http.StatusUnauthorized || statusErr.Detail != "invalid token" {
+		t.Fatalf("expected an unauthorized error, got %v", err)
+	}
+	if len(s.watermarks) != 0 {
+		t.Fatalf("expected no watermarks after a failed pull, got %v", s.watermarks)
+	}
+}
+
+// TestSyncDryRun asserts dry runs plan pulls without writing to the store or
+// remembering what they pulled.
+func TestSyncDryRun(t *testing.T) {
+	ctx := context.Background()
+	upstream, srv := newServer(t)
+	s, def := newTestSyncer(t, srv.URL)
+	s.dryRun = true
+
+	upstream.put("Users", "u1", map[string]any{"userName": "alice"})
+	upstream.put("Groups", "g1", map[string]any{"displayName": "admins", "members": []any{map[string]any{"value": "u1"}}})
+
+	for range 2 {
+		result, err := s.Sync(ctx, false)
+		if err != nil {
+			t.Fatalf("failed to sync : %v", err)
+		}
+		if want := (Result{Full: true, Created: 2}); result != want {
+			t.Fatalf("expected dry run %+v, got %+v", want, result)
+		}
+	}
+
+	users, err := def.Store.List(ctx, store.ResourceTypeUser)
+	if err != nil {
+		t.Fatalf("failed to list users : %v", err)
+	}
+	if len(users) != 0 || len(s.watermarks) != 0 {
+		t.Fatalf("expected dry runs to leave no trace, got %d users and watermarks %v", len(users), s.watermarks)
+	}
+}